@@ -0,0 +1,189 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/alkmc/storefront/internal/cache"
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/migrate"
+	"github.com/alkmc/storefront/internal/repository"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/alkmc/storefront/internal/service"
+	"github.com/google/uuid"
+)
+
+const usage = `usage: catalog <command> [flags]
+
+commands:
+  import [-format csv|ndjson] <file>  import products from a CSV or NDJSON file
+  import -resume <job-id>             continue an interrupted import from its checkpoint
+
+Both act for the tenant given by -tenant, TENANT_DEFAULT by default.
+`
+
+// rejectsPageSize bounds how many rejected lines are fetched per report query.
+const rejectsPageSize = 1000
+
+type importArgs struct {
+	format entity.ImportFormat
+	path   string
+	resume uuid.UUID
+	tenant string
+}
+
+func main() {
+	args := parseImportArgs()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	logger := cfg.Log.NewLogger(os.Stderr)
+	slog.SetDefault(logger)
+
+	if err := run(logger, cfg, args); err != nil {
+		logger.Error("catalog command failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger, cfg config.Config, args importArgs) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// An in-memory store would vanish with this process, taking the import with it, and the
+	// sqlite backend caches products in the server process, out of reach of invalidation from here.
+	if cfg.Storage.Backend != config.StoragePostgres {
+		return fmt.Errorf("catalog needs the %s storage backend, got %q",
+			config.StoragePostgres, cfg.Storage.Backend)
+	}
+	if err := migrate.Verify(ctx, cfg.Postgres.DSN()); err != nil {
+		return err
+	}
+
+	repo, err := repository.New(ctx, logger, cfg.Postgres)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	// Imports update existing products, so their cached copies must be invalidated.
+	rCache, err := cache.NewRedis(ctx, cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer rCache.Close()
+
+	importer := service.NewImporter(logger, repo, rCache, cfg.Import)
+	ctx = reqctx.WithActor(reqctx.WithTenant(ctx, cmp.Or(args.tenant, cfg.Tenant.Default)), reqctx.System)
+
+	var job entity.ImportJob
+	if args.resume != uuid.Nil {
+		job, err = importer.Resume(ctx, args.resume)
+	} else {
+		job, err = importer.Import(ctx, args.format, args.path)
+	}
+	if err != nil {
+		if job.ID != uuid.Nil {
+			return fmt.Errorf("import %s interrupted at line %d, resume with -resume: %w", job.ID, job.Line, err)
+		}
+		return err
+	}
+
+	if err := printReport(ctx, os.Stdout, importer, job); err != nil {
+		return err
+	}
+	if job.Status == entity.ImportFailed {
+		return fmt.Errorf("import %s failed: %s", job.ID, job.Error)
+	}
+	return nil
+}
+
+func parseImportArgs() importArgs {
+	if len(os.Args) < 2 || os.Args[1] != "import" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	format := fs.String("format", "", "csv or ndjson; inferred from the file extension when empty")
+	resume := fs.String("resume", "", "id of the import job to resume")
+	tenant := fs.String("tenant", "", "tenant to import into; TENANT_DEFAULT when empty")
+	_ = fs.Parse(os.Args[2:])
+
+	args := importArgs{tenant: *tenant}
+	switch {
+	case *resume != "" && fs.NArg() == 0:
+		id, err := uuid.Parse(*resume)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid job id %q\n", *resume)
+			os.Exit(2)
+		}
+		args.resume = id
+	case *resume == "" && fs.NArg() == 1:
+		args.path = fs.Arg(0)
+		args.format = entity.ImportFormat(*format)
+		if *format == "" {
+			args.format = formatFromExt(args.path)
+		}
+		if !args.format.Valid() {
+			fmt.Fprintf(os.Stderr, "unsupported format for %q, pass -format csv or -format ndjson\n", args.path)
+			os.Exit(2)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	return args
+}
+
+func formatFromExt(path string) entity.ImportFormat {
+	switch filepath.Ext(path) {
+	case ".csv":
+		return entity.ImportCSV
+	case ".ndjson", ".jsonl":
+		return entity.ImportNDJSON
+	default:
+		return ""
+	}
+}
+
+func printReport(ctx context.Context, w io.Writer, importer *service.Importer, job entity.ImportJob) error {
+	fmt.Fprintf(w, "job %s %s: %d accepted, %d rejected\n", job.ID, job.Status, job.Accepted, job.Rejected)
+	if job.Rejected == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tREASON")
+	var cursor int64
+	for {
+		page, err := importer.Rejects(ctx, job.ID, cursor, rejectsPageSize)
+		if err != nil {
+			return errors.Join(err, tw.Flush())
+		}
+		for _, r := range page.Items {
+			fmt.Fprintf(tw, "%d\t%s\n", r.Line, r.Reason)
+		}
+		if !page.HasMore || len(page.Items) == 0 {
+			break
+		}
+		cursor = page.Items[len(page.Items)-1].Line
+	}
+	return tw.Flush()
+}
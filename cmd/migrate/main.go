@@ -15,6 +15,7 @@ import (
 
 	"github.com/alkmc/storefront/internal/config"
 	"github.com/alkmc/storefront/internal/migrate"
+	sqliterepo "github.com/alkmc/storefront/internal/repository/sqlite"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/stdlib"
 )
@@ -55,7 +56,7 @@ func run(logger *slog.Logger, cfg config.Config, cmd string) error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	db, err := openDB(ctx, cfg.Postgres.DSN())
+	db, dialect, err := openDB(ctx, cfg)
 	if err != nil {
 		return err
 	}
@@ -65,8 +66,8 @@ func run(logger *slog.Logger, cfg config.Config, cmd string) error {
 		}
 	}()
 
-	logger.Info("running migrate command", slog.String("cmd", cmd))
-	return dispatch(ctx, logger, cmd, db)
+	logger.Info("running migrate command", slog.String("cmd", cmd), slog.String("dialect", string(dialect)))
+	return dispatch(ctx, logger, cmd, db, dialect)
 }
 
 func parseCommand() string {
@@ -82,42 +83,59 @@ func parseCommand() string {
 	return cmd
 }
 
-func openDB(ctx context.Context, dsn string) (*sql.DB, error) {
-	cfg, err := pgx.ParseConfig(dsn)
-	if err != nil {
-		return nil, fmt.Errorf("parse pg config: %w", err)
+// openDB connects to the database of the configured storage backend and returns the
+// migration dialect it takes.
+func openDB(ctx context.Context, cfg config.Config) (*sql.DB, migrate.Dialect, error) {
+	var (
+		db      *sql.DB
+		dialect migrate.Dialect
+	)
+	switch cfg.Storage.Backend {
+	case config.StoragePostgres:
+		pgCfg, err := pgx.ParseConfig(cfg.Postgres.DSN())
+		if err != nil {
+			return nil, "", fmt.Errorf("parse pg config: %w", err)
+		}
+		db, dialect = stdlib.OpenDB(*pgCfg), migrate.DialectPostgres
+	case config.StorageSQLite:
+		var err error
+		if db, err = sqliterepo.Open(cfg.SQLite); err != nil {
+			return nil, "", err
+		}
+		dialect = migrate.DialectSQLite
+	default:
+		return nil, "", fmt.Errorf("the %s storage backend has no schema to migrate", cfg.Storage.Backend)
 	}
-	db := stdlib.OpenDB(*cfg)
 	if err := db.PingContext(ctx); err != nil {
 		_ = db.Close()
-		return nil, fmt.Errorf("ping db: %w", err)
+		return nil, "", fmt.Errorf("ping db: %w", err)
 	}
-	return db, nil
+	return db, dialect, nil
 }
 
-func dispatch(ctx context.Context, logger *slog.Logger, cmd string, db *sql.DB) error {
+func dispatch(ctx context.Context, logger *slog.Logger, cmd string, db *sql.DB, d migrate.Dialect) error {
 	switch cmd {
 	case "up":
-		if err := migrate.Up(ctx, db); err != nil {
+		if err := migrate.Up(ctx, db, d); err != nil {
 			return err
 		}
 		logger.Info("migrations applied")
 		return nil
 	case "down":
-		if err := migrate.Down(ctx, db); err != nil {
+		if err := migrate.Down(ctx, db, d); err != nil {
 			return err
 		}
 		logger.Info("migration rolled back")
 		return nil
 	case "status":
-		return printStatus(ctx, os.Stdout, db)
+		return printStatus(ctx, os.Stdout, db, d)
 	default:
 		return errors.New("unknown command: " + cmd)
 	}
 }
 
-func printStatus(ctx context.Context, w io.Writer, db *sql.DB) error {
-	rows, err := migrate.Status(ctx, db)
+func printStatus(ctx context.Context, w io.Writer, db *sql.DB, d migrate.Dialect) error {
+	rows, err := migrate.Status(ctx, db, d)
 	if err != nil {
 		return err
 	}
@@ -9,13 +9,23 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/alkmc/storefront/internal/cache"
+	memcache "github.com/alkmc/storefront/internal/cache/memory"
 	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
 	"github.com/alkmc/storefront/internal/httpapi"
+	"github.com/alkmc/storefront/internal/jwt"
 	"github.com/alkmc/storefront/internal/migrate"
+	"github.com/alkmc/storefront/internal/ratelimit"
 	"github.com/alkmc/storefront/internal/repository"
+	memrepo "github.com/alkmc/storefront/internal/repository/memory"
+	sqliterepo "github.com/alkmc/storefront/internal/repository/sqlite"
 	"github.com/alkmc/storefront/internal/service"
+	"github.com/alkmc/storefront/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -25,43 +35,75 @@ func main() {
 		_, _ = fmt.Fprintf(os.Stderr, "load config: %v\n", err)
 		os.Exit(1)
 	}
+	auth, err := config.LoadAuth()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "load auth config: %v\n", err)
+		os.Exit(1)
+	}
 
-	logger := cfg.Log.NewLogger(os.Stdout)
+	logger := slog.New(tracing.LogHandler(cfg.Log.NewLogger(os.Stdout).Handler()))
 	slog.SetDefault(logger)
 
-	if err := run(logger, cfg); err != nil {
+	if err := run(logger, cfg, auth); err != nil {
 		logger.Error("application failed", slog.Any("error", err))
 		os.Exit(1)
 	}
 }
 
-func run(logger *slog.Logger, cfg config.Config) error {
+func run(logger *slog.Logger, cfg config.Config, auth config.Auth) error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	if err := migrate.Verify(ctx, cfg.Postgres.DSN()); err != nil {
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
 		return err
 	}
+	defer func() {
+		// Spans still buffered are flushed after the servers drain, within the same bound.
+		flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.HTTP.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Warn("failed to flush traces", slog.Any("error", err))
+		}
+	}()
 
-	repo, err := repository.NewPG(ctx, logger, cfg.Postgres)
+	b, err := openBackend(ctx, logger, cfg, auth)
 	if err != nil {
 		return err
 	}
-	defer repo.Close()
+	defer b.close()
+	h := httpapi.NewHandler(logger, b.srv, cfg.HTTP.RequestTimeout)
 
-	rCache, err := cache.NewRedis(ctx, cfg.Redis)
-	if err != nil {
-		return err
+	var (
+		tokens httpapi.TokenVerifier
+		jwks   *jwt.KeySet
+	)
+	if auth.JWT.Enabled() {
+		if jwks, err = jwt.NewKeySet(ctx, logger, auth.JWT); err != nil {
+			return err
+		}
+		tokens = jwt.NewVerifier(jwks, auth.JWT)
+		logger.Info("accepting jwt bearer tokens", slog.String("issuer", auth.JWT.Issuer))
+	}
+
+	var rl *httpapi.RateLimiter
+	if cfg.RateLimit.Enabled {
+		rl, err = httpapi.NewRateLimiter(b.limiter(cfg.RateLimit.RedisTimeout), httpapi.RateLimitCfg{
+			IP:             cfg.RateLimit.IP,
+			Tiers:          cfg.RateLimit.Tiers,
+			RouteCosts:     cfg.RateLimit.RouteCosts,
+			TrustedProxies: cfg.HTTP.TrustedProxies,
+		})
+		if err != nil {
+			return fmt.Errorf("rate limit: %w", err)
+		}
 	}
-	logger.Info("successfully connected to redis")
-	defer func() {
-		rCache.Close()
-		logger.Info("connection to redis closed")
-	}()
-	srv := service.NewService(logger, repo, rCache, cfg.Service.LoadTimeout)
-	h := httpapi.NewHandler(logger, srv, cfg.HTTP.RequestTimeout)
-	ih := httpapi.NewInternalHandler(repo, rCache)
 
+	tenantCfg := httpapi.TenantCfg{
+		Header:   cfg.Tenant.Header,
+		Default:  cfg.Tenant.Default,
+		Required: cfg.Tenant.Required,
+	}
 	mw, err := httpapi.NewMiddleware(httpapi.MiddlewareCfg{
 		MaxBodyBytes:       cfg.HTTP.MaxBodyBytes,
 		CompressMinBytes:   cfg.HTTP.CompressMinBytes,
@@ -69,12 +111,22 @@ func run(logger *slog.Logger, cfg config.Config) error {
 		CORSMaxAge:         cfg.HTTP.CORSMaxAge,
 		HSTSEnabled:        cfg.HTTP.HSTSEnabled,
 		HSTSMaxAge:         cfg.HTTP.HSTSMaxAge,
-	})
+		Tenant:             tenantCfg,
+		RateLimiter:        rl,
+	}, b.keys, tokens)
+	if err != nil {
+		return err
+	}
+	tenant, err := httpapi.NewTenantMiddleware(tenantCfg)
 	if err != nil {
 		return err
 	}
-	apiServer := httpapi.NewAPIServer(cfg.HTTP, mw(httpapi.NewMux(h)))
-	internalServer := httpapi.NewInternalServer(cfg.HTTP, httpapi.NewInternalMux(ih))
+	hm := httpapi.NewHTTPMetrics(prometheus.DefaultRegisterer)
+	apiMux, internalMux := httpapi.NewMux(h, rl), httpapi.NewInternalMux(b.internal, tenant)
+	apiServer := httpapi.NewAPIServer(cfg.HTTP,
+		hm.Instrument("api", apiMux, httpapi.Trace(apiMux, mw(apiMux))))
+	internalServer := httpapi.NewInternalServer(cfg.HTTP,
+		hm.Instrument("internal", internalMux, httpapi.Trace(internalMux, internalMux)))
 
 	eg, ctx := errgroup.WithContext(ctx)
 	serve := func(s *http.Server) {
@@ -98,6 +150,27 @@ func run(logger *slog.Logger, cfg config.Config) error {
 	}
 	serve(apiServer)
 	serve(internalServer)
+	eg.Go(func() error {
+		return b.srv.RunPurge(ctx, cfg.Service.PurgeInterval, cfg.Service.PurgeRetention)
+	})
+	eg.Go(func() error {
+		return b.importer.Run(ctx)
+	})
+	if jwks != nil {
+		eg.Go(func() error {
+			return jwks.Run(ctx)
+		})
+	}
+	if b.warmer != nil {
+		eg.Go(func() error {
+			return b.warmer.Run(ctx)
+		})
+	}
+	if b.monitor != nil {
+		eg.Go(func() error {
+			return b.monitor(ctx)
+		})
+	}
 
 	if err := eg.Wait(); err != nil {
 		return err
@@ -105,3 +178,208 @@ func run(logger *slog.Logger, cfg config.Config) error {
 	logger.Info("server shutdown completed")
 	return nil
 }
+
+// cacher is the Redis cache, behind a circuit breaker unless it is disabled.
+type cacher interface {
+	Set(context.Context, string, entity.Product, time.Duration) error
+	SetMissing(context.Context, string) error
+	SetMany(context.Context, []entity.Product, []string, time.Duration) (int, error)
+	Get(context.Context, string) (cache.Entry, error)
+	GetMany(context.Context, []string) ([]cache.Result, error)
+	Invalidate(context.Context, string) error
+	GetPage(context.Context, string) (entity.ProductPage, error)
+	PageVersion(context.Context) (cache.PageVersion, error)
+	SetPage(context.Context, string, entity.ProductPage, cache.PageVersion) error
+	InvalidatePages(context.Context, ...string) error
+	FlushPages(context.Context) error
+	Ping(context.Context) error
+}
+
+// registerPoolMetrics serves the connection pool usage reported by stats as metrics. Each series
+// is a function of its own, labelled by a constant label.
+func registerPoolMetrics(stats func() repository.PoolStats) {
+	for state, conns := range map[string]func(repository.PoolStats) int{
+		"idle":   func(s repository.PoolStats) int { return s.IdleConns },
+		"in_use": func(s repository.PoolStats) int { return s.InUseConns },
+	} {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "storefront_db_connections",
+			Help:        "Database connections in the pool, by state.",
+			ConstLabels: prometheus.Labels{"state": state},
+		}, func() float64 { return float64(conns(stats())) })
+	}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storefront_db_max_connections",
+		Help: "Most database connections the pool opens.",
+	}, func() float64 { return float64(stats().MaxConns) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "storefront_db_waits_total",
+		Help: "Database connections waited for.",
+	}, func() float64 { return float64(stats().WaitCount) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "storefront_db_wait_seconds_total",
+		Help: "Time waited for database connections.",
+	}, func() float64 { return stats().WaitDuration.Seconds() })
+}
+
+// registerCacheMetrics serves the lookups of each cache tier reported by stats as metrics.
+func registerCacheMetrics(stats func() cache.Stats) {
+	for _, series := range []struct {
+		tier, result string
+		lookups      func(cache.Stats) int64
+	}{
+		{"l1", "hit", func(s cache.Stats) int64 { return s.L1Hits }},
+		{"l1", "miss", func(s cache.Stats) int64 { return s.L1Misses }},
+		{"redis", "hit", func(s cache.Stats) int64 { return s.RedisHits }},
+		{"redis", "miss", func(s cache.Stats) int64 { return s.RedisMisses }},
+		{"redis", "tombstone", func(s cache.Stats) int64 { return s.TombstoneHits }},
+	} {
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "storefront_cache_tier_lookups_total",
+			Help:        "Cache lookups, by tier and result.",
+			ConstLabels: prometheus.Labels{"tier": series.tier, "result": series.result},
+		}, func() float64 { return float64(series.lookups(stats())) })
+	}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "storefront_cache_l1_entries",
+		Help: "Entries in the in-process cache tier.",
+	}, func() float64 { return float64(stats().L1Entries) })
+}
+
+func readinessCfg(cfg config.Config) httpapi.ReadinessCfg {
+	return httpapi.ReadinessCfg{
+		RequireCache:    cfg.Readiness.Requires(config.DependencyCache),
+		RequireReplicas: cfg.Readiness.Requires(config.DependencyReplicas),
+	}
+}
+
+type rateLimiter interface {
+	Allow(context.Context, string, config.Limit, int) (ratelimit.Result, error)
+}
+
+// localLimiter keeps rate limit buckets in process, which is enough for a single node.
+func localLimiter(time.Duration) rateLimiter {
+	return ratelimit.NewLocal()
+}
+
+// backend is the service layer wired to the storage selected by STORAGE_BACKEND.
+type backend struct {
+	srv      *service.Service
+	importer *service.Importer
+	internal *httpapi.InternalHandler
+	keys     *service.APIKeys
+	// warmer preloads the Redis cache; nil when there is none.
+	warmer *service.Warmer
+	// limiter returns where rate limit buckets are kept, giving a shared store timeout to answer.
+	limiter func(timeout time.Duration) rateLimiter
+	// monitor runs storage housekeeping until ctx is done; nil when there is none.
+	monitor func(context.Context) error
+	close   func()
+}
+
+func openBackend(ctx context.Context, logger *slog.Logger, cfg config.Config, auth config.Auth,
+) (backend, error) {
+	switch cfg.Storage.Backend {
+	case config.StorageMemory:
+		logger.Warn("using in-memory storage; all data is lost on exit")
+		repo, c := memrepo.New(), memcache.New(cfg.Redis)
+		importer := service.NewImporter(logger, repo, c, cfg.Import)
+		keys := service.NewAPIKeys(logger, repo, auth)
+		return backend{
+			srv:      service.NewService(logger, repo, c, cfg.Service.LoadTimeout),
+			importer: importer,
+			keys:     keys,
+			internal: httpapi.NewInternalHandler(logger, repo, c, repo, importer, keys, nil, readinessCfg(cfg)),
+			limiter:  localLimiter,
+			close:    func() {},
+		}, nil
+	case config.StorageSQLite:
+		return openSQLite(ctx, logger, cfg, auth)
+	default:
+		return openPostgres(ctx, logger, cfg, auth)
+	}
+}
+
+// openSQLite wires the SQLite store to an in-process cache; a single node needs no Redis.
+func openSQLite(ctx context.Context, logger *slog.Logger, cfg config.Config, auth config.Auth,
+) (backend, error) {
+	if err := verifySQLite(ctx, cfg.SQLite); err != nil {
+		return backend{}, err
+	}
+	repo, err := sqliterepo.New(ctx, logger, cfg.SQLite)
+	if err != nil {
+		return backend{}, err
+	}
+	c := memcache.New(cfg.Redis)
+	importer := service.NewImporter(logger, repo, c, cfg.Import)
+	keys := service.NewAPIKeys(logger, repo, auth)
+	return backend{
+		srv:      service.NewService(logger, repo, c, cfg.Service.LoadTimeout),
+		importer: importer,
+		keys:     keys,
+		internal: httpapi.NewInternalHandler(logger, repo, c, repo, importer, keys, nil, readinessCfg(cfg)),
+		limiter:  localLimiter,
+		close:    repo.Close,
+	}, nil
+}
+
+func verifySQLite(ctx context.Context, cfg config.SQLite) error {
+	db, err := sqliterepo.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return migrate.VerifyDB(ctx, db, migrate.DialectSQLite)
+}
+
+func openPostgres(ctx context.Context, logger *slog.Logger, cfg config.Config, auth config.Auth,
+) (backend, error) {
+	if err := migrate.Verify(ctx, cfg.Postgres.DSN()); err != nil {
+		return backend{}, err
+	}
+
+	repo, err := repository.New(ctx, logger, cfg.Postgres)
+	if err != nil {
+		return backend{}, err
+	}
+	registerPoolMetrics(repo.Stats)
+
+	rCache, err := cache.NewRedis(ctx, cfg.Redis)
+	if err != nil {
+		repo.Close()
+		return backend{}, err
+	}
+	logger.Info("successfully connected to redis")
+	registerCacheMetrics(rCache.Stats)
+	c := cacher(rCache)
+	if cfg.Redis.Breaker.Enabled {
+		c = cache.NewGuarded(logger, rCache, cfg.Redis.Breaker)
+	}
+
+	// Warming talks to Redis directly: a warm-up is a burst of writes the breaker should not
+	// judge the cache by.
+	warmer, err := service.NewWarmer(logger, repo, rCache, cfg.Redis.Warm)
+	if err != nil {
+		rCache.Close()
+		repo.Close()
+		return backend{}, err
+	}
+	importer := service.NewImporter(logger, repo, c, cfg.Import)
+	keys := service.NewAPIKeys(logger, repo, auth)
+	return backend{
+		srv:      service.NewService(logger, repo, c, cfg.Service.LoadTimeout),
+		importer: importer,
+		keys:     keys,
+		warmer:   warmer,
+		internal: httpapi.NewInternalHandler(logger, repo, c, repo, importer, keys, warmer, readinessCfg(cfg)),
+		limiter: func(timeout time.Duration) rateLimiter {
+			return ratelimit.NewFallback(logger, ratelimit.NewRedis(rCache.Client()), ratelimit.NewLocal(), timeout)
+		},
+		monitor: repo.RunReplicaMonitor,
+		close: func() {
+			rCache.Close()
+			logger.Info("connection to redis closed")
+			repo.Close()
+		},
+	}, nil
+}
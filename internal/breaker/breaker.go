@@ -0,0 +1,199 @@
+// Package breaker is a circuit breaker: it stops calls to a dependency that keeps failing, and
+// lets a few through now and then to find out when it recovers.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+)
+
+// ErrOpen is returned by Allow while the breaker rejects calls.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// windowBuckets is how many slices the failure window is counted in; it slides by one slice
+// at a time.
+const windowBuckets = 10
+
+// State is the state of a breaker.
+type State int
+
+const (
+	// Closed lets every call through and counts their failures.
+	Closed State = iota
+	// Open rejects every call until the breaker has been open for long enough.
+	Open
+	// HalfOpen lets a few probe calls through, closing when they succeed and opening when
+	// one fails.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Outcome is how a call allowed by a breaker went.
+type Outcome int
+
+const (
+	// Success is a call the dependency answered.
+	Success Outcome = iota
+	// Failure is a call the dependency failed or did not answer in time.
+	Failure
+	// Ignored is a call that says nothing about the dependency, such as one its caller gave up on.
+	Ignored
+)
+
+type (
+	Breaker struct {
+		cfg config.Breaker
+		// onChange is called with the lock held on each change of state.
+		onChange func(from, to State)
+
+		mu       sync.Mutex
+		state    State
+		buckets  [windowBuckets]bucket
+		openedAt time.Time
+		// probes counts the half-open calls in flight, and passed those that succeeded.
+		probes, passed int
+		// generation changes with the state, so outcomes of calls allowed before are dropped.
+		generation uint64
+	}
+	bucket struct {
+		// slot is the index of the slice of time the counts are for.
+		slot            int64
+		calls, failures int
+	}
+)
+
+// New returns a closed breaker configured by cfg. onChange, when not nil, is called on each
+// change of state; it must not call the breaker.
+func New(cfg config.Breaker, onChange func(from, to State)) *Breaker {
+	return new(Breaker{cfg: cfg, onChange: onChange})
+}
+
+// State returns the current state of b.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	return b.state
+}
+
+// Allow reports whether a call may go ahead, with ErrOpen when it may not. The call must then
+// report its outcome with done, once.
+func (b *Breaker) Allow() (done func(Outcome), err error) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expire(now)
+	switch b.state {
+	case Open:
+		return nil, ErrOpen
+	case HalfOpen:
+		if b.probes+b.passed >= b.cfg.HalfOpenProbes {
+			return nil, ErrOpen
+		}
+		b.probes++
+	}
+	generation := b.generation
+	return func(o Outcome) { b.record(generation, o) }, nil
+}
+
+func (b *Breaker) record(generation uint64, o Outcome) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case Closed:
+		if o == Ignored {
+			return
+		}
+		bk := b.bucket(now)
+		bk.calls++
+		if o == Failure {
+			bk.failures++
+			b.trip(now)
+		}
+	case HalfOpen:
+		b.probes--
+		switch o {
+		case Failure:
+			b.set(Open, now)
+		case Success:
+			if b.passed++; b.passed >= b.cfg.HalfOpenProbes {
+				b.set(Closed, now)
+			}
+		}
+	}
+}
+
+// trip opens b when enough calls in the window failed. The caller holds mu.
+func (b *Breaker) trip(now time.Time) {
+	var calls, failures int
+	slot := b.slot(now)
+	for _, bk := range b.buckets {
+		if slot-bk.slot < windowBuckets {
+			calls += bk.calls
+			failures += bk.failures
+		}
+	}
+	if calls >= b.cfg.MinRequests && float64(failures) >= b.cfg.FailureRate*float64(calls) {
+		b.set(Open, now)
+	}
+}
+
+// expire moves an open breaker to half-open once it has been open for long enough. The caller
+// holds mu.
+func (b *Breaker) expire(now time.Time) {
+	if b.state == Open && now.Sub(b.openedAt) >= b.cfg.OpenFor {
+		b.set(HalfOpen, now)
+	}
+}
+
+// set moves b to state. The caller holds mu.
+func (b *Breaker) set(state State, now time.Time) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.probes, b.passed = 0, 0
+	switch state {
+	case Open:
+		b.openedAt = now
+	case Closed:
+		b.buckets = [windowBuckets]bucket{}
+	}
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
+
+// bucket returns the bucket counting now, emptying it when it last counted an older slice.
+// The caller holds mu.
+func (b *Breaker) bucket(now time.Time) *bucket {
+	slot := b.slot(now)
+	bk := &b.buckets[slot%windowBuckets]
+	if bk.slot != slot {
+		*bk = bucket{slot: slot}
+	}
+	return bk
+}
+
+func (b *Breaker) slot(now time.Time) int64 {
+	return now.UnixNano() / max(int64(b.cfg.Window/windowBuckets), 1)
+}
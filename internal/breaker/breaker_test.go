@@ -0,0 +1,128 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+)
+
+var testCfg = config.Breaker{
+	Window:         10 * time.Second,
+	MinRequests:    4,
+	FailureRate:    0.5,
+	OpenFor:        5 * time.Second,
+	HalfOpenProbes: 2,
+}
+
+func call(t *testing.T, b *Breaker, o Outcome) {
+	t.Helper()
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	done(o)
+}
+
+func TestBreaker(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var changes []State
+		b := New(testCfg, func(_, to State) { changes = append(changes, to) })
+
+		// Failures below MinRequests, or below the rate, leave it closed.
+		call(t, b, Failure)
+		call(t, b, Success)
+		call(t, b, Success)
+		if b.State() != Closed {
+			t.Fatalf("got %v, want closed", b.State())
+		}
+		call(t, b, Failure)
+		if b.State() != Open {
+			t.Fatalf("got %v, want open at half the calls failing", b.State())
+		}
+		if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+			t.Fatalf("got %v, want ErrOpen", err)
+		}
+
+		// Once open for long enough, it lets HalfOpenProbes calls through.
+		time.Sleep(testCfg.OpenFor)
+		if b.State() != HalfOpen {
+			t.Fatalf("got %v, want half-open", b.State())
+		}
+		done1, err := b.Allow()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		done2, err := b.Allow()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+			t.Fatalf("got %v, want ErrOpen beyond the probes", err)
+		}
+		done1(Success)
+		done2(Success)
+		if b.State() != Closed {
+			t.Fatalf("got %v, want closed after the probes passed", b.State())
+		}
+
+		want := []State{Open, HalfOpen, Closed}
+		if len(changes) != len(want) {
+			t.Fatalf("got changes %v, want %v", changes, want)
+		}
+		for i := range want {
+			if changes[i] != want[i] {
+				t.Fatalf("got changes %v, want %v", changes, want)
+			}
+		}
+	})
+}
+
+func TestBreaker_FailedProbeReopens(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := New(testCfg, nil)
+		for range testCfg.MinRequests {
+			call(t, b, Failure)
+		}
+		time.Sleep(testCfg.OpenFor)
+		call(t, b, Success)
+		call(t, b, Failure)
+		if b.State() != Open {
+			t.Fatalf("got %v, want open after a failed probe", b.State())
+		}
+	})
+}
+
+func TestBreaker_Window(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := New(testCfg, nil)
+		for range testCfg.MinRequests - 1 {
+			call(t, b, Failure)
+		}
+		// Failures that left the window no longer count.
+		time.Sleep(testCfg.Window)
+		call(t, b, Failure)
+		if b.State() != Closed {
+			t.Fatalf("got %v, want closed", b.State())
+		}
+		// Neither do ignored calls, nor the outcomes of calls allowed before a change of state.
+		stale, err := b.Allow()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for range testCfg.MinRequests - 1 {
+			call(t, b, Ignored)
+			call(t, b, Failure)
+		}
+		if b.State() != Open {
+			t.Fatalf("got %v, want open", b.State())
+		}
+		time.Sleep(testCfg.OpenFor)
+		stale(Failure)
+		if b.State() != HalfOpen {
+			t.Fatalf("got %v, want half-open", b.State())
+		}
+	})
+}
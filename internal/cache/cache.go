@@ -2,92 +2,394 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/alkmc/storefront/internal/config"
 	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
 	"github.com/google/uuid"
 	"github.com/redis/rueidis"
 )
 
-// ErrCacheMiss is returned by Get when the key is not present in the cache.
-var ErrCacheMiss = errors.New("cache: key not found")
+var (
+	// ErrCacheMiss is returned by Get when the key is not present in the cache.
+	ErrCacheMiss = errors.New("cache: key not found")
+	// ErrTombstone is returned by Get when the key was cached as missing by SetMissing.
+	ErrTombstone = errors.New("cache: key cached as missing")
+)
+
+// tombstone is the value SetMissing stores; JSON entries are never empty.
+const tombstone = ""
 
 type (
 	cacheEntry struct {
 		ID    string     `json:"id"`
 		Name  string     `json:"name"`
 		Price moneyEntry `json:"price"`
+		// Expiry is when the entry goes stale and Delta how long it took to load, in Unix and
+		// plain milliseconds; entries written before they were stored read as stale.
+		Expiry int64 `json:"expiry,omitempty"`
+		Delta  int64 `json:"delta,omitempty"`
 	}
 	moneyEntry struct {
 		MinorAmount int64           `json:"minorAmount"`
 		Currency    entity.Currency `json:"currency"`
 	}
+	// RedisCache keeps products in Redis, with the hottest also in process when its L1 tier
+	// is enabled.
 	RedisCache struct {
-		client rueidis.Client
-		ttl    time.Duration
+		client       rueidis.Client
+		ttl          time.Duration
+		hardTTL      time.Duration
+		tombstoneTTL time.Duration
+		pageTTL      time.Duration
+		beta         float64
+		codec        Codec
+		// reads counts the product reads of each tenant key until TrackReads shares them; nil
+		// when counting is disabled.
+		reads     *readCounter
+		trackKeep int
+		trackHalf time.Duration
+		// l1 is nil when the in-process tier is disabled.
+		l1                     *local
+		l1Hits, l1Misses       atomic.Int64
+		redisHits, redisMisses atomic.Int64
+		tombstoneHits          atomic.Int64
+	}
+	// Stats counts the lookups each tier answered and missed. A miss of the L1 tier is a lookup
+	// of the Redis one, which answers with a product, a tombstone or nothing.
+	Stats struct {
+		L1Entries     int
+		L1Hits        int64
+		L1Misses      int64
+		RedisHits     int64
+		RedisMisses   int64
+		TombstoneHits int64
 	}
 )
 
-// NewRedis returns a Redis-backed cache configured from cfg.
+// TenantKey namespaces key by the tenant carried by ctx, so tenants sharing product ids
+// never read each other's entries. Tenant ids contain no colon, so namespaces cannot overlap.
+func TenantKey(ctx context.Context, key string) string {
+	return reqctx.Tenant(ctx) + ":" + key
+}
+
+// NewRedis returns a Redis-backed cache configured from cfg. Products go stale after cfg.TTL
+// and are dropped after cfg.HardTTL; pages of the product list are kept for cfg.PageTTL. With
+// cfg.L1Size set, it keeps up to that many products in process as well, for at most cfg.L1TTL.
+// Redis tracks the keys read into that tier and pushes their invalidation when any client
+// changes them, so every instance drops its copy; it must speak RESP3, as Redis 6 and later do.
+// Values are written with the codec named by cfg.Codec, and read in any codec. With
+// cfg.Warm.TrackInterval set, it counts the products read, for TrackReads to share.
 func NewRedis(ctx context.Context, cfg config.Redis) (*RedisCache, error) {
-	client, err := rueidis.NewClient(rueidis.ClientOption{
+	codec, err := CodecByName(cfg.Codec)
+	if err != nil {
+		return nil, err
+	}
+	r := new(RedisCache{
+		ttl:          cfg.TTL,
+		hardTTL:      max(cfg.HardTTL, cfg.TTL),
+		tombstoneTTL: cfg.TombstoneTTL,
+		pageTTL:      cfg.PageTTL,
+		beta:         cfg.XFetchBeta,
+		codec:        codec,
+		trackKeep:    cfg.Warm.TrackKeep,
+		trackHalf:    cfg.Warm.TrackHalfLife,
+	})
+	if cfg.Warm.TrackInterval > 0 {
+		r.reads = new(readCounter)
+	}
+	opt := rueidis.ClientOption{
 		InitAddress: []string{cfg.Address()},
 		Password:    cfg.Password.Reveal(),
 		SelectDB:    cfg.DB,
-	})
+	}
+	if cfg.L1Size > 0 {
+		r.l1 = newLocal(cfg.L1Size, min(cfg.L1TTL, cfg.TTL))
+		opt.OnInvalidations = r.onInvalidations
+	}
+	raw, err := rueidis.NewClient(opt)
 	if err != nil {
 		return nil, fmt.Errorf("create redis client: %w", err)
 	}
+	client := newTracedClient(raw, cfg)
 
 	if err := client.Do(ctx, client.B().Ping().Build()).Error(); err != nil {
 		client.Close()
 		return nil, fmt.Errorf("ping redis: %w", err)
 	}
 
-	return new(RedisCache{client: client, ttl: cfg.TTL}), nil
+	r.client = client
+	return r, nil
 }
 
-func (r *RedisCache) Set(ctx context.Context, key string, value entity.Product) error {
-	data, err := json.Marshal(cacheEntry{
-		ID:   value.ID.String(),
-		Name: value.Name,
-		Price: moneyEntry{
-			MinorAmount: value.Price.MinorAmount,
-			Currency:    value.Price.Currency,
-		},
-	})
+// Set stores value under key in the namespace of the tenant in ctx, fresh for the TTL of the
+// cache. delta is how long value took to load.
+func (r *RedisCache) Set(ctx context.Context, key string, value entity.Product, delta time.Duration) error {
+	cmd, err := r.setCmd(ctx, key, value, delta)
+	if err != nil {
+		return err
+	}
+	err = r.client.Do(ctx, cmd).Error()
+	// The invalidation Redis pushes for our own write may arrive after a read that follows it.
+	r.evictL1(ctx, key)
+	if err != nil {
+		return fmt.Errorf("set cache key %q: %w", key, err)
+	}
+	return nil
+}
+
+// setCmd builds the SET of value under key.
+func (r *RedisCache) setCmd(ctx context.Context, key string, value entity.Product, delta time.Duration,
+) (rueidis.Completed, error) {
+	e := newCacheEntry(value)
+	e.Expiry, e.Delta = time.Now().Add(r.ttl).UnixMilli(), delta.Milliseconds()
+	data, err := marshalVersioned(r.codec, e)
 	if err != nil {
-		return fmt.Errorf("marshal cache value for key %q: %w", key, err)
+		return rueidis.Completed{}, fmt.Errorf("marshal cache value for key %q: %w", key, err)
 	}
-	cmd := r.client.B().Set().Key(key).
+	return r.client.B().Set().Key(TenantKey(ctx, key)).
 		Value(rueidis.BinaryString(data)).
-		PxMilliseconds(r.ttl.Milliseconds()).
+		PxMilliseconds(r.hardTTL.Milliseconds()).
+		Build(), nil
+}
+
+// SetMissing caches key as missing in the namespace of the tenant in ctx, so lookups of
+// products that do not exist stop reaching the database until the tombstone expires or Set
+// replaces it. It does nothing when tombstones are disabled.
+func (r *RedisCache) SetMissing(ctx context.Context, key string) error {
+	if r.tombstoneTTL <= 0 {
+		return nil
+	}
+	err := r.client.Do(ctx, r.tombstoneCmd(ctx, key)).Error()
+	r.evictL1(ctx, key)
+	if err != nil {
+		return fmt.Errorf("set tombstone for cache key %q: %w", key, err)
+	}
+	return nil
+}
+
+func (r *RedisCache) tombstoneCmd(ctx context.Context, key string) rueidis.Completed {
+	return r.client.B().Set().Key(TenantKey(ctx, key)).
+		Value(tombstone).
+		PxMilliseconds(r.tombstoneTTL.Milliseconds()).
 		Build()
-	if err := r.client.Do(ctx, cmd).Error(); err != nil {
-		return fmt.Errorf("set cache key %q: %w", key, err)
+}
+
+// SetMany stores products, and tombstones for the missing keys unless they are disabled, in
+// the namespace of the tenant in ctx with a single pipeline, as Set and SetMissing do. delta
+// is how long they took to load. It returns how many were stored; on failure the error tells
+// how many were not.
+func (r *RedisCache) SetMany(ctx context.Context, products []entity.Product, missing []string,
+	delta time.Duration,
+) (int, error) {
+	keys := make([]string, 0, len(products)+len(missing))
+	cmds := make(rueidis.Commands, 0, len(products)+len(missing))
+	for _, p := range products {
+		key := p.ID.String()
+		cmd, err := r.setCmd(ctx, key, p, delta)
+		if err != nil {
+			return 0, err
+		}
+		keys, cmds = append(keys, key), append(cmds, cmd)
+	}
+	if r.tombstoneTTL > 0 {
+		for _, key := range missing {
+			keys, cmds = append(keys, key), append(cmds, r.tombstoneCmd(ctx, key))
+		}
+	}
+	if len(cmds) == 0 {
+		return 0, nil
+	}
+	var (
+		stored int
+		errs   []error
+	)
+	for i, res := range r.client.DoMulti(ctx, cmds...) {
+		if err := res.Error(); err != nil {
+			errs = append(errs, err)
+		} else {
+			stored++
+		}
+		r.evictL1(ctx, keys[i])
+	}
+	if len(errs) > 0 {
+		return stored, fmt.Errorf("set %d of %d cache keys: %w", len(errs), len(cmds), errs[0])
+	}
+	return stored, nil
+}
+
+// Get reads key from the namespace of the tenant in ctx, from process memory when the L1
+// tier holds it, and marks the entry for refresh when it is stale or picked to be refreshed
+// early.
+func (r *RedisCache) Get(ctx context.Context, key string) (Entry, error) {
+	e, err := r.get(ctx, key)
+	if r.reads != nil && (err == nil || errors.Is(err, ErrCacheMiss)) {
+		r.reads.add(TenantKey(ctx, key))
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Decide(time.Now(), r.beta)
+	return e, nil
+}
+
+func (r *RedisCache) get(ctx context.Context, key string) (Entry, error) {
+	if r.l1 == nil {
+		return r.decode(key, r.client.Do(ctx, r.client.B().Get().Key(TenantKey(ctx, key)).Build()))
+	}
+	tk := TenantKey(ctx, key)
+	if e, ok := r.l1.get(tk); ok {
+		r.l1Hits.Add(1)
+		return e, nil
+	}
+	r.l1Misses.Add(1)
+	epoch := r.l1.epoch(tk)
+	// CLIENT CACHING YES asks Redis to track the key read by the command that follows it on the
+	// same connection, which DoMulti keeps them on.
+	res := r.client.DoMulti(ctx,
+		r.client.B().ClientCaching().Yes().Build(),
+		r.client.B().Get().Key(tk).Build(),
+	)
+	if err := res[0].Error(); err != nil {
+		return Entry{}, fmt.Errorf("track cache key %q: %w", key, err)
+	}
+	e, err := r.decode(key, res[1])
+	if err == nil {
+		r.l1.add(tk, e, epoch)
+	}
+	return e, err
+}
+
+// GetMany reads keys like Get, in a single MGET for those the L1 tier does not hold, and
+// answers in their order. The error is set when none could be read.
+func (r *RedisCache) GetMany(ctx context.Context, keys []string) ([]Result, error) {
+	results := make([]Result, len(keys))
+	tks := make([]string, len(keys))
+	var pending []int
+	for i, key := range keys {
+		tks[i] = TenantKey(ctx, key)
+		if r.l1 != nil {
+			if e, ok := r.l1.get(tks[i]); ok {
+				r.l1Hits.Add(1)
+				results[i].Entry = e
+				continue
+			}
+			r.l1Misses.Add(1)
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) > 0 {
+		if err := r.mget(ctx, keys, tks, pending, results); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	for i := range results {
+		res := &results[i]
+		if r.reads != nil && (res.Err == nil || errors.Is(res.Err, ErrCacheMiss)) {
+			r.reads.add(tks[i])
+		}
+		if res.Err == nil {
+			res.Entry.Decide(now, r.beta)
+		}
+	}
+	return results, nil
+}
+
+// mget reads the keys at the pending indexes into results, tracking them for the L1 tier when
+// it is enabled. tks are the keys in the namespace of the tenant.
+func (r *RedisCache) mget(ctx context.Context, keys, tks []string, pending []int, results []Result) error {
+	mkeys := make([]string, len(pending))
+	epochs := make([]uint64, len(pending))
+	for j, i := range pending {
+		mkeys[j] = tks[i]
+		if r.l1 != nil {
+			epochs[j] = r.l1.epoch(tks[i])
+		}
+	}
+	mget := r.client.B().Mget().Key(mkeys...).Build()
+	var res rueidis.RedisResult
+	if r.l1 == nil {
+		res = r.client.Do(ctx, mget)
+	} else {
+		multi := r.client.DoMulti(ctx, r.client.B().ClientCaching().Yes().Build(), mget)
+		if err := multi[0].Error(); err != nil {
+			return fmt.Errorf("track %d cache keys: %w", len(mkeys), err)
+		}
+		res = multi[1]
+	}
+	msgs, err := res.ToArray()
+	if err != nil {
+		return fmt.Errorf("get %d cache keys: %w", len(mkeys), err)
+	}
+	if len(msgs) != len(mkeys) {
+		return fmt.Errorf("get %d cache keys: got %d values", len(mkeys), len(msgs))
+	}
+	for j, i := range pending {
+		e, err := r.decode(keys[i], &msgs[j])
+		results[i] = Result{Entry: e, Err: err}
+		if err == nil && r.l1 != nil {
+			r.l1.add(tks[i], e, epochs[j])
+		}
 	}
 	return nil
 }
 
-func (r *RedisCache) Get(ctx context.Context, key string) (entity.Product, error) {
-	data, err := r.client.Do(ctx, r.client.B().Get().Key(key).Build()).AsBytes()
+// decode decodes the reply to a GET of key, or the value of key in the reply to an MGET.
+func (r *RedisCache) decode(key string, res interface{ AsBytes() ([]byte, error) }) (Entry, error) {
+	data, err := res.AsBytes()
 	if err != nil {
 		if rueidis.IsRedisNil(err) {
-			return entity.Product{}, ErrCacheMiss
+			r.redisMisses.Add(1)
+			return Entry{}, ErrCacheMiss
 		}
-		return entity.Product{}, fmt.Errorf("get cache key %q: %w", key, err)
+		return Entry{}, fmt.Errorf("get cache key %q: %w", key, err)
+	}
+	if string(data) == tombstone {
+		r.tombstoneHits.Add(1)
+		return Entry{}, ErrTombstone
 	}
 	var e cacheEntry
-	if err := json.Unmarshal(data, &e); err != nil {
-		return entity.Product{}, fmt.Errorf("unmarshal cache value for key %q: %w", key, err)
+	if err := unmarshalVersioned(data, &e); err != nil {
+		if errors.Is(err, errUnknownVersion) {
+			r.redisMisses.Add(1)
+			return Entry{}, ErrCacheMiss
+		}
+		return Entry{}, fmt.Errorf("unmarshal cache value for key %q: %w", key, err)
 	}
+	r.redisHits.Add(1)
+	p, err := e.product()
+	if err != nil {
+		return Entry{}, fmt.Errorf("decode cache value for key %q: %w", key, err)
+	}
+	return Entry{
+		Product: p,
+		Expiry:  time.UnixMilli(e.Expiry),
+		Delta:   time.Duration(e.Delta) * time.Millisecond,
+	}, nil
+}
+
+// newCacheEntry keeps the identity, name and price of p.
+func newCacheEntry(p entity.Product) cacheEntry {
+	return cacheEntry{
+		ID:   p.ID.String(),
+		Name: p.Name,
+		Price: moneyEntry{
+			MinorAmount: p.Price.MinorAmount,
+			Currency:    p.Price.Currency,
+		},
+	}
+}
+
+func (e cacheEntry) product() (entity.Product, error) {
 	id, err := uuid.Parse(e.ID)
 	if err != nil {
-		return entity.Product{}, fmt.Errorf("parse cached id for key %q: %w", key, err)
+		return entity.Product{}, fmt.Errorf("parse cached id: %w", err)
 	}
 	return entity.Product{
 		ID:   id,
@@ -100,16 +402,60 @@ func (r *RedisCache) Get(ctx context.Context, key string) (entity.Product, error
 }
 
 func (r *RedisCache) Invalidate(ctx context.Context, key string) error {
-	if err := r.client.Do(ctx, r.client.B().Del().Key(key).Build()).Error(); err != nil {
+	err := r.client.Do(ctx, r.client.B().Del().Key(TenantKey(ctx, key)).Build()).Error()
+	r.evictL1(ctx, key)
+	if err != nil {
 		return fmt.Errorf("invalidate cache key %q: %w", key, err)
 	}
 	return nil
 }
 
+// Stats reports the hits and misses of each tier for metrics.
+func (r *RedisCache) Stats() Stats {
+	s := Stats{
+		L1Hits:        r.l1Hits.Load(),
+		L1Misses:      r.l1Misses.Load(),
+		RedisHits:     r.redisHits.Load(),
+		RedisMisses:   r.redisMisses.Load(),
+		TombstoneHits: r.tombstoneHits.Load(),
+	}
+	if r.l1 != nil {
+		s.L1Entries = r.l1.len()
+	}
+	return s
+}
+
+func (r *RedisCache) evictL1(ctx context.Context, key string) {
+	if r.l1 != nil {
+		r.l1.invalidate([]string{TenantKey(ctx, key)})
+	}
+}
+
+// onInvalidations evicts the keys Redis reports changed, or the whole L1 tier when it
+// reports none, as it does on FLUSHALL and when the connection drops and tracking is lost.
+func (r *RedisCache) onInvalidations(msgs []rueidis.RedisMessage) {
+	if msgs == nil {
+		r.l1.invalidate(nil)
+		return
+	}
+	keys := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		if key, err := m.ToString(); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	r.l1.invalidate(keys)
+}
+
 func (r *RedisCache) Ping(ctx context.Context) error {
 	return r.client.Do(ctx, r.client.B().Ping().Build()).Error()
 }
 
+// Client returns the client of the cache, to share its connections with other users of Redis.
+func (r *RedisCache) Client() rueidis.Client {
+	return r.client
+}
+
 func (r *RedisCache) Close() {
 	r.client.Close()
 }
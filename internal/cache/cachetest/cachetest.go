@@ -0,0 +1,415 @@
+// Package cachetest is the conformance suite every product cache must pass, so the Redis
+// cache and the in-memory stand-in keep the same semantics.
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/cache"
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// Cache is the cache surface the suite exercises.
+type Cache interface {
+	Set(context.Context, string, entity.Product, time.Duration) error
+	SetMissing(context.Context, string) error
+	SetMany(context.Context, []entity.Product, []string, time.Duration) (int, error)
+	Get(context.Context, string) (cache.Entry, error)
+	GetMany(context.Context, []string) ([]cache.Result, error)
+	Invalidate(context.Context, string) error
+	GetPage(context.Context, string) (entity.ProductPage, error)
+	PageVersion(context.Context) (cache.PageVersion, error)
+	SetPage(context.Context, string, entity.ProductPage, cache.PageVersion) error
+	InvalidatePages(context.Context, ...string) error
+	FlushPages(context.Context) error
+}
+
+// TTLs of the caches the suite asks for, short enough to wait out.
+const (
+	ttl          = 100 * time.Millisecond
+	hardTTL      = 300 * time.Millisecond
+	tombstoneTTL = 100 * time.Millisecond
+)
+
+// newConfig returns the settings the suite runs a cache with.
+func newConfig() config.Redis {
+	return config.Redis{
+		TTL:          ttl,
+		HardTTL:      hardTTL,
+		TombstoneTTL: tombstoneTTL,
+		PageTTL:      time.Minute,
+		Codec:        cache.MsgPack.Name(),
+	}
+}
+
+// Run runs the suite. newCache must return an empty cache configured by cfg, which it may
+// complete with how to reach the cache, for every subtest.
+func Run(t *testing.T, newCache func(t *testing.T, cfg config.Redis) Cache) {
+	tests := []struct {
+		name string
+		// tune adjusts newConfig for the case; nil keeps it.
+		tune func(*config.Redis)
+		fn   func(*testing.T, Cache)
+	}{
+		{"Miss", nil, testMiss},
+		{"SetAndGet", nil, testSetAndGet},
+		{"Tombstone", nil, testTombstone},
+		{"TombstonesDisabled", func(cfg *config.Redis) { cfg.TombstoneTTL = 0 }, testTombstonesDisabled},
+		{"Expiry", nil, testExpiry},
+		{"GetMany", nil, testGetMany},
+		{"SetMany", nil, testSetMany},
+		{"Pages", nil, testPages},
+		{"PageVersion", nil, testPageVersion},
+		{"FlushPages", nil, testFlushPages},
+		{"PagesDisabled", func(cfg *config.Redis) { cfg.PageTTL = 0 }, testPagesDisabled},
+		{"TenantIsolation", nil, testTenantIsolation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig()
+			if tt.tune != nil {
+				tt.tune(&cfg)
+			}
+			tt.fn(t, newCache(t, cfg))
+		})
+	}
+}
+
+func newProduct(name string) entity.Product {
+	return entity.Product{
+		ID:    uuid.Must(uuid.NewV7()),
+		Name:  name,
+		Price: entity.Money{MinorAmount: 100, Currency: entity.CurrencyPLN},
+	}
+}
+
+func set(t *testing.T, c Cache, ctx context.Context, ps ...entity.Product) {
+	t.Helper()
+	for _, p := range ps {
+		if err := c.Set(ctx, p.ID.String(), p, 20*time.Millisecond); err != nil {
+			t.Fatalf("failed to set %s: %v", p.ID, err)
+		}
+	}
+}
+
+func setMissing(t *testing.T, c Cache, ctx context.Context, key string) {
+	t.Helper()
+	if err := c.SetMissing(ctx, key); err != nil {
+		t.Fatalf("failed to set tombstone for %s: %v", key, err)
+	}
+}
+
+// wantProduct fails unless key holds p.
+func wantProduct(t *testing.T, c Cache, ctx context.Context, key string, p entity.Product) cache.Entry {
+	t.Helper()
+	e, err := c.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("got error %v, want %s", err, key)
+	}
+	if e.Product != p {
+		t.Errorf("got %+v, want %+v", e.Product, p)
+	}
+	return e
+}
+
+// wantErr fails unless key holds nothing, as target tells: ErrCacheMiss or ErrTombstone.
+func wantErr(t *testing.T, c Cache, ctx context.Context, key string, target error) {
+	t.Helper()
+	if _, err := c.Get(ctx, key); !errors.Is(err, target) {
+		t.Errorf("got error %v for %s, want %v", err, key, target)
+	}
+}
+
+func testMiss(t *testing.T, c Cache) {
+	ctx := t.Context()
+	wantErr(t, c, ctx, uuid.NewString(), cache.ErrCacheMiss)
+	if _, err := c.GetPage(ctx, "a"); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Errorf("got error %v for a page, want ErrCacheMiss", err)
+	}
+}
+
+func testSetAndGet(t *testing.T, c Cache) {
+	ctx := t.Context()
+	p := newProduct("Car")
+
+	before := time.Now()
+	set(t, c, ctx, p)
+	after := time.Now()
+	e := wantProduct(t, c, ctx, p.ID.String(), p)
+	if e.Delta != 20*time.Millisecond {
+		t.Errorf("got delta %v, want 20ms", e.Delta)
+	}
+	// Redis keeps the expiry to the millisecond.
+	if e.Expiry.Before(before.Add(ttl-time.Millisecond)) || e.Expiry.After(after.Add(ttl)) {
+		t.Errorf("got expiry %v, want %v after the set", e.Expiry, ttl)
+	}
+	if e.Refresh {
+		t.Error("got a fresh entry marked for refresh")
+	}
+
+	if err := c.Invalidate(ctx, p.ID.String()); err != nil {
+		t.Fatalf("failed to invalidate: %v", err)
+	}
+	wantErr(t, c, ctx, p.ID.String(), cache.ErrCacheMiss)
+}
+
+func testTombstone(t *testing.T, c Cache) {
+	ctx := t.Context()
+	p := newProduct("Car")
+	key := p.ID.String()
+
+	setMissing(t, c, ctx, key)
+	wantErr(t, c, ctx, key, cache.ErrTombstone)
+
+	set(t, c, ctx, p)
+	wantProduct(t, c, ctx, key, p)
+
+	setMissing(t, c, ctx, key)
+	wantErr(t, c, ctx, key, cache.ErrTombstone)
+	if err := c.Invalidate(ctx, key); err != nil {
+		t.Fatalf("failed to invalidate: %v", err)
+	}
+	wantErr(t, c, ctx, key, cache.ErrCacheMiss)
+}
+
+func testTombstonesDisabled(t *testing.T, c Cache) {
+	ctx := t.Context()
+	key := uuid.NewString()
+
+	setMissing(t, c, ctx, key)
+	wantErr(t, c, ctx, key, cache.ErrCacheMiss)
+
+	n, err := c.SetMany(ctx, []entity.Product{newProduct("Car")}, []string{key}, 0)
+	if err != nil || n != 1 {
+		t.Errorf("got %d stored, %v, want the product only", n, err)
+	}
+	wantErr(t, c, ctx, key, cache.ErrCacheMiss)
+}
+
+func testExpiry(t *testing.T, c Cache) {
+	ctx := t.Context()
+	p := newProduct("Car")
+	missing := uuid.NewString()
+	set(t, c, ctx, p)
+	setMissing(t, c, ctx, missing)
+
+	// Past the TTL the product is stale and served for a refresh; the tombstone is gone.
+	time.Sleep(ttl + 50*time.Millisecond)
+	e := wantProduct(t, c, ctx, p.ID.String(), p)
+	if !e.Stale(time.Now()) || !e.Refresh {
+		t.Errorf("got %+v, want a stale entry marked for refresh", e)
+	}
+	wantErr(t, c, ctx, missing, cache.ErrCacheMiss)
+
+	// Past the hard TTL it is dropped.
+	time.Sleep(hardTTL - ttl)
+	wantErr(t, c, ctx, p.ID.String(), cache.ErrCacheMiss)
+}
+
+func testGetMany(t *testing.T, c Cache) {
+	ctx := t.Context()
+	a, d := newProduct("Car"), newProduct("Bike")
+	missing, unknown := uuid.NewString(), uuid.NewString()
+	set(t, c, ctx, a, d)
+	setMissing(t, c, ctx, missing)
+
+	keys := []string{unknown, a.ID.String(), missing, d.ID.String(), a.ID.String()}
+	results, err := c.GetMany(ctx, keys)
+	if err != nil {
+		t.Fatalf("failed to get many: %v", err)
+	}
+	if len(results) != len(keys) {
+		t.Fatalf("got %d results, want %d", len(results), len(keys))
+	}
+	wantErrs := []error{cache.ErrCacheMiss, nil, cache.ErrTombstone, nil, nil}
+	wantProducts := []entity.Product{{}, a, {}, d, a}
+	for i, res := range results {
+		if !errors.Is(res.Err, wantErrs[i]) {
+			t.Errorf("result %d: got error %v, want %v", i, res.Err, wantErrs[i])
+			continue
+		}
+		if res.Entry.Product != wantProducts[i] {
+			t.Errorf("result %d: got %+v, want %+v", i, res.Entry.Product, wantProducts[i])
+		}
+	}
+
+	if results, err := c.GetMany(ctx, nil); err != nil || len(results) != 0 {
+		t.Errorf("got %v, %v for no keys, want nothing", results, err)
+	}
+}
+
+func testSetMany(t *testing.T, c Cache) {
+	ctx := t.Context()
+	a, b := newProduct("Car"), newProduct("Bike")
+	missing := uuid.NewString()
+
+	n, err := c.SetMany(ctx, []entity.Product{a, b}, []string{missing}, 20*time.Millisecond)
+	if err != nil || n != 3 {
+		t.Fatalf("got %d stored, %v, want 3", n, err)
+	}
+	wantProduct(t, c, ctx, a.ID.String(), a)
+	if e := wantProduct(t, c, ctx, b.ID.String(), b); e.Delta != 20*time.Millisecond {
+		t.Errorf("got delta %v, want 20ms", e.Delta)
+	}
+	wantErr(t, c, ctx, missing, cache.ErrTombstone)
+
+	if n, err := c.SetMany(ctx, nil, nil, 0); err != nil || n != 0 {
+		t.Errorf("got %d stored, %v for nothing, want 0", n, err)
+	}
+}
+
+func newPage(hasMore bool, names ...string) entity.ProductPage {
+	page := entity.ProductPage{HasMore: hasMore, Items: []entity.Product{}}
+	for _, name := range names {
+		page.Items = append(page.Items, newProduct(name))
+	}
+	return page
+}
+
+func version(t *testing.T, c Cache, ctx context.Context) cache.PageVersion {
+	t.Helper()
+	v, err := c.PageVersion(ctx)
+	if err != nil {
+		t.Fatalf("failed to get page version: %v", err)
+	}
+	return v
+}
+
+func setPage(t *testing.T, c Cache, ctx context.Context, key string, page entity.ProductPage,
+	v cache.PageVersion,
+) {
+	t.Helper()
+	if err := c.SetPage(ctx, key, page, v); err != nil {
+		t.Fatalf("failed to set page %s: %v", key, err)
+	}
+}
+
+func wantPage(t *testing.T, c Cache, ctx context.Context, key string, want entity.ProductPage) {
+	t.Helper()
+	got, err := c.GetPage(ctx, key)
+	if err != nil {
+		t.Fatalf("got error %v for page %s, want it", err, key)
+	}
+	if got.HasMore != want.HasMore || len(got.Items) != len(want.Items) {
+		t.Fatalf("page %s: got %+v, want %+v", key, got, want)
+	}
+	for i := range want.Items {
+		if got.Items[i] != want.Items[i] {
+			t.Errorf("page %s item %d: got %+v, want %+v", key, i, got.Items[i], want.Items[i])
+		}
+	}
+}
+
+func wantPageMiss(t *testing.T, c Cache, ctx context.Context, key string) {
+	t.Helper()
+	if _, err := c.GetPage(ctx, key); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Errorf("got error %v for page %s, want ErrCacheMiss", err, key)
+	}
+}
+
+func testPages(t *testing.T, c Cache) {
+	ctx := t.Context()
+	first, last := newPage(true, "Car", "Bike"), newPage(false, "Boat")
+	setPage(t, c, ctx, "first", first, version(t, c, ctx))
+	setPage(t, c, ctx, "last", last, version(t, c, ctx))
+	wantPage(t, c, ctx, "first", first)
+	wantPage(t, c, ctx, "last", last)
+
+	// A product created can only land on the last page.
+	if err := c.InvalidatePages(ctx, cache.TagTail); err != nil {
+		t.Fatalf("failed to invalidate pages: %v", err)
+	}
+	wantPageMiss(t, c, ctx, "last")
+	wantPage(t, c, ctx, "first", first)
+
+	if err := c.InvalidatePages(ctx, cache.ProductTag(first.Items[1].ID)); err != nil {
+		t.Fatalf("failed to invalidate pages: %v", err)
+	}
+	wantPageMiss(t, c, ctx, "first")
+
+	empty := newPage(false)
+	setPage(t, c, ctx, "empty", empty, version(t, c, ctx))
+	wantPage(t, c, ctx, "empty", empty)
+}
+
+func testPageVersion(t *testing.T, c Cache) {
+	ctx := t.Context()
+	page, other := newPage(true, "Car"), newPage(true, "Bike")
+
+	// A fill read before an invalidation of one of its tags is dropped; others are not.
+	v := version(t, c, ctx)
+	if err := c.InvalidatePages(ctx, cache.ProductTag(page.Items[0].ID)); err != nil {
+		t.Fatalf("failed to invalidate pages: %v", err)
+	}
+	if next := version(t, c, ctx); next.Seq <= v.Seq {
+		t.Errorf("got version %d after an invalidation, want past %d", next.Seq, v.Seq)
+	}
+	setPage(t, c, ctx, "a", page, v)
+	wantPageMiss(t, c, ctx, "a")
+	setPage(t, c, ctx, "b", other, v)
+	wantPage(t, c, ctx, "b", other)
+
+	setPage(t, c, ctx, "a", page, version(t, c, ctx))
+	wantPage(t, c, ctx, "a", page)
+
+	// A fill too old to be sure it saw every invalidation is dropped.
+	v = version(t, c, ctx)
+	v.At = v.At.Add(-cache.PageFillTimeout)
+	setPage(t, c, ctx, "c", other, v)
+	wantPageMiss(t, c, ctx, "c")
+}
+
+func testFlushPages(t *testing.T, c Cache) {
+	ctx := t.Context()
+	page := newPage(true, "Car")
+
+	setPage(t, c, ctx, "a", page, version(t, c, ctx))
+	v := version(t, c, ctx)
+	if err := c.FlushPages(ctx); err != nil {
+		t.Fatalf("failed to flush pages: %v", err)
+	}
+	wantPageMiss(t, c, ctx, "a")
+	setPage(t, c, ctx, "b", page, v)
+	wantPageMiss(t, c, ctx, "b")
+
+	setPage(t, c, ctx, "a", page, version(t, c, ctx))
+	wantPage(t, c, ctx, "a", page)
+}
+
+func testPagesDisabled(t *testing.T, c Cache) {
+	ctx := t.Context()
+	setPage(t, c, ctx, "a", newPage(false, "Car"), version(t, c, ctx))
+	wantPageMiss(t, c, ctx, "a")
+}
+
+func testTenantIsolation(t *testing.T, c Cache) {
+	acme := reqctx.WithTenant(t.Context(), "acme")
+	other := reqctx.WithTenant(t.Context(), "globex")
+	p := newProduct("Car")
+	missing := uuid.NewString()
+	page := newPage(false, "Bike")
+
+	set(t, c, acme, p)
+	setMissing(t, c, acme, missing)
+	setPage(t, c, acme, "a", page, version(t, c, acme))
+
+	wantErr(t, c, other, p.ID.String(), cache.ErrCacheMiss)
+	wantErr(t, c, other, missing, cache.ErrCacheMiss)
+	wantPageMiss(t, c, other, "a")
+
+	// Invalidations and flushes of one tenant leave the pages of others.
+	if err := c.InvalidatePages(other, cache.TagTail); err != nil {
+		t.Fatalf("failed to invalidate pages: %v", err)
+	}
+	if err := c.FlushPages(other); err != nil {
+		t.Fatalf("failed to flush pages: %v", err)
+	}
+	wantPage(t, c, acme, "a", page)
+	wantProduct(t, c, acme, p.ID.String(), p)
+}
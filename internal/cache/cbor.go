@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string {
+	return "cbor"
+}
+
+func (cborCodec) Format() byte {
+	return formatCBOR
+}
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	data, err := cbor.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cbor: %w", err)
+	}
+	return data, nil
+}
+
+// Unmarshal fails on data following the record, like the other codecs.
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	if err := cbor.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("cbor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// schemaVersion is the version of the records the cache stores. Fields may be added without
+// bumping it, as every codec skips the fields it does not know; bump it for changes older
+// instances would misread, so they take the new entries for misses during a rolling deploy.
+const schemaVersion = 1
+
+// errUnknownVersion is returned by unmarshalVersioned for payloads of a codec or schema this
+// build does not read; they are treated as misses.
+var errUnknownVersion = errors.New("cache: unknown payload version")
+
+// Codec encodes the records the cache stores in Redis. Every payload starts with a byte holding
+// the Format of its codec in the high nibble and the schema version in the low one, so payloads
+// of every codec stay readable whichever one writes.
+type Codec interface {
+	// Name is how REDIS_CACHE_CODEC selects the codec.
+	Name() string
+	// Format identifies the codec in payloads, from 1 to 15.
+	Format() byte
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Every codec writes a record as a map keyed by the JSON names of its fields, so records read
+// alike in all of them and unknown fields are skipped.
+var (
+	// JSON encodes records with encoding/json.
+	JSON Codec = jsonCodec{}
+	// MsgPack encodes records as MessagePack maps with github.com/vmihailenco/msgpack.
+	MsgPack Codec = msgpackCodec{}
+	// CBOR encodes records as CBOR (RFC 8949) maps with github.com/fxamacker/cbor.
+	CBOR Codec = cborCodec{}
+)
+
+// Formats of the codecs.
+const (
+	formatJSON    = 1
+	formatMsgPack = 2
+	formatCBOR    = 3
+)
+
+// codecs holds the codec of each format.
+var codecs = [16]Codec{formatJSON: JSON, formatMsgPack: MsgPack, formatCBOR: CBOR}
+
+// CodecByName returns the codec called name.
+func CodecByName(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c != nil && c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cache codec %q", name)
+}
+
+// marshalVersioned encodes v with c behind the version byte.
+func marshalVersioned(c Codec, v any) ([]byte, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{c.Format()<<4 | schemaVersion}, data...), nil
+}
+
+// unmarshalVersioned decodes a payload of any known codec into v. Payloads of an unknown codec
+// or schema, including the bare JSON written before payloads were versioned, fail with
+// errUnknownVersion.
+func unmarshalVersioned(data []byte, v any) error {
+	if len(data) == 0 {
+		return errUnknownVersion
+	}
+	c := codecs[data[0]>>4]
+	if c == nil || data[0]&0x0f != schemaVersion {
+		return errUnknownVersion
+	}
+	return c.Unmarshal(data[1:], v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Format() byte {
+	return formatJSON
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+var allCodecs = []Codec{JSON, MsgPack, CBOR}
+
+func testEntry(name string) cacheEntry {
+	e := newCacheEntry(entity.Product{
+		ID:    uuid.MustParse("0190b7a4-4e3c-7cc1-9f2b-3b2c1d0e4f5a"),
+		Name:  name,
+		Price: entity.Money{MinorAmount: 129_999, Currency: entity.CurrencyPLN},
+	})
+	e.Expiry, e.Delta = 1_760_000_000_000, 42
+	return e
+}
+
+func testPage(n int) pageEntry {
+	p := pageEntry{HasMore: true, Seq: 7}
+	for i := range n {
+		p.Items = append(p.Items, testEntry(fmt.Sprintf("Product %d", i)))
+	}
+	return p
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	values := map[string]any{
+		"entry":          testEntry("Car"),
+		"long name":      testEntry(strings.Repeat("x", 300)),
+		"negative delta": cacheEntry{ID: "a", Price: moneyEntry{MinorAmount: math.MinInt64}, Delta: -40},
+		"extreme amount": cacheEntry{ID: "b", Price: moneyEntry{MinorAmount: math.MaxInt64}, Expiry: -1},
+		"page":           testPage(20),
+		"empty page":     pageEntry{Items: []cacheEntry{}},
+	}
+	for _, c := range allCodecs {
+		for name, v := range values {
+			t.Run(c.Name()+"/"+name, func(t *testing.T) {
+				data, err := marshalVersioned(c, v)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got := reflect.New(reflect.TypeOf(v))
+				if err := unmarshalVersioned(data, got.Interface()); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got.Elem().Interface(), v) {
+					t.Errorf("got %+v, want %+v", got.Elem().Interface(), v)
+				}
+			})
+		}
+	}
+}
+
+// TestCodecs_Encoding checks the binary codecs against encodings from their specifications.
+func TestCodecs_Encoding(t *testing.T) {
+	type record struct {
+		A int64  `json:"a"`
+		B string `json:"b"`
+		C bool   `json:"c,omitempty"`
+		D []bool `json:"d"`
+	}
+	v := record{A: -300, B: "hi", D: []bool{true}}
+	tests := []struct {
+		codec Codec
+		want  []byte
+	}{
+		{MsgPack, []byte{0x83, 0xa1, 'a', 0xd1, 0xfe, 0xd4, 0xa1, 'b', 0xa2, 'h', 'i', 0xa1, 'd', 0x91, 0xc3}},
+		{CBOR, []byte{0xa3, 0x61, 'a', 0x39, 0x01, 0x2b, 0x61, 'b', 0x62, 'h', 'i', 0x61, 'd', 0x81, 0xf5}},
+	}
+	for _, tt := range tests {
+		got, err := tt.codec.Marshal(v)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.codec.Name(), err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % x, want % x", tt.codec.Name(), got, tt.want)
+		}
+	}
+}
+
+// TestCodecs_SkipUnknownFields checks that a record written by a newer build, with fields this
+// one does not know, still reads.
+func TestCodecs_SkipUnknownFields(t *testing.T) {
+	want := testEntry("Car")
+	newer := struct {
+		ID      string       `json:"id"`
+		Tags    []string     `json:"tags"`
+		Name    string       `json:"name"`
+		Nested  []moneyEntry `json:"nested"`
+		Price   moneyEntry   `json:"price"`
+		Flagged bool         `json:"flagged"`
+		Expiry  int64        `json:"expiry"`
+		Weight  int64        `json:"weight"`
+		Delta   int64        `json:"delta"`
+	}{
+		ID:      want.ID,
+		Tags:    []string{"a", strings.Repeat("b", 40)},
+		Name:    want.Name,
+		Nested:  []moneyEntry{{MinorAmount: 1 << 40, Currency: "EUR"}},
+		Price:   want.Price,
+		Flagged: true,
+		Expiry:  want.Expiry,
+		Weight:  -70_000,
+		Delta:   want.Delta,
+	}
+	for _, c := range allCodecs {
+		data, err := c.Marshal(newer)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.Name(), err)
+		}
+		var got cacheEntry
+		if err := c.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.Name(), err)
+		}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", c.Name(), got, want)
+		}
+	}
+}
+
+func TestCodecs_UnknownVersion(t *testing.T) {
+	current, err := marshalVersioned(MsgPack, testEntry("Car"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newerSchema := bytes.Clone(current)
+	newerSchema[0]++
+	unknownFormat := bytes.Clone(current)
+	unknownFormat[0] = 0xf0 | schemaVersion
+
+	tests := map[string][]byte{
+		"empty":                {},
+		"unversioned json":     []byte(`{"id":"a","name":"Car"}`),
+		"newer schema":         newerSchema,
+		"unknown format":       unknownFormat,
+		"unversioned msgpack":  current[1:],
+		"unknown version only": {0xff},
+	}
+	for name, data := range tests {
+		var e cacheEntry
+		if err := unmarshalVersioned(data, &e); !errors.Is(err, errUnknownVersion) {
+			t.Errorf("%s: got %v, want errUnknownVersion", name, err)
+		}
+	}
+}
+
+func TestCodecs_Corrupt(t *testing.T) {
+	for _, c := range []Codec{MsgPack, CBOR} {
+		data, err := c.Marshal(testPage(3))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for n := range len(data) {
+			var p pageEntry
+			if err := c.Unmarshal(data[:n], &p); err == nil {
+				t.Errorf("%s: decoded a payload truncated to %d bytes", c.Name(), n)
+			}
+		}
+		var p pageEntry
+		if err := c.Unmarshal(append(data, 0), &p); err == nil {
+			t.Errorf("%s: decoded a payload with trailing data", c.Name())
+		}
+		// A length beyond the payload must fail rather than allocate it.
+		huge := []byte{0xdd, 0xff, 0xff, 0xff, 0xff}
+		if c == CBOR {
+			huge = []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		}
+		var items []cacheEntry
+		if err := c.Unmarshal(huge, &items); err == nil {
+			t.Errorf("%s: decoded an array longer than its payload", c.Name())
+		}
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	for _, c := range allCodecs {
+		if got, err := CodecByName(c.Name()); err != nil || got != c {
+			t.Errorf("got %v, %v for %q", got, err, c.Name())
+		}
+	}
+	if _, err := CodecByName("gob"); err == nil {
+		t.Error("expected an error for an unknown codec")
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	values := []struct {
+		name string
+		v    any
+	}{
+		{"entry", testEntry("Mountain bike, 29 inch, carbon frame")},
+		{"page50", testPage(50)},
+	}
+	for _, c := range allCodecs {
+		for _, v := range values {
+			data, err := c.Marshal(v.v)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			b.Run(c.Name()+"/"+v.name+"/marshal", func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := c.Marshal(v.v); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(data)), "bytes")
+			})
+			b.Run(c.Name()+"/"+v.name+"/unmarshal", func(b *testing.B) {
+				b.ReportAllocs()
+				target := reflect.New(reflect.TypeOf(v.v)).Interface()
+				for b.Loop() {
+					if err := c.Unmarshal(data, target); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(data)), "bytes")
+			})
+		}
+	}
+}
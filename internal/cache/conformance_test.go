@@ -0,0 +1,40 @@
+//go:build integration
+
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/alkmc/storefront/internal/cache"
+	"github.com/alkmc/storefront/internal/cache/cachetest"
+	"github.com/alkmc/storefront/internal/config"
+)
+
+// TestRedisCache_Conformance runs the suite the in-memory cache passes as well, emptying the
+// database before every case, with and without the L1 tier. It is in a package of its own
+// because cachetest imports cache.
+func TestRedisCache_Conformance(t *testing.T) {
+	addr := cache.StartRedis(t)
+	for _, tier := range []struct {
+		name   string
+		l1Size int
+	}{{"redis", 0}, {"l1", 100}} {
+		t.Run(tier.name, func(t *testing.T) {
+			cachetest.Run(t, func(t *testing.T, cfg config.Redis) cachetest.Cache {
+				t.Helper()
+				cfg.Host, cfg.Port = addr.Host, addr.Port
+				cfg.L1Size, cfg.L1TTL = tier.l1Size, cfg.TTL
+				r, err := cache.NewRedis(t.Context(), cfg)
+				if err != nil {
+					t.Fatalf("failed to create cache: %v", err)
+				}
+				t.Cleanup(r.Close)
+				client := r.Client()
+				if err := client.Do(t.Context(), client.B().Flushall().Build()).Error(); err != nil {
+					t.Fatalf("failed to flush redis: %v", err)
+				}
+				return r
+			})
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+// Values of Status.Fwd, from the Cache-Status header (RFC 9211).
+const (
+	// FwdMiss is a lookup the cache held nothing for.
+	FwdMiss = "uri-miss"
+	// FwdBypass is a lookup that did not consult the cache.
+	FwdBypass = "bypass"
+)
+
+type (
+	// Entry is a cached product with what decides when to refresh it.
+	Entry struct {
+		Product entity.Product
+		// Expiry is when Product goes stale. Stale entries are served, and refreshed, until the
+		// hard TTL of the cache drops them.
+		Expiry time.Time
+		// Delta is how long Product took to load; slow loads are refreshed earlier.
+		Delta time.Duration
+		// Refresh is set on the entries to refresh now: stale ones, and fresh ones picked for an
+		// early refresh with a chance that grows as their expiry nears.
+		Refresh bool
+	}
+	// Result is the answer of GetMany for one of its keys: an entry, or why there is none.
+	Result struct {
+		Entry Entry
+		// Err is ErrCacheMiss, ErrTombstone, or why the entry could not be read.
+		Err error
+	}
+	// Status tells how a lookup was answered, in the terms of the Cache-Status header.
+	Status struct {
+		// Hit is set when the cache answered, with a product or a tombstone.
+		Hit bool
+		// TTL is how long a product hit stays fresh, negative once it is stale; zero for others.
+		TTL time.Duration
+		// Fwd is why a lookup that did not hit went to the database: FwdMiss or FwdBypass.
+		Fwd string
+		// Stored is set when the loaded answer was cached.
+		Stored bool
+		// Collapsed is set when the answer was loaded by a concurrent lookup.
+		Collapsed bool
+	}
+)
+
+// Stale reports whether e has expired at now.
+func (e Entry) Stale(now time.Time) bool {
+	return !now.Before(e.Expiry)
+}
+
+// Decide sets e.Refresh by the XFetch algorithm of Vattani et al., "Optimal Probabilistic Cache
+// Stampede Prevention": each lookup refreshes with a probability that rises towards one at the
+// expiry, sooner for entries that are slow to load and with a larger beta, so a hot key is
+// refreshed by one lookup shortly before it goes stale rather than by all of them after.
+// A zero beta refreshes only stale entries.
+func (e *Entry) Decide(now time.Time, beta float64) {
+	if e.Stale(now) {
+		e.Refresh = true
+		return
+	}
+	// -ln(U) for U uniform in (0, 1] is exponentially distributed with mean one.
+	gap := time.Duration(float64(e.Delta) * beta * -math.Log(1-rand.Float64()))
+	e.Refresh = !now.Add(gap).Before(e.Expiry)
+}
@@ -0,0 +1,6 @@
+//go:build integration
+
+package cache
+
+// StartRedis lets the conformance run, which cannot be in this package, share its container.
+var StartRedis = startRedis
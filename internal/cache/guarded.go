@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/alkmc/storefront/internal/breaker"
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+// ErrBypassed is returned by the calls of a Guarded cache while its circuit breaker is open.
+var ErrBypassed = errors.New("cache: bypassed while its circuit breaker is open")
+
+type (
+	// guardedCache is the cache behind a Guarded.
+	guardedCache interface {
+		Set(context.Context, string, entity.Product, time.Duration) error
+		SetMissing(context.Context, string) error
+		SetMany(context.Context, []entity.Product, []string, time.Duration) (int, error)
+		Get(context.Context, string) (Entry, error)
+		GetMany(context.Context, []string) ([]Result, error)
+		Invalidate(context.Context, string) error
+		GetPage(context.Context, string) (entity.ProductPage, error)
+		PageVersion(context.Context) (PageVersion, error)
+		SetPage(context.Context, string, entity.ProductPage, PageVersion) error
+		InvalidatePages(context.Context, ...string) error
+		FlushPages(context.Context) error
+		Ping(context.Context) error
+	}
+	// Guarded puts a circuit breaker in front of a cache, so a cache that stalls or fails costs
+	// requests a call timeout until the breaker opens, and nothing while it is: calls then fail
+	// at once with ErrBypassed and callers go to the database.
+	Guarded struct {
+		next    guardedCache
+		breaker *breaker.Breaker
+		timeout time.Duration
+	}
+)
+
+// NewGuarded wraps next in a circuit breaker configured by cfg, logging its changes of state.
+func NewGuarded(l *slog.Logger, next guardedCache, cfg config.Breaker) *Guarded {
+	onChange := func(from, to breaker.State) {
+		switch to {
+		case breaker.Open:
+			l.Warn("cache circuit breaker opened, bypassing the cache", slog.String("from", from.String()))
+		case breaker.Closed:
+			l.Info("cache circuit breaker closed, cache recovered")
+		}
+	}
+	return new(Guarded{next: next, breaker: breaker.New(cfg, onChange), timeout: cfg.CallTimeout})
+}
+
+// BreakerState returns the state of the circuit breaker of g.
+func (g *Guarded) BreakerState() breaker.State {
+	return g.breaker.State()
+}
+
+// do runs fn through the breaker, with the call timeout. Misses are answers like any other.
+func (g *Guarded) do(ctx context.Context, fn func(context.Context) error) error {
+	done, err := g.breaker.Allow()
+	if err != nil {
+		return ErrBypassed
+	}
+	err = g.call(ctx, fn)
+	switch {
+	case err == nil, errors.Is(err, ErrCacheMiss), errors.Is(err, ErrTombstone):
+		done(breaker.Success)
+	case ctx.Err() != nil:
+		done(breaker.Ignored)
+	default:
+		done(breaker.Failure)
+	}
+	return err
+}
+
+func (g *Guarded) call(ctx context.Context, fn func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+func (g *Guarded) Set(ctx context.Context, key string, value entity.Product, delta time.Duration) error {
+	return g.do(ctx, func(ctx context.Context) error {
+		return g.next.Set(ctx, key, value, delta)
+	})
+}
+
+func (g *Guarded) SetMissing(ctx context.Context, key string) error {
+	return g.do(ctx, func(ctx context.Context) error {
+		return g.next.SetMissing(ctx, key)
+	})
+}
+
+func (g *Guarded) SetMany(ctx context.Context, products []entity.Product, missing []string,
+	delta time.Duration,
+) (int, error) {
+	var n int
+	err := g.do(ctx, func(ctx context.Context) error {
+		var err error
+		n, err = g.next.SetMany(ctx, products, missing, delta)
+		return err
+	})
+	return n, err
+}
+
+func (g *Guarded) Get(ctx context.Context, key string) (Entry, error) {
+	var e Entry
+	err := g.do(ctx, func(ctx context.Context) error {
+		var err error
+		e, err = g.next.Get(ctx, key)
+		return err
+	})
+	return e, err
+}
+
+func (g *Guarded) GetMany(ctx context.Context, keys []string) ([]Result, error) {
+	var results []Result
+	err := g.do(ctx, func(ctx context.Context) error {
+		var err error
+		results, err = g.next.GetMany(ctx, keys)
+		return err
+	})
+	return results, err
+}
+
+func (g *Guarded) GetPage(ctx context.Context, key string) (entity.ProductPage, error) {
+	var page entity.ProductPage
+	err := g.do(ctx, func(ctx context.Context) error {
+		var err error
+		page, err = g.next.GetPage(ctx, key)
+		return err
+	})
+	return page, err
+}
+
+func (g *Guarded) PageVersion(ctx context.Context) (PageVersion, error) {
+	var v PageVersion
+	err := g.do(ctx, func(ctx context.Context) error {
+		var err error
+		v, err = g.next.PageVersion(ctx)
+		return err
+	})
+	return v, err
+}
+
+func (g *Guarded) SetPage(ctx context.Context, key string, page entity.ProductPage, v PageVersion) error {
+	return g.do(ctx, func(ctx context.Context) error {
+		return g.next.SetPage(ctx, key, page, v)
+	})
+}
+
+// Invalidate is tried even while the breaker is open, with the call timeout: a cache that is
+// only slow must still drop what writes change, or it serves it once the breaker closes. The
+// same goes for the page invalidations.
+func (g *Guarded) Invalidate(ctx context.Context, key string) error {
+	return g.call(ctx, func(ctx context.Context) error {
+		return g.next.Invalidate(ctx, key)
+	})
+}
+
+func (g *Guarded) InvalidatePages(ctx context.Context, tags ...string) error {
+	return g.call(ctx, func(ctx context.Context) error {
+		return g.next.InvalidatePages(ctx, tags...)
+	})
+}
+
+func (g *Guarded) FlushPages(ctx context.Context) error {
+	return g.call(ctx, g.next.FlushPages)
+}
+
+// Ping fails with ErrBypassed while the breaker is open.
+func (g *Guarded) Ping(ctx context.Context) error {
+	return g.do(ctx, g.next.Ping)
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// epochStripes is how many invalidation counters keys are spread over; a fill is dropped when
+// any key of its stripe was invalidated while it was read from Redis.
+const epochStripes = 256
+
+type (
+	// local is the in-process tier of RedisCache: a bounded LRU of decoded entries. Entries
+	// come only from reads Redis tracks, so the invalidations it pushes keep them coherent.
+	local struct {
+		size int
+		ttl  time.Duration
+		seed maphash.Seed
+
+		mu      sync.Mutex
+		entries map[string]*localEntry
+		// head links the entries in a ring, the most recently used first.
+		head   localEntry
+		epochs [epochStripes]uint64
+	}
+	localEntry struct {
+		key        string
+		entry      Entry
+		expiresAt  time.Time
+		prev, next *localEntry
+	}
+)
+
+func newLocal(size int, ttl time.Duration) *local {
+	l := new(local{size: size, ttl: ttl, seed: maphash.MakeSeed(), entries: make(map[string]*localEntry, size)})
+	l.head.prev, l.head.next = &l.head, &l.head
+	return l
+}
+
+func (l *local) get(key string) (Entry, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	if !now.Before(e.expiresAt) {
+		l.remove(e)
+		return Entry{}, false
+	}
+	l.unlink(e)
+	l.pushFront(e)
+	return e.entry, true
+}
+
+// epoch returns the invalidation count of the stripe of key, to pass to add once the value
+// has been read.
+func (l *local) epoch(key string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epochs[l.stripe(key)]
+}
+
+// add stores entry under key unless the stripe of key was invalidated since epoch, evicting the
+// least recently used entries beyond the size.
+func (l *local) add(key string, entry Entry, epoch uint64) {
+	expiresAt := time.Now().Add(l.ttl)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.epochs[l.stripe(key)] != epoch {
+		return
+	}
+	if e, ok := l.entries[key]; ok {
+		l.remove(e)
+	}
+	e := new(localEntry{key: key, entry: entry, expiresAt: expiresAt})
+	l.entries[key] = e
+	l.pushFront(e)
+	for len(l.entries) > l.size {
+		l.remove(l.head.prev)
+	}
+}
+
+// invalidate drops keys, or every entry when keys is nil, as Redis asks when it loses track
+// of them, and fails the fills in flight for them.
+func (l *local) invalidate(keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if keys == nil {
+		clear(l.entries)
+		l.head.prev, l.head.next = &l.head, &l.head
+		for i := range l.epochs {
+			l.epochs[i]++
+		}
+		return
+	}
+	for _, key := range keys {
+		l.epochs[l.stripe(key)]++
+		if e, ok := l.entries[key]; ok {
+			l.remove(e)
+		}
+	}
+}
+
+func (l *local) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// The caller of the list operations below holds mu.
+
+func (l *local) remove(e *localEntry) {
+	delete(l.entries, e.key)
+	l.unlink(e)
+}
+
+func (l *local) unlink(e *localEntry) {
+	e.prev.next, e.next.prev = e.next, e.prev
+}
+
+func (l *local) pushFront(e *localEntry) {
+	e.prev, e.next = &l.head, l.head.next
+	l.head.next.prev = e
+	l.head.next = e
+}
+
+func (l *local) stripe(key string) int {
+	return int(maphash.String(l.seed, key) % epochStripes)
+}
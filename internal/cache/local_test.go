@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+func TestLocal_Evicts(t *testing.T) {
+	l := newLocal(2, time.Minute)
+	for _, key := range []string{"a", "b"} {
+		l.add(key, Entry{Product: entity.Product{Name: key}}, l.epoch(key))
+	}
+	if _, ok := l.get("a"); !ok {
+		t.Fatal("got a miss for a")
+	}
+	l.add("c", Entry{Product: entity.Product{Name: "c"}}, l.epoch("c"))
+
+	if _, ok := l.get("b"); ok {
+		t.Error("got b, want the least recently used entry evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if e, ok := l.get(key); !ok || e.Product.Name != key {
+			t.Errorf("got %+v, %t for %s, want it kept", e, ok, key)
+		}
+	}
+	if n := l.len(); n != 2 {
+		t.Errorf("got %d entries, want 2", n)
+	}
+}
+
+func TestLocal_Expires(t *testing.T) {
+	l := newLocal(10, time.Millisecond)
+	l.add("a", Entry{Product: entity.Product{ID: uuid.New()}}, l.epoch("a"))
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := l.get("a"); ok {
+		t.Error("got an expired entry")
+	}
+	if n := l.len(); n != 0 {
+		t.Errorf("got %d entries, want the expired one dropped", n)
+	}
+}
+
+func TestLocal_Invalidate(t *testing.T) {
+	l := newLocal(10, time.Minute)
+	l.add("a", Entry{}, l.epoch("a"))
+	l.add("b", Entry{}, l.epoch("b"))
+
+	l.invalidate([]string{"a"})
+	if _, ok := l.get("a"); ok {
+		t.Error("got an invalidated entry")
+	}
+	if _, ok := l.get("b"); !ok {
+		t.Error("got a miss for an entry not invalidated")
+	}
+
+	l.invalidate(nil)
+	if n := l.len(); n != 0 {
+		t.Errorf("got %d entries, want none after a flush", n)
+	}
+}
+
+func TestLocal_RacingFill(t *testing.T) {
+	l := newLocal(10, time.Minute)
+	epoch := l.epoch("a")
+	// Another instance changes the key while this one reads the old value from Redis.
+	l.invalidate([]string{"a"})
+	l.add("a", Entry{Product: entity.Product{Name: "stale"}}, epoch)
+	if p, ok := l.get("a"); ok {
+		t.Errorf("got %+v, want the fill that raced an invalidation dropped", p)
+	}
+
+	epoch = l.epoch("b")
+	l.invalidate(nil)
+	l.add("b", Entry{}, epoch)
+	if _, ok := l.get("b"); ok {
+		t.Error("got an entry filled across a flush")
+	}
+}
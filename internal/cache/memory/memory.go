@@ -0,0 +1,298 @@
+// Package memory is an in-process cache with the semantics of the Redis cache,
+// for tests and local development.
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alkmc/storefront/internal/cache"
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+)
+
+type (
+	entry struct {
+		entry     cache.Entry
+		expiresAt time.Time
+		// missing marks a tombstone.
+		missing bool
+	}
+	page struct {
+		page entity.ProductPage
+		// tags are the tenant keys of its tags, and seq the version it was read at.
+		tags      []string
+		seq       int64
+		expiresAt time.Time
+	}
+	// mark records the invalidation of a tag until fills that may have missed it are dropped.
+	mark struct {
+		seq       int64
+		expiresAt time.Time
+	}
+	Cache struct {
+		ttl          time.Duration
+		hardTTL      time.Duration
+		tombstoneTTL time.Duration
+		pageTTL      time.Duration
+		beta         float64
+
+		mu          sync.Mutex
+		entries     map[string]entry
+		pages       map[string]page
+		invalidated map[string]mark
+		// seqs and flushed hold the invalidation count of each tenant, and its value at the
+		// last flush of its pages.
+		seqs      map[string]int64
+		flushed   map[string]int64
+		lastSweep time.Time
+	}
+)
+
+// New returns an empty cache with the TTLs of the Redis cache configured by cfg: entries go
+// stale cfg.TTL after they are set and expire cfg.HardTTL after, and tombstones expire
+// cfg.TombstoneTTL after; a zero cfg.TombstoneTTL disables them. List pages are kept for
+// cfg.PageTTL.
+func New(cfg config.Redis) *Cache {
+	return new(Cache{
+		ttl:          cfg.TTL,
+		hardTTL:      max(cfg.HardTTL, cfg.TTL),
+		tombstoneTTL: cfg.TombstoneTTL,
+		pageTTL:      cfg.PageTTL,
+		beta:         cfg.XFetchBeta,
+		entries:      make(map[string]entry),
+		pages:        make(map[string]page),
+		invalidated:  make(map[string]mark),
+		seqs:         make(map[string]int64),
+		flushed:      make(map[string]int64),
+		lastSweep:    time.Now(),
+	})
+}
+
+// Set stores a copy of value under key in the namespace of the tenant in ctx. Like the Redis
+// cache it keeps only the identity, name and price, so a deletion time does not survive a
+// round trip.
+func (c *Cache) Set(ctx context.Context, key string, value entity.Product, delta time.Duration) error {
+	key = cache.TenantKey(ctx, key)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+	c.entries[key] = entry{
+		entry: cache.Entry{
+			Product: entity.Product{ID: value.ID, Name: value.Name, Price: value.Price},
+			Expiry:  now.Add(c.ttl),
+			Delta:   delta,
+		},
+		expiresAt: now.Add(c.hardTTL),
+	}
+	return nil
+}
+
+// SetMissing caches key as missing in the namespace of the tenant in ctx, like the Redis cache.
+func (c *Cache) SetMissing(ctx context.Context, key string) error {
+	if c.tombstoneTTL <= 0 {
+		return nil
+	}
+	key = cache.TenantKey(ctx, key)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+	c.entries[key] = entry{expiresAt: now.Add(c.tombstoneTTL), missing: true}
+	return nil
+}
+
+// Get reads key from the namespace of the tenant in ctx and marks the entry for refresh like
+// the Redis cache does.
+func (c *Cache) Get(ctx context.Context, key string) (cache.Entry, error) {
+	key = cache.TenantKey(ctx, key)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return cache.Entry{}, cache.ErrCacheMiss
+	}
+	if !now.Before(e.expiresAt) {
+		delete(c.entries, key)
+		return cache.Entry{}, cache.ErrCacheMiss
+	}
+	if e.missing {
+		return cache.Entry{}, cache.ErrTombstone
+	}
+	res := e.entry
+	res.Decide(now, c.beta)
+	return res, nil
+}
+
+// GetMany reads keys like Get, in their order.
+func (c *Cache) GetMany(ctx context.Context, keys []string) ([]cache.Result, error) {
+	results := make([]cache.Result, len(keys))
+	for i, key := range keys {
+		e, err := c.Get(ctx, key)
+		results[i] = cache.Result{Entry: e, Err: err}
+	}
+	return results, nil
+}
+
+// SetMany stores products, and tombstones for the missing keys, like Set and SetMissing do.
+func (c *Cache) SetMany(ctx context.Context, products []entity.Product, missing []string,
+	delta time.Duration,
+) (int, error) {
+	for _, p := range products {
+		if err := c.Set(ctx, p.ID.String(), p, delta); err != nil {
+			return 0, err
+		}
+	}
+	for _, key := range missing {
+		if err := c.SetMissing(ctx, key); err != nil {
+			return len(products), err
+		}
+	}
+	if c.tombstoneTTL <= 0 {
+		return len(products), nil
+	}
+	return len(products) + len(missing), nil
+}
+
+func (c *Cache) Invalidate(ctx context.Context, key string) error {
+	key = cache.TenantKey(ctx, key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+	return nil
+}
+
+// GetPage reads the page cached under key in the namespace of the tenant in ctx.
+func (c *Cache) GetPage(ctx context.Context, key string) (entity.ProductPage, error) {
+	tenant, key := reqctx.Tenant(ctx), cache.TenantKey(ctx, key)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.pages[key]
+	if !ok || !now.Before(p.expiresAt) || c.flushed[tenant] > p.seq {
+		delete(c.pages, key)
+		return entity.ProductPage{}, cache.ErrCacheMiss
+	}
+	return entity.ProductPage{Items: slices.Clone(p.page.Items), HasMore: p.page.HasMore}, nil
+}
+
+// PageVersion returns the version to pass to SetPage for a page about to be read.
+func (c *Cache) PageVersion(ctx context.Context) (cache.PageVersion, error) {
+	tenant := reqctx.Tenant(ctx)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cache.PageVersion{Seq: c.seqs[tenant], At: now}, nil
+}
+
+// SetPage caches page under key in the namespace of the tenant in ctx, unless its tags were
+// invalidated after v, like the Redis cache.
+func (c *Cache) SetPage(ctx context.Context, key string, pg entity.ProductPage, v cache.PageVersion) error {
+	if c.pageTTL <= 0 || time.Since(v.At) >= cache.PageFillTimeout {
+		return nil
+	}
+	tenant, key := reqctx.Tenant(ctx), cache.TenantKey(ctx, key)
+	tags := cache.PageTags(pg)
+	for i, tag := range tags {
+		tags[i] = cache.TenantKey(ctx, tag)
+	}
+	items := make([]entity.Product, 0, len(pg.Items))
+	for _, p := range pg.Items {
+		items = append(items, entity.Product{ID: p.ID, Name: p.Name, Price: p.Price})
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.flushed[tenant] > v.Seq {
+		return nil
+	}
+	for _, tag := range tags {
+		if m, ok := c.invalidated[tag]; ok && m.seq > v.Seq {
+			return nil
+		}
+	}
+	c.sweep(now)
+	c.pages[key] = page{
+		page:      entity.ProductPage{Items: items, HasMore: pg.HasMore},
+		tags:      tags,
+		seq:       v.Seq,
+		expiresAt: now.Add(c.pageTTL),
+	}
+	return nil
+}
+
+// InvalidatePages deletes the pages of the tenant in ctx carrying any of tags, and fails the
+// fills of such pages read before.
+func (c *Cache) InvalidatePages(ctx context.Context, tags ...string) error {
+	tenant := reqctx.Tenant(ctx)
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, cache.TenantKey(ctx, tag))
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seqs[tenant]++
+	for _, tag := range keys {
+		c.invalidated[tag] = mark{seq: c.seqs[tenant], expiresAt: now.Add(2 * cache.PageFillTimeout)}
+	}
+	for key, p := range c.pages {
+		if slices.ContainsFunc(p.tags, func(tag string) bool { return slices.Contains(keys, tag) }) {
+			delete(c.pages, key)
+		}
+	}
+	return nil
+}
+
+// FlushPages drops every page of the tenant in ctx.
+func (c *Cache) FlushPages(ctx context.Context) error {
+	tenant := reqctx.Tenant(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seqs[tenant]++
+	c.flushed[tenant] = c.seqs[tenant]
+	return nil
+}
+
+func (c *Cache) Ping(context.Context) error {
+	return nil
+}
+
+func (c *Cache) Close() {}
+
+// sweep drops expired entries at most once per TTL, so keys that are never read again
+// do not accumulate. The caller holds mu.
+func (c *Cache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	for key, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	for key, p := range c.pages {
+		if !now.Before(p.expiresAt) {
+			delete(c.pages, key)
+		}
+	}
+	for tag, m := range c.invalidated {
+		if !now.Before(m.expiresAt) {
+			delete(c.invalidated, tag)
+		}
+	}
+	c.lastSweep = now
+}
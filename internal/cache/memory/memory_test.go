@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/alkmc/storefront/internal/cache/cachetest"
+	"github.com/alkmc/storefront/internal/config"
+)
+
+func TestCache_Conformance(t *testing.T) {
+	cachetest.Run(t, func(_ *testing.T, cfg config.Redis) cachetest.Cache {
+		return New(cfg)
+	})
+}
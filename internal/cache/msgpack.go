@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Format() byte {
+	return formatMsgPack
+}
+
+// Marshal writes integers in their shortest form, which is how they read in JSON too, rather
+// than in the fixed width of their Go type.
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("msgpack: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal reads fields by their json names, as Marshal writes them, and fails on data
+// following the record.
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("msgpack: %w", err)
+	}
+	if r.Len() != 0 {
+		return errors.New("msgpack: trailing data after payload")
+	}
+	return nil
+}
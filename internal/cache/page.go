@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+)
+
+// TagTail tags the last page of a listing. Product ids are time-ordered, so a product created
+// can only appear on pages that had no more after them.
+const TagTail = "tail"
+
+// PageFillTimeout bounds how long after its PageVersion a page may be stored. Invalidations
+// are remembered for twice as long, so an older fill could miss them and is dropped instead.
+const PageFillTimeout = 30 * time.Second
+
+type (
+	// PageVersion is the state of the page invalidations of a tenant before a page is read
+	// from the database; SetPage drops the page when any of its tags was invalidated since.
+	PageVersion struct {
+		// Seq counts the invalidations of the tenant.
+		Seq int64
+		// At is when Seq was read.
+		At time.Time
+	}
+	pageEntry struct {
+		Items   []cacheEntry `json:"items"`
+		HasMore bool         `json:"hasMore,omitempty"`
+		// Seq is the PageVersion the page was read at, to tell pages flushed after it.
+		Seq int64 `json:"seq"`
+	}
+)
+
+// ProductTag tags the pages listing the product of id.
+func ProductTag(id uuid.UUID) string {
+	return "product:" + id.String()
+}
+
+// PageTags returns the tags of page: one per product it lists, and TagTail when it is the last.
+func PageTags(page entity.ProductPage) []string {
+	tags := make([]string, 0, len(page.Items)+1)
+	for _, p := range page.Items {
+		tags = append(tags, ProductTag(p.ID))
+	}
+	if !page.HasMore {
+		tags = append(tags, TagTail)
+	}
+	return tags
+}
+
+// Keys of the page cache of a tenant, under its namespace.
+const (
+	pagePrefix    = "page:"
+	pageSeqKey    = "pages:seq"
+	pageFlushKey  = "pages:flushed"
+	pageInvPrefix = "pages:inv:"
+	pageTagPrefix = "pages:tag:"
+)
+
+// fillPageScript stores a page unless the pages of the tenant were flushed or any of its n
+// tags invalidated after the version it was read at, and adds it to the sets of its tags.
+// KEYS are the page, the flush marker, the n invalidation markers and the n tag sets; ARGV
+// the version, the page, its TTL in milliseconds and n.
+var fillPageScript = rueidis.NewLuaScript(`
+local seq = tonumber(ARGV[1])
+local n = tonumber(ARGV[4])
+for i = 2, 2 + n do
+	if tonumber(redis.call('GET', KEYS[i]) or '0') > seq then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+for i = 3 + n, 2 + 2 * n do
+	redis.call('SADD', KEYS[i], KEYS[1])
+	redis.call('PEXPIRE', KEYS[i], ARGV[3])
+end
+return 1
+`)
+
+// invalidatePagesScript bumps the invalidation counter of a tenant, marks each tag with it so
+// fills read before fail, and deletes the pages of each tag. KEYS are the counter followed by
+// the invalidation marker and the set of each tag; ARGV how long markers are kept.
+var invalidatePagesScript = rueidis.NewLuaScript(`
+local seq = redis.call('INCR', KEYS[1])
+for i = 2, #KEYS, 2 do
+	redis.call('SET', KEYS[i], seq, 'PX', ARGV[1])
+	for _, page in ipairs(redis.call('SMEMBERS', KEYS[i + 1])) do
+		redis.call('DEL', page)
+	end
+	redis.call('DEL', KEYS[i + 1])
+end
+return seq
+`)
+
+// flushPagesScript bumps the invalidation counter of a tenant and records it as the version
+// every page must be read at or after. KEYS are the counter and the flush marker.
+var flushPagesScript = rueidis.NewLuaScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('SET', KEYS[2], seq)
+return seq
+`)
+
+// GetPage reads the page cached under key in the namespace of the tenant in ctx.
+func (r *RedisCache) GetPage(ctx context.Context, key string) (entity.ProductPage, error) {
+	if r.pageTTL <= 0 {
+		return entity.ProductPage{}, ErrCacheMiss
+	}
+	vals, err := r.client.Do(ctx, r.client.B().Mget().
+		Key(TenantKey(ctx, pagePrefix+key), TenantKey(ctx, pageFlushKey)).
+		Build()).ToArray()
+	if err != nil {
+		return entity.ProductPage{}, fmt.Errorf("get cache page %q: %w", key, err)
+	}
+	data, err := vals[0].AsBytes()
+	if rueidis.IsRedisNil(err) {
+		return entity.ProductPage{}, ErrCacheMiss
+	}
+	if err != nil {
+		return entity.ProductPage{}, fmt.Errorf("get cache page %q: %w", key, err)
+	}
+	var e pageEntry
+	err = unmarshalVersioned(data, &e)
+	if errors.Is(err, errUnknownVersion) {
+		return entity.ProductPage{}, ErrCacheMiss
+	}
+	if err != nil {
+		return entity.ProductPage{}, fmt.Errorf("unmarshal cache page %q: %w", key, err)
+	}
+	if flushed, err := vals[1].AsInt64(); err == nil && flushed > e.Seq {
+		return entity.ProductPage{}, ErrCacheMiss
+	}
+	page := entity.ProductPage{Items: make([]entity.Product, 0, len(e.Items)), HasMore: e.HasMore}
+	for _, item := range e.Items {
+		p, err := item.product()
+		if err != nil {
+			return entity.ProductPage{}, fmt.Errorf("decode cache page %q: %w", key, err)
+		}
+		page.Items = append(page.Items, p)
+	}
+	return page, nil
+}
+
+// PageVersion returns the version to pass to SetPage for a page about to be read.
+func (r *RedisCache) PageVersion(ctx context.Context) (PageVersion, error) {
+	if r.pageTTL <= 0 {
+		return PageVersion{}, nil
+	}
+	at := time.Now()
+	seq, err := r.client.Do(ctx, r.client.B().Get().Key(TenantKey(ctx, pageSeqKey)).Build()).AsInt64()
+	if err != nil && !rueidis.IsRedisNil(err) {
+		return PageVersion{}, fmt.Errorf("get page version: %w", err)
+	}
+	return PageVersion{Seq: seq, At: at}, nil
+}
+
+// SetPage caches page under key in the namespace of the tenant in ctx, tagged by PageTags, unless
+// the tags were invalidated after v or v is older than PageFillTimeout; a concurrent write
+// therefore never leaves a page read before it cached.
+func (r *RedisCache) SetPage(ctx context.Context, key string, page entity.ProductPage, v PageVersion) error {
+	if r.pageTTL <= 0 || time.Since(v.At) >= PageFillTimeout {
+		return nil
+	}
+	e := pageEntry{Items: make([]cacheEntry, 0, len(page.Items)), HasMore: page.HasMore, Seq: v.Seq}
+	for _, p := range page.Items {
+		e.Items = append(e.Items, newCacheEntry(p))
+	}
+	data, err := marshalVersioned(r.codec, e)
+	if err != nil {
+		return fmt.Errorf("marshal cache page %q: %w", key, err)
+	}
+	tags := PageTags(page)
+	keys := make([]string, 0, 2+2*len(tags))
+	keys = append(keys, TenantKey(ctx, pagePrefix+key), TenantKey(ctx, pageFlushKey))
+	for _, tag := range tags {
+		keys = append(keys, TenantKey(ctx, pageInvPrefix+tag))
+	}
+	for _, tag := range tags {
+		keys = append(keys, TenantKey(ctx, pageTagPrefix+tag))
+	}
+	args := []string{
+		strconv.FormatInt(v.Seq, 10),
+		string(data),
+		strconv.FormatInt(r.pageTTL.Milliseconds(), 10),
+		strconv.Itoa(len(tags)),
+	}
+	if err := fillPageScript.Exec(ctx, r.client, keys, args).Error(); err != nil {
+		return fmt.Errorf("set cache page %q: %w", key, err)
+	}
+	return nil
+}
+
+// InvalidatePages deletes the pages of the tenant in ctx carrying any of tags, and fails the
+// fills of such pages read before.
+func (r *RedisCache) InvalidatePages(ctx context.Context, tags ...string) error {
+	if r.pageTTL <= 0 || len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, 1+2*len(tags))
+	keys = append(keys, TenantKey(ctx, pageSeqKey))
+	for _, tag := range tags {
+		keys = append(keys, TenantKey(ctx, pageInvPrefix+tag), TenantKey(ctx, pageTagPrefix+tag))
+	}
+	hold := strconv.FormatInt((2 * PageFillTimeout).Milliseconds(), 10)
+	if err := invalidatePagesScript.Exec(ctx, r.client, keys, []string{hold}).Error(); err != nil {
+		return fmt.Errorf("invalidate cache pages: %w", err)
+	}
+	return nil
+}
+
+// FlushPages drops every page of the tenant in ctx, for writes that may change any of them.
+func (r *RedisCache) FlushPages(ctx context.Context) error {
+	if r.pageTTL <= 0 {
+		return nil
+	}
+	keys := []string{TenantKey(ctx, pageSeqKey), TenantKey(ctx, pageFlushKey)}
+	if err := flushPagesScript.Exec(ctx, r.client, keys, nil).Error(); err != nil {
+		return fmt.Errorf("flush cache pages: %w", err)
+	}
+	return nil
+}
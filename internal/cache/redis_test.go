@@ -0,0 +1,334 @@
+//go:build integration
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// startRedis starts a Redis container and returns settings reaching it, caching pages and
+// tombstones but nothing in process.
+func startRedis(t *testing.T) config.Redis {
+	t.Helper()
+	ctx := t.Context()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:8",
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections").WithStartupTimeout(10 * time.Second),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to start redis container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Errorf("failed to terminate redis container: %v", err)
+		}
+	})
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("failed to get host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, "6379/tcp")
+	if err != nil {
+		t.Fatalf("failed to get mapped port: %v", err)
+	}
+
+	return config.Redis{
+		Host:         host,
+		Port:         int(port.Num()),
+		TTL:          time.Minute,
+		HardTTL:      2 * time.Minute,
+		TombstoneTTL: time.Minute,
+		PageTTL:      time.Minute,
+		Codec:        MsgPack.Name(),
+	}
+}
+
+// newTestRedis returns a cache configured by cfg, closed when t ends.
+func newTestRedis(t *testing.T, cfg config.Redis) *RedisCache {
+	t.Helper()
+	r, err := NewRedis(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func testProductPage(hasMore bool, names ...string) entity.ProductPage {
+	page := entity.ProductPage{HasMore: hasMore}
+	for _, name := range names {
+		page.Items = append(page.Items, entity.Product{
+			ID:    uuid.Must(uuid.NewV7()),
+			Name:  name,
+			Price: entity.Money{MinorAmount: 100, Currency: entity.CurrencyPLN},
+		})
+	}
+	return page
+}
+
+func TestRedisCache_Pages(t *testing.T) {
+	r := newTestRedis(t, startRedis(t))
+
+	// Each case has a tenant of its own, so none sees the pages or markers of another.
+	tenantCtx := func(t *testing.T) context.Context {
+		return reqctx.WithTenant(t.Context(), "t"+uuid.NewString()[:8])
+	}
+	version := func(t *testing.T, ctx context.Context) PageVersion {
+		t.Helper()
+		v, err := r.PageVersion(ctx)
+		if err != nil {
+			t.Fatalf("failed to get page version: %v", err)
+		}
+		return v
+	}
+	setPage := func(t *testing.T, ctx context.Context, key string, page entity.ProductPage, v PageVersion) {
+		t.Helper()
+		if err := r.SetPage(ctx, key, page, v); err != nil {
+			t.Fatalf("failed to set page: %v", err)
+		}
+	}
+	wantPage := func(t *testing.T, ctx context.Context, key string, want entity.ProductPage) {
+		t.Helper()
+		got, err := r.GetPage(ctx, key)
+		if err != nil {
+			t.Fatalf("got error %v, want the page", err)
+		}
+		if len(got.Items) != len(want.Items) || got.HasMore != want.HasMore {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+		for i := range want.Items {
+			if got.Items[i].ID != want.Items[i].ID || got.Items[i].Name != want.Items[i].Name {
+				t.Errorf("item %d: got %+v, want %+v", i, got.Items[i], want.Items[i])
+			}
+		}
+	}
+	wantMiss := func(t *testing.T, ctx context.Context, key string) {
+		t.Helper()
+		if _, err := r.GetPage(ctx, key); !errors.Is(err, ErrCacheMiss) {
+			t.Errorf("got error %v, want ErrCacheMiss", err)
+		}
+	}
+
+	t.Run("fill", func(t *testing.T) {
+		ctx := tenantCtx(t)
+		page := testProductPage(true, "Car", "Bike")
+		setPage(t, ctx, "a", page, version(t, ctx))
+		wantPage(t, ctx, "a", page)
+	})
+
+	t.Run("fill read before invalidation is dropped", func(t *testing.T) {
+		ctx := tenantCtx(t)
+		page, other := testProductPage(true, "Car", "Bike"), testProductPage(true, "Boat")
+		v := version(t, ctx)
+		if err := r.InvalidatePages(ctx, ProductTag(page.Items[1].ID)); err != nil {
+			t.Fatalf("failed to invalidate pages: %v", err)
+		}
+		setPage(t, ctx, "a", page, v)
+		wantMiss(t, ctx, "a")
+
+		// Pages of tags left alone are still filled, and later fills of the tag again.
+		setPage(t, ctx, "b", other, v)
+		wantPage(t, ctx, "b", other)
+		setPage(t, ctx, "a", page, version(t, ctx))
+		wantPage(t, ctx, "a", page)
+	})
+
+	t.Run("invalidation deletes the pages of its tags", func(t *testing.T) {
+		ctx := tenantCtx(t)
+		page, other := testProductPage(false, "Car"), testProductPage(true, "Boat")
+		setPage(t, ctx, "a", page, version(t, ctx))
+		setPage(t, ctx, "b", other, version(t, ctx))
+		if err := r.InvalidatePages(ctx, TagTail); err != nil {
+			t.Fatalf("failed to invalidate pages: %v", err)
+		}
+		wantMiss(t, ctx, "a")
+		wantPage(t, ctx, "b", other)
+	})
+
+	t.Run("fill read before flush is dropped", func(t *testing.T) {
+		ctx := tenantCtx(t)
+		page := testProductPage(true, "Car")
+		v := version(t, ctx)
+		if err := r.FlushPages(ctx); err != nil {
+			t.Fatalf("failed to flush pages: %v", err)
+		}
+		setPage(t, ctx, "a", page, v)
+		wantMiss(t, ctx, "a")
+
+		setPage(t, ctx, "a", page, version(t, ctx))
+		wantPage(t, ctx, "a", page)
+	})
+
+	t.Run("flush hides pages filled before", func(t *testing.T) {
+		ctx := tenantCtx(t)
+		setPage(t, ctx, "a", testProductPage(true, "Car"), version(t, ctx))
+		if err := r.FlushPages(ctx); err != nil {
+			t.Fatalf("failed to flush pages: %v", err)
+		}
+		wantMiss(t, ctx, "a")
+	})
+
+	t.Run("fill older than the timeout is dropped", func(t *testing.T) {
+		ctx := tenantCtx(t)
+		v := version(t, ctx)
+		v.At = v.At.Add(-PageFillTimeout)
+		setPage(t, ctx, "a", testProductPage(true, "Car"), v)
+		wantMiss(t, ctx, "a")
+	})
+
+	t.Run("tag sets are cleaned up", func(t *testing.T) {
+		ctx := tenantCtx(t)
+		page := testProductPage(false, "Car")
+		setPage(t, ctx, "a", page, version(t, ctx))
+
+		tags := PageTags(page)
+		for _, tag := range tags {
+			set := TenantKey(ctx, pageTagPrefix+tag)
+			members, err := r.client.Do(ctx, r.client.B().Smembers().Key(set).Build()).AsStrSlice()
+			if err != nil {
+				t.Fatalf("failed to read tag set: %v", err)
+			}
+			if len(members) != 1 || members[0] != TenantKey(ctx, pagePrefix+"a") {
+				t.Errorf("tag %s: got members %v, want the page", tag, members)
+			}
+			ttl, err := r.client.Do(ctx, r.client.B().Pttl().Key(set).Build()).AsInt64()
+			if err != nil {
+				t.Fatalf("failed to read tag set TTL: %v", err)
+			}
+			if ttl <= 0 || ttl > time.Minute.Milliseconds() {
+				t.Errorf("tag %s: got TTL %dms, want at most the page TTL", tag, ttl)
+			}
+		}
+
+		if err := r.InvalidatePages(ctx, tags...); err != nil {
+			t.Fatalf("failed to invalidate pages: %v", err)
+		}
+		for _, tag := range tags {
+			n, err := r.client.Do(ctx, r.client.B().Exists().Key(TenantKey(ctx, pageTagPrefix+tag)).Build()).
+				AsInt64()
+			if err != nil {
+				t.Fatalf("failed to check tag set: %v", err)
+			}
+			if n != 0 {
+				t.Errorf("tag %s: set kept after its invalidation", tag)
+			}
+			ttl, err := r.client.Do(ctx, r.client.B().Pttl().Key(TenantKey(ctx, pageInvPrefix+tag)).Build()).
+				AsInt64()
+			if err != nil {
+				t.Fatalf("failed to read marker TTL: %v", err)
+			}
+			if ttl <= 0 || ttl > (2*PageFillTimeout).Milliseconds() {
+				t.Errorf("tag %s: got marker TTL %dms, want at most twice the fill timeout", tag, ttl)
+			}
+		}
+		wantMiss(t, ctx, "a")
+	})
+}
+
+func TestRedisCache_TrackReads(t *testing.T) {
+	cfg := startRedis(t)
+	cfg.Warm = config.Warm{TrackInterval: time.Second, TrackKeep: 3, TrackHalfLife: time.Hour}
+	r := newTestRedis(t, cfg)
+	ctx := reqctx.WithTenant(t.Context(), "acme")
+
+	ids := make([]uuid.UUID, 4)
+	for i := range ids {
+		ids[i] = uuid.Must(uuid.NewV7())
+	}
+	read := func(id uuid.UUID, n int) {
+		t.Helper()
+		for range n {
+			if _, err := r.Get(ctx, id.String()); err != nil && !errors.Is(err, ErrCacheMiss) {
+				t.Fatalf("failed to get %s: %v", id, err)
+			}
+		}
+	}
+	track := func() {
+		t.Helper()
+		if err := r.TrackReads(ctx); err != nil {
+			t.Fatalf("failed to track reads: %v", err)
+		}
+	}
+	wantScores := func(want map[uuid.UUID]float64) {
+		t.Helper()
+		n, err := r.client.Do(ctx, r.client.B().Zcard().Key(readsKey).Build()).AsInt64()
+		if err != nil {
+			t.Fatalf("failed to count tracked reads: %v", err)
+		}
+		if n != int64(len(want)) {
+			t.Errorf("got %d members, want %d", n, len(want))
+		}
+		for id, score := range want {
+			got, err := r.client.Do(ctx, r.client.B().Zscore().Key(readsKey).Member(TenantKey(ctx, id.String())).
+				Build()).AsFloat64()
+			if err != nil {
+				t.Fatalf("failed to read the score of %s: %v", id, err)
+			}
+			if got != score {
+				t.Errorf("%s: got score %v, want %v", id, got, score)
+			}
+		}
+	}
+
+	// The first call of a half-life halves the counts, after the fewest read were trimmed.
+	read(ids[0], 4)
+	read(ids[1], 3)
+	read(ids[2], 2)
+	read(ids[3], 1)
+	track()
+	wantScores(map[uuid.UUID]float64{ids[0]: 2, ids[1]: 1.5, ids[2]: 1})
+	ttl, err := r.client.Do(ctx, r.client.B().Pttl().Key(readsDecayKey).Build()).AsInt64()
+	if err != nil {
+		t.Fatalf("failed to read decay marker TTL: %v", err)
+	}
+	if ttl <= 0 || ttl > time.Hour.Milliseconds() {
+		t.Errorf("got decay marker TTL %dms, want at most the half-life", ttl)
+	}
+
+	// Later calls in the half-life add up.
+	read(ids[3], 5)
+	read(ids[0], 1)
+	track()
+	wantScores(map[uuid.UUID]float64{ids[3]: 5, ids[0]: 3, ids[1]: 1.5})
+
+	// Once the half-life has passed, the next call halves again.
+	if err := r.client.Do(ctx, r.client.B().Del().Key(readsDecayKey).Build()).Error(); err != nil {
+		t.Fatalf("failed to end the half-life: %v", err)
+	}
+	read(ids[1], 1)
+	track()
+	wantScores(map[uuid.UUID]float64{ids[3]: 2.5, ids[0]: 1.5, ids[1]: 1.25})
+
+	if err := r.client.Do(ctx, r.client.B().Zadd().Key(readsKey).ScoreMember().
+		ScoreMember(100, TenantKey(ctx, "not-a-product")).Build()).Error(); err != nil {
+		t.Fatalf("failed to add a foreign member: %v", err)
+	}
+	got, err := r.Hottest(ctx, 3)
+	if err != nil {
+		t.Fatalf("failed to read hottest: %v", err)
+	}
+	want := []entity.ProductRef{{Tenant: "acme", ID: ids[3]}, {Tenant: "acme", ID: ids[0]}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got hottest %v, want %v", got, want)
+	}
+	if got, err := r.Hottest(ctx, 0); err != nil || got != nil {
+		t.Errorf("got %v, %v for none, want nothing", got, err)
+	}
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/redis/rueidis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/alkmc/storefront/internal/cache")
+
+// tracedClient records the commands sent through a rueidis client in client spans named by
+// the command, such as GET or EVALSHA, and the pipelines in spans named BATCH. Arguments hold
+// keys and cached values, so they are not recorded.
+type tracedClient struct {
+	rueidis.Client
+	attrs []attribute.KeyValue
+}
+
+func newTracedClient(c rueidis.Client, cfg config.Redis) tracedClient {
+	return tracedClient{Client: c, attrs: []attribute.KeyValue{
+		semconv.DBSystemNameRedis, semconv.DBNamespace(strconv.Itoa(cfg.DB)),
+		semconv.ServerAddress(cfg.Host), semconv.ServerPort(cfg.Port),
+	}}
+}
+
+func (c tracedClient) Do(ctx context.Context, cmd rueidis.Completed) rueidis.RedisResult {
+	ctx, span := c.start(ctx, commandName(cmd.Commands()))
+	res := c.Client.Do(ctx, cmd)
+	endSpan(span, res.Error())
+	return res
+}
+
+func (c tracedClient) DoMulti(ctx context.Context, multi ...rueidis.Completed) []rueidis.RedisResult {
+	names := make([]string, len(multi))
+	for i, cmd := range multi {
+		names[i] = commandName(cmd.Commands())
+	}
+	ctx, span := c.start(ctx, batchName(names), semconv.DBOperationBatchSize(len(multi)))
+	res := c.Client.DoMulti(ctx, multi...)
+	endSpan(span, firstError(res))
+	return res
+}
+
+func (c tracedClient) DoCache(ctx context.Context, cmd rueidis.Cacheable, ttl time.Duration,
+) rueidis.RedisResult {
+	ctx, span := c.start(ctx, commandName(cmd.Commands()))
+	res := c.Client.DoCache(ctx, cmd, ttl)
+	endSpan(span, res.Error())
+	return res
+}
+
+func (c tracedClient) DoMultiCache(ctx context.Context, multi ...rueidis.CacheableTTL) []rueidis.RedisResult {
+	names := make([]string, len(multi))
+	for i, cmd := range multi {
+		names[i] = commandName(cmd.Cmd.Commands())
+	}
+	ctx, span := c.start(ctx, batchName(names), semconv.DBOperationBatchSize(len(multi)))
+	res := c.Client.DoMultiCache(ctx, multi...)
+	endSpan(span, firstError(res))
+	return res
+}
+
+// start starts the client span of operation op.
+func (c tracedClient) start(ctx context.Context, op string, attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.DBOperationName(op))
+	return tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, c.attrs...)...))
+}
+
+// endSpan ends span, marking it failed by err. A nil reply, such as a miss, is no failure.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !rueidis.IsRedisNil(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func firstError(res []rueidis.RedisResult) error {
+	for _, r := range res {
+		if err := r.Error(); err != nil && !rueidis.IsRedisNil(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandName returns the command of args, with its subcommand for the commands that have
+// them, such as CLIENT CACHING.
+func commandName(args []string) string {
+	if len(args) == 0 {
+		return "UNKNOWN"
+	}
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "CLIENT", "SCRIPT", "CONFIG", "OBJECT":
+		if len(args) > 1 {
+			return name + " " + strings.ToUpper(args[1])
+		}
+	}
+	return name
+}
+
+// batchName names a pipeline BATCH, followed by its command when they all run the same one.
+func batchName(names []string) string {
+	if len(names) == 0 || slices.ContainsFunc(names, func(n string) bool { return n != names[0] }) {
+		return "BATCH"
+	}
+	return "BATCH " + names[0]
+}
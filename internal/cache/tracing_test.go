@@ -0,0 +1,35 @@
+package cache
+
+import "testing"
+
+func TestCommandName(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"get", "t:1"}, "GET"},
+		{[]string{"CLIENT", "CACHING", "YES"}, "CLIENT CACHING"},
+		{nil, "UNKNOWN"},
+	}
+	for _, tt := range tests {
+		if got := commandName(tt.args); got != tt.want {
+			t.Errorf("commandName(%q): got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestBatchName(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"SET", "SET"}, "BATCH SET"},
+		{[]string{"SET", "DEL"}, "BATCH"},
+		{nil, "BATCH"},
+	}
+	for _, tt := range tests {
+		if got := batchName(tt.names); got != tt.want {
+			t.Errorf("batchName(%q): got %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+)
+
+// Keys of the read counts, shared by every tenant. Tenant ids start with a letter or digit, so
+// the keys are in no tenant namespace.
+const (
+	readsKey      = "_warm:reads"
+	readsDecayKey = "_warm:decayed"
+)
+
+// trackReadsScript adds read counts to the sorted set of counts, keeps the most read members,
+// and halves every count once per half-life however many instances track. KEYS are the set and
+// the decay marker; ARGV how many members to keep, the half-life in milliseconds, then each
+// member followed by its count.
+var trackReadsScript = rueidis.NewLuaScript(`
+for i = 3, #ARGV, 2 do
+	redis.call('ZINCRBY', KEYS[1], ARGV[i + 1], ARGV[i])
+end
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[1]) - 1)
+if redis.call('SET', KEYS[2], 1, 'NX', 'PX', ARGV[2]) then
+	redis.call('ZUNIONSTORE', KEYS[1], 1, KEYS[1], 'WEIGHTS', 0.5)
+end
+return redis.call('ZCARD', KEYS[1])
+`)
+
+// readCounter counts the reads of each tenant key in process, so they reach Redis in one
+// script per interval rather than one command per read.
+type readCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *readCounter) add(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[key]++
+}
+
+// take returns the counts and starts over.
+func (c *readCounter) take() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = nil
+	return counts
+}
+
+// TrackReads adds the product reads counted since the last call to the read counts shared in
+// Redis. It does nothing when counting is disabled; counts it fails to add are dropped.
+func (r *RedisCache) TrackReads(ctx context.Context) error {
+	if r.reads == nil {
+		return nil
+	}
+	counts := r.reads.take()
+	if len(counts) == 0 {
+		return nil
+	}
+	args := make([]string, 0, 2+2*len(counts))
+	args = append(args, strconv.Itoa(max(r.trackKeep, 1)),
+		strconv.FormatInt(max(r.trackHalf.Milliseconds(), 1), 10))
+	for key, n := range counts {
+		args = append(args, key, strconv.FormatInt(n, 10))
+	}
+	keys := []string{readsKey, readsDecayKey}
+	if err := trackReadsScript.Exec(ctx, r.client, keys, args).Error(); err != nil {
+		return fmt.Errorf("track %d product reads: %w", len(counts), err)
+	}
+	return nil
+}
+
+// Hottest returns the products of every tenant read most, most read first, at most n of them.
+func (r *RedisCache) Hottest(ctx context.Context, n int) ([]entity.ProductRef, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	keys, err := r.client.Do(ctx, r.client.B().Zrange().Key(readsKey).
+		Min("0").Max(strconv.Itoa(n-1)).Rev().
+		Build()).AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("read hottest products: %w", err)
+	}
+	refs := make([]entity.ProductRef, 0, len(keys))
+	for _, key := range keys {
+		tenant, rest, _ := strings.Cut(key, ":")
+		if id, err := uuid.Parse(rest); err == nil {
+			refs = append(refs, entity.ProductRef{Tenant: tenant, ID: id})
+		}
+	}
+	return refs, nil
+}
@@ -1,25 +1,133 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"net/url"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/caarlos0/env/v11"
 )
 
+// Supported values of Storage.Backend.
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
+)
+
+// Dependency names a dependency the service can run without.
+type Dependency string
+
+// Optional dependencies.
+const (
+	DependencyCache    Dependency = "cache"
+	DependencyReplicas Dependency = "replicas"
+)
+
+func (d *Dependency) UnmarshalText(text []byte) error {
+	switch dep := Dependency(text); dep {
+	case DependencyCache, DependencyReplicas:
+		*d = dep
+		return nil
+	}
+	return fmt.Errorf("unknown dependency %q, want %s or %s", text, DependencyCache, DependencyReplicas)
+}
+
 type (
 	Config struct {
-		HTTP     HTTP
-		Postgres Postgres
-		Redis    Redis
-		Service  Service
-		Log      Log
+		Storage Storage
+		HTTP    HTTP
+		// Postgres and Redis are loaded only for the postgres storage backend, SQLite only for sqlite.
+		Postgres  Postgres `env:"-"`
+		Redis     Redis    `env:"-"`
+		SQLite    SQLite   `env:"-"`
+		Service   Service
+		Import    Import
+		Tenant    Tenant
+		RateLimit RateLimit
+		Readiness Readiness
+		Log       Log
+		Tracing   Tracing
+	}
+	Storage struct {
+		// Backend is postgres, which also uses Redis; sqlite, a single database file with an
+		// in-process cache for single-node deployments; or memory, which keeps everything in
+		// process and loses it on exit and is meant for tests and local development.
+		Backend string `env:"STORAGE_BACKEND" envDefault:"postgres"`
 	}
 	Service struct {
-		LoadTimeout time.Duration `env:"SERVICE_LOAD_TIMEOUT" envDefault:"1s"`
+		LoadTimeout    time.Duration `env:"SERVICE_LOAD_TIMEOUT" envDefault:"1s"`
+		PurgeInterval  time.Duration `env:"SERVICE_PURGE_INTERVAL" envDefault:"1h"`
+		PurgeRetention time.Duration `env:"SERVICE_PURGE_RETENTION" envDefault:"720h"` // 30 days
+	}
+	Import struct {
+		// Dir holds uploads while they are checked and copied into the store; empty means a
+		// directory under os.TempDir. It need not be shared: jobs read their source from the store.
+		Dir       string        `env:"IMPORT_DIR"`
+		BatchSize int           `env:"IMPORT_BATCH_SIZE" envDefault:"5000"`
+		MaxBytes  int64         `env:"IMPORT_MAX_BYTES" envDefault:"268435456"` // 256 MiB
+		Lease     time.Duration `env:"IMPORT_LEASE" envDefault:"1m"`
+	}
+	Tenant struct {
+		// Header names the tenant of a request; API keys pin requests to their own tenant instead.
+		Header string `env:"TENANT_HEADER" envDefault:"X-Tenant-ID"`
+		// Default serves requests naming no tenant, unless Required is set.
+		Default  string `env:"TENANT_DEFAULT" envDefault:"default"`
+		Required bool   `env:"TENANT_REQUIRED" envDefault:"false"`
+	}
+	// RateLimit throttles the public API with token buckets, kept in Redis by the postgres
+	// backend and in process otherwise.
+	RateLimit struct {
+		Enabled bool `env:"RATE_LIMIT_ENABLED" envDefault:"true"`
+		// IP limits each client address before authentication, so floods of bad credentials
+		// cannot reach the database.
+		IP Limit `env:"RATE_LIMIT_IP" envDefault:"100/s:200"`
+		// Tiers limits authenticated clients by the tier of their API key or token; it must
+		// define the default tier, which also serves tiers it does not define.
+		Tiers Limits `env:"RATE_LIMIT_TIERS" envDefault:"standard=20/s:40;premium=200/s:400"`
+		// RouteCosts is how many tokens a request to a route takes, by route pattern; other
+		// routes take one.
+		RouteCosts Costs `env:"RATE_LIMIT_ROUTE_COSTS" envDefault:"GET /product/export=10"`
+		// RedisTimeout bounds a bucket update in Redis. Past it, and for a while after, buckets
+		// are kept per process.
+		RedisTimeout time.Duration `env:"RATE_LIMIT_REDIS_TIMEOUT" envDefault:"50ms"`
+	}
+	// Auth configures API key and JWT authentication; see LoadAuth.
+	Auth struct {
+		// APIKeyPepper keys the HMAC under which API keys are stored, so a leaked table alone
+		// cannot be brute-forced. Rotating it invalidates every key.
+		APIKeyPepper Secret `env:"API_KEY_PEPPER,required,unset"`
+		// APIKeyTouchInterval bounds how often the last use of a key is written back.
+		APIKeyTouchInterval time.Duration `env:"API_KEY_TOUCH_INTERVAL" envDefault:"1m"`
+		JWT                 JWT           `envPrefix:"JWT_"`
+	}
+	// JWT configures the bearer tokens of an external issuer; they are accepted only when a
+	// JWKS source is set.
+	JWT struct {
+		// JWKSFile or JWKSURL is where the verification keys are read from, every JWKSRefresh.
+		JWKSFile    string        `env:"JWKS_FILE"`
+		JWKSURL     string        `env:"JWKS_URL"`
+		JWKSRefresh time.Duration `env:"JWKS_REFRESH" envDefault:"5m"`
+		Issuer      string        `env:"ISSUER"`
+		Audience    string        `env:"AUDIENCE"`
+		// ClockSkew is how far exp and nbf may be off the local clock.
+		ClockSkew time.Duration `env:"CLOCK_SKEW" envDefault:"30s"`
+		// TenantClaim names the claim holding the tenant of the token; it is required.
+		TenantClaim string `env:"TENANT_CLAIM" envDefault:"tenant"`
+		// TierClaim names the claim holding the rate limit tier of the token; tokens without
+		// it are in the default tier.
+		TierClaim string `env:"TIER_CLAIM" envDefault:"tier"`
+		// ScopeClaim names the claim granting scopes, a space-separated string or an array.
+		ScopeClaim string `env:"SCOPE_CLAIM" envDefault:"scope"`
+		// ClaimScopes maps values of ScopeClaim to space-separated scopes, for issuers whose
+		// roles are not named after them, e.g. catalog-editor=products:read products:write.
+		ClaimScopes map[string]string `env:"CLAIM_SCOPES" envSeparator:"," envKeyValSeparator:"="`
 	}
 	HTTP struct {
 		Host            string        `env:"HTTP_HOST"`
@@ -38,6 +146,10 @@ type (
 		CORSMaxAge         int      `env:"CORS_MAX_AGE" envDefault:"600"`
 		HSTSEnabled        bool     `env:"HSTS_ENABLED" envDefault:"false"`
 		HSTSMaxAge         int      `env:"HSTS_MAX_AGE" envDefault:"31536000"`
+
+		// TrustedProxies are the addresses whose X-Forwarded-For is believed when identifying
+		// the client of a request.
+		TrustedProxies []netip.Prefix `env:"HTTP_TRUSTED_PROXIES" envSeparator:","`
 	}
 	Postgres struct {
 		Host            string        `env:"PG_HOST,required"`
@@ -47,21 +159,134 @@ type (
 		Database        string        `env:"PG_DB,required"`
 		SSLMode         string        `env:"PG_SSLMODE" envDefault:"disable"`
 		MaxOpenConns    int           `env:"PG_MAX_OPEN_CONNS" envDefault:"25"`
-		MaxIdleConns    int           `env:"PG_MAX_IDLE_CONNS" envDefault:"5"`
 		ConnMaxLifetime time.Duration `env:"PG_CONN_MAX_LIFETIME" envDefault:"30m"`
+		// MaxIdleConns caps the idle connections of the stdlib driver. MinIdleConns is the
+		// pgxpool counterpart the other way round: the idle connections it keeps open ahead of
+		// demand. Each driver ignores the other's setting.
+		MaxIdleConns int `env:"PG_MAX_IDLE_CONNS" envDefault:"5"`
+		MinIdleConns int `env:"PG_MIN_IDLE_CONNS" envDefault:"0"`
+
+		Driver           string        `env:"PG_DRIVER" envDefault:"pgxpool"` // pgxpool or stdlib
+		StatementTimeout time.Duration `env:"PG_STATEMENT_TIMEOUT" envDefault:"5s"`
+		ApplicationName  string        `env:"PG_APPLICATION_NAME" envDefault:"storefront"`
+
+		// Transactions failing with a serialization failure, deadlock or dropped connection are
+		// retried up to RetryAttempts times in total, with jittered exponential backoff.
+		RetryAttempts  int           `env:"PG_RETRY_ATTEMPTS" envDefault:"3"`
+		RetryBaseDelay time.Duration `env:"PG_RETRY_BASE_DELAY" envDefault:"10ms"`
+		RetryMaxDelay  time.Duration `env:"PG_RETRY_MAX_DELAY" envDefault:"200ms"`
+		// TxIsolation sets the isolation level of the transactions of an operation, as op:level
+		// pairs such as update:serializable; the repository lists the operations. Levels are
+		// read_committed, repeatable_read and serializable.
+		TxIsolation map[string]string `env:"PG_TX_ISOLATION" envSeparator:"," envKeyValSeparator:":"`
+
+		// ReplicaHosts lists read replicas as host or host:port; they share the primary's credentials.
+		ReplicaHosts         []string      `env:"PG_REPLICA_HOSTS" envSeparator:","`
+		ReplicaMaxLag        time.Duration `env:"PG_REPLICA_MAX_LAG" envDefault:"2s"`
+		ReplicaCheckInterval time.Duration `env:"PG_REPLICA_CHECK_INTERVAL" envDefault:"5s"`
 	}
 	Redis struct {
-		Host     string        `env:"REDIS_HOST,required"`
-		Port     int           `env:"REDIS_PORT,required"`
-		Password Secret        `env:"REDIS_PASSWORD,required,unset"`
-		DB       int           `env:"REDIS_DB" envDefault:"0"`
-		TTL      time.Duration `env:"REDIS_CACHE_TTL" envDefault:"10s"`
+		Host     string `env:"REDIS_HOST,required"`
+		Port     int    `env:"REDIS_PORT,required"`
+		Password Secret `env:"REDIS_PASSWORD,required,unset"`
+		DB       int    `env:"REDIS_DB" envDefault:"0"`
+		// TTL is how long a product stays fresh. Stale products are served while they are
+		// refreshed in the background, until HardTTL drops them; it is at least TTL.
+		TTL     time.Duration `env:"REDIS_CACHE_TTL" envDefault:"10s"`
+		HardTTL time.Duration `env:"REDIS_CACHE_HARD_TTL" envDefault:"60s"`
+		// XFetchBeta scales how early fresh products may be refreshed, in multiples of how long
+		// they took to load; 0 refreshes only stale ones.
+		XFetchBeta float64 `env:"REDIS_CACHE_XFETCH_BETA" envDefault:"1"`
+		// TombstoneTTL is how long a product found missing is remembered as such; 0 disables it.
+		TombstoneTTL time.Duration `env:"REDIS_TOMBSTONE_TTL" envDefault:"2s"`
+		// PageTTL is how long a page of the product list is cached; writes invalidate the pages
+		// they change before then. 0 disables it.
+		PageTTL time.Duration `env:"REDIS_PAGE_TTL" envDefault:"1m"`
+		// Codec encodes cached values: json, msgpack or cbor. Values of every codec are read, so
+		// it can be changed with a rolling deploy.
+		Codec   string  `env:"REDIS_CACHE_CODEC" envDefault:"msgpack"`
+		Breaker Breaker `envPrefix:"REDIS_BREAKER_"`
+		Warm    Warm    `envPrefix:"REDIS_WARM_"`
+		// L1Size is how many products each instance also keeps in process, kept coherent by
+		// Redis client-side caching invalidations; 0 disables the tier.
+		L1Size int `env:"REDIS_L1_SIZE" envDefault:"10000"`
+		// L1TTL bounds how long a product is served from process memory; it is capped by TTL.
+		L1TTL time.Duration `env:"REDIS_L1_TTL" envDefault:"5s"`
+	}
+	// Breaker configures the circuit breaker in front of the Redis cache. It opens once
+	// MinRequests calls in the last Window failed at FailureRate or more, then bypasses the cache
+	// for OpenFor before letting HalfOpenProbes calls test it; it closes when they all succeed.
+	Breaker struct {
+		Enabled        bool          `env:"ENABLED" envDefault:"true"`
+		Window         time.Duration `env:"WINDOW" envDefault:"10s"`
+		MinRequests    int           `env:"MIN_REQUESTS" envDefault:"20"`
+		FailureRate    float64       `env:"FAILURE_RATE" envDefault:"0.5"`
+		OpenFor        time.Duration `env:"OPEN_FOR" envDefault:"5s"`
+		HalfOpenProbes int           `env:"HALF_OPEN_PROBES" envDefault:"3"`
+		// CallTimeout bounds each cache call, failing it; a stalled Redis then costs requests
+		// this much until the breaker opens, and nothing after.
+		CallTimeout time.Duration `env:"CALL_TIMEOUT" envDefault:"100ms"`
+	}
+	// Warm configures preloading the cache with the products it is likely to be asked for, on
+	// startup and on demand.
+	Warm struct {
+		// OnStart warms the cache before the instance turns ready.
+		OnStart bool `env:"ON_START" envDefault:"true"`
+		// Source picks the products: hot, the most requested, topped up with the most recently
+		// updated when fewer were counted; or recent, the most recently updated only.
+		Source string `env:"SOURCE" envDefault:"hot"`
+		// Size is how many products a warm-up preloads.
+		Size int `env:"SIZE" envDefault:"1000"`
+		// BatchSize is how many products each database read and pipeline of SETs carries.
+		BatchSize int `env:"BATCH_SIZE" envDefault:"200"`
+		// Timeout bounds a warm-up; readiness waits for the one on startup no longer.
+		Timeout time.Duration `env:"TIMEOUT" envDefault:"30s"`
+		// TrackInterval is how often each instance adds the product reads it counted to the
+		// counts shared in Redis; 0 stops counting.
+		TrackInterval time.Duration `env:"TRACK_INTERVAL" envDefault:"10s"`
+		// TrackKeep is how many of the most requested products the counts are kept for.
+		TrackKeep int `env:"TRACK_KEEP" envDefault:"10000"`
+		// TrackHalfLife is how often the counts are halved, so recent reads outweigh old ones.
+		TrackHalfLife time.Duration `env:"TRACK_HALF_LIFE" envDefault:"1h"`
+	}
+	// Readiness decides what GET /readyz requires besides the primary database.
+	Readiness struct {
+		// Require lists the optional dependencies readiness also fails without. Without them the
+		// service degrades: it bypasses the cache, or reads from the primary.
+		Require []Dependency `env:"READINESS_REQUIRE" envSeparator:","`
+	}
+	SQLite struct {
+		Path string `env:"SQLITE_PATH" envDefault:"storefront.db"`
+		// BusyTimeout bounds how long a statement waits for a lock held by another process.
+		BusyTimeout time.Duration `env:"SQLITE_BUSY_TIMEOUT" envDefault:"5s"`
+		// MaxReadConns sizes the pool of read-only connections; writes share a single connection.
+		MaxReadConns int `env:"SQLITE_MAX_READ_CONNS" envDefault:"4"`
 	}
 	Log struct {
 		Level slog.Level `env:"LOG_LEVEL" envDefault:"INFO"`
 	}
+	// Tracing configures OpenTelemetry tracing under the standard OTEL_ variables. The OTLP
+	// exporter also reads the OTEL_EXPORTER_OTLP_ ones for its endpoint, headers and timeout.
+	Tracing struct {
+		// Exporter is where finished spans go: otlp; console, which writes them to stderr for
+		// local use; or none, which still propagates the trace context of requests.
+		Exporter string `env:"OTEL_TRACES_EXPORTER" envDefault:"none"`
+		// Protocol is the OTLP transport, http/protobuf or grpc.
+		Protocol string `env:"OTEL_EXPORTER_OTLP_PROTOCOL" envDefault:"http/protobuf"`
+		// Sampler decides which traces are recorded: always_on, always_off or traceidratio,
+		// optionally prefixed with parentbased_ to follow the decision of the caller.
+		Sampler string `env:"OTEL_TRACES_SAMPLER" envDefault:"parentbased_always_on"`
+		// SamplerArg is the share of traces traceidratio samplers record, from 0 to 1.
+		SamplerArg  float64 `env:"OTEL_TRACES_SAMPLER_ARG" envDefault:"1"`
+		ServiceName string  `env:"OTEL_SERVICE_NAME" envDefault:"storefront"`
+	}
 )
 
+// Requires reports whether readiness fails without dep.
+func (r Readiness) Requires(dep Dependency) bool {
+	return slices.Contains(r.Require, dep)
+}
+
 func (h HTTP) Address() string {
 	return net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
 }
@@ -88,6 +313,119 @@ func (p Postgres) DSN() string {
 	return u.String()
 }
 
+// Replica returns the settings for the replica at addr, which is host or host:port.
+// The port defaults to the primary's.
+func (p Postgres) Replica(addr string) (Postgres, error) {
+	r := p
+	r.ReplicaHosts = nil
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		r.Host = addr
+		return r, nil
+	}
+	r.Host = host
+	if r.Port, err = strconv.Atoi(port); err != nil {
+		return Postgres{}, fmt.Errorf("invalid replica port in %q", addr)
+	}
+	return r, nil
+}
+
+// memoryCache holds the Redis settings the in-memory cache of the sqlite and memory
+// backends honours as well.
+type memoryCache struct {
+	TTL          time.Duration `env:"REDIS_CACHE_TTL" envDefault:"10s"`
+	HardTTL      time.Duration `env:"REDIS_CACHE_HARD_TTL" envDefault:"60s"`
+	XFetchBeta   float64       `env:"REDIS_CACHE_XFETCH_BETA" envDefault:"1"`
+	TombstoneTTL time.Duration `env:"REDIS_TOMBSTONE_TTL" envDefault:"2s"`
+	PageTTL      time.Duration `env:"REDIS_PAGE_TTL" envDefault:"1m"`
+}
+
+// minImportLease is the shortest accepted IMPORT_LEASE. Importers look for expired leases
+// every half lease, and a job that takes longer than one to commit a batch is taken over.
+const minImportLease = time.Second
+
 func Load() (Config, error) {
-	return env.ParseAs[Config]()
+	cfg, err := env.ParseAs[Config]()
+	if err != nil {
+		return Config{}, err
+	}
+	if cfg.Import.Lease < minImportLease {
+		return Config{}, fmt.Errorf("IMPORT_LEASE must be at least %s", minImportLease)
+	}
+	switch cfg.Storage.Backend {
+	case StoragePostgres:
+		if cfg.Postgres, err = env.ParseAs[Postgres](); err != nil {
+			return Config{}, err
+		}
+		if err := cfg.Postgres.validate(); err != nil {
+			return Config{}, err
+		}
+		if cfg.Redis, err = env.ParseAs[Redis](); err != nil {
+			return Config{}, err
+		}
+	case StorageSQLite:
+		if cfg.SQLite, err = env.ParseAs[SQLite](); err != nil {
+			return Config{}, err
+		}
+		fallthrough
+	case StorageMemory:
+		mc, err := env.ParseAs[memoryCache]()
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Redis.TTL, cfg.Redis.HardTTL, cfg.Redis.XFetchBeta = mc.TTL, mc.HardTTL, mc.XFetchBeta
+		cfg.Redis.TombstoneTTL, cfg.Redis.PageTTL = mc.TombstoneTTL, mc.PageTTL
+	default:
+		return Config{}, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+	return cfg, nil
+}
+
+func (p Postgres) validate() error {
+	if p.MinIdleConns < 0 || p.MinIdleConns > p.MaxOpenConns {
+		return errors.New("PG_MIN_IDLE_CONNS must be between 0 and PG_MAX_OPEN_CONNS")
+	}
+	if len(p.ReplicaHosts) > 0 && p.ReplicaCheckInterval <= 0 {
+		return errors.New("PG_REPLICA_CHECK_INTERVAL must be positive with PG_REPLICA_HOSTS")
+	}
+	return nil
+}
+
+// minPepperLen is the shortest accepted API_KEY_PEPPER, in bytes.
+const minPepperLen = 32
+
+// LoadAuth loads the settings of API key and JWT authentication, which only the server needs.
+func LoadAuth() (Auth, error) {
+	a, err := env.ParseAs[Auth]()
+	if err != nil {
+		return Auth{}, err
+	}
+	if len(a.APIKeyPepper.Reveal()) < minPepperLen {
+		return Auth{}, fmt.Errorf("API_KEY_PEPPER must be at least %d bytes", minPepperLen)
+	}
+	if err := a.JWT.validate(); err != nil {
+		return Auth{}, err
+	}
+	return a, nil
+}
+
+// Enabled reports whether bearer JWTs are accepted.
+func (j JWT) Enabled() bool {
+	return j.JWKSFile != "" || j.JWKSURL != ""
+}
+
+func (j JWT) validate() error {
+	switch {
+	case !j.Enabled():
+		return nil
+	case j.JWKSFile != "" && j.JWKSURL != "":
+		return errors.New("JWT_JWKS_FILE and JWT_JWKS_URL are mutually exclusive")
+	case j.Issuer == "" || j.Audience == "":
+		return errors.New("JWT_ISSUER and JWT_AUDIENCE are required with a JWKS")
+	case j.JWKSRefresh <= 0:
+		return errors.New("JWT_JWKS_REFRESH must be positive")
+	case j.TenantClaim == "" || j.ScopeClaim == "":
+		return errors.New("JWT_TENANT_CLAIM and JWT_SCOPE_CLAIM must not be empty")
+	}
+	return nil
 }
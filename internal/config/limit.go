@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Limit is a token bucket of Burst requests refilled at Rate requests per second, written
+	// as N/unit[:burst], e.g. 20/s:40 or 600/m; the burst defaults to N.
+	Limit struct {
+		Rate  float64
+		Burst int
+	}
+	// Limits maps names to limits, written as name=limit pairs separated by ';'.
+	Limits map[string]Limit
+	// Costs maps names to positive token counts, written as name=count pairs separated by ';'.
+	Costs map[string]int
+)
+
+func (l *Limit) UnmarshalText(text []byte) error {
+	spec, burst, hasBurst := strings.Cut(string(text), ":")
+	n, unit, ok := strings.Cut(spec, "/")
+	if !ok {
+		return fmt.Errorf("limit %q is not N/unit[:burst]", text)
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count <= 0 {
+		return fmt.Errorf("limit %q: the count must be a positive integer", text)
+	}
+	var per time.Duration
+	switch unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return fmt.Errorf("limit %q: the unit must be s, m or h", text)
+	}
+	*l = Limit{Rate: float64(count) / per.Seconds(), Burst: count}
+	if hasBurst {
+		if l.Burst, err = strconv.Atoi(burst); err != nil || l.Burst <= 0 {
+			return fmt.Errorf("limit %q: the burst must be a positive integer", text)
+		}
+	}
+	return nil
+}
+
+func (ls *Limits) UnmarshalText(text []byte) error {
+	out := make(Limits)
+	err := parsePairs(text, func(name, spec string) error {
+		var l Limit
+		if err := l.UnmarshalText([]byte(spec)); err != nil {
+			return err
+		}
+		out[name] = l
+		return nil
+	})
+	*ls = out
+	return err
+}
+
+func (cs *Costs) UnmarshalText(text []byte) error {
+	out := make(Costs)
+	err := parsePairs(text, func(name, count string) error {
+		n, err := strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("cost of %q: %q is not a positive integer", name, count)
+		}
+		out[name] = n
+		return nil
+	})
+	*cs = out
+	return err
+}
+
+// parsePairs calls fn with the name and value of each name=value pair in text, separated by ';'.
+func parsePairs(text []byte, fn func(name, value string) error) error {
+	for pair := range strings.SplitSeq(string(text), ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not name=value", pair)
+		}
+		if err := fn(strings.TrimSpace(name), strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
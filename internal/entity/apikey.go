@@ -0,0 +1,103 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// MaxAPIKeyNameLength is the longest API key name in characters.
+const MaxAPIKeyNameLength = 100
+
+// MaxTierLength is the longest rate limit tier name in characters.
+const MaxTierLength = 32
+
+// DefaultTier is the rate limit tier of clients that are not assigned one.
+const DefaultTier = "standard"
+
+// FieldScopes and FieldTier name the scopes and tier of an API key in a ConstraintError.
+const (
+	FieldScopes = "scopes"
+	FieldTier   = "tier"
+)
+
+// ErrInvalidAPIKey rejects a credential that matches no active API key.
+var ErrInvalidAPIKey = errors.New("entity: invalid api key")
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	ScopeProductsRead  Scope = "products:read"
+	ScopeProductsWrite Scope = "products:write"
+)
+
+// Scopes lists every scope an API key can be granted.
+var Scopes = []Scope{ScopeProductsRead, ScopeProductsWrite}
+
+func (s Scope) Valid() bool {
+	return slices.Contains(Scopes, s)
+}
+
+// APIKey authenticates a client of the public API as acting for Tenant with Scopes.
+// The secret is shown once at creation; only its peppered digest, Hash, is kept.
+type APIKey struct {
+	ID     uuid.UUID
+	Tenant string
+	Name   string
+	Scopes []Scope
+	// Tier selects the rate limits of the key's requests.
+	Tier string
+	Hash []byte
+	// LastUsedAt is when the key last authenticated a request, updated at a coarse
+	// granularity; zero for keys never used.
+	LastUsedAt time.Time
+	// RevokedAt is when the key was revoked; zero for active keys.
+	RevokedAt time.Time
+	CreatedAt time.Time
+}
+
+// Validate ensures the key has a name, at least one known scope and a well-formed tier.
+func (k *APIKey) Validate() error {
+	if k.Name == "" {
+		return invalid(FieldName, "the api key name is empty")
+	}
+	if utf8.RuneCountInString(k.Name) > MaxAPIKeyNameLength {
+		return invalid(FieldName, fmt.Sprintf("the api key name is longer than %d characters", MaxAPIKeyNameLength))
+	}
+	if len(k.Scopes) == 0 {
+		return invalid(FieldScopes, "the api key has no scopes")
+	}
+	for _, s := range k.Scopes {
+		if !s.Valid() {
+			return invalid(FieldScopes, fmt.Sprintf("unknown scope %q", s))
+		}
+	}
+	if !ValidTier(k.Tier) {
+		return invalid(FieldTier,
+			fmt.Sprintf("the tier must be 1 to %d lowercase letters, digits or '-'", MaxTierLength))
+	}
+	return nil
+}
+
+// ValidTier accepts 1 to MaxTierLength lowercase letters, digits and '-'.
+func ValidTier(t string) bool {
+	if t == "" || len(t) > MaxTierLength {
+		return false
+	}
+	for _, c := range []byte(t) {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Revoked reports whether the key has been revoked.
+func (k *APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAPIKey_Validate(t *testing.T) {
+	read := []Scope{ScopeProductsRead}
+	tests := []struct {
+		name      string
+		key       APIKey
+		wantField string
+	}{
+		{
+			name: "valid",
+			key:  APIKey{Name: "storefront", Scopes: Scopes, Tier: DefaultTier},
+		},
+		{
+			name:      "empty name",
+			key:       APIKey{Scopes: read, Tier: DefaultTier},
+			wantField: FieldName,
+		},
+		{
+			name:      "name too long",
+			key:       APIKey{Name: strings.Repeat("ż", MaxAPIKeyNameLength+1), Scopes: read, Tier: DefaultTier},
+			wantField: FieldName,
+		},
+		{
+			name:      "no scopes",
+			key:       APIKey{Name: "storefront", Tier: DefaultTier},
+			wantField: FieldScopes,
+		},
+		{
+			name:      "unknown scope",
+			key:       APIKey{Name: "storefront", Scopes: []Scope{"products:admin"}, Tier: DefaultTier},
+			wantField: FieldScopes,
+		},
+		{
+			name:      "no tier",
+			key:       APIKey{Name: "storefront", Scopes: read},
+			wantField: FieldTier,
+		},
+		{
+			name:      "malformed tier",
+			key:       APIKey{Name: "storefront", Scopes: read, Tier: "Gold Plus"},
+			wantField: FieldTier,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.key.Validate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			ce, ok := errors.AsType[*ConstraintError](err)
+			if !ok {
+				t.Fatalf("got %v, want a *ConstraintError", err)
+			}
+			if ce.Field != tt.wantField {
+				t.Errorf("got field %q, want %q", ce.Field, tt.wantField)
+			}
+		})
+	}
+}
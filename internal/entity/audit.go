@@ -0,0 +1,77 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditAction names the kind of product mutation an audit entry records.
+type AuditAction string
+
+const (
+	AuditCreate  AuditAction = "create"
+	AuditUpdate  AuditAction = "update"
+	AuditDelete  AuditAction = "delete"
+	AuditRestore AuditAction = "restore"
+	AuditPurge   AuditAction = "purge"
+)
+
+type (
+	// AuditEntry is an immutable record of a single product mutation
+	AuditEntry struct {
+		ID         int64
+		ProductID  uuid.UUID
+		Action     AuditAction
+		Actor      string
+		RequestID  string
+		OccurredAt time.Time
+		Changes    []FieldChange
+	}
+	// FieldChange holds the before and after value of one product field; nil means absent.
+	FieldChange struct {
+		Field  string
+		Before any
+		After  any
+	}
+	// AuditPage is a single keyset page of audit entries, newest first
+	AuditPage struct {
+		Items   []AuditEntry
+		HasMore bool
+	}
+)
+
+// Diff returns the field-level changes between before and after.
+// A nil before describes a creation, so every field of after is reported.
+func Diff(before, after *Product) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, b, a any, changed bool) {
+		if before == nil {
+			b = nil
+		} else if !changed {
+			return
+		}
+		changes = append(changes, FieldChange{Field: field, Before: b, After: a})
+	}
+
+	var prev Product
+	if before != nil {
+		prev = *before
+	}
+	add("name", prev.Name, after.Name, prev.Name != after.Name)
+	add("price.minorAmount", prev.Price.MinorAmount, after.Price.MinorAmount,
+		prev.Price.MinorAmount != after.Price.MinorAmount)
+	add("price.currency", string(prev.Price.Currency), string(after.Price.Currency),
+		prev.Price.Currency != after.Price.Currency)
+	if before != nil || after.Deleted() {
+		add("deletedAt", deletedAtValue(prev), deletedAtValue(*after), !prev.DeletedAt.Equal(after.DeletedAt))
+	}
+	return changes
+}
+
+func deletedAtValue(p Product) any {
+	if !p.Deleted() {
+		return nil
+	}
+	return p.DeletedAt.UTC()
+}
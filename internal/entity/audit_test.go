@@ -0,0 +1,61 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDiff(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+	deletedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	car := Product{ID: id, Name: "Car", Price: Money{MinorAmount: 100, Currency: CurrencyPLN}}
+
+	tests := []struct {
+		name   string
+		before *Product
+		after  Product
+		want   []FieldChange
+	}{
+		{
+			name:  "creation reports every field",
+			after: car,
+			want: []FieldChange{
+				{Field: "name", After: "Car"},
+				{Field: "price.minorAmount", After: int64(100)},
+				{Field: "price.currency", After: "PLN"},
+			},
+		},
+		{
+			name:   "update reports changed fields only",
+			before: &car,
+			after:  Product{ID: id, Name: "Car", Price: Money{MinorAmount: 250, Currency: CurrencyEUR}},
+			want: []FieldChange{
+				{Field: "price.minorAmount", Before: int64(100), After: int64(250)},
+				{Field: "price.currency", Before: "PLN", After: "EUR"},
+			},
+		},
+		{
+			name:   "soft delete reports the tombstone",
+			before: &car,
+			after:  Product{ID: id, Name: "Car", Price: car.Price, DeletedAt: deletedAt},
+			want:   []FieldChange{{Field: "deletedAt", Before: nil, After: deletedAt}},
+		},
+		{
+			name:   "no changes",
+			before: &car,
+			after:  car,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(tt.before, &tt.after)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
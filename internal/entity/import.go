@@ -0,0 +1,96 @@
+package entity
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrImportCheckpoint signals that an import chunk no longer continues from the job's
+	// checkpoint, because another worker has advanced it.
+	ErrImportCheckpoint = errors.New("entity: import checkpoint moved")
+	// ErrImportTooLarge rejects an import source exceeding the configured size limit.
+	ErrImportTooLarge = errors.New("entity: import source too large")
+)
+
+type (
+	// ImportFormat is the encoding of a bulk import source.
+	ImportFormat string
+	// ImportStatus is the lifecycle state of an import job.
+	ImportStatus string
+	// ImportOrigin tells which process owns an import job and may resume it.
+	ImportOrigin string
+)
+
+const (
+	// Keep these lists in sync with the import_jobs CHECK constraints.
+	ImportCSV    ImportFormat = "csv"
+	ImportNDJSON ImportFormat = "ndjson"
+
+	ImportPending   ImportStatus = "pending"
+	ImportRunning   ImportStatus = "running"
+	ImportCompleted ImportStatus = "completed"
+	ImportFailed    ImportStatus = "failed"
+
+	ImportOriginAPI ImportOrigin = "api"
+	ImportOriginCLI ImportOrigin = "cli"
+)
+
+type (
+	// ImportJob tracks a bulk product import. Line is the checkpoint: every input line
+	// up to and including it has been merged or rejected, so a resumed job skips them.
+	// Tenant owns the job and every product it loads. Source is the file a CLI job reads;
+	// uploads are kept in the store with their job and leave it empty.
+	ImportJob struct {
+		ID        uuid.UUID
+		Tenant    string
+		Format    ImportFormat
+		Origin    ImportOrigin
+		Source    string
+		Status    ImportStatus
+		Line      int64
+		Accepted  int64
+		Rejected  int64
+		Error     string
+		Actor     string
+		RequestID string
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+	// ImportRow is a valid product read from line Line of the source
+	ImportRow struct {
+		Line    int64
+		Product Product
+	}
+	// ImportReject is a source line that was not imported and why
+	ImportReject struct {
+		Line   int64
+		Reason string
+	}
+	// ImportChunk is the contiguous run of source lines after checkpoint From up to and
+	// including LastLine, applied atomically
+	ImportChunk struct {
+		JobID    uuid.UUID
+		From     int64
+		LastLine int64
+		Rows     []ImportRow
+		Rejects  []ImportReject
+	}
+	// ImportRejectPage is a single keyset page of rejects, ordered by line
+	ImportRejectPage struct {
+		Items   []ImportReject
+		HasMore bool
+	}
+)
+
+// Valid reports whether f is a supported import format.
+func (f ImportFormat) Valid() bool {
+	return f == ImportCSV || f == ImportNDJSON
+}
+
+// Done reports whether the job reached a terminal state.
+func (s ImportStatus) Done() bool {
+	return s == ImportCompleted || s == ImportFailed
+}
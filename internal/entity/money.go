@@ -1,7 +1,5 @@
 package entity
 
-import "errors"
-
 // Currency is a supported ISO 4217 currency code.
 type Currency string
 
@@ -31,10 +29,10 @@ func (c Currency) Valid() bool {
 
 func (m Money) Validate() error {
 	if m.MinorAmount <= 0 {
-		return errors.New("the product price must be positive")
+		return invalid(FieldPriceAmount, "the product price must be positive")
 	}
 	if !m.Currency.Valid() {
-		return errors.New("the product currency is invalid")
+		return invalid(FieldPriceCurrency, "the product currency is invalid")
 	}
 	return nil
 }
@@ -2,12 +2,51 @@ package entity
 
 import (
 	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
-// ErrNotFound signals a missing aggregate at the domain boundary.
-var ErrNotFound = errors.New("entity: not found")
+// MaxNameLength is the longest product name in characters.
+// Keep it in sync with the products.name VARCHAR length.
+const MaxNameLength = 100
+
+// Product fields named by a ConstraintError, spelled as in the API.
+const (
+	FieldID            = "id"
+	FieldName          = "name"
+	FieldPriceAmount   = "price.minorAmount"
+	FieldPriceCurrency = "price.currency"
+)
+
+var (
+	// ErrNotFound signals a missing aggregate at the domain boundary.
+	ErrNotFound = errors.New("entity: not found")
+	// ErrConflict signals a write colliding with existing data, such as a duplicate key.
+	ErrConflict = errors.New("entity: conflict")
+	// ErrConstraintViolation signals a write rejected by a data integrity rule of the store.
+	ErrConstraintViolation = errors.New("entity: constraint violation")
+)
+
+// ConstraintError attributes an invalid product or a rejected write to a single field.
+// It wraps ErrConflict or ErrConstraintViolation.
+type ConstraintError struct {
+	// Field is one of the Field constants, or empty when the store did not say which field it was.
+	Field  string
+	Reason string
+	Err    error
+}
+
+func (e *ConstraintError) Error() string { return e.Reason }
+
+func (e *ConstraintError) Unwrap() error { return e.Err }
+
+// invalid reports a field that breaks a business rule.
+func invalid(field, reason string) *ConstraintError {
+	return &ConstraintError{Field: field, Reason: reason, Err: ErrConstraintViolation}
+}
 
 type (
 	// Product represents a purchasable item in the system
@@ -15,21 +54,37 @@ type (
 		ID    uuid.UUID
 		Name  string
 		Price Money
+		// DeletedAt is the tombstone time of a soft-deleted product; zero for live products.
+		DeletedAt time.Time
 	}
 	// ProductPage is a single keyset page
 	ProductPage struct {
 		Items   []Product
 		HasMore bool
 	}
+	// ProductFilter narrows a product listing
+	ProductFilter struct {
+		IncludeDeleted bool
+		// Currency keeps only products priced in it; empty means any currency.
+		Currency Currency
+	}
 )
 
 // Validate ensures the product meets basic business rules before processing
 func (p *Product) Validate() error {
 	if p.Name == "" {
-		return errors.New("the product name is empty")
+		return invalid(FieldName, "the product name is empty")
+	}
+	if utf8.RuneCountInString(p.Name) > MaxNameLength {
+		return invalid(FieldName, fmt.Sprintf("the product name is longer than %d characters", MaxNameLength))
 	}
 	if err := p.Price.Validate(); err != nil {
 		return err
 	}
 	return nil
 }
+
+// Deleted reports whether the product carries a soft-delete tombstone.
+func (p *Product) Deleted() bool {
+	return !p.DeletedAt.IsZero()
+}
@@ -0,0 +1,67 @@
+package entity
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProduct_Validate(t *testing.T) {
+	price := Money{MinorAmount: 100, Currency: CurrencyPLN}
+	tests := []struct {
+		name      string
+		product   Product
+		wantField string
+	}{
+		{
+			name:    "valid",
+			product: Product{Name: "Car", Price: price},
+		},
+		{
+			name:    "longest name counted in characters",
+			product: Product{Name: strings.Repeat("ż", MaxNameLength), Price: price},
+		},
+		{
+			name:      "empty name",
+			product:   Product{Price: price},
+			wantField: FieldName,
+		},
+		{
+			name:      "name too long",
+			product:   Product{Name: strings.Repeat("ż", MaxNameLength+1), Price: price},
+			wantField: FieldName,
+		},
+		{
+			name:      "zero price",
+			product:   Product{Name: "Car", Price: Money{Currency: CurrencyPLN}},
+			wantField: FieldPriceAmount,
+		},
+		{
+			name:      "invalid currency",
+			product:   Product{Name: "Car", Price: Money{MinorAmount: 100, Currency: Currency("XXX")}},
+			wantField: FieldPriceCurrency,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.product.Validate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			ce, ok := errors.AsType[*ConstraintError](err)
+			if !ok {
+				t.Fatalf("got %v, want a *ConstraintError", err)
+			}
+			if ce.Field != tt.wantField {
+				t.Errorf("got field %q, want %q", ce.Field, tt.wantField)
+			}
+			if !errors.Is(err, ErrConstraintViolation) {
+				t.Errorf("got %v, want it to wrap ErrConstraintViolation", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// ReplicaStatus is the last health check of a read replica.
+type ReplicaStatus struct {
+	Addr string
+	// Available replicas receive reads: reachable and no further behind than the configured lag.
+	Available bool
+	Lag       time.Duration
+	// Error explains why the replica is unavailable.
+	Error     string
+	CheckedAt time.Time
+}
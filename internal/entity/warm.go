@@ -0,0 +1,54 @@
+package entity
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrWarmRunning is returned when a cache warm-up is asked for while another one runs.
+var ErrWarmRunning = errors.New("entity: cache warm-up already running")
+
+type (
+	// ProductRef identifies a product across tenants.
+	ProductRef struct {
+		Tenant string
+		ID     uuid.UUID
+	}
+	// WarmSource picks the products a cache warm-up preloads.
+	WarmSource string
+	// WarmStatus is the lifecycle state of a cache warm-up.
+	WarmStatus string
+)
+
+const (
+	// WarmHot preloads the most requested products, as counted by the cache.
+	WarmHot WarmSource = "hot"
+	// WarmRecent preloads the most recently created, changed or restored products.
+	WarmRecent WarmSource = "recent"
+
+	WarmRunning   WarmStatus = "running"
+	WarmCompleted WarmStatus = "completed"
+	WarmFailed    WarmStatus = "failed"
+)
+
+// WarmRun reports the progress of a cache warm-up. Picked products are loaded from the
+// database in batches; Missing were deleted since they were picked, and Stored reached the
+// cache.
+type WarmRun struct {
+	Source     WarmSource
+	Status     WarmStatus
+	Picked     int
+	Loaded     int
+	Missing    int
+	Stored     int
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Valid reports whether s is a supported warm-up source.
+func (s WarmSource) Valid() bool {
+	return s == WarmHot || s == WarmRecent
+}
@@ -0,0 +1,65 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+// CreateAPIKey issues a key for the tenant of the request, in the default rate limit tier unless
+// the body names one. The secret is in the response only; it cannot be retrieved again.
+func (h *InternalHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var in apiKeyInput
+	if err := decodeBody(r.Body, &in); err != nil {
+		respondDecodeError(w, err)
+		return
+	}
+
+	key, secret, err := h.keys.Create(r.Context(), in.Name, in.Scopes, in.Tier)
+	if err != nil {
+		if respondConstraintError(w, err) {
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "failed to create api key", slog.Any("error", err))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return
+	}
+	out := toAPIKeyResponse(key)
+	out.Secret = secret
+	respond(w, http.StatusCreated, out)
+}
+
+// ListAPIKeys lists the keys of the tenant of the request, revoked ones included.
+func (h *InternalHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List(r.Context())
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to list api keys", slog.Any("error", err))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return
+	}
+	respond(w, http.StatusOK, toAPIKeysList(keys))
+}
+
+// RevokeAPIKey revokes a key for good; requests using it get 401 from then on.
+func (h *InternalHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.keys.Revoke(r.Context(), id); err != nil {
+		if errors.Is(err, entity.ErrNotFound) {
+			respondError(w, http.StatusNotFound, "api key not found or already revoked")
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "failed to revoke api key", slog.Any("error", err),
+			slog.String("id", id.String()))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
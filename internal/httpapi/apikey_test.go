@@ -0,0 +1,152 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+type mockAPIKeys struct {
+	create func(context.Context, string, []entity.Scope, string) (entity.APIKey, string, error)
+	list   func(context.Context) ([]entity.APIKey, error)
+	revoke func(context.Context, uuid.UUID) error
+}
+
+func (m *mockAPIKeys) Create(ctx context.Context, name string, scopes []entity.Scope, tier string,
+) (entity.APIKey, string, error) {
+	return m.create(ctx, name, scopes, tier)
+}
+
+func (m *mockAPIKeys) List(ctx context.Context) ([]entity.APIKey, error) {
+	return m.list(ctx)
+}
+
+func (m *mockAPIKeys) Revoke(ctx context.Context, id uuid.UUID) error {
+	return m.revoke(ctx, id)
+}
+
+func newAPIKeyMux(m *mockAPIKeys) *http.ServeMux {
+	ih := NewInternalHandler(slog.New(slog.DiscardHandler), nil, nil, nil, nil, m, nil, ReadinessCfg{})
+	return NewInternalMux(ih, defaultTenant)
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "created",
+			body:       `{"name":"storefront","scopes":["products:read"],"tier":"premium"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "unknown scope",
+			body:       `{"name":"storefront","scopes":["products:admin"],"tier":"premium"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "malformed",
+			body:       `{"name":`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := newAPIKeyMux(&mockAPIKeys{
+				create: func(_ context.Context, name string, scopes []entity.Scope, tier string,
+				) (entity.APIKey, string, error) {
+					k := entity.APIKey{ID: id, Name: name, Scopes: scopes, Tier: tier, CreatedAt: time.Now()}
+					if err := k.Validate(); err != nil {
+						return entity.APIKey{}, "", err
+					}
+					return k, "sfk_secret", nil
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/apikey", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			var got apiKeyResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.ID != id || got.Secret != "sfk_secret" || got.Tier != "premium" {
+				t.Errorf("got %+v, want key %s in the premium tier with the issued secret", got, id)
+			}
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	revoked := entity.APIKey{ID: uuid.Must(uuid.NewV7()), Name: "old", RevokedAt: time.Now()}
+	mux := newAPIKeyMux(&mockAPIKeys{
+		list: func(context.Context) ([]entity.APIKey, error) {
+			return []entity.APIKey{revoked}, nil
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/apikey", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "secret") || !strings.Contains(body, "revokedAt") {
+		t.Errorf("got %s, want the revoked key without a secret", body)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	active := uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+	}{
+		{name: "revoked", id: active.String(), wantStatus: http.StatusNoContent},
+		{name: "unknown", id: uuid.Must(uuid.NewV7()).String(), wantStatus: http.StatusNotFound},
+		{name: "malformed id", id: "nope", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := newAPIKeyMux(&mockAPIKeys{
+				revoke: func(_ context.Context, id uuid.UUID) error {
+					if id != active {
+						return entity.ErrNotFound
+					}
+					return nil
+				},
+			})
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/apikey/"+tt.id, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// exportFlushEvery bounds how many NDJSON lines are buffered before a flush.
+const exportFlushEvery = 500
+
+// ExportAudit streams the audit log as NDJSON, oldest entry first.
+// The optional since query parameter (RFC 3339) limits the export to newer entries.
+func (h *InternalHandler) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	written := 0
+	for e, err := range h.audit.AuditLog(r.Context(), since) {
+		if err != nil {
+			if written == 0 {
+				h.logger.ErrorContext(r.Context(), "failed to export audit log", slog.Any("error", err))
+				respondError(w, http.StatusInternalServerError, msgInternalError)
+				return
+			}
+			// Headers are gone; abort so the client sees a truncated stream, not a clean EOF.
+			h.logger.ErrorContext(r.Context(), "audit export aborted", slog.Any("error", err),
+				slog.Int("written", written))
+			panic(http.ErrAbortHandler)
+		}
+		if written == 0 {
+			w.Header().Set("Content-Type", MediaTypeNDJSON)
+			w.WriteHeader(http.StatusOK)
+		}
+		if err := enc.Encode(toAuditEntryResponse(e)); err != nil {
+			h.logger.WarnContext(r.Context(), "audit export write failed", slog.Any("error", err))
+			return
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			if err := rc.Flush(); err != nil {
+				h.logger.WarnContext(r.Context(), "audit export flush failed", slog.Any("error", err))
+				return
+			}
+		}
+	}
+	if written == 0 {
+		w.Header().Set("Content-Type", MediaTypeNDJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func parseSince(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since: %q", raw)
+	}
+	return t, nil
+}
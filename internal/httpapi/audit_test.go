@@ -0,0 +1,114 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+type mockAuditLog func(context.Context, time.Time) iter.Seq2[entity.AuditEntry, error]
+
+func (m mockAuditLog) AuditLog(ctx context.Context, since time.Time) iter.Seq2[entity.AuditEntry, error] {
+	return m(ctx, since)
+}
+
+func auditEntries(entries []entity.AuditEntry, err error) iter.Seq2[entity.AuditEntry, error] {
+	return func(yield func(entity.AuditEntry, error) bool) {
+		for _, e := range entries {
+			if !yield(e, nil) {
+				return
+			}
+		}
+		if err != nil {
+			yield(entity.AuditEntry{}, err)
+		}
+	}
+}
+
+func TestExportAudit(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		url        string
+		entries    []entity.AuditEntry
+		err        error
+		wantStatus int
+		wantLines  int
+	}{
+		{
+			name: "streams entries as ndjson",
+			url:  "/audit/export?since=" + since.Format(time.RFC3339),
+			entries: []entity.AuditEntry{
+				{ID: 1, ProductID: id, Action: entity.AuditCreate},
+				{ID: 2, ProductID: id, Action: entity.AuditDelete},
+			},
+			wantStatus: http.StatusOK,
+			wantLines:  2,
+		},
+		{
+			name:       "empty log",
+			url:        "/audit/export",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "failure before first entry",
+			url:        "/audit/export",
+			err:        errors.New("db down"),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "invalid since",
+			url:        "/audit/export?since=yesterday",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := mockAuditLog(func(_ context.Context, got time.Time) iter.Seq2[entity.AuditEntry, error] {
+				if tt.wantLines > 0 && !got.Equal(since) {
+					t.Errorf("got since %v, want %v", got, since)
+				}
+				return auditEntries(tt.entries, tt.err)
+			})
+			ih := NewInternalHandler(slog.New(slog.DiscardHandler), nil, nil, log, nil, nil, nil, ReadinessCfg{})
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tt.url, nil)
+			rec := httptest.NewRecorder()
+			NewInternalMux(ih, defaultTenant).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != MediaTypeNDJSON {
+				t.Errorf("got content-type %q, want %q", got, MediaTypeNDJSON)
+			}
+			lines := 0
+			sc := bufio.NewScanner(rec.Body)
+			for sc.Scan() {
+				e := decodeJSON[auditEntryResponse](t, strings.NewReader(sc.Text()))
+				if e.ID != tt.entries[lines].ID {
+					t.Errorf("line %d: got id %d, want %d", lines, e.ID, tt.entries[lines].ID)
+				}
+				lines++
+			}
+			if lines != tt.wantLines {
+				t.Errorf("got %d lines, want %d", lines, tt.wantLines)
+			}
+		})
+	}
+}
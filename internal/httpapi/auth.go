@@ -0,0 +1,168 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/jwt"
+	"github.com/alkmc/storefront/internal/reqctx"
+)
+
+const (
+	msgMissingAPIKey = "missing api key"
+	msgInvalidAPIKey = "invalid api key"
+	msgInvalidToken  = "invalid bearer token"
+	// actorAPIKeyPrefix and actorJWTPrefix attribute requests, and the changes they make,
+	// to the key or token subject that made them.
+	actorAPIKeyPrefix = "apikey:"
+	actorJWTPrefix    = "jwt:"
+)
+
+type (
+	// Authenticator verifies the secret of an API key.
+	Authenticator interface {
+		Authenticate(context.Context, string) (entity.APIKey, error)
+	}
+	// TokenVerifier verifies bearer JWTs.
+	TokenVerifier interface {
+		Verify(context.Context, string) (jwt.Claims, error)
+	}
+	// principal is the authenticated client of a request and what it may do.
+	principal struct {
+		// ID identifies the client: "apikey:" and the key id, or "jwt:" and the token subject.
+		ID     string
+		Tenant string
+		Scopes []entity.Scope
+		// Tier selects the rate limits of the principal's requests.
+		Tier string
+	}
+	principalCtxKey struct{}
+)
+
+// allows reports whether the principal was granted scope s.
+func (p principal) allows(s entity.Scope) bool {
+	return slices.Contains(p.Scopes, s)
+}
+
+// authenticate requires every request to present an API key, as a bearer token or in
+// X-API-Key, or a JWT bearer token when tokens is not nil, and stores the principal it
+// authenticates in the request context. Requests without valid credentials get 401.
+// The principal's tenant and identity become those of the request.
+func authenticate(keys Authenticator, tokens TokenVerifier) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret, bearer := credential(r)
+			var (
+				p  principal
+				ok bool
+			)
+			switch {
+			case secret == "":
+				unauthorized(w, msgMissingAPIKey)
+				return
+			case bearer && tokens != nil && looksLikeJWT(secret):
+				p, ok = verifyToken(w, r, tokens, secret)
+			default:
+				p, ok = verifyAPIKey(w, r, keys, secret)
+			}
+			if !ok {
+				return
+			}
+			ctx := context.WithValue(r.Context(), principalCtxKey{}, p)
+			ctx = reqctx.WithActor(reqctx.WithTenant(ctx, p.Tenant), p.ID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func verifyAPIKey(w http.ResponseWriter, r *http.Request, keys Authenticator, secret string,
+) (principal, bool) {
+	key, err := keys.Authenticate(r.Context(), secret)
+	if err != nil {
+		if errors.Is(err, entity.ErrInvalidAPIKey) {
+			unauthorized(w, msgInvalidAPIKey)
+			return principal{}, false
+		}
+		slog.Default().ErrorContext(r.Context(), "failed to authenticate api key", slog.Any("error", err))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return principal{}, false
+	}
+	return principal{
+		ID:     actorAPIKeyPrefix + key.ID.String(),
+		Tenant: key.Tenant,
+		Scopes: key.Scopes,
+		Tier:   key.Tier,
+	}, true
+}
+
+func verifyToken(w http.ResponseWriter, r *http.Request, tokens TokenVerifier, token string,
+) (principal, bool) {
+	c, err := tokens.Verify(r.Context(), token)
+	if err == nil && !validTenant(c.Tenant) {
+		err = errors.New("malformed tenant claim")
+	}
+	if err != nil {
+		// The reason stays in the logs; clients only learn that the token was refused.
+		slog.Default().InfoContext(r.Context(), "rejected bearer token", slog.Any("error", err))
+		w.Header().Set("WWW-Authenticate", `Bearer realm="storefront", error="invalid_token"`)
+		respondError(w, http.StatusUnauthorized, msgInvalidToken)
+		return principal{}, false
+	}
+	return principal{ID: actorJWTPrefix + c.Subject, Tenant: c.Tenant, Scopes: c.Scopes, Tier: c.Tier}, true
+}
+
+// credential returns the bearer token of the request, or else its X-API-Key, and whether it
+// was a bearer token.
+func credential(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token), true
+	}
+	return r.Header.Get(headerAPIKey), false
+}
+
+// looksLikeJWT tells a compact JWS, three dot-separated parts, from an API key secret,
+// which has no dots.
+func looksLikeJWT(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="storefront"`)
+	respondError(w, http.StatusUnauthorized, msg)
+}
+
+// principalFrom returns the principal that authenticated the request in ctx.
+func principalFrom(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(principal)
+	return p, ok
+}
+
+// requireScope serves h only to requests whose principal was granted scope; see authorize.
+func requireScope(scope entity.Scope, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize(w, r, scope) {
+			h(w, r)
+		}
+	})
+}
+
+// authorize reports whether the principal of r was granted scope. When it was not, it answers
+// 403, or 401 when nothing authenticated the request.
+func authorize(w http.ResponseWriter, r *http.Request, scope entity.Scope) bool {
+	p, ok := principalFrom(r.Context())
+	if !ok {
+		unauthorized(w, msgMissingAPIKey)
+		return false
+	}
+	if !p.allows(scope) {
+		respondError(w, http.StatusForbidden, "the credentials lack the "+string(scope)+" scope")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,246 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/jwt"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// withAPIKey serves next as if every request had been authenticated by a key of the
+// default tenant with all scopes.
+func withAPIKey(next http.Handler) http.Handler {
+	p := principal{ID: actorAPIKeyPrefix + uuid.NewString(), Tenant: reqctx.DefaultTenant, Scopes: entity.Scopes}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalCtxKey{}, p)))
+	})
+}
+
+type authenticatorFunc func(context.Context, string) (entity.APIKey, error)
+
+func (f authenticatorFunc) Authenticate(ctx context.Context, secret string) (entity.APIKey, error) {
+	return f(ctx, secret)
+}
+
+type verifierFunc func(context.Context, string) (jwt.Claims, error)
+
+func (f verifierFunc) Verify(ctx context.Context, token string) (jwt.Claims, error) {
+	return f(ctx, token)
+}
+
+func TestAuthenticate(t *testing.T) {
+	key := entity.APIKey{ID: uuid.Must(uuid.NewV7()), Tenant: "acme", Scopes: entity.Scopes}
+	apiKeyActor := actorAPIKeyPrefix + key.ID.String()
+	auth := authenticatorFunc(func(_ context.Context, secret string) (entity.APIKey, error) {
+		switch secret {
+		case "sfk_valid":
+			return key, nil
+		case "sfk_broken":
+			return entity.APIKey{}, errors.New("db down")
+		default:
+			return entity.APIKey{}, entity.ErrInvalidAPIKey
+		}
+	})
+
+	tokens := verifierFunc(func(_ context.Context, token string) (jwt.Claims, error) {
+		switch token {
+		case "h.valid.s":
+			return jwt.Claims{Subject: "user-1", Tenant: "globex", Scopes: entity.Scopes}, nil
+		case "h.tenant.s":
+			return jwt.Claims{Subject: "user-1", Tenant: "Not A Tenant"}, nil
+		default:
+			return jwt.Claims{}, jwt.ErrInvalidToken
+		}
+	})
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		noTokens   bool
+		wantStatus int
+		wantActor  string
+		wantTenant string
+	}{
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+		{
+			name:       "bearer",
+			header:     "Authorization",
+			value:      "Bearer sfk_valid",
+			wantStatus: http.StatusOK,
+			wantActor:  apiKeyActor,
+			wantTenant: "acme",
+		},
+		{
+			name:       "bearer case",
+			header:     "Authorization",
+			value:      "bearer sfk_valid",
+			wantStatus: http.StatusOK,
+			wantActor:  apiKeyActor,
+			wantTenant: "acme",
+		},
+		{
+			name:       "header",
+			header:     headerAPIKey,
+			value:      "sfk_valid",
+			wantStatus: http.StatusOK,
+			wantActor:  apiKeyActor,
+			wantTenant: "acme",
+		},
+		{
+			name:       "basic",
+			header:     "Authorization",
+			value:      "Basic c2ZrX3ZhbGlk",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{name: "invalid", header: headerAPIKey, value: "sfk_other", wantStatus: http.StatusUnauthorized},
+		{
+			name:       "store error",
+			header:     headerAPIKey,
+			value:      "sfk_broken",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "jwt",
+			header:     "Authorization",
+			value:      "Bearer h.valid.s",
+			wantStatus: http.StatusOK,
+			wantActor:  actorJWTPrefix + "user-1",
+			wantTenant: "globex",
+		},
+		{
+			name:       "invalid jwt",
+			header:     "Authorization",
+			value:      "Bearer h.forged.s",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "jwt tenant",
+			header:     "Authorization",
+			value:      "Bearer h.tenant.s",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "jwt disabled",
+			header:     "Authorization",
+			value:      "Bearer h.valid.s",
+			noTokens:   true,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got principal
+			var tenant, actor string
+			var verifier TokenVerifier = tokens
+			if tt.noTokens {
+				verifier = nil
+			}
+			h := authenticate(auth, verifier)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got, _ = principalFrom(r.Context())
+				tenant, actor = reqctx.Tenant(r.Context()), reqctx.Actor(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/product", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate challenge")
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if got.ID != tt.wantActor || actor != tt.wantActor {
+				t.Errorf("got principal %q and actor %q, want %q", got.ID, actor, tt.wantActor)
+			}
+			if got.Tenant != tt.wantTenant || tenant != tt.wantTenant {
+				t.Errorf("got tenant %q, want %q", tenant, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		p          *principal
+		wantStatus int
+	}{
+		{name: "no key", wantStatus: http.StatusUnauthorized},
+		{
+			name:       "missing scope",
+			p:          &principal{Scopes: []entity.Scope{entity.ScopeProductsRead}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "granted",
+			p:          &principal{Scopes: []entity.Scope{entity.ScopeProductsWrite}},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := requireScope(entity.ScopeProductsWrite, func(http.ResponseWriter, *http.Request) {})
+			req := httptest.NewRequest(http.MethodPost, "/product", nil)
+			if tt.p != nil {
+				req = req.WithContext(context.WithValue(req.Context(), principalCtxKey{}, *tt.p))
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewMux_DeletedProductsNeedWriteScope(t *testing.T) {
+	proc := new(mockProcessor{
+		findAll: func(context.Context, uuid.NullUUID, int, entity.ProductFilter) (entity.ProductPage, error) {
+			return entity.ProductPage{}, nil
+		},
+	})
+	reader := principal{ID: "apikey:1", Scopes: []entity.Scope{entity.ScopeProductsRead}}
+	mux := withPrincipal(reader, NewMux(NewHandler(slog.New(slog.DiscardHandler), proc, time.Second), nil))
+
+	tests := []struct {
+		url        string
+		wantStatus int
+	}{
+		{url: "/product", wantStatus: http.StatusOK},
+		{url: "/product?includeDeleted=false", wantStatus: http.StatusOK},
+		{url: "/product?includeDeleted=true", wantStatus: http.StatusForbidden},
+		{url: "/product/export?includeDeleted=true", wantStatus: http.StatusForbidden},
+		{url: "/product/" + uuid.NewString() + "/history", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tt.url, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
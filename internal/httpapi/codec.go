@@ -5,12 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 const (
-	MediaTypeJSON = "application/json"
+	MediaTypeJSON   = "application/json"
+	MediaTypeNDJSON = "application/x-ndjson"
+	MediaTypeCSV    = "text/csv"
 
 	msgEncodeFailed  = "error encoding data"
 	msgBodyTooLarge  = "request body too large"
@@ -22,6 +26,8 @@ const (
 
 type messageResponse struct {
 	Message string `json:"message"`
+	// Field names the offending input field of a 409 or 422, e.g. "price.currency".
+	Field string `json:"field,omitempty"`
 }
 
 // respond replies to the request with the specified payload and HTTP code
@@ -73,3 +79,54 @@ func mapDecodeError(err error) (msg string, status int) {
 	}
 	return msgInvalidBody, http.StatusBadRequest
 }
+
+// negotiate picks the offer the Accept header prefers, honouring q-values and wildcards.
+// Ties go to the earlier offer, and an absent header accepts the first one.
+// It returns "" when the client accepts none of the offers.
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value of the most specific Accept range matching offer.
+func acceptQuality(accept, offer string) float64 {
+	offerType, _, _ := strings.Cut(offer, "/")
+	q, specificity := 0.0, -1
+	for r := range strings.SplitSeq(accept, ",") {
+		mt, params, err := mime.ParseMediaType(r)
+		if err != nil {
+			continue
+		}
+		rangeType, rangeSub, _ := strings.Cut(mt, "/")
+		var s int
+		switch {
+		case mt == offer:
+			s = 2
+		case rangeType == offerType && rangeSub == "*":
+			s = 1
+		case mt == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		rq := 1.0
+		if raw, ok := params["q"]; ok {
+			if rq, err = strconv.ParseFloat(raw, 64); err != nil || rq < 0 || rq > 1 {
+				continue
+			}
+		}
+		q, specificity = rq, s
+	}
+	return q
+}
@@ -1,15 +1,19 @@
 package httpapi
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/alkmc/storefront/internal/entity"
 	"github.com/google/uuid"
 )
 
 type (
 	productResponse struct {
-		ID    uuid.UUID `json:"id"`
-		Name  string    `json:"name"`
-		Price moneyDTO  `json:"price"`
+		ID        uuid.UUID  `json:"id"`
+		Name      string     `json:"name"`
+		Price     moneyDTO   `json:"price"`
+		DeletedAt *time.Time `json:"deletedAt,omitempty"`
 	}
 	moneyDTO struct {
 		MinorAmount int64           `json:"minorAmount"`
@@ -19,10 +23,110 @@ type (
 		Items      []productResponse `json:"items"`
 		NextCursor string            `json:"nextCursor,omitempty"`
 	}
+	// productBatch answers a batch lookup: the products found, and the ids of the others.
+	productBatch struct {
+		Items    []productResponse `json:"items"`
+		NotFound []uuid.UUID       `json:"notFound"`
+	}
+	auditEntryResponse struct {
+		ID         int64            `json:"id"`
+		ProductID  uuid.UUID        `json:"productId"`
+		Action     string           `json:"action"`
+		Actor      string           `json:"actor"`
+		RequestID  string           `json:"requestId,omitempty"`
+		OccurredAt time.Time        `json:"occurredAt"`
+		Changes    []fieldChangeDTO `json:"changes"`
+	}
+	fieldChangeDTO struct {
+		Field  string `json:"field"`
+		Before any    `json:"before"`
+		After  any    `json:"after"`
+	}
+	auditPage struct {
+		Items      []auditEntryResponse `json:"items"`
+		NextCursor string               `json:"nextCursor,omitempty"`
+	}
+	importJobResponse struct {
+		ID        uuid.UUID `json:"id"`
+		Format    string    `json:"format"`
+		Status    string    `json:"status"`
+		Line      int64     `json:"line"`
+		Accepted  int64     `json:"accepted"`
+		Rejected  int64     `json:"rejected"`
+		Error     string    `json:"error,omitempty"`
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+	warmRunResponse struct {
+		Source     string     `json:"source"`
+		Status     string     `json:"status"`
+		Picked     int        `json:"picked"`
+		Loaded     int        `json:"loaded"`
+		Missing    int        `json:"missing"`
+		Stored     int        `json:"stored"`
+		Error      string     `json:"error,omitempty"`
+		StartedAt  time.Time  `json:"startedAt"`
+		FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	}
+	importRejectDTO struct {
+		Line   int64  `json:"line"`
+		Reason string `json:"reason"`
+	}
+	importRejectsPage struct {
+		Items      []importRejectDTO `json:"items"`
+		NextCursor string            `json:"nextCursor,omitempty"`
+	}
+	apiKeyInput struct {
+		Name   string         `json:"name"`
+		Scopes []entity.Scope `json:"scopes"`
+		Tier   string         `json:"tier"`
+	}
+	apiKeyResponse struct {
+		ID         uuid.UUID      `json:"id"`
+		Name       string         `json:"name"`
+		Tenant     string         `json:"tenant"`
+		Scopes     []entity.Scope `json:"scopes"`
+		Tier       string         `json:"tier"`
+		LastUsedAt time.Time      `json:"lastUsedAt,omitzero"`
+		RevokedAt  time.Time      `json:"revokedAt,omitzero"`
+		CreatedAt  time.Time      `json:"createdAt"`
+		// Secret is only returned on creation.
+		Secret string `json:"secret,omitempty"`
+	}
+	apiKeysList struct {
+		Items []apiKeyResponse `json:"items"`
+	}
+	healthResponse struct {
+		Status       string             `json:"status"`
+		Dependencies []dependencyDTO    `json:"dependencies"`
+		Replicas     []replicaStatusDTO `json:"replicas,omitempty"`
+	}
+	dependencyDTO struct {
+		Name     string `json:"name"`
+		Status   string `json:"status"`
+		Required bool   `json:"required"`
+		// Breaker is the state of the circuit breaker in front of the dependency, if any.
+		Breaker string `json:"breaker,omitempty"`
+		Error   string `json:"error,omitempty"`
+	}
+	readinessResponse struct {
+		Replicas []replicaStatusDTO `json:"replicas"`
+	}
+	replicaStatusDTO struct {
+		Addr       string    `json:"addr"`
+		Available  bool      `json:"available"`
+		LagSeconds float64   `json:"lagSeconds"`
+		Error      string    `json:"error,omitempty"`
+		CheckedAt  time.Time `json:"checkedAt,omitzero"`
+	}
 )
 
 func toProductResponse(p entity.Product) productResponse {
-	return productResponse{ID: p.ID, Name: p.Name, Price: toMoneyDTO(p.Price)}
+	resp := productResponse{ID: p.ID, Name: p.Name, Price: toMoneyDTO(p.Price)}
+	if p.Deleted() {
+		resp.DeletedAt = new(p.DeletedAt.UTC())
+	}
+	return resp
 }
 
 func toProductsResponse(ps []entity.Product) []productResponse {
@@ -40,6 +144,13 @@ func toProductsPage(page entity.ProductPage) productsPage {
 	}
 }
 
+func toProductBatch(found []entity.Product, notFound []uuid.UUID) productBatch {
+	if notFound == nil {
+		notFound = []uuid.UUID{}
+	}
+	return productBatch{Items: toProductsResponse(found), NotFound: notFound}
+}
+
 func nextCursor(page entity.ProductPage) string {
 	if !page.HasMore || len(page.Items) == 0 {
 		return ""
@@ -54,3 +165,114 @@ func toMoney(in moneyInput) entity.Money {
 func toMoneyDTO(m entity.Money) moneyDTO {
 	return moneyDTO{MinorAmount: m.MinorAmount, Currency: m.Currency}
 }
+
+func toAuditEntryResponse(e entity.AuditEntry) auditEntryResponse {
+	changes := make([]fieldChangeDTO, len(e.Changes))
+	for i, c := range e.Changes {
+		changes[i] = fieldChangeDTO{Field: c.Field, Before: c.Before, After: c.After}
+	}
+	return auditEntryResponse{
+		ID:         e.ID,
+		ProductID:  e.ProductID,
+		Action:     string(e.Action),
+		Actor:      e.Actor,
+		RequestID:  e.RequestID,
+		OccurredAt: e.OccurredAt.UTC(),
+		Changes:    changes,
+	}
+}
+
+func toAuditPage(page entity.AuditPage) auditPage {
+	items := make([]auditEntryResponse, len(page.Items))
+	for i, e := range page.Items {
+		items[i] = toAuditEntryResponse(e)
+	}
+	out := auditPage{Items: items}
+	if page.HasMore && len(page.Items) > 0 {
+		out.NextCursor = strconv.FormatInt(page.Items[len(page.Items)-1].ID, 10)
+	}
+	return out
+}
+
+func toImportJobResponse(j entity.ImportJob) importJobResponse {
+	return importJobResponse{
+		ID:        j.ID,
+		Format:    string(j.Format),
+		Status:    string(j.Status),
+		Line:      j.Line,
+		Accepted:  j.Accepted,
+		Rejected:  j.Rejected,
+		Error:     j.Error,
+		CreatedAt: j.CreatedAt.UTC(),
+		UpdatedAt: j.UpdatedAt.UTC(),
+	}
+}
+
+func toWarmRunResponse(run entity.WarmRun) warmRunResponse {
+	resp := warmRunResponse{
+		Source:    string(run.Source),
+		Status:    string(run.Status),
+		Picked:    run.Picked,
+		Loaded:    run.Loaded,
+		Missing:   run.Missing,
+		Stored:    run.Stored,
+		Error:     run.Error,
+		StartedAt: run.StartedAt.UTC(),
+	}
+	if !run.FinishedAt.IsZero() {
+		resp.FinishedAt = new(run.FinishedAt.UTC())
+	}
+	return resp
+}
+
+func toImportRejectsPage(page entity.ImportRejectPage) importRejectsPage {
+	items := make([]importRejectDTO, len(page.Items))
+	for i, r := range page.Items {
+		items[i] = importRejectDTO{Line: r.Line, Reason: r.Reason}
+	}
+	out := importRejectsPage{Items: items}
+	if page.HasMore && len(page.Items) > 0 {
+		out.NextCursor = strconv.FormatInt(page.Items[len(page.Items)-1].Line, 10)
+	}
+	return out
+}
+
+func toAPIKeyResponse(k entity.APIKey) apiKeyResponse {
+	out := apiKeyResponse{
+		ID:        k.ID,
+		Name:      k.Name,
+		Tenant:    k.Tenant,
+		Scopes:    k.Scopes,
+		Tier:      k.Tier,
+		CreatedAt: k.CreatedAt.UTC(),
+	}
+	if !k.LastUsedAt.IsZero() {
+		out.LastUsedAt = k.LastUsedAt.UTC()
+	}
+	if k.Revoked() {
+		out.RevokedAt = k.RevokedAt.UTC()
+	}
+	return out
+}
+
+func toAPIKeysList(keys []entity.APIKey) apiKeysList {
+	items := make([]apiKeyResponse, len(keys))
+	for i, k := range keys {
+		items[i] = toAPIKeyResponse(k)
+	}
+	return apiKeysList{Items: items}
+}
+
+func toReadinessResponse(replicas []entity.ReplicaStatus) readinessResponse {
+	out := make([]replicaStatusDTO, len(replicas))
+	for i, r := range replicas {
+		out[i] = replicaStatusDTO{
+			Addr:       r.Addr,
+			Available:  r.Available,
+			LagSeconds: r.Lag.Seconds(),
+			Error:      r.Error,
+			CheckedAt:  r.CheckedAt,
+		}
+	}
+	return readinessResponse{Replicas: out}
+}
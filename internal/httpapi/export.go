@@ -0,0 +1,203 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+// exportWriteWindow replaces HTTP_WRITE_TIMEOUT for exports: the write deadline is pushed
+// this far ahead on every flush, so a slow client fails fast but a large catalog does not.
+const exportWriteWindow = 30 * time.Second
+
+type (
+	// productEncoder writes one export document; close terminates it.
+	productEncoder interface {
+		encode(entity.Product) error
+		flush() error
+		close() error
+	}
+	exportFormat struct {
+		contentType string
+		filename    string
+		newEncoder  func(io.Writer) productEncoder
+	}
+	jsonArrayEncoder struct {
+		w       io.Writer
+		enc     *json.Encoder
+		written bool
+	}
+	ndjsonEncoder struct {
+		enc *json.Encoder
+	}
+	csvEncoder struct {
+		w *csv.Writer
+	}
+)
+
+// exportOffers lists the media types Export negotiates, in order of preference.
+var exportOffers = []string{MediaTypeJSON, MediaTypeNDJSON, MediaTypeCSV}
+
+var exportFormats = map[string]exportFormat{
+	MediaTypeJSON: {
+		contentType: MediaTypeJSON,
+		filename:    "products.json",
+		newEncoder:  func(w io.Writer) productEncoder { return &jsonArrayEncoder{w: w, enc: json.NewEncoder(w)} },
+	},
+	MediaTypeNDJSON: {
+		contentType: MediaTypeNDJSON,
+		filename:    "products.ndjson",
+		newEncoder:  func(w io.Writer) productEncoder { return ndjsonEncoder{enc: json.NewEncoder(w)} },
+	},
+	MediaTypeCSV: {
+		contentType: MediaTypeCSV + "; charset=utf-8",
+		filename:    "products.csv",
+		newEncoder:  newCSVEncoder,
+	},
+}
+
+// Export streams the whole catalog in id order as a JSON array, NDJSON or CSV, chosen by Accept.
+// It takes the includeDeleted and currency filters of the list endpoint. Rows are read through
+// a database cursor and flushed as they go, so neither side holds the catalog in memory.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	f, err := parseProductFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if f.IncludeDeleted && !authorize(w, r, entity.ScopeProductsWrite) {
+		return
+	}
+	format, ok := exportFormats[negotiate(r.Header.Get("Accept"), exportOffers...)]
+	if !ok {
+		respondError(w, http.StatusNotAcceptable, "export is available as application/json, "+
+			"application/x-ndjson or text/csv")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := extendWriteDeadline(rc); err != nil {
+		h.logger.WarnContext(r.Context(), "failed to extend export write deadline", slog.Any("error", err))
+	}
+
+	var enc productEncoder
+	start := func() {
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+format.filename+`"`)
+		w.WriteHeader(http.StatusOK)
+		enc = format.newEncoder(w)
+	}
+	written := 0
+	for p, err := range h.processor.Export(r.Context(), f) {
+		if err != nil {
+			if enc == nil {
+				h.internalError(w, r, "failed to export products", slog.Any("error", err))
+				return
+			}
+			// Headers are gone; abort so the client sees a truncated stream, not a clean EOF.
+			h.logger.ErrorContext(r.Context(), "product export aborted", slog.Any("error", err),
+				slog.Int("written", written))
+			panic(http.ErrAbortHandler)
+		}
+		if enc == nil {
+			start()
+		}
+		if err := enc.encode(p); err != nil {
+			h.logger.WarnContext(r.Context(), "product export write failed", slog.Any("error", err))
+			return
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			if err := flushExport(rc, enc); err != nil {
+				h.logger.WarnContext(r.Context(), "product export flush failed", slog.Any("error", err))
+				return
+			}
+		}
+	}
+	if enc == nil {
+		start()
+	}
+	if err := enc.close(); err != nil {
+		h.logger.WarnContext(r.Context(), "product export write failed", slog.Any("error", err))
+	}
+}
+
+// flushExport pushes buffered rows to the client and moves the write deadline forward.
+func flushExport(rc *http.ResponseController, enc productEncoder) error {
+	if err := enc.flush(); err != nil {
+		return err
+	}
+	if err := rc.Flush(); err != nil {
+		return err
+	}
+	return extendWriteDeadline(rc)
+}
+
+func extendWriteDeadline(rc *http.ResponseController) error {
+	err := rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+func (e *jsonArrayEncoder) encode(p entity.Product) error {
+	sep := ","
+	if !e.written {
+		sep, e.written = "[", true
+	}
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	return e.enc.Encode(toProductResponse(p))
+}
+
+func (e *jsonArrayEncoder) flush() error { return nil }
+
+func (e *jsonArrayEncoder) close() error {
+	end := "]\n"
+	if !e.written {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+func (e ndjsonEncoder) encode(p entity.Product) error {
+	return e.enc.Encode(toProductResponse(p))
+}
+
+func (e ndjsonEncoder) flush() error { return nil }
+
+func (e ndjsonEncoder) close() error { return nil }
+
+// newCSVEncoder writes the header row; deletedAt is empty for live products.
+func newCSVEncoder(w io.Writer) productEncoder {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "name", "minorAmount", "currency", "deletedAt"})
+	return csvEncoder{w: cw}
+}
+
+func (e csvEncoder) encode(p entity.Product) error {
+	var deletedAt string
+	if p.Deleted() {
+		deletedAt = p.DeletedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return e.w.Write([]string{
+		p.ID.String(), p.Name, strconv.FormatInt(p.Price.MinorAmount, 10), string(p.Price.Currency), deletedAt,
+	})
+}
+
+func (e csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e csvEncoder) close() error { return e.flush() }
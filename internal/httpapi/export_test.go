@@ -0,0 +1,241 @@
+package httpapi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+func productSeq(products []entity.Product, err error) iter.Seq2[entity.Product, error] {
+	return func(yield func(entity.Product, error) bool) {
+		for _, p := range products {
+			if !yield(p, nil) {
+				return
+			}
+		}
+		if err != nil {
+			yield(entity.Product{}, err)
+		}
+	}
+}
+
+func TestExportProducts(t *testing.T) {
+	mux, proc := setupTest(t, testHTTPConfig)
+	products := []entity.Product{
+		{ID: uuid.Must(uuid.NewV7()), Name: "Car", Price: testMoney()},
+		{ID: uuid.Must(uuid.NewV7()), Name: "Bike, red", Price: testMoney(), DeletedAt: time.Now()},
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		accept     string
+		products   []entity.Product
+		err        error
+		wantStatus int
+		wantType   string
+		wantFilter entity.ProductFilter
+	}{
+		{
+			name:       "json array by default",
+			url:        "/product/export",
+			products:   products,
+			wantStatus: http.StatusOK,
+			wantType:   MediaTypeJSON,
+		},
+		{
+			name:       "ndjson",
+			url:        "/product/export",
+			accept:     MediaTypeNDJSON,
+			products:   products,
+			wantStatus: http.StatusOK,
+			wantType:   MediaTypeNDJSON,
+		},
+		{
+			name:       "csv preferred by q-value",
+			url:        "/product/export?includeDeleted=true&currency=PLN",
+			accept:     "application/json;q=0.5, text/*",
+			products:   products,
+			wantStatus: http.StatusOK,
+			wantType:   MediaTypeCSV + "; charset=utf-8",
+			wantFilter: entity.ProductFilter{IncludeDeleted: true, Currency: entity.CurrencyPLN},
+		},
+		{
+			name:       "empty catalog",
+			url:        "/product/export",
+			wantStatus: http.StatusOK,
+			wantType:   MediaTypeJSON,
+		},
+		{
+			name:       "not acceptable",
+			url:        "/product/export",
+			accept:     "application/xml",
+			wantStatus: http.StatusNotAcceptable,
+		},
+		{
+			name:       "invalid currency",
+			url:        "/product/export?currency=BTC",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "failure before first row",
+			url:        "/product/export",
+			err:        errors.New("db down"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc.export = func(_ context.Context, f entity.ProductFilter) iter.Seq2[entity.Product, error] {
+				if f != tt.wantFilter {
+					t.Errorf("got filter %+v, want %+v", f, tt.wantFilter)
+				}
+				return productSeq(tt.products, tt.err)
+			}
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Fatalf("got content-type %q, want %q", got, tt.wantType)
+			}
+
+			var got []productResponse
+			switch tt.wantType {
+			case MediaTypeJSON:
+				got = decodeJSON[[]productResponse](t, rec.Body)
+			case MediaTypeNDJSON:
+				sc := bufio.NewScanner(rec.Body)
+				for sc.Scan() {
+					got = append(got, decodeJSON[productResponse](t, strings.NewReader(sc.Text())))
+				}
+			default:
+				records, err := csv.NewReader(rec.Body).ReadAll()
+				if err != nil {
+					t.Fatalf("read csv: %v", err)
+				}
+				if records[0][0] != "id" || records[2][4] == "" {
+					t.Errorf("got header %v and tombstone %q", records[0], records[2][4])
+				}
+				for _, r := range records[1:] {
+					got = append(got, productResponse{ID: uuid.MustParse(r[0]), Name: r[1]})
+				}
+			}
+			if len(got) != len(tt.products) {
+				t.Fatalf("got %d products, want %d", len(got), len(tt.products))
+			}
+			for i, p := range tt.products {
+				if got[i].ID != p.ID || got[i].Name != p.Name {
+					t.Errorf("row %d: got %+v, want %s %q", i, got[i], p.ID, p.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestExportProductsAbortsMidStream(t *testing.T) {
+	mux, proc := setupTest(t, testHTTPConfig)
+	proc.export = func(context.Context, entity.ProductFilter) iter.Seq2[entity.Product, error] {
+		return productSeq([]entity.Product{{ID: uuid.Must(uuid.NewV7()), Name: "Car"}}, errors.New("conn reset"))
+	}
+
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Errorf("got panic %v, want http.ErrAbortHandler", got)
+		}
+	}()
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/product/export", nil)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestExportProductsCompressed(t *testing.T) {
+	proc := new(mockProcessor{})
+	products := make([]entity.Product, 2*exportFlushEvery+1)
+	for i := range products {
+		products[i] = entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Car", Price: testMoney()}
+	}
+	proc.export = func(context.Context, entity.ProductFilter) iter.Seq2[entity.Product, error] {
+		return productSeq(products, nil)
+	}
+	compression, err := compress(testHTTPConfig.CompressMinBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(slog.New(slog.DiscardHandler), proc, time.Second)
+	srv := httptest.NewServer(compression(withAPIKey(NewMux(h, nil))))
+	defer srv.Close()
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL+"/product/export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", MediaTypeNDJSON)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("got content-encoding %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for sc := bufio.NewScanner(zr); sc.Scan(); {
+		lines++
+	}
+	if lines != len(products) {
+		t.Errorf("got %d lines, want %d", lines, len(products))
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: MediaTypeJSON},
+		{accept: "*/*", want: MediaTypeJSON},
+		{accept: "text/csv", want: MediaTypeCSV},
+		{accept: "application/x-ndjson, application/json", want: MediaTypeJSON},
+		{accept: "application/*;q=0.2, text/csv;q=0.9", want: MediaTypeCSV},
+		{accept: "text/*, text/csv;q=0", want: ""},
+		{accept: "*/*;q=0.1, application/x-ndjson", want: MediaTypeNDJSON},
+		{accept: "image/png", want: ""},
+		{accept: "application/json;q=abc, text/csv", want: MediaTypeCSV},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := negotiate(tt.accept, exportOffers...); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
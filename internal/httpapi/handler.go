@@ -1,14 +1,20 @@
 package httpapi
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/alkmc/storefront/internal/cache"
 	"github.com/alkmc/storefront/internal/entity"
 	"github.com/google/uuid"
 )
@@ -16,15 +22,25 @@ import (
 const (
 	defaultLimit = 50
 	maxLimit     = 200
+	// maxBatchIDs caps the ids of a batch lookup.
+	maxBatchIDs = 100
+
+	headerCacheStatus = "Cache-Status"
+	// cacheName identifies the product cache in Cache-Status headers.
+	cacheName = "storefront"
 )
 
 type (
 	processor interface {
 		Create(context.Context, entity.Product) (entity.Product, error)
-		FindByID(context.Context, uuid.UUID) (entity.Product, error)
-		FindAll(context.Context, uuid.NullUUID, int) (entity.ProductPage, error)
+		Lookup(context.Context, uuid.UUID) (entity.Product, cache.Status, error)
+		FindByIDs(context.Context, []uuid.UUID) ([]entity.Product, []uuid.UUID, error)
+		FindAll(context.Context, uuid.NullUUID, int, entity.ProductFilter) (entity.ProductPage, error)
+		Export(context.Context, entity.ProductFilter) iter.Seq2[entity.Product, error]
 		Update(context.Context, entity.Product) error
 		Delete(context.Context, uuid.UUID) error
+		Restore(context.Context, uuid.UUID) (entity.Product, error)
+		History(context.Context, uuid.UUID, int64, int) (entity.AuditPage, error)
 	}
 	Handler struct {
 		logger         *slog.Logger
@@ -60,14 +76,15 @@ func (h *Handler) GetByID(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
 	defer cancel()
 
-	p, err := h.processor.FindByID(ctx, id)
+	p, status, err := h.processor.Lookup(ctx, id)
+	w.Header().Set(headerCacheStatus, cacheStatus(status))
 	if err != nil {
 		if errors.Is(err, entity.ErrNotFound) {
 			respondError(w, http.StatusNotFound, "product not found")
 			return
 		}
 		h.internalError(
-			w, "failed to find product by id",
+			w, r, "failed to find product by id",
 			slog.Any("error", err), slog.String("id", id.String()),
 		)
 		return
@@ -75,8 +92,56 @@ func (h *Handler) GetByID(w http.ResponseWriter, r *http.Request) {
 	respond(w, http.StatusOK, toProductResponse(p))
 }
 
+// getMany answers the products of ids in their order, each once, listing those that do not
+// exist apart.
+func (h *Handler) getMany(w http.ResponseWriter, r *http.Request, raw string) {
+	ids, err := parseIDs(raw)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
+	defer cancel()
+
+	found, notFound, err := h.processor.FindByIDs(ctx, ids)
+	if err != nil {
+		h.internalError(w, r, "failed to find products by ids", slog.Any("error", err), slog.Int("ids", len(ids)))
+		return
+	}
+	respond(w, http.StatusOK, toProductBatch(found, notFound))
+}
+
+// cacheStatus formats s as the entry of the product cache in a Cache-Status header (RFC 9211):
+// a hit with the seconds it stays fresh, negative once stale, or the reason the lookup went to
+// the database and whether its answer was stored or shared with concurrent lookups.
+func cacheStatus(s cache.Status) string {
+	params := []string{cacheName}
+	if s.Hit {
+		params = append(params, "hit")
+		if s.TTL != 0 {
+			params = append(params, "ttl="+strconv.Itoa(int(math.Floor(s.TTL.Seconds()))))
+		}
+		return strings.Join(params, "; ")
+	}
+	params = append(params, "fwd="+cmp.Or(s.Fwd, cache.FwdMiss))
+	if s.Stored {
+		params = append(params, "stored")
+	}
+	if s.Collapsed {
+		params = append(params, "collapsed")
+	}
+	return strings.Join(params, "; ")
+}
+
+// Get lists products a page at a time, or looks up those of the ids parameter, a comma
+// separated list of up to maxBatchIDs ids.
 func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
+	if q.Has("ids") {
+		h.getMany(w, r, q.Get("ids"))
+		return
+	}
 	limit, err := parseLimit(q.Get("limit"))
 	if err != nil {
 		respondError(w, http.StatusBadRequest, err.Error())
@@ -87,13 +152,21 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	f, err := parseProductFilter(q)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if f.IncludeDeleted && !authorize(w, r, entity.ScopeProductsWrite) {
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
 	defer cancel()
 
-	page, err := h.processor.FindAll(ctx, cursor, limit)
+	page, err := h.processor.FindAll(ctx, cursor, limit, f)
 	if err != nil {
-		h.internalError(w, "failed to find all products", slog.Any("error", err))
+		h.internalError(w, r, "failed to find all products", slog.Any("error", err))
 		return
 	}
 	respond(w, http.StatusOK, toProductsPage(page))
@@ -107,14 +180,16 @@ func (h *Handler) Add(w http.ResponseWriter, r *http.Request) {
 
 	var in productInput
 	if err := decodeBody(r.Body, &in); err != nil {
-		h.logger.Warn("decode body failed", slog.Any("error", err))
+		h.logger.WarnContext(r.Context(), "decode body failed", slog.Any("error", err))
 		respondDecodeError(w, err)
 		return
 	}
 
 	p := entity.Product{Name: in.Name, Price: toMoney(in.Price)}
 	if err := p.Validate(); err != nil {
-		respondError(w, http.StatusUnprocessableEntity, err.Error())
+		if !respondConstraintError(w, err) {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+		}
 		return
 	}
 
@@ -123,7 +198,10 @@ func (h *Handler) Add(w http.ResponseWriter, r *http.Request) {
 
 	result, err := h.processor.Create(ctx, p)
 	if err != nil {
-		h.internalError(w, "failed to create product", slog.Any("error", err))
+		if respondConstraintError(w, err) {
+			return
+		}
+		h.internalError(w, r, "failed to create product", slog.Any("error", err))
 		return
 	}
 	respond(w, http.StatusCreated, toProductResponse(result))
@@ -145,7 +223,7 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		h.internalError(
-			w, "failed to delete product",
+			w, r, "failed to delete product",
 			slog.Any("error", err), slog.String("id", id.String()),
 		)
 		return
@@ -153,6 +231,63 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	respond(w, http.StatusOK, messageResponse{Message: "product deleted"})
 }
 
+func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
+	defer cancel()
+
+	p, err := h.processor.Restore(ctx, id)
+	if err != nil {
+		if errors.Is(err, entity.ErrNotFound) {
+			respondError(w, http.StatusNotFound, "unable to restore product, which is not deleted")
+			return
+		}
+		h.internalError(
+			w, r, "failed to restore product",
+			slog.Any("error", err), slog.String("id", id.String()),
+		)
+		return
+	}
+	respond(w, http.StatusOK, toProductResponse(p))
+}
+
+func (h *Handler) History(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	limit, err := parseLimit(q.Get("limit"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cursor, err := parseSeqCursor(q.Get("cursor"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
+	defer cancel()
+
+	page, err := h.processor.History(ctx, id, cursor, limit)
+	if err != nil {
+		h.internalError(
+			w, r, "failed to find product history",
+			slog.Any("error", err), slog.String("id", id.String()),
+		)
+		return
+	}
+	respond(w, http.StatusOK, toAuditPage(page))
+}
+
 func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 	id, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
@@ -167,14 +302,16 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 
 	var in productInput
 	if err := decodeBody(r.Body, &in); err != nil {
-		h.logger.Warn("decode body failed", slog.Any("error", err))
+		h.logger.WarnContext(r.Context(), "decode body failed", slog.Any("error", err))
 		respondDecodeError(w, err)
 		return
 	}
 
 	p := entity.Product{ID: id, Name: in.Name, Price: toMoney(in.Price)}
 	if err := p.Validate(); err != nil {
-		respondError(w, http.StatusUnprocessableEntity, err.Error())
+		if !respondConstraintError(w, err) {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+		}
 		return
 	}
 
@@ -186,16 +323,35 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusNotFound, "unable to update product, which does not exist")
 			return
 		}
-		h.internalError(w, "failed to update product",
+		if respondConstraintError(w, err) {
+			return
+		}
+		h.internalError(w, r, "failed to update product",
 			slog.Any("error", err), slog.String("id", id.String()))
 		return
 	}
 	respond(w, http.StatusOK, toProductResponse(p))
 }
 
+// respondConstraintError answers an invalid product or a write the store rejected with 409
+// for conflicts and 422 otherwise, naming the offending field. It reports whether err was
+// an entity.ConstraintError.
+func respondConstraintError(w http.ResponseWriter, err error) bool {
+	ce, ok := errors.AsType[*entity.ConstraintError](err)
+	if !ok {
+		return false
+	}
+	code := http.StatusUnprocessableEntity
+	if errors.Is(ce, entity.ErrConflict) {
+		code = http.StatusConflict
+	}
+	respond(w, code, messageResponse{Message: ce.Reason, Field: ce.Field})
+	return true
+}
+
 // internalError logs the failure with attrs and replies with a generic 500.
-func (h *Handler) internalError(w http.ResponseWriter, msg string, attrs ...any) {
-	h.logger.Error(msg, attrs...)
+func (h *Handler) internalError(w http.ResponseWriter, r *http.Request, msg string, attrs ...any) {
+	h.logger.ErrorContext(r.Context(), msg, attrs...)
 	respondError(w, http.StatusInternalServerError, msgInternalError)
 }
 
@@ -213,6 +369,68 @@ func parseLimit(raw string) (int, error) {
 	return min(n, maxLimit), nil
 }
 
+// parseSeqCursor parses a positive integer keyset cursor, such as an audit entry id.
+func parseSeqCursor(raw string) (int64, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid cursor: %q", raw)
+	}
+	return n, nil
+}
+
+// parseProductFilter reads the includeDeleted and currency query parameters shared by list and export.
+// Deleted products are for principals that may change the catalog, which callers check.
+func parseProductFilter(q url.Values) (entity.ProductFilter, error) {
+	includeDeleted, err := parseIncludeDeleted(q.Get("includeDeleted"))
+	if err != nil {
+		return entity.ProductFilter{}, err
+	}
+	currency, err := parseCurrency(q.Get("currency"))
+	if err != nil {
+		return entity.ProductFilter{}, err
+	}
+	return entity.ProductFilter{IncludeDeleted: includeDeleted, Currency: currency}, nil
+}
+
+func parseCurrency(raw string) (entity.Currency, error) {
+	c := entity.Currency(raw)
+	if raw != "" && !c.Valid() {
+		return "", fmt.Errorf("invalid currency: %q", raw)
+	}
+	return c, nil
+}
+
+func parseIncludeDeleted(raw string) (bool, error) {
+	if raw == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid includeDeleted: %q", raw)
+	}
+	return v, nil
+}
+
+// parseIDs parses a comma separated list of 1 to maxBatchIDs product ids.
+func parseIDs(raw string) ([]uuid.UUID, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxBatchIDs {
+		return nil, fmt.Errorf("too many ids: %d, at most %d", len(parts), maxBatchIDs)
+	}
+	ids := make([]uuid.UUID, len(parts))
+	for i, part := range parts {
+		id, err := uuid.Parse(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid id: %q", part)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
 func parseCursor(raw string) (uuid.NullUUID, error) {
 	if raw == "" {
 		return uuid.NullUUID{}, nil
@@ -5,13 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"iter"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/alkmc/storefront/internal/cache"
 	"github.com/alkmc/storefront/internal/config"
 	"github.com/alkmc/storefront/internal/entity"
 	"github.com/google/uuid"
@@ -46,25 +49,45 @@ func decodeJSON[T any](t *testing.T, r io.Reader) T {
 type mockProcessor struct {
 	create   func(context.Context, entity.Product) (entity.Product, error)
 	findByID func(context.Context, uuid.UUID) (entity.Product, error)
-	findAll  func(context.Context, uuid.NullUUID, int) (entity.ProductPage, error)
-	update   func(context.Context, entity.Product) error
-	delete   func(context.Context, uuid.UUID) error
+	// findByIDs answers FindByIDs; nil finds none.
+	findByIDs func(context.Context, []uuid.UUID) ([]entity.Product, []uuid.UUID, error)
+	// status is what Lookup reports of the cache.
+	status  cache.Status
+	findAll func(context.Context, uuid.NullUUID, int, entity.ProductFilter) (entity.ProductPage, error)
+	export  func(context.Context, entity.ProductFilter) iter.Seq2[entity.Product, error]
+	update  func(context.Context, entity.Product) error
+	delete  func(context.Context, uuid.UUID) error
+	restore func(context.Context, uuid.UUID) (entity.Product, error)
+	history func(context.Context, uuid.UUID, int64, int) (entity.AuditPage, error)
 }
 
 func (m *mockProcessor) Create(ctx context.Context, p entity.Product) (entity.Product, error) {
 	return m.create(ctx, p)
 }
 
-func (m *mockProcessor) FindByID(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+func (m *mockProcessor) Lookup(ctx context.Context, id uuid.UUID) (entity.Product, cache.Status, error) {
 	if m.findByID == nil {
-		return entity.Product{}, entity.ErrNotFound
+		return entity.Product{}, m.status, entity.ErrNotFound
 	}
-	return m.findByID(ctx, id)
+	p, err := m.findByID(ctx, id)
+	return p, m.status, err
 }
 
-func (m *mockProcessor) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int,
+func (m *mockProcessor) FindByIDs(ctx context.Context, ids []uuid.UUID,
+) ([]entity.Product, []uuid.UUID, error) {
+	if m.findByIDs == nil {
+		return nil, ids, nil
+	}
+	return m.findByIDs(ctx, ids)
+}
+
+func (m *mockProcessor) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int, f entity.ProductFilter,
 ) (entity.ProductPage, error) {
-	return m.findAll(ctx, cursor, limit)
+	return m.findAll(ctx, cursor, limit, f)
+}
+
+func (m *mockProcessor) Export(ctx context.Context, f entity.ProductFilter) iter.Seq2[entity.Product, error] {
+	return m.export(ctx, f)
 }
 
 func (m *mockProcessor) Update(ctx context.Context, p entity.Product) error {
@@ -75,13 +98,22 @@ func (m *mockProcessor) Delete(ctx context.Context, id uuid.UUID) error {
 	return m.delete(ctx, id)
 }
 
+func (m *mockProcessor) Restore(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	return m.restore(ctx, id)
+}
+
+func (m *mockProcessor) History(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.AuditPage, error) {
+	return m.history(ctx, id, cursor, limit)
+}
+
 func setupTest(t *testing.T, cfg config.HTTP) (http.Handler, *mockProcessor) {
 	t.Helper()
 	logger := slog.New(slog.DiscardHandler)
 	proc := new(mockProcessor{})
 
 	h := NewHandler(logger, proc, 2*time.Second)
-	return bodyLimit(cfg.MaxBodyBytes)(NewMux(h)), proc
+	return bodyLimit(cfg.MaxBodyBytes)(withAPIKey(NewMux(h, nil))), proc
 }
 
 func TestGetProductByID(t *testing.T) {
@@ -156,6 +188,120 @@ func TestGetProductByID(t *testing.T) {
 	}
 }
 
+func TestGetProductByID_CacheStatus(t *testing.T) {
+	mux, proc := setupTest(t, testHTTPConfig)
+	proc.findByID = func(_ context.Context, id uuid.UUID) (entity.Product, error) {
+		return entity.Product{ID: id, Name: "Car", Price: testMoney()}, nil
+	}
+
+	tests := []struct {
+		name   string
+		status cache.Status
+		want   string
+	}{
+		{
+			name:   "fresh",
+			status: cache.Status{Hit: true, TTL: 4500 * time.Millisecond},
+			want:   "storefront; hit; ttl=4",
+		},
+		{
+			name:   "stale",
+			status: cache.Status{Hit: true, TTL: -time.Second / 2},
+			want:   "storefront; hit; ttl=-1",
+		},
+		{name: "tombstone", status: cache.Status{Hit: true}, want: "storefront; hit"},
+		{
+			name:   "miss",
+			status: cache.Status{Fwd: cache.FwdMiss, Stored: true, Collapsed: true},
+			want:   "storefront; fwd=uri-miss; stored; collapsed",
+		},
+		{name: "bypass", status: cache.Status{Fwd: cache.FwdBypass}, want: "storefront; fwd=bypass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc.status = tt.status
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/product/"+uuid.NewString(), nil)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+
+			if got := resp.Header().Get("Cache-Status"); got != tt.want {
+				t.Errorf("got Cache-Status %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetProductsByIDs(t *testing.T) {
+	mux, proc := setupTest(t, testHTTPConfig)
+	a, b, gone := uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7())
+	proc.findByIDs = func(_ context.Context, ids []uuid.UUID) ([]entity.Product, []uuid.UUID, error) {
+		var (
+			found    []entity.Product
+			notFound []uuid.UUID
+		)
+		for _, id := range ids {
+			if id == gone {
+				notFound = append(notFound, id)
+				continue
+			}
+			found = append(found, entity.Product{ID: id, Name: "Car", Price: testMoney()})
+		}
+		return found, notFound, nil
+	}
+	tooMany := strings.TrimSuffix(strings.Repeat(a.String()+",", maxBatchIDs+1), ",")
+
+	tests := []struct {
+		name         string
+		ids          string
+		wantStatus   int
+		wantIDs      []uuid.UUID
+		wantNotFound []uuid.UUID
+	}{
+		{
+			name:         "in request order",
+			ids:          b.String() + "," + gone.String() + "," + a.String(),
+			wantStatus:   http.StatusOK,
+			wantIDs:      []uuid.UUID{b, a},
+			wantNotFound: []uuid.UUID{gone},
+		},
+		{
+			name:         "all found",
+			ids:          a.String(),
+			wantStatus:   http.StatusOK,
+			wantIDs:      []uuid.UUID{a},
+			wantNotFound: []uuid.UUID{},
+		},
+		{name: "empty", ids: "", wantStatus: http.StatusBadRequest},
+		{name: "invalid id", ids: a.String() + ",nope", wantStatus: http.StatusBadRequest},
+		{name: "too many", ids: tooMany, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/product?ids="+tt.ids, nil)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			batch := decodeJSON[productBatch](t, resp.Body)
+			ids := make([]uuid.UUID, len(batch.Items))
+			for i, p := range batch.Items {
+				ids[i] = p.ID
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("got ids %v, want %v", ids, tt.wantIDs)
+			}
+			if batch.NotFound == nil || !slices.Equal(batch.NotFound, tt.wantNotFound) {
+				t.Errorf("got not found %v, want %v", batch.NotFound, tt.wantNotFound)
+			}
+		})
+	}
+}
+
 func TestGetProducts(t *testing.T) {
 	mux, proc := setupTest(t, testHTTPConfig)
 
@@ -174,7 +320,9 @@ func TestGetProducts(t *testing.T) {
 		{
 			name: "empty",
 			setupMock: func() {
-				proc.findAll = func(_ context.Context, _ uuid.NullUUID, _ int) (entity.ProductPage, error) {
+				proc.findAll = func(
+					_ context.Context, _ uuid.NullUUID, _ int, _ entity.ProductFilter,
+				) (entity.ProductPage, error) {
 					return entity.ProductPage{}, nil
 				}
 			},
@@ -184,7 +332,9 @@ func TestGetProducts(t *testing.T) {
 		{
 			name: "success with default pagination",
 			setupMock: func() {
-				proc.findAll = func(_ context.Context, cursor uuid.NullUUID, limit int) (entity.ProductPage, error) {
+				proc.findAll = func(
+					_ context.Context, cursor uuid.NullUUID, limit int, _ entity.ProductFilter,
+				) (entity.ProductPage, error) {
 					if limit != 50 || cursor.Valid {
 						t.Errorf("got limit=%d cursor=%v, want 50/first-page", limit, cursor)
 					}
@@ -198,7 +348,9 @@ func TestGetProducts(t *testing.T) {
 			name: "explicit limit and cursor",
 			url:  "/product?limit=10&cursor=" + cursorID.String(),
 			setupMock: func() {
-				proc.findAll = func(_ context.Context, cursor uuid.NullUUID, limit int) (entity.ProductPage, error) {
+				proc.findAll = func(
+					_ context.Context, cursor uuid.NullUUID, limit int, _ entity.ProductFilter,
+				) (entity.ProductPage, error) {
 					if limit != 10 || !cursor.Valid || cursor.UUID != cursorID {
 						t.Errorf("got limit=%d cursor=%v, want 10/%s", limit, cursor, cursorID)
 					}
@@ -212,7 +364,9 @@ func TestGetProducts(t *testing.T) {
 			name: "limit clamped to max",
 			url:  "/product?limit=500",
 			setupMock: func() {
-				proc.findAll = func(_ context.Context, _ uuid.NullUUID, limit int) (entity.ProductPage, error) {
+				proc.findAll = func(
+					_ context.Context, _ uuid.NullUUID, limit int, _ entity.ProductFilter,
+				) (entity.ProductPage, error) {
 					if limit != 200 {
 						t.Errorf("got limit=%d, want 200", limit)
 					}
@@ -226,7 +380,9 @@ func TestGetProducts(t *testing.T) {
 			name: "negative limit falls back to default",
 			url:  "/product?limit=-5",
 			setupMock: func() {
-				proc.findAll = func(_ context.Context, _ uuid.NullUUID, limit int) (entity.ProductPage, error) {
+				proc.findAll = func(
+					_ context.Context, _ uuid.NullUUID, limit int, _ entity.ProductFilter,
+				) (entity.ProductPage, error) {
 					if limit != 50 {
 						t.Errorf("got limit=%d, want 50", limit)
 					}
@@ -239,7 +395,9 @@ func TestGetProducts(t *testing.T) {
 		{
 			name: "more pages set next cursor",
 			setupMock: func() {
-				proc.findAll = func(_ context.Context, _ uuid.NullUUID, _ int) (entity.ProductPage, error) {
+				proc.findAll = func(
+					_ context.Context, _ uuid.NullUUID, _ int, _ entity.ProductFilter,
+				) (entity.ProductPage, error) {
 					return entity.ProductPage{
 						Items:   []entity.Product{{ID: lastID, Name: "Car", Price: testMoney()}},
 						HasMore: true,
@@ -250,6 +408,54 @@ func TestGetProducts(t *testing.T) {
 			expectedNames:  []string{"Car"},
 			wantNextCursor: lastID.String(),
 		},
+		{
+			name: "include deleted",
+			url:  "/product?includeDeleted=true",
+			setupMock: func() {
+				proc.findAll = func(
+					_ context.Context, _ uuid.NullUUID, _ int, f entity.ProductFilter,
+				) (entity.ProductPage, error) {
+					if !f.IncludeDeleted {
+						t.Errorf("got filter %+v, want IncludeDeleted", f)
+					}
+					return entity.ProductPage{Items: []entity.Product{
+						{Name: "Car", Price: testMoney(), DeletedAt: time.Now()},
+					}}, nil
+				}
+			},
+			expectedStatus: http.StatusOK,
+			expectedNames:  []string{"Car"},
+		},
+		{
+			name: "currency filter",
+			url:  "/product?currency=EUR",
+			setupMock: func() {
+				proc.findAll = func(
+					_ context.Context, _ uuid.NullUUID, _ int, f entity.ProductFilter,
+				) (entity.ProductPage, error) {
+					if f.Currency != entity.CurrencyEUR {
+						t.Errorf("got filter %+v, want Currency EUR", f)
+					}
+					return entity.ProductPage{}, nil
+				}
+			},
+			expectedStatus: http.StatusOK,
+			expectedNames:  []string{},
+		},
+		{
+			name:           "invalid currency",
+			url:            "/product?currency=XYZ",
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "invalid currency: \"XYZ\"",
+		},
+		{
+			name:           "invalid includeDeleted",
+			url:            "/product?includeDeleted=maybe",
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "invalid includeDeleted: \"maybe\"",
+		},
 		{
 			name:           "invalid limit",
 			url:            "/product?limit=abc",
@@ -313,6 +519,7 @@ func TestAddProduct(t *testing.T) {
 		setupMock      func()
 		expectedStatus int
 		expectedMsg    string
+		expectedField  string
 	}{
 		{
 			name: "success",
@@ -348,6 +555,15 @@ func TestAddProduct(t *testing.T) {
 			setupMock:      func() {},
 			expectedStatus: http.StatusUnprocessableEntity,
 			expectedMsg:    "the product price must be positive",
+			expectedField:  entity.FieldPriceAmount,
+		},
+		{
+			name:           "name too long",
+			body:           productInput{Name: strings.Repeat("é", entity.MaxNameLength+1), Price: testMoneyInput(1)},
+			setupMock:      func() {},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedMsg:    "the product name is longer than 100 characters",
+			expectedField:  entity.FieldName,
 		},
 		{
 			name: "invalid currency",
@@ -358,6 +574,34 @@ func TestAddProduct(t *testing.T) {
 			setupMock:      func() {},
 			expectedStatus: http.StatusUnprocessableEntity,
 			expectedMsg:    "the product currency is invalid",
+			expectedField:  entity.FieldPriceCurrency,
+		},
+		{
+			name: "conflict",
+			body: productInput{Name: "Car", Price: testMoneyInput(123)},
+			setupMock: func() {
+				proc.create = func(context.Context, entity.Product) (entity.Product, error) {
+					return entity.Product{}, &entity.ConstraintError{
+						Field: entity.FieldID, Reason: "a product with this id already exists", Err: entity.ErrConflict,
+					}
+				}
+			},
+			expectedStatus: http.StatusConflict,
+			expectedMsg:    "a product with this id already exists",
+			expectedField:  entity.FieldID,
+		},
+		{
+			name: "constraint violation",
+			body: productInput{Name: "Car", Price: testMoneyInput(123)},
+			setupMock: func() {
+				proc.create = func(context.Context, entity.Product) (entity.Product, error) {
+					return entity.Product{}, &entity.ConstraintError{
+						Reason: "the product violates a data constraint", Err: entity.ErrConstraintViolation,
+					}
+				}
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedMsg:    "the product violates a data constraint",
 		},
 	}
 
@@ -382,6 +626,9 @@ func TestAddProduct(t *testing.T) {
 				if e.Message != tt.expectedMsg {
 					t.Errorf("got msg %q, want %q", e.Message, tt.expectedMsg)
 				}
+				if e.Field != tt.expectedField {
+					t.Errorf("got field %q, want %q", e.Field, tt.expectedField)
+				}
 			}
 		})
 	}
@@ -522,3 +769,170 @@ func TestUpdateProduct(t *testing.T) {
 		})
 	}
 }
+
+func TestRestoreProduct(t *testing.T) {
+	mux, proc := setupTest(t, testHTTPConfig)
+
+	tests := []struct {
+		name           string
+		id             string
+		setupMock      func()
+		expectedStatus int
+		expectedMsg    string
+	}{
+		{
+			name: "success",
+			id:   uuid.Must(uuid.NewV7()).String(),
+			setupMock: func() {
+				proc.restore = func(_ context.Context, id uuid.UUID) (entity.Product, error) {
+					return entity.Product{ID: id, Name: "Car", Price: testMoney()}, nil
+				}
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "not deleted",
+			id:   uuid.Must(uuid.NewV7()).String(),
+			setupMock: func() {
+				proc.restore = func(_ context.Context, _ uuid.UUID) (entity.Product, error) {
+					return entity.Product{}, entity.ErrNotFound
+				}
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedMsg:    "unable to restore product, which is not deleted",
+		},
+		{
+			name:           "incorrect uuid",
+			id:             "incorrect",
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "invalid UUID length: 9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock()
+			url := "/product/" + tt.id + "/restore"
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, url, nil)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+
+			if resp.Code != tt.expectedStatus {
+				t.Errorf("got status %d, want %d", resp.Code, tt.expectedStatus)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				p := decodeJSON[productResponse](t, resp.Body)
+				if p.ID != uuid.MustParse(tt.id) {
+					t.Errorf("got id %v, want %v", p.ID, tt.id)
+				}
+				if p.DeletedAt != nil {
+					t.Errorf("got deletedAt %v, want none", p.DeletedAt)
+				}
+				return
+			}
+			e := decodeJSON[messageResponse](t, resp.Body)
+			if e.Message != tt.expectedMsg {
+				t.Errorf("got msg %q, want %q", e.Message, tt.expectedMsg)
+			}
+		})
+	}
+}
+
+func TestProductHistory(t *testing.T) {
+	mux, proc := setupTest(t, testHTTPConfig)
+	id := uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name           string
+		url            string
+		setupMock      func()
+		expectedStatus int
+		expectedMsg    string
+		wantIDs        []int64
+		wantNextCursor string
+	}{
+		{
+			name: "first page",
+			url:  "/product/" + id.String() + "/history?limit=2",
+			setupMock: func() {
+				proc.history = func(
+					_ context.Context, _ uuid.UUID, cursor int64, limit int,
+				) (entity.AuditPage, error) {
+					if cursor != 0 || limit != 2 {
+						t.Errorf("got cursor=%d limit=%d, want 0/2", cursor, limit)
+					}
+					return entity.AuditPage{
+						Items: []entity.AuditEntry{
+							{
+								ID: 9, ProductID: id, Action: entity.AuditUpdate, Actor: "anonymous",
+								Changes: []entity.FieldChange{{Field: "name", Before: "Car", After: "Bike"}},
+							},
+							{ID: 7, ProductID: id, Action: entity.AuditCreate, Actor: "anonymous"},
+						},
+						HasMore: true,
+					}, nil
+				}
+			},
+			expectedStatus: http.StatusOK,
+			wantIDs:        []int64{9, 7},
+			wantNextCursor: "7",
+		},
+		{
+			name: "next page",
+			url:  "/product/" + id.String() + "/history?cursor=7",
+			setupMock: func() {
+				proc.history = func(
+					_ context.Context, _ uuid.UUID, cursor int64, _ int,
+				) (entity.AuditPage, error) {
+					if cursor != 7 {
+						t.Errorf("got cursor=%d, want 7", cursor)
+					}
+					return entity.AuditPage{Items: []entity.AuditEntry{{ID: 3, ProductID: id}}}, nil
+				}
+			},
+			expectedStatus: http.StatusOK,
+			wantIDs:        []int64{3},
+		},
+		{
+			name:           "invalid cursor",
+			url:            "/product/" + id.String() + "/history?cursor=-1",
+			setupMock:      func() {},
+			expectedStatus: http.StatusBadRequest,
+			expectedMsg:    "invalid cursor: \"-1\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock()
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, tt.url, nil)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+
+			if resp.Code != tt.expectedStatus {
+				t.Errorf("got status %d, want %d", resp.Code, tt.expectedStatus)
+			}
+			if tt.expectedMsg != "" {
+				e := decodeJSON[messageResponse](t, resp.Body)
+				if e.Message != tt.expectedMsg {
+					t.Errorf("got msg %q, want %q", e.Message, tt.expectedMsg)
+				}
+				return
+			}
+			page := decodeJSON[auditPage](t, resp.Body)
+			if len(page.Items) != len(tt.wantIDs) {
+				t.Fatalf("got len %d, want %d", len(page.Items), len(tt.wantIDs))
+			}
+			for i, want := range tt.wantIDs {
+				if page.Items[i].ID != want {
+					t.Errorf("got id %d, want %d", page.Items[i].ID, want)
+				}
+			}
+			if page.NextCursor != tt.wantNextCursor {
+				t.Errorf("got nextCursor %q, want %q", page.NextCursor, tt.wantNextCursor)
+			}
+		})
+	}
+}
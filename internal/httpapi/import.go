@@ -0,0 +1,126 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+// SubmitImport spools the request body and answers 202 with the queued job.
+// The format follows the Content-Type: text/csv or application/x-ndjson.
+func (h *InternalHandler) SubmitImport(w http.ResponseWriter, r *http.Request) {
+	format, err := importFormat(r.Header.Get("Content-Type"))
+	if err != nil {
+		respondError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	// Large catalogs take longer to upload than the server-wide ReadTimeout allows.
+	if err := http.NewResponseController(w).SetReadDeadline(time.Time{}); err != nil {
+		h.logger.WarnContext(r.Context(), "failed to clear import read deadline", slog.Any("error", err))
+	}
+
+	job, err := h.imports.Submit(r.Context(), format, r.Body)
+	if err != nil {
+		if errors.Is(err, entity.ErrImportTooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, msgBodyTooLarge)
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "failed to submit import", slog.Any("error", err))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return
+	}
+	w.Header().Set("Location", "/product/import/"+job.ID.String())
+	respond(w, http.StatusAccepted, toImportJobResponse(job))
+}
+
+func (h *InternalHandler) ImportStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job, err := h.imports.Job(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, entity.ErrNotFound) {
+			respondError(w, http.StatusNotFound, "import job not found")
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "failed to find import job", slog.Any("error", err),
+			slog.String("id", id.String()))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return
+	}
+	respond(w, http.StatusOK, toImportJobResponse(job))
+}
+
+// ImportRejects pages through the rejected lines of a job in line order.
+func (h *InternalHandler) ImportRejects(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	limit, err := parseLimit(q.Get("limit"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cursor, err := parseSeqCursor(q.Get("cursor"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := h.imports.Rejects(r.Context(), id, cursor, limit)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to list import rejects", slog.Any("error", err),
+			slog.String("id", id.String()))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return
+	}
+	respond(w, http.StatusOK, toImportRejectsPage(page))
+}
+
+// RetryImport requeues a failed or interrupted job to continue from its checkpoint.
+func (h *InternalHandler) RetryImport(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job, err := h.imports.Retry(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, entity.ErrNotFound) {
+			respondError(w, http.StatusNotFound, "unable to resume import job, which is missing or completed")
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "failed to resume import job", slog.Any("error", err),
+			slog.String("id", id.String()))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return
+	}
+	respond(w, http.StatusAccepted, toImportJobResponse(job))
+}
+
+func importFormat(contentType string) (entity.ImportFormat, error) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		switch mt {
+		case MediaTypeCSV:
+			return entity.ImportCSV, nil
+		case MediaTypeNDJSON:
+			return entity.ImportNDJSON, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported Content-Type %q: use %s or %s",
+		contentType, MediaTypeCSV, MediaTypeNDJSON)
+}
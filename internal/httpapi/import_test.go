@@ -0,0 +1,212 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+type mockImporter struct {
+	submit  func(context.Context, entity.ImportFormat, io.Reader) (entity.ImportJob, error)
+	retry   func(context.Context, uuid.UUID) (entity.ImportJob, error)
+	job     func(context.Context, uuid.UUID) (entity.ImportJob, error)
+	rejects func(context.Context, uuid.UUID, int64, int) (entity.ImportRejectPage, error)
+}
+
+func (m *mockImporter) Submit(ctx context.Context, f entity.ImportFormat, src io.Reader,
+) (entity.ImportJob, error) {
+	return m.submit(ctx, f, src)
+}
+
+func (m *mockImporter) Retry(ctx context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	return m.retry(ctx, id)
+}
+
+func (m *mockImporter) Job(ctx context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	return m.job(ctx, id)
+}
+
+func (m *mockImporter) Rejects(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.ImportRejectPage, error) {
+	return m.rejects(ctx, id, cursor, limit)
+}
+
+func newImportMux(m *mockImporter) *http.ServeMux {
+	ih := NewInternalHandler(slog.New(slog.DiscardHandler), nil, nil, nil, m, nil, nil, ReadinessCfg{})
+	return NewInternalMux(ih, defaultTenant)
+}
+
+func TestSubmitImport(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name         string
+		contentType  string
+		err          error
+		wantStatus   int
+		wantFormat   entity.ImportFormat
+		wantLocation string
+	}{
+		{
+			name:         "csv",
+			contentType:  "text/csv; charset=utf-8",
+			wantStatus:   http.StatusAccepted,
+			wantFormat:   entity.ImportCSV,
+			wantLocation: "/product/import/" + id.String(),
+		},
+		{
+			name:         "ndjson",
+			contentType:  MediaTypeNDJSON,
+			wantStatus:   http.StatusAccepted,
+			wantFormat:   entity.ImportNDJSON,
+			wantLocation: "/product/import/" + id.String(),
+		},
+		{
+			name:        "unsupported content type",
+			contentType: MediaTypeJSON,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "too large",
+			contentType: MediaTypeCSV,
+			err:         entity.ErrImportTooLarge,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "store failure",
+			contentType: MediaTypeCSV,
+			err:         errors.New("db down"),
+			wantStatus:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotFormat entity.ImportFormat
+			mux := newImportMux(&mockImporter{
+				submit: func(_ context.Context, f entity.ImportFormat, _ io.Reader) (entity.ImportJob, error) {
+					gotFormat = f
+					if tt.err != nil {
+						return entity.ImportJob{}, tt.err
+					}
+					return entity.ImportJob{ID: id, Format: f, Status: entity.ImportPending}, nil
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/product/import", strings.NewReader("name\n"))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusAccepted {
+				return
+			}
+			if gotFormat != tt.wantFormat {
+				t.Errorf("got format %q, want %q", gotFormat, tt.wantFormat)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("got Location %q, want %q", got, tt.wantLocation)
+			}
+			if resp := decodeJSON[importJobResponse](t, rec.Body); resp.Status != "pending" {
+				t.Errorf("got status %q, want pending", resp.Status)
+			}
+		})
+	}
+}
+
+func TestImportStatus(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+	mux := newImportMux(&mockImporter{
+		job: func(_ context.Context, got uuid.UUID) (entity.ImportJob, error) {
+			if got != id {
+				return entity.ImportJob{}, entity.ErrNotFound
+			}
+			return entity.ImportJob{ID: id, Status: entity.ImportRunning, Line: 42, Accepted: 40, Rejected: 1}, nil
+		},
+		retry: func(context.Context, uuid.UUID) (entity.ImportJob, error) {
+			return entity.ImportJob{}, entity.ErrNotFound
+		},
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		wantStatus int
+	}{
+		{name: "found", method: http.MethodGet, url: "/product/import/" + id.String(), wantStatus: http.StatusOK},
+		{
+			name: "not found", method: http.MethodGet,
+			url: "/product/import/" + uuid.Must(uuid.NewV7()).String(), wantStatus: http.StatusNotFound,
+		},
+		{name: "invalid id", method: http.MethodGet, url: "/product/import/abc", wantStatus: http.StatusBadRequest},
+		{
+			name: "resume completed job", method: http.MethodPost,
+			url: "/product/import/" + id.String() + "/resume", wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			resp := decodeJSON[importJobResponse](t, rec.Body)
+			if resp.Line != 42 || resp.Accepted != 40 || resp.Rejected != 1 {
+				t.Errorf("got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+	var gotCursor int64
+	var gotLimit int
+	mux := newImportMux(&mockImporter{
+		rejects: func(_ context.Context, _ uuid.UUID, cursor int64, limit int) (entity.ImportRejectPage, error) {
+			gotCursor, gotLimit = cursor, limit
+			return entity.ImportRejectPage{
+				Items:   []entity.ImportReject{{Line: 12, Reason: "the product name is empty"}},
+				HasMore: true,
+			}, nil
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/product/import/"+id.String()+"/rejects?cursor=7&limit=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	if gotCursor != 7 || gotLimit != 1 {
+		t.Errorf("got cursor %d limit %d, want 7 and 1", gotCursor, gotLimit)
+	}
+	page := decodeJSON[importRejectsPage](t, rec.Body)
+	if len(page.Items) != 1 || page.Items[0].Line != 12 || page.NextCursor != "12" {
+		t.Errorf("got %+v", page)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/product/import/"+id.String()+"/rejects?cursor=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for invalid cursor, want 400", rec.Code)
+	}
+}
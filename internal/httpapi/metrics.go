@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// routeUnmatched labels the requests that match no route, such as 404s and probes of paths
+// that were never served, so they cannot grow the label values.
+const routeUnmatched = "unmatched"
+
+// sizeBuckets bound response sizes in bytes, from 100B to 10MiB.
+var sizeBuckets = []float64{100, 1000, 10_000, 100_000, 1 << 20, 10 << 20}
+
+// HTTPMetrics keeps the rate, errors and duration of the requests each server serves. Requests
+// are labelled by the pattern of the route they match rather than their path, so the label
+// values are bounded by the routes.
+type HTTPMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	size     *prometheus.HistogramVec
+}
+
+// NewHTTPMetrics registers the HTTP metrics with reg.
+func NewHTTPMetrics(reg prometheus.Registerer) *HTTPMetrics {
+	f := promauto.With(reg)
+	return new(HTTPMetrics{
+		requests: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by route and status code.",
+		}, []string{"server", "method", "route", "code"}),
+		duration: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time to serve HTTP requests, by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"server", "method", "route"}),
+		inFlight: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "HTTP requests being served, by route.",
+		}, []string{"server", "route"}),
+		size: f.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "Size of HTTP response bodies, by route.",
+			Buckets: sizeBuckets,
+		}, []string{"server", "method", "route"}),
+	})
+}
+
+// Instrument measures the requests h serves on server, labelled by the route of routes they
+// match. h is routes itself, or routes behind middleware; middleware that answers early, such
+// as authentication, is measured under the route the request would have reached.
+func (m *HTTPMetrics) Instrument(server string, routes *http.ServeMux, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeUnmatched
+		if _, pattern := routes.Handler(r); pattern != "" {
+			route = pattern
+		}
+		method := metricMethod(r.Method)
+
+		inFlight := m.inFlight.WithLabelValues(server, route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		rec := new(meteredWriter{ResponseWriter: w, status: http.StatusOK})
+		defer func() {
+			m.requests.WithLabelValues(server, method, route, strconv.Itoa(rec.status)).Inc()
+			m.duration.WithLabelValues(server, method, route).Observe(time.Since(start).Seconds())
+			m.size.WithLabelValues(server, method, route).Observe(float64(rec.written))
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// metricMethod returns the standard methods as they are and every other one as OTHER, so
+// clients cannot make up label values.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// meteredWriter records the status code and body size of a response.
+type meteredWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *meteredWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer's capabilities.
+func (w *meteredWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
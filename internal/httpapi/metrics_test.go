@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHTTPMetrics_Instrument(t *testing.T) {
+	m := NewHTTPMetrics(prometheus.NewRegistry())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /product/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("product"))
+	})
+	// Middleware answering before the mux is measured under the route the request matches.
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := m.Instrument("api", mux, auth(mux))
+
+	for _, tc := range []struct {
+		method, path string
+		auth         bool
+	}{
+		{http.MethodGet, "/product/1", true},
+		{http.MethodGet, "/product/2", true},
+		{http.MethodGet, "/product/3", false},
+		{http.MethodGet, "/nothing/here", true},
+		{"BREW", "/product/4", true},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.auth {
+			r.Header.Set("Authorization", "Bearer token")
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	for _, tc := range []struct {
+		labels []string
+		want   float64
+	}{
+		{[]string{"api", "GET", "GET /product/{id}", "200"}, 2},
+		{[]string{"api", "GET", "GET /product/{id}", "401"}, 1},
+		{[]string{"api", "GET", routeUnmatched, "404"}, 1},
+		{[]string{"api", "OTHER", routeUnmatched, "405"}, 1},
+	} {
+		if got := testutil.ToFloat64(m.requests.WithLabelValues(tc.labels...)); got != tc.want {
+			t.Errorf("requests %v: got %v, want %v", tc.labels, got, tc.want)
+		}
+	}
+	if got := testutil.ToFloat64(m.inFlight.WithLabelValues("api", "GET /product/{id}")); got != 0 {
+		t.Errorf("in flight: got %v, want 0", got)
+	}
+}
@@ -1,6 +1,7 @@
 package httpapi
 
 import (
+	"cmp"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -9,10 +10,17 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
 	"github.com/jub0bs/cors"
 	"github.com/klauspost/compress/gzhttp"
 )
 
+const (
+	headerRequestID = "X-Request-Id"
+	maxRequestIDLen = 128
+)
+
 type (
 	// MiddlewareCfg carries the transport-level knobs the middleware chain needs.
 	MiddlewareCfg struct {
@@ -22,12 +30,17 @@ type (
 		CORSMaxAge         int
 		HSTSEnabled        bool
 		HSTSMaxAge         int
+		Tenant             TenantCfg
+		// RateLimiter limits requests per client address before authentication; nil does not.
+		RateLimiter *RateLimiter
 	}
 	Middleware = func(http.Handler) http.Handler
 )
 
-// NewMiddleware builds the standard middleware chain.
-func NewMiddleware(cfg MiddlewareCfg) (Middleware, error) {
+// NewMiddleware builds the standard middleware chain of the public API, which requires
+// every request to authenticate with an API key verified by keys or, unless tokens is nil,
+// a JWT verified by tokens.
+func NewMiddleware(cfg MiddlewareCfg, keys Authenticator, tokens TokenVerifier) (Middleware, error) {
 	compression, err := compress(cfg.CompressMinBytes)
 	if err != nil {
 		return nil, err
@@ -36,19 +49,29 @@ func NewMiddleware(cfg MiddlewareCfg) (Middleware, error) {
 	if err != nil {
 		return nil, err
 	}
-	corsMW, err := corsMiddleware(cfg.CORSAllowedOrigins, cfg.CORSMaxAge)
+	tenantHeader := cmp.Or(cfg.Tenant.Header, defaultTenantHeader)
+	corsMW, err := corsMiddleware(cfg.CORSAllowedOrigins, cfg.CORSMaxAge,
+		"Authorization", headerAPIKey, tenantHeader)
 	if err != nil {
 		return nil, err
 	}
+	tenantMW, err := NewTenantMiddleware(cfg.Tenant)
+	if err != nil {
+		return nil, fmt.Errorf("tenant: %w", err)
+	}
 
 	return func(next http.Handler) http.Handler {
 		return chain(
 			next,
+			requestID,
 			logging,
 			recoverer,
 			secureHeaders(cfg.HSTSEnabled, cfg.HSTSMaxAge),
 			corsMW,
 			csrfMW,
+			traced("rate limit", cfg.RateLimiter.perIP),
+			traced("authenticate", authenticate(keys, tokens)),
+			tenantMW,
 			bodyLimit(cfg.MaxBodyBytes),
 			compression,
 		)
@@ -63,30 +86,63 @@ func chain(h http.Handler, mws ...Middleware) http.Handler {
 	return h
 }
 
+// requestID propagates a client-supplied X-Request-ID or assigns a fresh one,
+// echoing it on the response and storing it in the request context.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(headerRequestID)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(headerRequestID, id)
+		next.ServeHTTP(w, r.WithContext(reqctx.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts short printable ASCII IDs so they are safe to log and store.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
 // logging logs method, path, status, and request duration.
 func logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		rec := new(statusRecorder{ResponseWriter: w, status: http.StatusOK})
 		defer func() {
-			slog.Default().Info(
+			slog.Default().InfoContext(
+				r.Context(),
 				"http request",
 				slog.String("method", r.Method),
 				slog.String("path", r.URL.Path),
 				slog.Int("status", rec.status),
 				slog.Duration("duration", time.Since(start)),
+				slog.String("request_id", reqctx.RequestID(r.Context())),
 			)
 		}()
 		next.ServeHTTP(rec, r)
 	})
 }
 
-// recoverer catches panics and prevents the server from crashing
+// recoverer catches panics and prevents the server from crashing.
+// http.ErrAbortHandler is re-raised so net/http drops the connection of an aborted stream.
 func recoverer(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				slog.Default().Error(
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				slog.Default().ErrorContext(
+					r.Context(),
 					"panic recovered",
 					slog.Any("error", err),
 					slog.String("method", r.Method),
@@ -120,7 +176,8 @@ func secureHeaders(hstsEnabled bool, hstsMaxAge int) Middleware {
 }
 
 // corsMiddleware enforces an origin allowlist. Empty list disables CORS entirely.
-func corsMiddleware(origins []string, maxAge int) (Middleware, error) {
+// headers are allowed on requests besides Content-Type and X-Request-Id.
+func corsMiddleware(origins []string, maxAge int, headers ...string) (Middleware, error) {
 	if len(origins) == 0 {
 		return func(next http.Handler) http.Handler {
 			return next
@@ -129,7 +186,8 @@ func corsMiddleware(origins []string, maxAge int) (Middleware, error) {
 	m, err := cors.NewMiddleware(cors.Config{
 		Origins:         origins,
 		Methods:         []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
-		RequestHeaders:  []string{"Content-Type"},
+		RequestHeaders:  append([]string{"Content-Type", headerRequestID}, headers...),
+		ResponseHeaders: []string{headerRequestID},
 		MaxAgeInSeconds: maxAge,
 	})
 	if err != nil {
@@ -166,11 +224,11 @@ func bodyLimit(maxBytes int64) Middleware {
 	}
 }
 
-// compress applies zstd/gzip to JSON responses larger than minBytes
+// compress applies zstd/gzip to JSON, NDJSON and CSV responses larger than minBytes
 func compress(minBytes int) (Middleware, error) {
 	wrap, err := gzhttp.NewWrapper(
 		gzhttp.MinSize(minBytes),
-		gzhttp.ContentTypes([]string{MediaTypeJSON}),
+		gzhttp.ContentTypes([]string{MediaTypeJSON, MediaTypeNDJSON, MediaTypeCSV}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("compress: %w", err)
@@ -5,6 +5,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/alkmc/storefront/internal/reqctx"
 )
 
 func okHandler() http.Handler {
@@ -120,3 +122,52 @@ func TestCSRFInvalidTrustedOrigin(t *testing.T) {
 		t.Errorf("expected error for invalid trusted origin")
 	}
 }
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantSame bool
+	}{
+		{name: "propagates client id", incoming: "abc-123", wantSame: true},
+		{name: "generates when missing", incoming: ""},
+		{name: "replaces unsafe id", incoming: "bad id\n"},
+		{name: "replaces oversized id", incoming: strings.Repeat("a", maxRequestIDLen+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			next := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				seen = reqctx.RequestID(r.Context())
+			})
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(headerRequestID, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			requestID(next).ServeHTTP(rec, req)
+
+			got := rec.Header().Get(headerRequestID)
+			if got == "" || got != seen {
+				t.Fatalf("response id %q does not match context id %q", got, seen)
+			}
+			if (got == tt.incoming) != tt.wantSame {
+				t.Errorf("got id %q for incoming %q, want same=%v", got, tt.incoming, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestRecovererPassesAbortThrough(t *testing.T) {
+	abort := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) })
+
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Errorf("got panic %v, want http.ErrAbortHandler", got)
+		}
+	}()
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
+	recoverer(abort).ServeHTTP(httptest.NewRecorder(), req)
+	t.Error("recoverer swallowed http.ErrAbortHandler")
+}
@@ -2,39 +2,176 @@ package httpapi
 
 import (
 	"context"
+	"io"
+	"iter"
+	"log/slog"
 	"net/http"
+	"slices"
 	"time"
+
+	"github.com/alkmc/storefront/internal/breaker"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
 )
 
 type (
 	pinger interface {
 		Ping(context.Context) error
 	}
+	database interface {
+		pinger
+		Replicas() []entity.ReplicaStatus
+	}
+	// breakerReporter is implemented by caches behind a circuit breaker.
+	breakerReporter interface {
+		BreakerState() breaker.State
+	}
+	auditLogger interface {
+		AuditLog(context.Context, time.Time) iter.Seq2[entity.AuditEntry, error]
+	}
+	importer interface {
+		Submit(context.Context, entity.ImportFormat, io.Reader) (entity.ImportJob, error)
+		Retry(context.Context, uuid.UUID) (entity.ImportJob, error)
+		Job(context.Context, uuid.UUID) (entity.ImportJob, error)
+		Rejects(context.Context, uuid.UUID, int64, int) (entity.ImportRejectPage, error)
+	}
+	apiKeyManager interface {
+		Create(context.Context, string, []entity.Scope, string) (entity.APIKey, string, error)
+		List(context.Context) ([]entity.APIKey, error)
+		Revoke(context.Context, uuid.UUID) error
+	}
+	cacheWarmer interface {
+		Ready() bool
+		Start(entity.WarmSource) (entity.WarmRun, error)
+		Progress() (entity.WarmRun, bool)
+	}
+	// ReadinessCfg lists the optional dependencies readiness fails without. Without the others
+	// the service reports itself degraded but stays ready.
+	ReadinessCfg struct {
+		RequireCache    bool
+		RequireReplicas bool
+	}
 	InternalHandler struct {
-		db    pinger
-		cache pinger
+		logger  *slog.Logger
+		db      database
+		cache   pinger
+		audit   auditLogger
+		imports importer
+		keys    apiKeyManager
+		// warmer is nil when the cache is not warmed.
+		warmer    cacheWarmer
+		readiness ReadinessCfg
 	}
 )
 
-func NewInternalHandler(db pinger, cache pinger) *InternalHandler {
-	return &InternalHandler{db: db, cache: cache}
+// Health of a dependency, and of the whole service.
+const (
+	healthUp       = "up"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+func NewInternalHandler(l *slog.Logger, db database, cache pinger, audit auditLogger, imports importer,
+	keys apiKeyManager, warmer cacheWarmer, readiness ReadinessCfg,
+) *InternalHandler {
+	return &InternalHandler{
+		logger:    l,
+		db:        db,
+		cache:     cache,
+		audit:     audit,
+		imports:   imports,
+		keys:      keys,
+		warmer:    warmer,
+		readiness: readiness,
+	}
 }
 
 func (h *InternalHandler) Healthz(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Readyz fails when the primary database is unreachable, while the cache warms up on startup,
+// and when an optional dependency that ReadinessCfg requires is: the cache, or every replica.
+// Without the others the service degrades, bypassing the cache or reading from the primary,
+// and stays ready. When replicas are configured, the body lists the result of their last check.
 func (h *InternalHandler) Readyz(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
 
-	if err := h.db.Ping(ctx); err != nil {
-		http.Error(w, "db unavailable", http.StatusServiceUnavailable)
-		return
+	health, replicas := h.check(ctx)
+	for _, d := range health.Dependencies {
+		if d.Status == healthDown {
+			http.Error(w, d.Name+" unavailable", http.StatusServiceUnavailable)
+			return
+		}
 	}
-	if err := h.cache.Ping(ctx); err != nil {
-		http.Error(w, "cache unavailable", http.StatusServiceUnavailable)
+	if len(replicas) > 0 {
+		respond(w, http.StatusOK, toReadinessResponse(replicas))
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Health details the state of every dependency, and of the service: down when readiness fails,
+// degraded when it runs without an optional dependency.
+func (h *InternalHandler) Health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	health, _ := h.check(ctx)
+	status := http.StatusOK
+	if health.Status == healthDown {
+		status = http.StatusServiceUnavailable
+	}
+	respond(w, status, health)
+}
+
+// check probes the dependencies. An optional dependency that fails is degraded, unless
+// readiness requires it.
+func (h *InternalHandler) check(ctx context.Context) (healthResponse, []entity.ReplicaStatus) {
+	resp := healthResponse{Status: healthUp}
+	add := func(d dependencyDTO) {
+		resp.Dependencies = append(resp.Dependencies, d)
+		switch {
+		case d.Status == healthDown:
+			resp.Status = healthDown
+		case d.Status == healthDegraded && resp.Status == healthUp:
+			resp.Status = healthDegraded
+		}
+	}
+	failed := func(required bool) string {
+		if required {
+			return healthDown
+		}
+		return healthDegraded
+	}
+
+	db := dependencyDTO{Name: "db", Status: healthUp, Required: true}
+	if err := h.db.Ping(ctx); err != nil {
+		db.Status, db.Error = healthDown, err.Error()
+	}
+	add(db)
+
+	c := dependencyDTO{Name: "cache", Status: healthUp, Required: h.readiness.RequireCache}
+	if b, ok := h.cache.(breakerReporter); ok {
+		c.Breaker = b.BreakerState().String()
+	}
+	if err := h.cache.Ping(ctx); err != nil {
+		c.Status, c.Error = failed(c.Required), err.Error()
+	}
+	add(c)
+	if h.warmer != nil && !h.warmer.Ready() {
+		add(dependencyDTO{Name: "warmup", Status: healthDown, Required: true, Error: "cache warm-up running"})
+	}
+
+	replicas := h.db.Replicas()
+	if len(replicas) > 0 {
+		d := dependencyDTO{Name: "replicas", Status: healthUp, Required: h.readiness.RequireReplicas}
+		if !slices.ContainsFunc(replicas, func(r entity.ReplicaStatus) bool { return r.Available }) {
+			d.Status, d.Error = failed(d.Required), "no replica available"
+		}
+		add(d)
+		resp.Replicas = toReadinessResponse(replicas).Replicas
+	}
+	return resp, replicas
+}
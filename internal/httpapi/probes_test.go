@@ -0,0 +1,167 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/breaker"
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+type (
+	fakePinger   struct{ err error }
+	fakeDatabase struct {
+		fakePinger
+		replicas []entity.ReplicaStatus
+	}
+	fakeGuardedCache struct {
+		fakePinger
+		state breaker.State
+	}
+)
+
+func (p fakePinger) Ping(context.Context) error { return p.err }
+
+func (d fakeDatabase) Replicas() []entity.ReplicaStatus { return d.replicas }
+
+func (c fakeGuardedCache) BreakerState() breaker.State { return c.state }
+
+func TestReadyz(t *testing.T) {
+	down := errors.New("down")
+	replicas := []entity.ReplicaStatus{
+		{Addr: "replica-1:5432", Available: true, Lag: 1500 * time.Millisecond, CheckedAt: time.Now()},
+		{Addr: "replica-2:5432", Error: "lag 9s exceeds 2s", Lag: 9 * time.Second, CheckedAt: time.Now()},
+	}
+
+	tests := []struct {
+		name       string
+		db         fakeDatabase
+		cache      fakePinger
+		warmer     cacheWarmer
+		readiness  ReadinessCfg
+		wantStatus int
+	}{
+		{name: "ready", wantStatus: http.StatusNoContent},
+		{name: "warming up", warmer: &fakeWarmer{}, wantStatus: http.StatusServiceUnavailable},
+		{name: "warmed up", warmer: &fakeWarmer{ready: true}, wantStatus: http.StatusNoContent},
+		{
+			name:       "db down",
+			db:         fakeDatabase{fakePinger: fakePinger{down}},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{name: "cache down degrades", cache: fakePinger{down}, wantStatus: http.StatusNoContent},
+		{
+			name:       "cache down when required",
+			cache:      fakePinger{down},
+			readiness:  ReadinessCfg{RequireCache: true},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "lagging replica does not fail readiness",
+			db:         fakeDatabase{replicas: replicas},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "no replica available when required",
+			db:         fakeDatabase{replicas: replicas[1:]},
+			readiness:  ReadinessCfg{RequireReplicas: true},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ih := NewInternalHandler(slog.New(slog.DiscardHandler), tt.db, tt.cache, nil, nil, nil, tt.warmer,
+				tt.readiness)
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/readyz", nil)
+			rec := httptest.NewRecorder()
+			NewInternalMux(ih, defaultTenant).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			resp := decodeJSON[readinessResponse](t, rec.Body)
+			if len(resp.Replicas) != 2 {
+				t.Fatalf("got %d replicas, want 2", len(resp.Replicas))
+			}
+			if r := resp.Replicas[0]; !r.Available || r.LagSeconds != 1.5 {
+				t.Errorf("got %+v, want available with 1.5s lag", r)
+			}
+			if r := resp.Replicas[1]; r.Available || r.Error == "" {
+				t.Errorf("got %+v, want unavailable with a reason", r)
+			}
+		})
+	}
+}
+
+func TestHealth(t *testing.T) {
+	down := errors.New("down")
+	replicas := []entity.ReplicaStatus{{Addr: "replica-1:5432", Error: "unreachable", CheckedAt: time.Now()}}
+
+	tests := []struct {
+		name       string
+		db         fakeDatabase
+		cache      pinger
+		wantStatus int
+		want       healthResponse
+	}{
+		{
+			name:       "up",
+			cache:      fakeGuardedCache{state: breaker.Closed},
+			wantStatus: http.StatusOK,
+			want: healthResponse{Status: healthUp, Dependencies: []dependencyDTO{
+				{Name: "db", Status: healthUp, Required: true},
+				{Name: "cache", Status: healthUp, Breaker: "closed"},
+			}},
+		},
+		{
+			name:       "cache bypassed",
+			db:         fakeDatabase{replicas: replicas},
+			cache:      fakeGuardedCache{fakePinger{down}, breaker.Open},
+			wantStatus: http.StatusOK,
+			want: healthResponse{Status: healthDegraded, Dependencies: []dependencyDTO{
+				{Name: "db", Status: healthUp, Required: true},
+				{Name: "cache", Status: healthDegraded, Breaker: "open", Error: "down"},
+				{Name: "replicas", Status: healthDegraded, Error: "no replica available"},
+			}},
+		},
+		{
+			name:       "db down",
+			db:         fakeDatabase{fakePinger: fakePinger{down}},
+			cache:      fakePinger{},
+			wantStatus: http.StatusServiceUnavailable,
+			want: healthResponse{Status: healthDown, Dependencies: []dependencyDTO{
+				{Name: "db", Status: healthDown, Required: true, Error: "down"},
+				{Name: "cache", Status: healthUp},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ih := NewInternalHandler(slog.New(slog.DiscardHandler), tt.db, tt.cache, nil, nil, nil, nil,
+				ReadinessCfg{})
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/health", nil)
+			rec := httptest.NewRecorder()
+			NewInternalMux(ih, defaultTenant).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			resp := decodeJSON[healthResponse](t, rec.Body)
+			resp.Replicas = nil
+			if !reflect.DeepEqual(resp, tt.want) {
+				t.Errorf("got %+v, want %+v", resp, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/ratelimit"
+)
+
+const (
+	msgRateLimited = "rate limit exceeded"
+	// ipKeyPrefix keys the buckets of client addresses, apart from those of principals.
+	ipKeyPrefix = "ip:"
+)
+
+type (
+	// RateLimitCfg configures the throttling of the public API.
+	RateLimitCfg struct {
+		// IP limits each client address, before authentication.
+		IP config.Limit
+		// Tiers limits authenticated clients by tier; it must define entity.DefaultTier,
+		// which serves the tiers it does not define.
+		Tiers config.Limits
+		// RouteCosts is how many tokens a request to a route pattern takes; other routes take one.
+		RouteCosts map[string]int
+		// TrustedProxies are the peers whose X-Forwarded-For names the client.
+		TrustedProxies []netip.Prefix
+	}
+	limiter interface {
+		Allow(context.Context, string, config.Limit, int) (ratelimit.Result, error)
+	}
+	// RateLimiter throttles clients of the public API with token buckets: each client address
+	// has one, spent before authentication, and each authenticated client one sized by its tier,
+	// which its requests drain by the cost of their route. A nil RateLimiter does not throttle.
+	RateLimiter struct {
+		limiter limiter
+		cfg     RateLimitCfg
+	}
+)
+
+// NewRateLimiter returns a RateLimiter keeping its buckets in l.
+func NewRateLimiter(l limiter, cfg RateLimitCfg) (*RateLimiter, error) {
+	if _, ok := cfg.Tiers[entity.DefaultTier]; !ok {
+		return nil, fmt.Errorf("no limit for the default tier %q", entity.DefaultTier)
+	}
+	for pattern, cost := range cfg.RouteCosts {
+		for name, tier := range cfg.Tiers {
+			if cost > tier.Burst {
+				return nil, fmt.Errorf("route %q costs more than the burst of tier %q", pattern, name)
+			}
+		}
+	}
+	return new(RateLimiter{limiter: l, cfg: cfg}), nil
+}
+
+// perIP limits requests by client address.
+func (rl *RateLimiter) perIP(next http.Handler) http.Handler {
+	if rl == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ipKeyPrefix + clientIP(r, rl.cfg.TrustedProxies).String()
+		if rl.allow(w, r, key, rl.cfg.IP, 1, false) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// route limits the requests to the route with pattern by their principal, or by client address
+// when they have none, and reports the state of the bucket in RateLimit headers.
+func (rl *RateLimiter) route(pattern string, next http.Handler) http.Handler {
+	if rl == nil {
+		return next
+	}
+	cost := max(rl.cfg.RouteCosts[pattern], 1)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, tier := ipKeyPrefix+clientIP(r, rl.cfg.TrustedProxies).String(), entity.DefaultTier
+		if p, ok := principalFrom(r.Context()); ok {
+			key, tier = p.ID, p.Tier
+		}
+		limit, ok := rl.cfg.Tiers[tier]
+		if !ok {
+			limit = rl.cfg.Tiers[entity.DefaultTier]
+		}
+		if rl.allow(w, r, key, limit, cost, true) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allow takes cost tokens from the bucket of key and answers 429 when there are not enough;
+// report adds the RateLimit headers to allowed requests too. Requests are let through when the
+// limiter fails, as throttling is not worth an outage.
+func (rl *RateLimiter) allow(w http.ResponseWriter, r *http.Request, key string, l config.Limit, cost int,
+	report bool,
+) bool {
+	res, err := rl.limiter.Allow(r.Context(), key, l, cost)
+	if err != nil {
+		slog.Default().WarnContext(r.Context(), "failed to rate limit", slog.Any("error", err),
+			slog.String("key", key))
+		return true
+	}
+	if res.Allowed {
+		if report {
+			setRateLimitHeaders(w.Header(), l, res)
+		}
+		return true
+	}
+	setRateLimitHeaders(w.Header(), l, res)
+	w.Header().Set("Retry-After", ceilSeconds(max(res.RetryAfter, time.Second)))
+	respondError(w, http.StatusTooManyRequests, msgRateLimited)
+	return false
+}
+
+// RateLimit headers in the form of draft-ietf-httpapi-ratelimit-headers.
+const (
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+	headerRateLimitPolicy    = "RateLimit-Policy"
+)
+
+// setRateLimitHeaders describes the bucket: its size, the tokens left, the seconds until it is
+// full, and the policy as the quota over the window in which it refills from empty.
+func setRateLimitHeaders(h http.Header, l config.Limit, res ratelimit.Result) {
+	h.Set(headerRateLimitLimit, strconv.Itoa(l.Burst))
+	h.Set(headerRateLimitRemaining, strconv.Itoa(res.Remaining))
+	h.Set(headerRateLimitReset, ceilSeconds(res.Reset))
+	window := int(math.Ceil(float64(l.Burst) / l.Rate))
+	h.Set(headerRateLimitPolicy, strconv.Itoa(l.Burst)+";w="+strconv.Itoa(window))
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// clientIP returns the address of the client of r. When the peer is a trusted proxy, the
+// client is the rightmost untrusted address in X-Forwarded-For, as earlier entries can be
+// forged by the client; when every entry is trusted, it is the leftmost one.
+func clientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	addr := remoteAddr(r)
+	if !isTrusted(addr, trusted) {
+		return addr
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for _, hop := range slices.Backward(hops) {
+		a, err := netip.ParseAddr(strings.TrimSpace(hop))
+		if err != nil {
+			// What lies beyond a malformed entry cannot be trusted.
+			return addr
+		}
+		addr = a.Unmap()
+		if !isTrusted(addr, trusted) {
+			return addr
+		}
+	}
+	return addr
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	a, _ := netip.ParseAddr(host)
+	return a.Unmap()
+}
+
+func isTrusted(a netip.Addr, trusted []netip.Prefix) bool {
+	return slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(a) })
+}
@@ -0,0 +1,221 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/ratelimit"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{name: "direct", remote: "203.0.113.7:5000", want: "203.0.113.7"},
+		{
+			name:   "untrusted peer",
+			remote: "203.0.113.7:5000",
+			xff:    []string{"198.51.100.1"},
+			want:   "203.0.113.7",
+		},
+		{name: "trusted proxy", remote: "10.0.0.1:5000", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{
+			name:   "forged entry",
+			remote: "10.0.0.1:5000",
+			xff:    []string{"192.0.2.9, 198.51.100.1"},
+			want:   "198.51.100.1",
+		},
+		{
+			name:   "chained proxies",
+			remote: "10.0.0.1:5000",
+			xff:    []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"},
+			want:   "198.51.100.1",
+		},
+		{name: "all trusted", remote: "10.0.0.1:5000", xff: []string{"10.0.0.2, 10.0.0.3"}, want: "10.0.0.2"},
+		{name: "malformed", remote: "10.0.0.1:5000", xff: []string{"198.51.100.1, junk"}, want: "10.0.0.1"},
+		{name: "no header", remote: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "mapped", remote: "[::ffff:203.0.113.7]:5000", want: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r, trusted).String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+type limiterFunc func(context.Context, string, config.Limit, int) (ratelimit.Result, error)
+
+func (f limiterFunc) Allow(ctx context.Context, key string, l config.Limit, cost int,
+) (ratelimit.Result, error) {
+	return f(ctx, key, l, cost)
+}
+
+func newTestRateLimiter(t *testing.T, l limiter) *RateLimiter {
+	t.Helper()
+	rl, err := NewRateLimiter(l, RateLimitCfg{
+		IP: config.Limit{Rate: 1, Burst: 2},
+		Tiers: config.Limits{
+			entity.DefaultTier: {Rate: 1, Burst: 3},
+			"premium":          {Rate: 10, Burst: 20},
+		},
+		RouteCosts: map[string]int{"GET /product/export": 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rl
+}
+
+func withPrincipal(p principal, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalCtxKey{}, p)))
+	})
+}
+
+func TestRateLimiter_Route(t *testing.T) {
+	rl := newTestRateLimiter(t, ratelimit.NewLocal())
+	standard := principal{ID: "apikey:1", Tier: entity.DefaultTier}
+	h := withPrincipal(standard, rl.route("GET /product", okHandler()))
+
+	for i := range 3 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i, w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get(headerRateLimitLimit); got != "3" {
+			t.Errorf("got %s %q, want 3", headerRateLimitLimit, got)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	for header, want := range map[string]string{
+		"Retry-After":            "1",
+		headerRateLimitRemaining: "0",
+		headerRateLimitReset:     "3",
+		headerRateLimitPolicy:    "3;w=3",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("got %s %q, want %q", header, got, want)
+		}
+	}
+
+	premium := withPrincipal(principal{ID: "apikey:2", Tier: "premium"}, rl.route("GET /product", okHandler()))
+	w = httptest.NewRecorder()
+	premium.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product", nil))
+	if w.Code != http.StatusOK || w.Header().Get(headerRateLimitLimit) != "20" {
+		t.Errorf("got status %d with limit %q, want the premium bucket of another key",
+			w.Code, w.Header().Get(headerRateLimitLimit))
+	}
+
+	unknown := withPrincipal(principal{ID: "apikey:3", Tier: "gold"}, rl.route("GET /product", okHandler()))
+	w = httptest.NewRecorder()
+	unknown.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product", nil))
+	if got := w.Header().Get(headerRateLimitLimit); got != "3" {
+		t.Errorf("got limit %q, want the default tier for an unknown one", got)
+	}
+}
+
+func TestRateLimiter_RouteCost(t *testing.T) {
+	rl := newTestRateLimiter(t, ratelimit.NewLocal())
+	p := principal{ID: "apikey:1", Tier: entity.DefaultTier}
+
+	w := httptest.NewRecorder()
+	withPrincipal(p, rl.route("GET /product/export", okHandler())).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product/export", nil))
+	if w.Code != http.StatusOK || w.Header().Get(headerRateLimitRemaining) != "0" {
+		t.Fatalf("got status %d with %q left, want the whole bucket spent",
+			w.Code, w.Header().Get(headerRateLimitRemaining))
+	}
+	w = httptest.NewRecorder()
+	withPrincipal(p, rl.route("GET /product", okHandler())).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want routes to share the bucket of the key", w.Code)
+	}
+}
+
+func TestRateLimiter_PerIP(t *testing.T) {
+	rl := newTestRateLimiter(t, ratelimit.NewLocal())
+	h := rl.perIP(okHandler())
+	serve := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/product", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for range 2 {
+		if w := serve("203.0.113.7:5000"); w.Code != http.StatusOK || w.Header().Get(headerRateLimitLimit) != "" {
+			t.Fatalf("got status %d with headers %v, want allowed without headers", w.Code, w.Header())
+		}
+	}
+	if w := serve("203.0.113.7:6000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want the address limited across ports", w.Code)
+	}
+	if w := serve("203.0.113.8:5000"); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want another address allowed", w.Code)
+	}
+}
+
+func TestRateLimiter_FailOpen(t *testing.T) {
+	failing := limiterFunc(func(context.Context, string, config.Limit, int) (ratelimit.Result, error) {
+		return ratelimit.Result{}, errors.New("redis down")
+	})
+	rl := newTestRateLimiter(t, failing)
+
+	w := httptest.NewRecorder()
+	rl.route("GET /product", okHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want requests let through when the limiter fails", w.Code)
+	}
+}
+
+func TestNewRateLimiter_Invalid(t *testing.T) {
+	tests := map[string]RateLimitCfg{
+		"no default tier": {Tiers: config.Limits{"premium": {Rate: 1, Burst: 1}}},
+		"route over burst": {
+			Tiers:      config.Limits{entity.DefaultTier: {Rate: 1, Burst: 5}},
+			RouteCosts: map[string]int{"GET /product/export": 10},
+		},
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewRateLimiter(ratelimit.NewLocal(), cfg); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestRateLimiter_Nil(t *testing.T) {
+	var rl *RateLimiter
+	w := httptest.NewRecorder()
+	h := rl.perIP(rl.route("GET /product", okHandler()))
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want a nil limiter to let requests through", w.Code)
+	}
+}
@@ -3,25 +3,55 @@ package httpapi
 import (
 	"net/http"
 	"net/http/pprof"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// NewMux initializes new ServeMux and registers routes.
-func NewMux(h *Handler) *http.ServeMux {
+// NewMux initializes new ServeMux and registers routes, each requiring the scope it needs and
+// rate limited by rl, unless it is nil.
+func NewMux(h *Handler, rl *RateLimiter) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /product", h.Add)
-	mux.HandleFunc("PUT /product/{id}", h.Update)
-	mux.HandleFunc("GET /product", h.Get)
-	mux.HandleFunc("GET /product/{id}", h.GetByID)
-	mux.HandleFunc("DELETE /product/{id}", h.Delete)
+	read := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, rl.route(pattern, requireScope(entity.ScopeProductsRead, h)))
+	}
+	write := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, rl.route(pattern, requireScope(entity.ScopeProductsWrite, h)))
+	}
+	write("POST /product", h.Add)
+	write("PUT /product/{id}", h.Update)
+	read("GET /product", h.Get)
+	read("GET /product/export", h.Export)
+	read("GET /product/{id}", h.GetByID)
+	write("DELETE /product/{id}", h.Delete)
+	write("POST /product/{id}/restore", h.Restore)
+	// History names who changed what, and keeps deleted products, so it is not for readers.
+	write("GET /product/{id}/history", h.History)
 
 	return mux
 }
 
-// NewInternalMux returns a mux for the internal-only port.
-func NewInternalMux(hh *InternalHandler) *http.ServeMux {
+// NewInternalMux returns a mux for the internal-only port. tenant resolves the tenant of the
+// routes that read or write tenant data; probes and debug endpoints serve the whole process.
+func NewInternalMux(hh *InternalHandler, tenant Middleware) *http.ServeMux {
 	mux := http.NewServeMux()
+	scoped := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, tenant(h))
+	}
 	mux.HandleFunc("GET /healthz", hh.Healthz)
 	mux.HandleFunc("GET /readyz", hh.Readyz)
+	mux.HandleFunc("GET /health", hh.Health)
+	scoped("GET /audit/export", hh.ExportAudit)
+	scoped("POST /product/import", hh.SubmitImport)
+	scoped("GET /product/import/{id}", hh.ImportStatus)
+	scoped("GET /product/import/{id}/rejects", hh.ImportRejects)
+	scoped("POST /product/import/{id}/resume", hh.RetryImport)
+	scoped("POST /apikey", hh.CreateAPIKey)
+	scoped("GET /apikey", hh.ListAPIKeys)
+	scoped("DELETE /apikey/{id}", hh.RevokeAPIKey)
+	mux.HandleFunc("POST /admin/cache/warm", hh.WarmCache)
+	mux.HandleFunc("GET /admin/cache/warm", hh.WarmProgress)
+	mux.Handle("GET /metrics", promhttp.Handler())
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+
+	"github.com/alkmc/storefront/internal/reqctx"
+)
+
+const (
+	headerAPIKey        = "X-API-Key"
+	defaultTenantHeader = "X-Tenant-ID"
+	maxTenantLen        = 63
+	msgTenantMismatch   = "the credentials do not belong to the requested tenant"
+	msgTenantRequired   = "a tenant is required"
+	msgTenantMalformed  = "invalid tenant id"
+)
+
+// TenantCfg configures how requests are attributed to a tenant.
+type TenantCfg struct {
+	// Header names the tenant of a request; empty means X-Tenant-ID.
+	Header string
+	// Default serves requests that name no tenant, unless Required is set.
+	Default  string
+	Required bool
+}
+
+// NewTenantMiddleware resolves the tenant of each request and stores it in the request context,
+// where the repositories scope every query to it. An authenticated request acts for the tenant
+// of its API key or token, and naming another one in the tenant header gets 403.
+func NewTenantMiddleware(cfg TenantCfg) (Middleware, error) {
+	header := cmp.Or(cfg.Header, defaultTenantHeader)
+	if !cfg.Required && !validTenant(cfg.Default) {
+		return nil, fmt.Errorf("invalid default tenant %q", cfg.Default)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant := r.Header.Get(header)
+			if tenant != "" && !validTenant(tenant) {
+				respondError(w, http.StatusBadRequest, msgTenantMalformed)
+				return
+			}
+			if p, ok := principalFrom(r.Context()); ok {
+				if tenant != "" && tenant != p.Tenant {
+					respondError(w, http.StatusForbidden, msgTenantMismatch)
+					return
+				}
+				tenant = p.Tenant
+			}
+			if tenant == "" {
+				if cfg.Required {
+					respondError(w, http.StatusBadRequest, msgTenantRequired)
+					return
+				}
+				tenant = cfg.Default
+			}
+			next.ServeHTTP(w, r.WithContext(reqctx.WithTenant(r.Context(), tenant)))
+		})
+	}, nil
+}
+
+// validTenant accepts 1 to 63 lowercase letters, digits, '-' and '_', starting with a letter or
+// digit. Cache keys rely on tenants never containing ':'.
+func validTenant(id string) bool {
+	if id == "" || len(id) > maxTenantLen {
+		return false
+	}
+	for i, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case (c == '-' || c == '_') && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
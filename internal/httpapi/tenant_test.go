@@ -0,0 +1,140 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alkmc/storefront/internal/reqctx"
+)
+
+// defaultTenant leaves requests to the default tenant the repositories fall back to.
+func defaultTenant(next http.Handler) http.Handler {
+	return next
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        TenantCfg
+		header     string
+		authTenant string
+		wantStatus int
+		wantTenant string
+	}{
+		{
+			name:       "default tenant",
+			cfg:        TenantCfg{Default: "default"},
+			wantStatus: http.StatusOK,
+			wantTenant: "default",
+		},
+		{
+			name:       "tenant header",
+			cfg:        TenantCfg{Default: "default"},
+			header:     "globex",
+			wantStatus: http.StatusOK,
+			wantTenant: "globex",
+		},
+		{
+			name:       "api key",
+			cfg:        TenantCfg{Required: true},
+			authTenant: "acme",
+			wantStatus: http.StatusOK,
+			wantTenant: "acme",
+		},
+		{
+			name:       "api key with its own tenant",
+			cfg:        TenantCfg{Default: "default"},
+			header:     "acme",
+			authTenant: "acme",
+			wantStatus: http.StatusOK,
+			wantTenant: "acme",
+		},
+		{
+			name:       "api key with another tenant",
+			cfg:        TenantCfg{Default: "default"},
+			header:     "globex",
+			authTenant: "acme",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "malformed tenant",
+			cfg:        TenantCfg{Default: "default"},
+			header:     "acme:1",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "required tenant missing",
+			cfg:        TenantCfg{Required: true},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw, err := NewTenantMiddleware(tt.cfg)
+			if err != nil {
+				t.Fatalf("tenant init: %v", err)
+			}
+			var got string
+			next := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = reqctx.Tenant(r.Context())
+			})
+			ctx := t.Context()
+			if tt.authTenant != "" {
+				ctx = context.WithValue(ctx, principalCtxKey{}, principal{Tenant: tt.authTenant})
+			}
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			mw(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got != tt.wantTenant {
+				t.Errorf("got tenant %q, want %q", got, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestNewTenantMiddleware_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  TenantCfg
+	}{
+		{name: "invalid default", cfg: TenantCfg{Default: "Acme"}},
+		{name: "empty default", cfg: TenantCfg{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTenantMiddleware(tt.cfg); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidTenant(t *testing.T) {
+	for id, want := range map[string]bool{
+		"acme":                  true,
+		"shop-2_eu":             true,
+		"0":                     true,
+		"":                      false,
+		"_acme":                 false,
+		"Acme":                  false,
+		"acme:1":                false,
+		strings.Repeat("a", 63): true,
+		strings.Repeat("a", 64): false,
+		"acme shop":             false,
+	} {
+		if got := validTenant(id); got != want {
+			t.Errorf("validTenant(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/alkmc/storefront/internal/httpapi")
+
+// untracedRoutes are polled too often for their traces to be worth keeping.
+var untracedRoutes = map[string]bool{"GET /healthz": true, "GET /readyz": true, "GET /metrics": true}
+
+// parentSpanKey holds the span of the request while a traced middleware runs.
+type parentSpanKey struct{}
+
+// Trace records each request h serves in a server span, continuing the trace of its W3C
+// traceparent and tracestate headers. Like HTTPMetrics.Instrument, it names requests by the
+// route of routes they match.
+func Trace(routes *http.ServeMux, h http.Handler) http.Handler {
+	traced := otelhttp.NewHandler(h, "", otelhttp.WithSpanNameFormatter(spanName))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := routes.Handler(r)
+		if untracedRoutes[pattern] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// The mux sets the pattern on the requests it routes too late for the span, which
+		// middleware has already started by then.
+		r.Pattern = pattern
+		traced.ServeHTTP(w, r)
+	})
+}
+
+// spanName is the method and route of r, or its method alone when it matches no route.
+func spanName(_ string, r *http.Request) string {
+	switch {
+	case r.Pattern == "":
+		return r.Method
+	case strings.HasPrefix(r.Pattern, "/"):
+		return r.Method + " " + r.Pattern
+	}
+	return r.Pattern
+}
+
+// traced records the work mw does before it passes a request on, or answers it itself, in a
+// span named name. The handlers after mw are in the span of the request rather than of mw.
+// Requests without one are not traced.
+func traced(name string, mw Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			trace.SpanFromContext(ctx).End()
+			if parent, ok := ctx.Value(parentSpanKey{}).(trace.Span); ok {
+				ctx = trace.ContextWithSpan(ctx, parent)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := trace.SpanFromContext(r.Context())
+			if !parent.SpanContext().IsValid() {
+				h.ServeHTTP(w, r)
+				return
+			}
+			ctx := context.WithValue(r.Context(), parentSpanKey{}, parent)
+			ctx, span := tracer.Start(ctx, name)
+			defer span.End()
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTrace(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /product/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracer.Start(r.Context(), "handler")
+		span.End()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	passThrough := func(next http.Handler) http.Handler { return next }
+	h := Trace(mux, traced("authenticate", passThrough)(mux))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest(http.MethodGet, "/product/1", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = s
+	}
+	if len(spans) != 3 {
+		t.Fatalf("got spans %v, want the request, authenticate and handler only", spans)
+	}
+	server, ok := spans["GET /product/{id}"]
+	if !ok {
+		t.Fatalf("got spans %v, want one named by the route", spans)
+	}
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("got trace id %s, want the one of the traceparent header", got)
+	}
+	// The handler runs in the span of the request, not of the middleware before it.
+	for _, name := range []string{"authenticate", "handler"} {
+		if got := spans[name].Parent().SpanID(); got != server.SpanContext().SpanID() {
+			t.Errorf("%s: got parent %s, want the request span", name, got)
+		}
+	}
+}
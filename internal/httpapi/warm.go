@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+const warmPath = "/admin/cache/warm"
+
+// WarmCache starts warming the cache, from the source in the query or the configured one,
+// and answers 202 with its progress. It answers 409 while a warm-up runs.
+func (h *InternalHandler) WarmCache(w http.ResponseWriter, r *http.Request) {
+	if h.warmer == nil {
+		respondError(w, http.StatusNotFound, "cache warming not available")
+		return
+	}
+	source := entity.WarmSource(r.URL.Query().Get("source"))
+	if source != "" && !source.Valid() {
+		respondError(w, http.StatusBadRequest, "source must be hot or recent")
+		return
+	}
+
+	run, err := h.warmer.Start(source)
+	if err != nil {
+		if errors.Is(err, entity.ErrWarmRunning) {
+			respondError(w, http.StatusConflict, "cache warm-up already running")
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "failed to start cache warm-up", slog.Any("error", err))
+		respondError(w, http.StatusInternalServerError, msgInternalError)
+		return
+	}
+	w.Header().Set("Location", warmPath)
+	respond(w, http.StatusAccepted, toWarmRunResponse(run))
+}
+
+// WarmProgress reports the progress of the latest warm-up.
+func (h *InternalHandler) WarmProgress(w http.ResponseWriter, _ *http.Request) {
+	if h.warmer == nil {
+		respondError(w, http.StatusNotFound, "cache warming not available")
+		return
+	}
+	run, ok := h.warmer.Progress()
+	if !ok {
+		respondError(w, http.StatusNotFound, "no cache warm-up yet")
+		return
+	}
+	respond(w, http.StatusOK, toWarmRunResponse(run))
+}
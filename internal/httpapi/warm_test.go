@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+type fakeWarmer struct {
+	ready   bool
+	last    entity.WarmRun
+	started []entity.WarmSource
+}
+
+func (w *fakeWarmer) Ready() bool { return w.ready }
+
+func (w *fakeWarmer) Start(source entity.WarmSource) (entity.WarmRun, error) {
+	if w.last.Status == entity.WarmRunning {
+		return entity.WarmRun{}, entity.ErrWarmRunning
+	}
+	w.started = append(w.started, source)
+	w.last = entity.WarmRun{Source: source, Status: entity.WarmRunning, StartedAt: time.Now()}
+	return w.last, nil
+}
+
+func (w *fakeWarmer) Progress() (entity.WarmRun, bool) {
+	return w.last, !w.last.StartedAt.IsZero()
+}
+
+func TestWarmCache(t *testing.T) {
+	w := &fakeWarmer{ready: true}
+	mux := NewInternalMux(NewInternalHandler(slog.New(slog.DiscardHandler), nil, nil, nil, nil, nil, w,
+		ReadinessCfg{}), defaultTenant)
+	do := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(t.Context(), method, url, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/admin/cache/warm"); rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %d before any warm-up, want 404", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/admin/cache/warm?source=cold"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d for an unknown source, want 400", rec.Code)
+	}
+
+	rec := do(http.MethodPost, "/admin/cache/warm?source=recent")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/admin/cache/warm" {
+		t.Errorf("got Location %q", loc)
+	}
+	if resp := decodeJSON[warmRunResponse](t, rec.Body); resp.Source != "recent" || resp.Status != "running" ||
+		resp.FinishedAt != nil {
+		t.Errorf("got %+v, want a running warm-up from recent", resp)
+	}
+	if rec := do(http.MethodPost, "/admin/cache/warm"); rec.Code != http.StatusConflict {
+		t.Fatalf("got status %d while running, want 409", rec.Code)
+	}
+
+	w.last.Status, w.last.Picked, w.last.Stored, w.last.FinishedAt = entity.WarmCompleted, 3, 2, time.Now()
+	rec = do(http.MethodGet, "/admin/cache/warm")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rec.Code)
+	}
+	if resp := decodeJSON[warmRunResponse](t, rec.Body); resp.Status != "completed" || resp.Picked != 3 ||
+		resp.Stored != 2 || resp.FinishedAt == nil {
+		t.Errorf("got %+v, want the completed warm-up", resp)
+	}
+
+	if rec := do(http.MethodPost, "/admin/cache/warm"); rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", rec.Code)
+	}
+	if want := []entity.WarmSource{entity.WarmRecent, ""}; !slices.Equal(w.started, want) {
+		t.Errorf("got sources %q, want %q", w.started, want)
+	}
+}
+
+func TestWarmCache_Disabled(t *testing.T) {
+	mux := NewInternalMux(NewInternalHandler(slog.New(slog.DiscardHandler), nil, nil, nil, nil, nil, nil,
+		ReadinessCfg{}), defaultTenant)
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/admin/cache/warm", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want 404", rec.Code)
+	}
+}
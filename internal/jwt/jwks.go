@@ -0,0 +1,271 @@
+// Package jwt verifies the bearer tokens of an external identity provider against its
+// JSON Web Key Set (RFC 7517), using RS256, ES256 or EdDSA signatures only.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+)
+
+const (
+	// maxJWKSBytes bounds a fetched key set.
+	maxJWKSBytes = 1 << 20
+	fetchTimeout = 10 * time.Second
+	// minRefetch bounds how often a token signed by an unknown key can trigger a reload,
+	// so forged key ids cannot hammer the source.
+	minRefetch = 30 * time.Second
+	// minRSABits is the smallest RSA modulus accepted.
+	minRSABits = 2048
+)
+
+type (
+	// KeySet holds the verification keys of a JWKS by key id and keeps them current:
+	// Run reloads them periodically, and a token signed by an unknown key triggers an early
+	// reload, so the issuer can rotate keys without restarting the server.
+	KeySet struct {
+		logger  *slog.Logger
+		load    func(context.Context) ([]byte, error)
+		refresh time.Duration
+
+		mu      sync.RWMutex
+		keys    map[string]key
+		fetched time.Time
+		// reload serializes loads, so concurrent misses reload once.
+		reload sync.Mutex
+	}
+	// key is a public key with the algorithm it verifies.
+	key struct {
+		alg string
+		pub crypto.PublicKey
+	}
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+)
+
+// NewKeySet loads the key set named by cfg, failing when it cannot be read or holds no
+// usable key.
+func NewKeySet(ctx context.Context, l *slog.Logger, cfg config.JWT) (*KeySet, error) {
+	ks := new(KeySet{logger: l, refresh: cfg.JWKSRefresh})
+	if cfg.JWKSFile != "" {
+		ks.load = func(context.Context) ([]byte, error) { return os.ReadFile(cfg.JWKSFile) }
+	} else {
+		client := &http.Client{Timeout: fetchTimeout}
+		ks.load = func(ctx context.Context) ([]byte, error) { return fetch(ctx, client, cfg.JWKSURL) }
+	}
+	if err := ks.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Run reloads the key set every refresh interval until ctx is done. A failed reload keeps
+// the current keys.
+func (ks *KeySet) Run(ctx context.Context) error {
+	t := time.NewTicker(ks.refresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			if err := ks.Reload(ctx); err != nil {
+				ks.logger.Warn("failed to reload jwks", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// Reload replaces the keys with those currently published.
+func (ks *KeySet) Reload(ctx context.Context) error {
+	ks.reload.Lock()
+	defer ks.reload.Unlock()
+	return ks.reloadLocked(ctx)
+}
+
+func (ks *KeySet) reloadLocked(ctx context.Context) error {
+	data, err := ks.load(ctx)
+	ks.mu.Lock()
+	ks.fetched = time.Now()
+	ks.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to read jwks: %w", err)
+	}
+	keys, err := parseKeySet(ks.logger, data)
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+	return nil
+}
+
+// lookup returns the key with id kid, reloading the set once when the id is unknown and
+// the last load is older than minRefetch.
+func (ks *KeySet) lookup(ctx context.Context, kid string) (key, bool) {
+	if k, ok := ks.get(kid); ok {
+		return k, true
+	}
+	ks.reload.Lock()
+	defer ks.reload.Unlock()
+	// Another request may have reloaded while this one waited.
+	if k, ok := ks.get(kid); ok {
+		return k, true
+	}
+	ks.mu.RLock()
+	recent := time.Since(ks.fetched) < minRefetch
+	ks.mu.RUnlock()
+	if recent {
+		return key{}, false
+	}
+	if err := ks.reloadLocked(ctx); err != nil {
+		ks.logger.Warn("failed to reload jwks for an unknown key", slog.Any("error", err), slog.String("kid", kid))
+		return key{}, false
+	}
+	return ks.get(kid)
+}
+
+func (ks *KeySet) get(kid string) (key, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+func fetch(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+}
+
+// parseKeySet keeps the signature keys of a JWKS that use a supported algorithm. Keys
+// without an id are skipped, as tokens must name the key that signed them, and malformed
+// keys are logged and skipped, so one bad entry does not take down the rest of the set.
+func parseKeySet(l *slog.Logger, data []byte) (map[string]key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode jwks: %w", err)
+	}
+	keys := make(map[string]key, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Kid == "" || (j.Use != "" && j.Use != "sig") {
+			continue
+		}
+		k, err := j.key()
+		if err != nil {
+			l.Warn("skipping unusable jwk", slog.String("kid", j.Kid), slog.Any("error", err))
+			continue
+		}
+		if k.pub != nil {
+			keys[j.Kid] = k
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no usable signature keys")
+	}
+	return keys, nil
+}
+
+// key decodes j, or returns the zero key for key types and algorithms it does not support.
+func (j jwk) key() (key, error) {
+	switch {
+	case j.Kty == "RSA" && (j.Alg == "" || j.Alg == algRS256):
+		n, err := decodeInt(j.N)
+		if err != nil {
+			return key{}, fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeInt(j.E)
+		if err != nil {
+			return key{}, fmt.Errorf("e: %w", err)
+		}
+		if n.BitLen() < minRSABits {
+			return key{}, fmt.Errorf("rsa key shorter than %d bits", minRSABits)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return key{}, errors.New("invalid rsa exponent")
+		}
+		return key{alg: algRS256, pub: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case j.Kty == "EC" && j.Crv == "P-256" && (j.Alg == "" || j.Alg == algES256):
+		x, err := decodeFixed(j.X, 32)
+		if err != nil {
+			return key{}, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeFixed(j.Y, 32)
+		if err != nil {
+			return key{}, fmt.Errorf("y: %w", err)
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return key{}, err
+		}
+		return key{alg: algES256, pub: pub}, nil
+	case j.Kty == "OKP" && j.Crv == "Ed25519" && (j.Alg == "" || j.Alg == algEdDSA || j.Alg == algEd25519):
+		x, err := decodeFixed(j.X, ed25519.PublicKeySize)
+		if err != nil {
+			return key{}, fmt.Errorf("x: %w", err)
+		}
+		return key{alg: algEdDSA, pub: ed25519.PublicKey(x)}, nil
+	}
+	return key{}, nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func decodeFixed(s string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("got %d bytes, want %d", len(b), size)
+	}
+	return b, nil
+}
@@ -0,0 +1,400 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+const (
+	testIssuer   = "https://sso.example.com"
+	testAudience = "storefront"
+)
+
+// signer signs test tokens with a locally generated key.
+type signer struct {
+	kid  string
+	alg  string
+	priv crypto.Signer
+}
+
+func newSigner(t *testing.T, kid, alg string) signer {
+	t.Helper()
+	var (
+		priv crypto.Signer
+		err  error
+	)
+	switch alg {
+	case algRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case algES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("failed to generate %s key: %v", alg, err)
+	}
+	return signer{kid: kid, alg: alg, priv: priv}
+}
+
+// jwk returns the public key in JWK form.
+func (s signer) jwk() map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pub := s.priv.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA", "kid": s.kid, "use": "sig", "alg": s.alg,
+			"n": enc(pub.N.Bytes()), "e": enc(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		b, _ := pub.Bytes()
+		return map[string]string{"kty": "EC", "kid": s.kid, "crv": "P-256", "x": enc(b[1:33]), "y": enc(b[33:])}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": s.kid, "crv": "Ed25519", "x": enc(pub)}
+	}
+	return nil
+}
+
+func (s signer) sign(t *testing.T, alg string, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": s.kid, "typ": "JWT"}) + "." + enc(claims)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch priv := s.priv.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		var r, ss *big.Int
+		r, ss, err = ecdsa.Sign(rand.Reader, priv, sum[:])
+		sig = append(r.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(priv, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJWKS(t *testing.T, path string, signers ...signer) {
+	t.Helper()
+	keys := make([]map[string]string, len(signers))
+	for i, s := range signers {
+		keys[i] = s.jwk()
+	}
+	b, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatalf("failed to marshal jwks: %v", err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("failed to write jwks: %v", err)
+	}
+}
+
+func testConfig(jwksFile string) config.JWT {
+	return config.JWT{
+		JWKSFile:    jwksFile,
+		JWKSRefresh: time.Hour,
+		Issuer:      testIssuer,
+		Audience:    testAudience,
+		ClockSkew:   30 * time.Second,
+		TenantClaim: "tenant",
+		TierClaim:   "tier",
+		ScopeClaim:  "scope",
+		ClaimScopes: map[string]string{"catalog-editor": "products:read products:write"},
+	}
+}
+
+func newTestVerifier(t *testing.T, signers ...signer) (*Verifier, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, signers...)
+	cfg := testConfig(path)
+	ks, err := NewKeySet(t.Context(), slog.New(slog.DiscardHandler), cfg)
+	if err != nil {
+		t.Fatalf("failed to load jwks: %v", err)
+	}
+	return NewVerifier(ks, cfg), path
+}
+
+func validClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":    testIssuer,
+		"aud":    testAudience,
+		"sub":    "user-1",
+		"tenant": "acme",
+		"scope":  "openid products:read",
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Minute).Unix(),
+	}
+}
+
+func with(claims map[string]any, kv ...any) map[string]any {
+	for i := 0; i < len(kv); i += 2 {
+		if kv[i+1] == nil {
+			delete(claims, kv[i].(string))
+			continue
+		}
+		claims[kv[i].(string)] = kv[i+1]
+	}
+	return claims
+}
+
+func TestVerify_Algorithms(t *testing.T) {
+	for _, alg := range []string{algRS256, algES256, algEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			s := newSigner(t, "k-"+alg, alg)
+			v, _ := newTestVerifier(t, s)
+
+			c, err := v.Verify(t.Context(), s.sign(t, alg, validClaims()))
+			if err != nil {
+				t.Fatalf("failed to verify: %v", err)
+			}
+			if c.Subject != "user-1" || c.Tenant != "acme" || c.Tier != entity.DefaultTier {
+				t.Errorf("got subject %q of %q in tier %q, want user-1 of acme in the default tier",
+					c.Subject, c.Tenant, c.Tier)
+			}
+			if !slices.Equal(c.Scopes, []entity.Scope{entity.ScopeProductsRead}) {
+				t.Errorf("got scopes %v, want [products:read]", c.Scopes)
+			}
+		})
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	rs := newSigner(t, "rs", algRS256)
+	es := newSigner(t, "es", algES256)
+	stranger := newSigner(t, "rs", algRS256)
+	v, _ := newTestVerifier(t, rs, es)
+	inMinute := time.Now().Add(time.Minute).Unix()
+	minuteAgo := time.Now().Add(-time.Minute).Unix()
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "expired", token: rs.sign(t, algRS256, with(validClaims(), "exp", minuteAgo))},
+		{name: "no expiry", token: rs.sign(t, algRS256, with(validClaims(), "exp", nil))},
+		{name: "not yet valid", token: rs.sign(t, algRS256, with(validClaims(), "nbf", inMinute))},
+		{name: "wrong issuer", token: rs.sign(t, algRS256, with(validClaims(), "iss", "https://sso.evil.com"))},
+		{name: "wrong audience", token: rs.sign(t, algRS256, with(validClaims(), "aud", []string{"billing"}))},
+		{name: "no subject", token: rs.sign(t, algRS256, with(validClaims(), "sub", nil))},
+		{name: "no tenant", token: rs.sign(t, algRS256, with(validClaims(), "tenant", nil))},
+		{name: "malformed tier", token: rs.sign(t, algRS256, with(validClaims(), "tier", "Gold Plus"))},
+		{name: "unknown key", token: newSigner(t, "other", algEdDSA).sign(t, algEdDSA, validClaims())},
+		{name: "foreign key with a known id", token: stranger.sign(t, algRS256, validClaims())},
+		{name: "algorithm of another key type", token: es.sign(t, algRS256, validClaims())},
+		{name: "alg none", token: noneToken(t)},
+		{name: "tampered claims", token: tamper(t, rs.sign(t, algRS256, validClaims()))},
+		{name: "not a jws", token: "a.b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Verify(t.Context(), tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+// noneToken is an unsecured JWS (RFC 7519 §6) naming the rs key.
+func noneToken(t *testing.T) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return enc(map[string]string{"alg": "none", "kid": "rs"}) + "." + enc(validClaims()) + "."
+}
+
+// tamper grants the bearer of token another tenant, keeping the signature.
+func tamper(t *testing.T, token string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	b, _ := json.Marshal(with(validClaims(), "tenant", "other"))
+	parts[1] = base64.RawURLEncoding.EncodeToString(b)
+	return strings.Join(parts, ".")
+}
+
+func TestVerify_ClockSkew(t *testing.T) {
+	s := newSigner(t, "k", algEdDSA)
+	v, _ := newTestVerifier(t, s)
+	now := time.Now()
+
+	within := with(validClaims(), "exp", now.Add(-10*time.Second).Unix(), "nbf", now.Add(10*time.Second).Unix())
+	if _, err := v.Verify(t.Context(), s.sign(t, algEdDSA, within)); err != nil {
+		t.Errorf("got %v, want exp and nbf within the skew accepted", err)
+	}
+	beyond := with(validClaims(), "exp", now.Add(-time.Minute).Unix())
+	if _, err := v.Verify(t.Context(), s.sign(t, algEdDSA, beyond)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v, want an expiry beyond the skew rejected", err)
+	}
+}
+
+func TestVerify_Scopes(t *testing.T) {
+	s := newSigner(t, "k", algEdDSA)
+	v, _ := newTestVerifier(t, s)
+
+	tests := []struct {
+		name  string
+		scope any
+		want  []entity.Scope
+	}{
+		{name: "space separated", scope: "products:read products:write", want: entity.Scopes},
+		{name: "array", scope: []string{"products:write"}, want: []entity.Scope{entity.ScopeProductsWrite}},
+		{name: "mapped role", scope: []string{"catalog-editor"}, want: entity.Scopes},
+		{name: "unknown values", scope: "openid profile"},
+		{name: "absent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := v.Verify(t.Context(), s.sign(t, algEdDSA, with(validClaims(), "scope", tt.scope)))
+			if err != nil {
+				t.Fatalf("failed to verify: %v", err)
+			}
+			if !slices.Equal(c.Scopes, tt.want) {
+				t.Errorf("got scopes %v, want %v", c.Scopes, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify_Tier(t *testing.T) {
+	s := newSigner(t, "k", algEdDSA)
+	v, _ := newTestVerifier(t, s)
+
+	c, err := v.Verify(t.Context(), s.sign(t, algEdDSA, with(validClaims(), "tier", "premium")))
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if c.Tier != "premium" {
+		t.Errorf("got tier %q, want premium", c.Tier)
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	old, rotated := newSigner(t, "2026-01", algES256), newSigner(t, "2026-02", algES256)
+	v, path := newTestVerifier(t, old)
+
+	// A token of a key published after the last load triggers a reload, once the set is
+	// older than minRefetch.
+	writeJWKS(t, path, rotated)
+	token := rotated.sign(t, algES256, validClaims())
+	if _, err := v.Verify(t.Context(), token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got %v, want the unknown key rejected right after a load", err)
+	}
+	v.keys.mu.Lock()
+	v.keys.fetched = time.Now().Add(-minRefetch)
+	v.keys.mu.Unlock()
+	if _, err := v.Verify(t.Context(), token); err != nil {
+		t.Fatalf("failed to verify with the rotated key: %v", err)
+	}
+	if _, err := v.Verify(t.Context(), old.sign(t, algES256, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v, want the retired key rejected", err)
+	}
+}
+
+func TestKeySet_URL(t *testing.T) {
+	s := newSigner(t, "k", algRS256)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, s)
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		http.ServeFile(w, r, path)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := testConfig("")
+	cfg.JWKSURL = srv.URL
+	ks, err := NewKeySet(t.Context(), slog.New(slog.DiscardHandler), cfg)
+	if err != nil {
+		t.Fatalf("failed to load jwks: %v", err)
+	}
+	if _, err := NewVerifier(ks, cfg).Verify(t.Context(), s.sign(t, algRS256, validClaims())); err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if err := ks.Reload(t.Context()); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+}
+
+func TestNewKeySet_Unusable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	for _, body := range []string{
+		`{"keys":[]}`,
+		`{"keys":[{"kty":"oct","kid":"k","k":"c2VjcmV0"}]}`,
+		`{"keys":[{"kty":"OKP","kid":"k","crv":"Ed25519","x":"c2hvcnQ"}]}`,
+		`not json`,
+	} {
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("failed to write jwks: %v", err)
+		}
+		if _, err := NewKeySet(t.Context(), slog.New(slog.DiscardHandler), testConfig(path)); err == nil {
+			t.Errorf("expected %s to be rejected", body)
+		}
+	}
+}
+
+func TestNewKeySet_SkipsMalformedKeys(t *testing.T) {
+	good := newSigner(t, "good", algEdDSA)
+	bad := good.jwk()
+	bad["kid"], bad["x"] = "bad", "c2hvcnQ"
+	b, err := json.Marshal(map[string]any{"keys": []map[string]string{bad, good.jwk()}})
+	if err != nil {
+		t.Fatalf("failed to marshal jwks: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("failed to write jwks: %v", err)
+	}
+
+	ks, err := NewKeySet(t.Context(), slog.New(slog.DiscardHandler), testConfig(path))
+	if err != nil {
+		t.Fatalf("failed to load jwks with one malformed key: %v", err)
+	}
+	if _, ok := ks.get("bad"); ok {
+		t.Error("expected the malformed key to be skipped")
+	}
+	token := good.sign(t, algEdDSA, validClaims())
+	if _, err := NewVerifier(ks, testConfig(path)).Verify(t.Context(), token); err != nil {
+		t.Errorf("failed to verify with the remaining key: %v", err)
+	}
+}
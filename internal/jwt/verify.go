@@ -0,0 +1,245 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+// Signature algorithms accepted in the alg header; "Ed25519" is the fully-specified name
+// of EdDSA over Ed25519 (RFC 9864).
+const (
+	algRS256   = "RS256"
+	algES256   = "ES256"
+	algEdDSA   = "EdDSA"
+	algEd25519 = "Ed25519"
+)
+
+// maxTokenBytes bounds the tokens worth parsing.
+const maxTokenBytes = 8 << 10
+
+// ErrInvalidToken rejects a token that is malformed, not signed by a known key, or whose
+// claims do not hold; the wrapped error says why.
+var ErrInvalidToken = errors.New("jwt: invalid token")
+
+type (
+	// Verifier checks tokens against a KeySet and the expected issuer and audience.
+	Verifier struct {
+		keys *KeySet
+		cfg  config.JWT
+	}
+	// Claims are what a verified token says about its bearer.
+	Claims struct {
+		Subject string
+		Tenant  string
+		// Scopes are the known scopes granted by the scope claim; unknown values are ignored.
+		Scopes []entity.Scope
+		// Tier is the rate limit tier of the bearer, entity.DefaultTier when the token has none.
+		Tier      string
+		ExpiresAt time.Time
+	}
+	header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+		// Crit lists extensions the token requires to be understood; none are.
+		Crit []string `json:"crit"`
+	}
+	// numericDate is seconds since the epoch, possibly fractional.
+	numericDate float64
+	// audience is a single string or an array of them.
+	audience []string
+)
+
+// NewVerifier returns a Verifier of tokens signed by keys and meeting cfg.
+func NewVerifier(keys *KeySet, cfg config.JWT) *Verifier {
+	return new(Verifier{keys: keys, cfg: cfg})
+}
+
+// Verify checks the signature and the registered claims of token and maps the rest to Claims.
+// Every rejection wraps ErrInvalidToken.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	c, err := v.verify(ctx, token)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return c, nil
+}
+
+func (v *Verifier) verify(ctx context.Context, token string) (Claims, error) {
+	if len(token) > maxTokenBytes {
+		return Claims{}, errors.New("token too large")
+	}
+	h64, rest, ok1 := strings.Cut(token, ".")
+	p64, s64, ok2 := strings.Cut(rest, ".")
+	if !ok1 || !ok2 || strings.Contains(s64, ".") {
+		return Claims{}, errors.New("not a compact jws")
+	}
+
+	var h header
+	if err := decodeJSON(h64, &h); err != nil {
+		return Claims{}, fmt.Errorf("header: %w", err)
+	}
+	if len(h.Crit) > 0 {
+		return Claims{}, fmt.Errorf("unsupported critical extensions %q", h.Crit)
+	}
+	alg := h.Alg
+	if alg == algEd25519 {
+		alg = algEdDSA
+	}
+	k, ok := v.keys.lookup(ctx, h.Kid)
+	if !ok {
+		return Claims{}, fmt.Errorf("unknown key %q", h.Kid)
+	}
+	// The algorithm is the key's, never the token's choice alone.
+	if alg != k.alg {
+		return Claims{}, fmt.Errorf("algorithm %q does not match key %q", h.Alg, h.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s64)
+	if err != nil {
+		return Claims{}, fmt.Errorf("signature: %w", err)
+	}
+	if !verifySignature(k, []byte(token[:len(h64)+1+len(p64)]), sig) {
+		return Claims{}, errors.New("bad signature")
+	}
+
+	var claims map[string]json.RawMessage
+	if err := decodeJSON(p64, &claims); err != nil {
+		return Claims{}, fmt.Errorf("claims: %w", err)
+	}
+	return v.checkClaims(claims, time.Now())
+}
+
+func verifySignature(k key, signed, sig []byte) bool {
+	switch pub := k.pub.(type) {
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as r || s, 32 bytes each (RFC 7518 §3.4).
+		if len(sig) != 64 {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, signed, sig)
+	}
+	return false
+}
+
+// checkClaims validates iss, aud, exp and nbf at now, allowing the configured clock skew,
+// and maps the tenant and scope claims.
+func (v *Verifier) checkClaims(raw map[string]json.RawMessage, now time.Time) (Claims, error) {
+	var (
+		iss, sub string
+		aud      audience
+		exp, nbf numericDate
+	)
+	for name, dst := range map[string]any{"iss": &iss, "sub": &sub, "aud": &aud, "exp": &exp, "nbf": &nbf} {
+		if b, ok := raw[name]; ok {
+			if err := json.Unmarshal(b, dst); err != nil {
+				return Claims{}, fmt.Errorf("claim %s: %w", name, err)
+			}
+		}
+	}
+
+	skew := v.cfg.ClockSkew
+	switch {
+	case iss != v.cfg.Issuer:
+		return Claims{}, fmt.Errorf("unexpected issuer %q", iss)
+	case !slices.Contains(aud, v.cfg.Audience):
+		return Claims{}, fmt.Errorf("audience %q not in %q", v.cfg.Audience, []string(aud))
+	case exp == 0:
+		return Claims{}, errors.New("no expiry")
+	case !now.Before(exp.time().Add(skew)):
+		return Claims{}, errors.New("expired")
+	case nbf != 0 && now.Before(nbf.time().Add(-skew)):
+		return Claims{}, errors.New("not valid yet")
+	case sub == "":
+		return Claims{}, errors.New("no subject")
+	}
+
+	var tenant string
+	if b, ok := raw[v.cfg.TenantClaim]; !ok || json.Unmarshal(b, &tenant) != nil || tenant == "" {
+		return Claims{}, fmt.Errorf("no %s claim", v.cfg.TenantClaim)
+	}
+	tier := entity.DefaultTier
+	if b, ok := raw[v.cfg.TierClaim]; ok && v.cfg.TierClaim != "" {
+		if json.Unmarshal(b, &tier) != nil || !entity.ValidTier(tier) {
+			return Claims{}, fmt.Errorf("malformed %s claim", v.cfg.TierClaim)
+		}
+	}
+	scopes, err := v.scopes(raw[v.cfg.ScopeClaim])
+	if err != nil {
+		return Claims{}, err
+	}
+	return Claims{Subject: sub, Tenant: tenant, Scopes: scopes, Tier: tier, ExpiresAt: exp.time()}, nil
+}
+
+// scopes maps the values of the scope claim, a space-separated string as in OAuth 2.0 or
+// an array, to the scopes they name directly or through ClaimScopes.
+func (v *Verifier) scopes(raw json.RawMessage) ([]entity.Scope, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var values []string
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		values = strings.Fields(s)
+	} else if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("claim %s: %w", v.cfg.ScopeClaim, err)
+	}
+
+	var scopes []entity.Scope
+	grant := func(s entity.Scope) {
+		if s.Valid() && !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	for _, val := range values {
+		grant(entity.Scope(val))
+		for mapped := range strings.FieldsSeq(v.cfg.ClaimScopes[val]) {
+			grant(entity.Scope(mapped))
+		}
+	}
+	return scopes, nil
+}
+
+func decodeJSON(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (d numericDate) time() time.Time {
+	sec, frac := math.Modf(float64(d))
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
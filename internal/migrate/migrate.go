@@ -12,19 +12,37 @@ import (
 	"github.com/pressly/goose/v3"
 )
 
-//go:embed migrations/*.sql
+// Dialect selects the migration set, one per supported database.
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
 var fsys embed.FS
 
-func newProvider(db *sql.DB) (*goose.Provider, error) {
-	sub, err := fs.Sub(fsys, "migrations")
+// gooseDialects maps every Dialect to the goose dialect of its database.
+var gooseDialects = map[Dialect]goose.Dialect{
+	DialectPostgres: goose.DialectPostgres,
+	DialectSQLite:   goose.DialectSQLite3,
+}
+
+func newProvider(db *sql.DB, d Dialect) (*goose.Provider, error) {
+	gd, ok := gooseDialects[d]
+	if !ok {
+		return nil, fmt.Errorf("unknown migration dialect %q", d)
+	}
+	sub, err := fs.Sub(fsys, "migrations/"+string(d))
 	if err != nil {
 		return nil, fmt.Errorf("sub migrations fs: %w", err)
 	}
-	return goose.NewProvider(goose.DialectPostgres, db, sub)
+	return goose.NewProvider(gd, db, sub)
 }
 
-func Up(ctx context.Context, db *sql.DB) error {
-	p, err := newProvider(db)
+func Up(ctx context.Context, db *sql.DB, d Dialect) error {
+	p, err := newProvider(db, d)
 	if err != nil {
 		return err
 	}
@@ -34,8 +52,8 @@ func Up(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-func Down(ctx context.Context, db *sql.DB) error {
-	p, err := newProvider(db)
+func Down(ctx context.Context, db *sql.DB, d Dialect) error {
+	p, err := newProvider(db, d)
 	if err != nil {
 		return err
 	}
@@ -45,14 +63,15 @@ func Down(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-func Status(ctx context.Context, db *sql.DB) ([]*goose.MigrationStatus, error) {
-	p, err := newProvider(db)
+func Status(ctx context.Context, db *sql.DB, d Dialect) ([]*goose.MigrationStatus, error) {
+	p, err := newProvider(db, d)
 	if err != nil {
 		return nil, err
 	}
 	return p.Status(ctx)
 }
 
+// Verify fails when the PostgreSQL database at dsn is behind the embedded migrations.
 func Verify(ctx context.Context, dsn string) error {
 	cfg, err := pgx.ParseConfig(dsn)
 	if err != nil {
@@ -60,12 +79,16 @@ func Verify(ctx context.Context, dsn string) error {
 	}
 	db := stdlib.OpenDB(*cfg)
 	defer db.Close()
+	return VerifyDB(ctx, db, DialectPostgres)
+}
 
+// VerifyDB fails when db is behind the embedded migrations of dialect d.
+func VerifyDB(ctx context.Context, db *sql.DB, d Dialect) error {
 	if err := db.PingContext(ctx); err != nil {
 		return fmt.Errorf("ping db: %w", err)
 	}
 
-	p, err := newProvider(db)
+	p, err := newProvider(db, d)
 	if err != nil {
 		return err
 	}
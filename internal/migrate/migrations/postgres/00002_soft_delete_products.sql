@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE products ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+-- Tombstones cannot be represented without the column, so drop them for good.
+DELETE FROM products WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS products_deleted_at_idx;
+ALTER TABLE products DROP COLUMN IF EXISTS deleted_at;
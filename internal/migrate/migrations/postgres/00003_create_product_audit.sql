@@ -0,0 +1,17 @@
+-- +goose Up
+-- No foreign key to products: the history must outlive purged products.
+CREATE TABLE product_audit (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    product_id UUID NOT NULL,
+    -- Keep this list in sync with internal/entity/audit.go.
+    action VARCHAR(16) NOT NULL CHECK (action IN ('create', 'update', 'delete', 'restore', 'purge')),
+    actor TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    changes JSONB NOT NULL DEFAULT '[]'
+);
+CREATE INDEX product_audit_product_id_idx ON product_audit (product_id, id);
+CREATE INDEX product_audit_occurred_at_idx ON product_audit (occurred_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS product_audit;
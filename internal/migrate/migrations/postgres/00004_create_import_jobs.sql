@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE import_jobs (
+    id UUID PRIMARY KEY,
+    -- Keep these lists in sync with internal/entity/import.go.
+    format VARCHAR(8) NOT NULL CHECK (format IN ('csv', 'ndjson')),
+    origin VARCHAR(8) NOT NULL CHECK (origin IN ('api', 'cli')),
+    source TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    -- Checkpoint: the last source line already merged or rejected.
+    line BIGINT NOT NULL DEFAULT 0,
+    accepted BIGINT NOT NULL DEFAULT 0,
+    rejected BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    actor TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX import_jobs_claimable_idx ON import_jobs (origin, created_at)
+    WHERE status IN ('pending', 'running');
+
+CREATE TABLE import_rejects (
+    job_id UUID NOT NULL REFERENCES import_jobs (id) ON DELETE CASCADE,
+    line BIGINT NOT NULL,
+    reason TEXT NOT NULL,
+    PRIMARY KEY (job_id, line)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS import_rejects;
+DROP TABLE IF EXISTS import_jobs;
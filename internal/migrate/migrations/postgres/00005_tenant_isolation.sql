@@ -0,0 +1,69 @@
+-- +goose Up
+-- Every row belongs to a tenant. Rows that predate tenancy go to reqctx.DefaultTenant; new rows
+-- default to the tenant the repository scopes each transaction to through app.tenant_id.
+ALTER TABLE products ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE product_audit ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE import_jobs ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE import_rejects ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE products ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+ALTER TABLE product_audit ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+ALTER TABLE import_jobs ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+ALTER TABLE import_rejects ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+
+-- Tenants choose product ids independently, e.g. in imports, so ids are unique per tenant only.
+-- The key also serves keyset pagination within a tenant.
+ALTER TABLE products DROP CONSTRAINT products_pkey;
+ALTER TABLE products ADD CONSTRAINT products_pkey PRIMARY KEY (tenant_id, id);
+
+-- Requests run as storefront_tenant, which only sees the rows of the tenant in app.tenant_id.
+-- The table owner bypasses the policies; maintenance spanning all tenants runs as the owner.
+-- +goose StatementBegin
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'storefront_tenant') THEN
+        CREATE ROLE storefront_tenant NOLOGIN;
+    END IF;
+END
+$$;
+-- +goose StatementEnd
+GRANT storefront_tenant TO CURRENT_USER;
+GRANT SELECT, INSERT, UPDATE, DELETE ON products, product_audit, import_jobs, import_rejects
+    TO storefront_tenant;
+GRANT USAGE ON SEQUENCE product_audit_id_seq TO storefront_tenant;
+
+ALTER TABLE products ENABLE ROW LEVEL SECURITY;
+ALTER TABLE product_audit ENABLE ROW LEVEL SECURITY;
+ALTER TABLE import_jobs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE import_rejects ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON products
+    USING (tenant_id = current_setting('app.tenant_id'));
+CREATE POLICY tenant_isolation ON product_audit
+    USING (tenant_id = current_setting('app.tenant_id'));
+CREATE POLICY tenant_isolation ON import_jobs
+    USING (tenant_id = current_setting('app.tenant_id'));
+CREATE POLICY tenant_isolation ON import_rejects
+    USING (tenant_id = current_setting('app.tenant_id'));
+
+-- +goose Down
+DROP POLICY IF EXISTS tenant_isolation ON import_rejects;
+DROP POLICY IF EXISTS tenant_isolation ON import_jobs;
+DROP POLICY IF EXISTS tenant_isolation ON product_audit;
+DROP POLICY IF EXISTS tenant_isolation ON products;
+ALTER TABLE import_rejects DISABLE ROW LEVEL SECURITY;
+ALTER TABLE import_jobs DISABLE ROW LEVEL SECURITY;
+ALTER TABLE product_audit DISABLE ROW LEVEL SECURITY;
+ALTER TABLE products DISABLE ROW LEVEL SECURITY;
+-- The role is cluster-wide and may serve other databases, so it is left in place.
+REVOKE ALL ON products, product_audit, import_jobs, import_rejects FROM storefront_tenant;
+REVOKE ALL ON SEQUENCE product_audit_id_seq FROM storefront_tenant;
+
+-- Ids of other tenants may collide with the default tenant's, so their data is dropped for good.
+DELETE FROM import_jobs WHERE tenant_id <> 'default';
+DELETE FROM product_audit WHERE tenant_id <> 'default';
+DELETE FROM products WHERE tenant_id <> 'default';
+ALTER TABLE products DROP CONSTRAINT products_pkey;
+ALTER TABLE products ADD CONSTRAINT products_pkey PRIMARY KEY (id);
+ALTER TABLE import_rejects DROP COLUMN tenant_id;
+ALTER TABLE import_jobs DROP COLUMN tenant_id;
+ALTER TABLE product_audit DROP COLUMN tenant_id;
+ALTER TABLE products DROP COLUMN tenant_id;
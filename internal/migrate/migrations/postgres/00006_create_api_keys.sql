@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE api_keys (
+    id UUID PRIMARY KEY,
+    tenant_id TEXT NOT NULL DEFAULT current_setting('app.tenant_id'),
+    name VARCHAR(100) NOT NULL,
+    -- HMAC-SHA256 of the secret under the server's pepper; the secret itself is never stored.
+    hash BYTEA NOT NULL,
+    -- Keep this list in sync with internal/entity/apikey.go.
+    scopes TEXT[] NOT NULL CHECK (scopes <@ ARRAY['products:read', 'products:write']),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT api_keys_hash_key UNIQUE (hash)
+);
+CREATE INDEX api_keys_tenant_id_idx ON api_keys (tenant_id, created_at);
+
+-- Keys are managed per tenant like the rest of the data. Authentication looks keys up by hash
+-- before the tenant is known, so it runs as the table owner.
+GRANT SELECT, INSERT, UPDATE, DELETE ON api_keys TO storefront_tenant;
+ALTER TABLE api_keys ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON api_keys
+    USING (tenant_id = current_setting('app.tenant_id'));
+
+-- +goose Down
+DROP TABLE IF EXISTS api_keys;
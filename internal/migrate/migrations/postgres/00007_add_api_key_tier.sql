@@ -0,0 +1,6 @@
+-- +goose Up
+-- The rate limit tier of the key's requests; tiers are defined by RATE_LIMIT_TIERS.
+ALTER TABLE api_keys ADD COLUMN tier VARCHAR(32) NOT NULL DEFAULT 'standard';
+
+-- +goose Down
+ALTER TABLE api_keys DROP COLUMN tier;
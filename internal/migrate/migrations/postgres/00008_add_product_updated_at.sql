@@ -0,0 +1,8 @@
+-- +goose Up
+-- When the product was last created, changed or restored; cache warming preloads the latest.
+ALTER TABLE products ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX products_updated_at_idx ON products (updated_at DESC) WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS products_updated_at_idx;
+ALTER TABLE products DROP COLUMN IF EXISTS updated_at;
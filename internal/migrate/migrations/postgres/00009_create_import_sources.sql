@@ -0,0 +1,17 @@
+-- +goose Up
+-- Uploaded import sources, in parts of a bounded size, so that any instance can run or resume
+-- the job. The parts of a job are deleted once it completes.
+CREATE TABLE import_sources (
+    tenant_id TEXT NOT NULL DEFAULT current_setting('app.tenant_id'),
+    job_id UUID NOT NULL REFERENCES import_jobs (id) ON DELETE CASCADE,
+    part INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (job_id, part)
+);
+GRANT SELECT, INSERT, DELETE ON import_sources TO storefront_tenant;
+ALTER TABLE import_sources ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON import_sources
+    USING (tenant_id = current_setting('app.tenant_id'));
+
+-- +goose Down
+DROP TABLE IF EXISTS import_sources;
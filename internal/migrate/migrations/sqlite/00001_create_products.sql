@@ -0,0 +1,18 @@
+-- +goose Up
+-- Ids hold the 16 bytes of the UUID, which sort bytewise like PostgreSQL sorts UUIDs,
+-- so keyset pagination returns the same order on both databases.
+-- Constraint names mirror the PostgreSQL ones; the repository maps them to product fields.
+CREATE TABLE products (
+    id BLOB NOT NULL PRIMARY KEY CHECK (length(id) = 16),
+    name TEXT NOT NULL,
+    price_minor INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    CONSTRAINT products_name_length CHECK (length(name) <= 100),
+    CONSTRAINT products_currency_length CHECK (length(currency) <= 3),
+    CONSTRAINT products_price_minor_check CHECK (price_minor > 0),
+    -- Keep this list in sync with internal/entity/money.go.
+    CONSTRAINT products_currency_check CHECK (currency IN ('PLN', 'EUR', 'USD', 'GBP', 'CHF'))
+) STRICT, WITHOUT ROWID;
+
+-- +goose Down
+DROP TABLE IF EXISTS products;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- Timestamps are Unix microseconds, the precision of PostgreSQL timestamps.
+ALTER TABLE products ADD COLUMN deleted_at INTEGER;
+CREATE INDEX products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+-- Tombstones cannot be represented without the column, so drop them for good.
+DELETE FROM products WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS products_deleted_at_idx;
+ALTER TABLE products DROP COLUMN deleted_at;
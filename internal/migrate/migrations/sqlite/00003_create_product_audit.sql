@@ -0,0 +1,18 @@
+-- +goose Up
+-- No foreign key to products: the history must outlive purged products.
+-- AUTOINCREMENT keeps ids from being reused, like a PostgreSQL identity.
+CREATE TABLE product_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_id BLOB NOT NULL,
+    -- Keep this list in sync with internal/entity/audit.go.
+    action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete', 'restore', 'purge')),
+    actor TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    occurred_at INTEGER NOT NULL,
+    changes TEXT NOT NULL DEFAULT '[]'
+) STRICT;
+CREATE INDEX product_audit_product_id_idx ON product_audit (product_id, id);
+CREATE INDEX product_audit_occurred_at_idx ON product_audit (occurred_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS product_audit;
@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE import_jobs (
+    id BLOB NOT NULL PRIMARY KEY,
+    -- Keep these lists in sync with internal/entity/import.go.
+    format TEXT NOT NULL CHECK (format IN ('csv', 'ndjson')),
+    origin TEXT NOT NULL CHECK (origin IN ('api', 'cli')),
+    source TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    -- Checkpoint: the last source line already merged or rejected.
+    line INTEGER NOT NULL DEFAULT 0,
+    accepted INTEGER NOT NULL DEFAULT 0,
+    rejected INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    actor TEXT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+) STRICT, WITHOUT ROWID;
+CREATE INDEX import_jobs_claimable_idx ON import_jobs (origin, created_at)
+    WHERE status IN ('pending', 'running');
+
+CREATE TABLE import_rejects (
+    job_id BLOB NOT NULL REFERENCES import_jobs (id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    PRIMARY KEY (job_id, line)
+) STRICT, WITHOUT ROWID;
+
+-- +goose Down
+DROP TABLE IF EXISTS import_rejects;
+DROP TABLE IF EXISTS import_jobs;
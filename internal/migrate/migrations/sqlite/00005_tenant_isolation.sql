@@ -0,0 +1,59 @@
+-- +goose Up
+-- Every row belongs to a tenant; rows that predate tenancy go to reqctx.DefaultTenant.
+-- SQLite has no row-level security, so the repository filters every statement by tenant.
+-- Product ids are unique per tenant only, which takes rebuilding the table to change its key.
+CREATE TABLE products_tenant (
+    tenant_id TEXT NOT NULL,
+    id BLOB NOT NULL CHECK (length(id) = 16),
+    name TEXT NOT NULL,
+    price_minor INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    deleted_at INTEGER,
+    PRIMARY KEY (tenant_id, id),
+    CONSTRAINT products_name_length CHECK (length(name) <= 100),
+    CONSTRAINT products_currency_length CHECK (length(currency) <= 3),
+    CONSTRAINT products_price_minor_check CHECK (price_minor > 0),
+    -- Keep this list in sync with internal/entity/money.go.
+    CONSTRAINT products_currency_check CHECK (currency IN ('PLN', 'EUR', 'USD', 'GBP', 'CHF'))
+) STRICT, WITHOUT ROWID;
+INSERT INTO products_tenant (tenant_id, id, name, price_minor, currency, deleted_at)
+SELECT 'default', id, name, price_minor, currency, deleted_at
+FROM products;
+DROP TABLE products;
+ALTER TABLE products_tenant RENAME TO products;
+CREATE INDEX products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE product_audit ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+DROP INDEX product_audit_product_id_idx;
+CREATE INDEX product_audit_product_id_idx ON product_audit (tenant_id, product_id, id);
+ALTER TABLE import_jobs ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE import_rejects ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+
+-- +goose Down
+-- Ids of other tenants may collide with the default tenant's, so their data is dropped for good.
+DELETE FROM import_jobs WHERE tenant_id <> 'default';
+DELETE FROM product_audit WHERE tenant_id <> 'default';
+ALTER TABLE import_rejects DROP COLUMN tenant_id;
+ALTER TABLE import_jobs DROP COLUMN tenant_id;
+DROP INDEX product_audit_product_id_idx;
+ALTER TABLE product_audit DROP COLUMN tenant_id;
+CREATE INDEX product_audit_product_id_idx ON product_audit (product_id, id);
+
+CREATE TABLE products_single (
+    id BLOB NOT NULL PRIMARY KEY CHECK (length(id) = 16),
+    name TEXT NOT NULL,
+    price_minor INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    deleted_at INTEGER,
+    CONSTRAINT products_name_length CHECK (length(name) <= 100),
+    CONSTRAINT products_currency_length CHECK (length(currency) <= 3),
+    CONSTRAINT products_price_minor_check CHECK (price_minor > 0),
+    CONSTRAINT products_currency_check CHECK (currency IN ('PLN', 'EUR', 'USD', 'GBP', 'CHF'))
+) STRICT, WITHOUT ROWID;
+INSERT INTO products_single (id, name, price_minor, currency, deleted_at)
+SELECT id, name, price_minor, currency, deleted_at
+FROM products
+WHERE tenant_id = 'default';
+DROP TABLE products;
+ALTER TABLE products_single RENAME TO products;
+CREATE INDEX products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
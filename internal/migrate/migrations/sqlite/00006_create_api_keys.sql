@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE api_keys (
+    id BLOB NOT NULL PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    name TEXT NOT NULL CHECK (length(name) <= 100),
+    -- HMAC-SHA256 of the secret under the server's pepper; the secret itself is never stored.
+    hash BLOB NOT NULL,
+    -- Space-separated; keep the scopes in sync with internal/entity/apikey.go.
+    scopes TEXT NOT NULL,
+    last_used_at INTEGER,
+    revoked_at INTEGER,
+    created_at INTEGER NOT NULL,
+    CONSTRAINT api_keys_hash_key UNIQUE (hash)
+) STRICT, WITHOUT ROWID;
+CREATE INDEX api_keys_tenant_id_idx ON api_keys (tenant_id, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- When the product was last created, changed or restored; cache warming preloads the latest.
+-- Existing products count as changed when the column was added.
+ALTER TABLE products ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+UPDATE products SET updated_at = CAST(strftime('%s', 'now') AS INTEGER) * 1000000;
+CREATE INDEX products_updated_at_idx ON products (updated_at DESC) WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS products_updated_at_idx;
+ALTER TABLE products DROP COLUMN updated_at;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- Uploaded import sources, in parts of a bounded size. The parts of a job are deleted once it
+-- completes.
+CREATE TABLE import_sources (
+    tenant_id TEXT NOT NULL,
+    job_id BLOB NOT NULL REFERENCES import_jobs (id) ON DELETE CASCADE,
+    part INTEGER NOT NULL,
+    data BLOB NOT NULL,
+    PRIMARY KEY (job_id, part)
+) STRICT;
+
+-- +goose Down
+DROP TABLE IF EXISTS import_sources;
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+)
+
+// retryPrimaryAfter is how long Fallback keeps to its fallback after the primary failed,
+// so an outage costs one timeout per period rather than one per request.
+const retryPrimaryAfter = 5 * time.Second
+
+type (
+	limiter interface {
+		Allow(context.Context, string, config.Limit, int) (Result, error)
+	}
+	// Fallback takes tokens from a primary limiter, normally Redis, and from a local one while
+	// the primary fails. Limits are then enforced per process rather than across instances.
+	Fallback struct {
+		logger   *slog.Logger
+		primary  limiter
+		fallback *Local
+		timeout  time.Duration
+		// downUntil is when to try the primary again, in Unix nanoseconds; zero while it works.
+		downUntil atomic.Int64
+	}
+)
+
+// NewFallback returns a limiter that gives primary timeout to answer before using fallback.
+func NewFallback(l *slog.Logger, primary limiter, fallback *Local, timeout time.Duration) *Fallback {
+	return new(Fallback{logger: l, primary: primary, fallback: fallback, timeout: timeout})
+}
+
+// Allow takes cost tokens from the bucket of key. It fails only when ctx is done.
+func (f *Fallback) Allow(ctx context.Context, key string, l config.Limit, cost int) (Result, error) {
+	if until := f.downUntil.Load(); until == 0 || time.Now().UnixNano() >= until {
+		res, err := f.allowPrimary(ctx, key, l, cost)
+		if err == nil {
+			if f.downUntil.Swap(0) != 0 {
+				f.logger.Info("rate limiter recovered")
+			}
+			return res, nil
+		}
+		// A request that went away says nothing about the primary.
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
+		if f.downUntil.Swap(time.Now().Add(retryPrimaryAfter).UnixNano()) == 0 {
+			f.logger.Warn("rate limiter failed, limiting per process", slog.Any("error", err))
+		}
+	}
+	return f.fallback.Allow(ctx, key, l, cost)
+}
+
+func (f *Fallback) allowPrimary(ctx context.Context, key string, l config.Limit, cost int) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	return f.primary.Allow(ctx, key, l, cost)
+}
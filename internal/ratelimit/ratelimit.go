@@ -0,0 +1,101 @@
+// Package ratelimit implements token buckets shared through Redis, with an in-process
+// fallback for when Redis cannot be reached.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+)
+
+// sweepEvery is how often the local limiter drops buckets that have refilled.
+const sweepEvery = time.Minute
+
+type (
+	// Result is the outcome of taking tokens from a bucket.
+	Result struct {
+		Allowed bool
+		// Limit is the size of the bucket and Remaining the whole tokens left in it.
+		Limit     int
+		Remaining int
+		// RetryAfter is how long until the tokens asked for are available; zero when Allowed.
+		RetryAfter time.Duration
+		// Reset is how long until the bucket is full again.
+		Reset time.Duration
+	}
+	// Local keeps buckets in process, so each instance enforces the limits on its own.
+	Local struct {
+		mu        sync.Mutex
+		buckets   map[string]bucket
+		lastSweep time.Time
+	}
+	bucket struct {
+		tokens float64
+		at     time.Time
+		// full is when the bucket has refilled and can be forgotten.
+		full time.Time
+	}
+)
+
+// NewLocal returns an in-process limiter.
+func NewLocal() *Local {
+	return new(Local{buckets: make(map[string]bucket), lastSweep: time.Now()})
+}
+
+// Allow takes cost tokens from the bucket of key, which holds up to l.Burst tokens and
+// refills at l.Rate per second. Buckets start full. It never fails.
+func (lc *Local) Allow(_ context.Context, key string, l config.Limit, cost int) (Result, error) {
+	now := time.Now()
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.sweep(now)
+	tokens := float64(l.Burst)
+	if b, ok := lc.buckets[key]; ok {
+		tokens = refill(b.tokens, now.Sub(b.at), l)
+	}
+	res, tokens := take(tokens, l, cost)
+	lc.buckets[key] = bucket{tokens: tokens, at: now, full: now.Add(res.Reset)}
+	return res, nil
+}
+
+// sweep drops full buckets at most once per sweepEvery; a missing bucket reads as full.
+// The caller holds mu.
+func (lc *Local) sweep(now time.Time) {
+	if now.Sub(lc.lastSweep) < sweepEvery {
+		return
+	}
+	for key, b := range lc.buckets {
+		if !now.Before(b.full) {
+			delete(lc.buckets, key)
+		}
+	}
+	lc.lastSweep = now
+}
+
+func refill(tokens float64, elapsed time.Duration, l config.Limit) float64 {
+	return min(float64(l.Burst), tokens+max(elapsed.Seconds(), 0)*l.Rate)
+}
+
+// take takes cost tokens if there are that many and returns the result with the tokens
+// left. It is the algorithm of the Redis script, which must be kept in sync.
+func take(tokens float64, l config.Limit, cost int) (Result, float64) {
+	res := Result{Limit: l.Burst}
+	if need := float64(cost); tokens >= need {
+		tokens -= need
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((need - tokens) / l.Rate)
+	}
+	res.Remaining = int(tokens)
+	res.Reset = seconds((float64(l.Burst) - tokens) / l.Rate)
+	return res, tokens
+}
+
+// seconds converts s to a duration rounded up to the millisecond, the precision of Redis.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s*1000)) * time.Millisecond
+}
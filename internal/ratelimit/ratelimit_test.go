@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+)
+
+// limiterTests are the cases every limiter passes. Each takes from buckets under key, which
+// no other case uses.
+var limiterTests = []struct {
+	name string
+	run  func(t *testing.T, lim limiter, key string)
+}{
+	{name: "burst", run: func(t *testing.T, lim limiter, key string) {
+		l := config.Limit{Rate: 1, Burst: 3}
+		for i := range 3 {
+			res := allow(t, lim, key, l, 1)
+			if !res.Allowed || res.Remaining != 2-i || res.Limit != 3 {
+				t.Fatalf("request %d: got %+v, want allowed with %d left", i, res, 2-i)
+			}
+		}
+		res := allow(t, lim, key, l, 1)
+		if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+			t.Errorf("got %+v, want rejected with a retry within a second", res)
+		}
+		if res.Reset <= 2*time.Second || res.Reset > 3*time.Second {
+			t.Errorf("got reset in %v, want about 3s", res.Reset)
+		}
+
+		if res := allow(t, lim, key+":other", l, 1); !res.Allowed {
+			t.Errorf("got %+v, want buckets kept per key", res)
+		}
+	}},
+	{name: "cost", run: func(t *testing.T, lim limiter, key string) {
+		l := config.Limit{Rate: 10, Burst: 10}
+		if res := allow(t, lim, key, l, 8); !res.Allowed || res.Remaining != 2 {
+			t.Fatalf("got %+v, want allowed with 2 left", res)
+		}
+		res := allow(t, lim, key, l, 8)
+		if res.Allowed || res.Remaining != 2 {
+			t.Fatalf("got %+v, want rejected with 2 left", res)
+		}
+		// 6 more tokens at 10 per second, and 8 to fill the bucket.
+		if res.RetryAfter < 550*time.Millisecond || res.RetryAfter > 600*time.Millisecond {
+			t.Errorf("got retry after %v, want about 600ms", res.RetryAfter)
+		}
+		if res.Reset < 750*time.Millisecond || res.Reset > 800*time.Millisecond {
+			t.Errorf("got reset in %v, want about 800ms", res.Reset)
+		}
+		if res.RetryAfter%time.Millisecond != 0 || res.Reset%time.Millisecond != 0 {
+			t.Errorf("got retry after %v and reset in %v, want whole milliseconds", res.RetryAfter, res.Reset)
+		}
+	}},
+	{name: "cost above burst", run: func(t *testing.T, lim limiter, key string) {
+		l := config.Limit{Rate: 1, Burst: 3}
+		if res := allow(t, lim, key, l, 4); res.Allowed || res.Remaining != 3 || res.Reset != 0 {
+			t.Errorf("got %+v, want rejected with the bucket left full", res)
+		}
+	}},
+	{name: "refill", run: func(t *testing.T, lim limiter, key string) {
+		l := config.Limit{Rate: 100, Burst: 1}
+		if res := allow(t, lim, key, l, 1); !res.Allowed {
+			t.Fatalf("got %+v, want allowed", res)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if res := allow(t, lim, key, l, 1); !res.Allowed {
+			t.Errorf("got %+v, want the bucket refilled", res)
+		}
+	}},
+}
+
+// runLimiterTests runs limiterTests against lim.
+func runLimiterTests(t *testing.T, lim limiter) {
+	t.Helper()
+	for _, tt := range limiterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, lim, tt.name)
+		})
+	}
+}
+
+func allow(t *testing.T, lim limiter, key string, l config.Limit, cost int) Result {
+	t.Helper()
+	res, err := lim.Allow(t.Context(), key, l, cost)
+	if err != nil {
+		t.Fatalf("failed to take %d tokens: %v", cost, err)
+	}
+	return res
+}
+
+func TestLocal_Allow(t *testing.T) {
+	runLimiterTests(t, NewLocal())
+}
+
+type limiterFunc func(context.Context, string, config.Limit, int) (Result, error)
+
+func (f limiterFunc) Allow(ctx context.Context, key string, l config.Limit, cost int) (Result, error) {
+	return f(ctx, key, l, cost)
+}
+
+func TestFallback(t *testing.T) {
+	var calls int
+	fail := true
+	primary := limiterFunc(func(context.Context, string, config.Limit, int) (Result, error) {
+		calls++
+		if fail {
+			return Result{}, errors.New("redis down")
+		}
+		return Result{Allowed: true, Limit: 100, Remaining: 99}, nil
+	})
+	f := NewFallback(slog.New(slog.DiscardHandler), primary, NewLocal(), time.Second)
+	l := config.Limit{Rate: 1, Burst: 1}
+
+	if res, err := f.Allow(t.Context(), "k", l, 1); err != nil || !res.Allowed || res.Limit != 1 {
+		t.Fatalf("got %+v, %v, want the local bucket to allow", res, err)
+	}
+	if res, _ := f.Allow(t.Context(), "k", l, 1); res.Allowed {
+		t.Errorf("got %+v, want the local bucket to limit", res)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls to the failed primary, want 1 until the retry period passes", calls)
+	}
+
+	fail = false
+	f.downUntil.Store(time.Now().UnixNano())
+	if res, _ := f.Allow(t.Context(), "k", l, 1); !res.Allowed || res.Limit != 100 {
+		t.Errorf("got %+v, want the recovered primary used", res)
+	}
+}
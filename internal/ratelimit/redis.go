@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/redis/rueidis"
+)
+
+// keyPrefix namespaces the buckets among the other keys of the Redis database.
+const keyPrefix = "ratelimit:"
+
+// tokenBucket updates a bucket atomically, on the clock of the Redis server so that instances
+// with drifting clocks agree. It keeps the fractional tokens and the time of the last update in
+// a hash that expires once the bucket has refilled, and returns the allowed flag, the whole
+// tokens left, and the milliseconds until the cost is available and until the bucket is full.
+// Keep it in sync with take.
+var tokenBucket = rueidis.NewLuaScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1])
+local at = tonumber(state[2])
+if tokens == nil or at == nil then
+  tokens = burst
+else
+  tokens = math.min(burst, tokens + math.max(0, now - at) * rate / 1000)
+end
+
+local allowed, retry = 0, 0
+if tokens >= cost then
+  tokens = tokens - cost
+  allowed = 1
+else
+  retry = math.ceil((cost - tokens) * 1000 / rate)
+end
+local reset = math.ceil((burst - tokens) * 1000 / rate)
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', now)
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+// Redis keeps buckets in Redis, so every instance draws from the same ones.
+type Redis struct {
+	client rueidis.Client
+}
+
+// NewRedis returns a limiter over client.
+func NewRedis(client rueidis.Client) *Redis {
+	return new(Redis{client: client})
+}
+
+// Allow takes cost tokens from the bucket of key, like Local.Allow.
+func (rd *Redis) Allow(ctx context.Context, key string, l config.Limit, cost int) (Result, error) {
+	args := []string{
+		strconv.FormatFloat(l.Rate, 'f', -1, 64),
+		strconv.Itoa(l.Burst),
+		strconv.Itoa(cost),
+	}
+	out, err := tokenBucket.Exec(ctx, rd.client, []string{keyPrefix + key}, args).AsIntSlice()
+	if err != nil {
+		return Result{}, fmt.Errorf("rate limit %q: %w", key, err)
+	}
+	if len(out) != 4 {
+		return Result{}, fmt.Errorf("rate limit %q: unexpected reply %v", key, out)
+	}
+	return Result{
+		Allowed:    out[0] == 1,
+		Limit:      l.Burst,
+		Remaining:  int(out[1]),
+		RetryAfter: time.Duration(out[2]) * time.Millisecond,
+		Reset:      time.Duration(out[3]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,71 @@
+//go:build integration
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/redis/rueidis"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// startRedis starts a Redis container and returns a client of it, closed when t ends.
+func startRedis(t *testing.T) rueidis.Client {
+	t.Helper()
+	ctx := t.Context()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:8",
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections").WithStartupTimeout(10 * time.Second),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to start redis container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Errorf("failed to terminate redis container: %v", err)
+		}
+	})
+
+	endpoint, err := container.Endpoint(ctx, "")
+	if err != nil {
+		t.Fatalf("failed to get endpoint: %v", err)
+	}
+	client, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{endpoint}})
+	if err != nil {
+		t.Fatalf("failed to create redis client: %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestRedis_Allow(t *testing.T) {
+	runLimiterTests(t, NewRedis(startRedis(t)))
+}
+
+func TestRedis_Expire(t *testing.T) {
+	client := startRedis(t)
+	rd := NewRedis(client)
+	l := config.Limit{Rate: 10, Burst: 10}
+
+	res := allow(t, rd, "k", l, 4)
+	if !res.Allowed || res.Reset != 400*time.Millisecond {
+		t.Fatalf("got %+v, want allowed with the bucket full in 400ms", res)
+	}
+	// The bucket is kept a second past its refill, then reads as full.
+	ttl, err := client.Do(t.Context(), client.B().Pttl().Key(keyPrefix+"k").Build()).AsInt64()
+	if err != nil {
+		t.Fatalf("failed to read bucket TTL: %v", err)
+	}
+	if ttl <= 1000 || ttl > 1400 {
+		t.Errorf("got TTL %dms, want about 1400ms", ttl)
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+// CreateAPIKey stores a new key of the tenant in ctx and fills in its tenant and creation time.
+func (pg *Repository) CreateAPIKey(ctx context.Context, k entity.APIKey) (entity.APIKey, error) {
+	err := pg.inTx(ctx, func(tx dbTx) error {
+		row := tx.queryRow(ctx, queryInsertAPIKey, k.ID, k.Name, k.Hash, scopeStrings(k.Scopes), k.Tier)
+		return row.Scan(&k.Tenant, &k.CreatedAt)
+	})
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+	return k, nil
+}
+
+// FindAPIKey returns the active key with hash, of whichever tenant owns it.
+func (pg *Repository) FindAPIKey(ctx context.Context, hash []byte) (entity.APIKey, error) {
+	var k entity.APIKey
+	err := pg.inTxWith(ctx, txAllTenants, func(tx dbTx) (err error) {
+		k, err = scanAPIKey(tx.queryRow(ctx, queryFindAPIKey, hash))
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.ErrNotFound
+		}
+		return err
+	})
+	return k, err
+}
+
+// ListAPIKeys returns every key of the tenant in ctx, revoked ones included, oldest first.
+func (pg *Repository) ListAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	var keys []entity.APIKey
+	err := pg.conn(ctx, func(q querier) error {
+		rows, err := q.query(ctx, queryListAPIKeys)
+		if err != nil {
+			return err
+		}
+		defer rows.close()
+
+		for rows.Next() {
+			k, err := scanAPIKey(rows)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k)
+		}
+		return rows.Err()
+	})
+	return keys, err
+}
+
+// RevokeAPIKey revokes an active key of the tenant in ctx.
+func (pg *Repository) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	return pg.inTx(ctx, func(tx dbTx) error {
+		n, err := tx.exec(ctx, queryRevokeAPIKey, id)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return entity.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// TouchAPIKey records that the key authenticated a request at. It runs on behalf of the
+// authenticated request, whose tenant is only known from the key, so it spans tenants.
+func (pg *Repository) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return pg.inTxWith(ctx, txAllTenants, func(tx dbTx) error {
+		_, err := tx.exec(ctx, queryTouchAPIKey, id, at)
+		return err
+	})
+}
+
+func scanAPIKey(s scanner) (entity.APIKey, error) {
+	var (
+		k                   entity.APIKey
+		scopes              string
+		lastUsed, revokedAt sql.NullTime
+	)
+	err := s.Scan(&k.ID, &k.Tenant, &k.Name, &scopes, &k.Tier, &lastUsed, &revokedAt, &k.CreatedAt)
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+	k.Scopes = parseScopes(scopes)
+	k.LastUsedAt = lastUsed.Time
+	k.RevokedAt = revokedAt.Time
+	return k, nil
+}
+
+func scopeStrings(scopes []entity.Scope) []string {
+	out := make([]string, len(scopes))
+	for i, s := range scopes {
+		out[i] = string(s)
+	}
+	return out
+}
+
+// parseScopes splits space-separated scopes.
+func parseScopes(s string) []entity.Scope {
+	fields := strings.Fields(s)
+	out := make([]entity.Scope, len(fields))
+	for i, f := range fields {
+		out[i] = entity.Scope(f)
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+type auditChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// History returns one page of audit entries for the product, newest first.
+// A zero cursor starts from the most recent entry.
+func (pg *Repository) History(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.AuditPage, error) {
+	var entries []entity.AuditEntry
+	err := pg.conn(ctx, func(q querier) (err error) {
+		entries, err = history(ctx, q, id, cursor, limit+1)
+		return err
+	})
+	if err != nil {
+		return entity.AuditPage{}, err
+	}
+	if len(entries) <= limit {
+		return entity.AuditPage{Items: entries}, nil
+	}
+	return entity.AuditPage{Items: entries[:limit], HasMore: true}, nil
+}
+
+// history reads up to pageLimit audit entries of the product before cursor.
+func history(ctx context.Context, q querier, id uuid.UUID, cursor int64, pageLimit int,
+) ([]entity.AuditEntry, error) {
+	var (
+		rows rows
+		err  error
+	)
+	if cursor > 0 {
+		rows, err = q.query(ctx, queryHistoryBeforeCursor, id, cursor, pageLimit)
+	} else {
+		rows, err = q.query(ctx, queryHistory, id, pageLimit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.close()
+
+	entries := make([]entity.AuditEntry, 0, pageLimit)
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// AuditLog streams every audit entry recorded at or after since, oldest first,
+// without buffering the result set.
+func (pg *Repository) AuditLog(ctx context.Context, since time.Time) iter.Seq2[entity.AuditEntry, error] {
+	return func(yield func(entity.AuditEntry, error) bool) {
+		err := pg.conn(ctx, func(q querier) error {
+			rows, err := q.query(ctx, queryAuditLog, since)
+			if err != nil {
+				return err
+			}
+			defer rows.close()
+
+			for rows.Next() {
+				e, err := scanAudit(rows)
+				if err != nil {
+					return err
+				}
+				if !yield(e, nil) {
+					return nil
+				}
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			yield(entity.AuditEntry{}, err)
+		}
+	}
+}
+
+// insertAudit records a mutation of the product through q, attributing it to the
+// actor and request carried by ctx.
+func insertAudit(ctx context.Context, q querier, id uuid.UUID, action entity.AuditAction,
+	changes []entity.FieldChange,
+) error {
+	s, err := auditStatement(ctx, id, action, changes)
+	if err != nil {
+		return err
+	}
+	_, err = q.exec(ctx, s.query, s.args...)
+	return err
+}
+
+// auditStatement builds the audit insert so it can be batched with the mutation it records.
+func auditStatement(ctx context.Context, id uuid.UUID, action entity.AuditAction,
+	changes []entity.FieldChange,
+) (statement, error) {
+	data, err := json.Marshal(toAuditChanges(changes))
+	if err != nil {
+		return statement{}, fmt.Errorf("marshal audit changes: %w", err)
+	}
+	return stmt(queryInsertAudit, id, string(action), reqctx.Actor(ctx), reqctx.RequestID(ctx), data), nil
+}
+
+func toAuditChanges(changes []entity.FieldChange) []auditChange {
+	out := make([]auditChange, len(changes))
+	for i, c := range changes {
+		out[i] = auditChange{Field: c.Field, Before: c.Before, After: c.After}
+	}
+	return out
+}
+
+func scanAudit(s scanner) (entity.AuditEntry, error) {
+	var (
+		e       entity.AuditEntry
+		action  string
+		changes []byte
+	)
+	if err := s.Scan(
+		&e.ID, &e.ProductID, &action, &e.Actor, &e.RequestID, &e.OccurredAt, &changes,
+	); err != nil {
+		return entity.AuditEntry{}, err
+	}
+	e.Action = entity.AuditAction(action)
+
+	// UseNumber keeps int64 amounts exact instead of widening them to float64.
+	dec := json.NewDecoder(bytes.NewReader(changes))
+	dec.UseNumber()
+	var stored []auditChange
+	if err := dec.Decode(&stored); err != nil {
+		return entity.AuditEntry{}, fmt.Errorf("unmarshal audit changes of entry %d: %w", e.ID, err)
+	}
+	e.Changes = make([]entity.FieldChange, len(stored))
+	for i, c := range stored {
+		e.Changes[i] = entity.FieldChange{Field: c.Field, Before: c.Before, After: c.After}
+	}
+	return e, nil
+}
@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Supported values of config.Postgres.Driver.
+const (
+	DriverPGXPool = "pgxpool"
+	DriverStdlib  = "stdlib"
+)
+
+type (
+	// querier is the statement surface shared by pools and transactions of both drivers,
+	// so query logic is written once.
+	querier interface {
+		exec(ctx context.Context, query string, args ...any) (int64, error)
+		queryRow(ctx context.Context, query string, args ...any) scanner
+		query(ctx context.Context, query string, args ...any) (rows, error)
+	}
+	rows interface {
+		scanner
+		Next() bool
+		Err() error
+		close()
+	}
+	dbTx interface {
+		querier
+		// batch executes stmts in order, in a single round trip where the driver allows it.
+		batch(ctx context.Context, stmts []statement) error
+		// copyFrom bulk-loads rows into table, through COPY where the driver allows it.
+		copyFrom(ctx context.Context, table string, columns []string, rows [][]any) (int64, error)
+		commit(ctx context.Context) error
+		rollback(ctx context.Context) error
+	}
+	driver interface {
+		querier
+		begin(ctx context.Context, opts txOptions) (dbTx, error)
+		ping(ctx context.Context) error
+		close() error
+		stats() PoolStats
+	}
+	statement struct {
+		query string
+		args  []any
+	}
+	// txOptions selects the isolation level and access mode of a transaction.
+	// The zero value is the server default, READ COMMITTED read-write, scoped to the tenant
+	// in the context.
+	txOptions struct {
+		isolation sql.IsolationLevel
+		readOnly  bool
+		// allTenants leaves the transaction unscoped, running as the connecting role, which
+		// owns the tables and so bypasses their row-level security. Only maintenance that
+		// spans tenants uses it.
+		allTenants bool
+	}
+
+	// PoolStats is a driver-neutral snapshot of connection pool usage.
+	PoolStats struct {
+		Driver       string        `json:"driver"`
+		MaxConns     int           `json:"maxConns"`
+		TotalConns   int           `json:"totalConns"`
+		IdleConns    int           `json:"idleConns"`
+		InUseConns   int           `json:"inUseConns"`
+		WaitCount    int64         `json:"waitCount"`
+		WaitDuration time.Duration `json:"waitDuration"`
+	}
+)
+
+func stmt(query string, args ...any) statement {
+	return statement{query: query, args: args}
+}
+
+// database/sql driver
+
+type (
+	sqlConn interface {
+		ExecContext(context.Context, string, ...any) (sql.Result, error)
+		QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	}
+	sqlQuerier struct{ conn sqlConn }
+	sqlRows    struct{ *sql.Rows }
+	sqlDriver  struct {
+		sqlQuerier
+		db *sql.DB
+	}
+	sqlTx struct {
+		sqlQuerier
+		tx *sql.Tx
+	}
+)
+
+func newSQLDriver(db *sql.DB) *sqlDriver {
+	return &sqlDriver{sqlQuerier: sqlQuerier{conn: db}, db: db}
+}
+
+func (q sqlQuerier) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	res, err := q.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (q sqlQuerier) queryRow(ctx context.Context, query string, args ...any) scanner {
+	return q.conn.QueryRowContext(ctx, query, args...)
+}
+
+func (q sqlQuerier) query(ctx context.Context, query string, args ...any) (rows, error) {
+	r, err := q.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sqlRows{r}, nil
+}
+
+func (r sqlRows) close() { _ = r.Rows.Close() }
+
+func (d *sqlDriver) begin(ctx context.Context, opts txOptions) (dbTx, error) {
+	t, err := d.db.BeginTx(ctx, &sql.TxOptions{Isolation: opts.isolation, ReadOnly: opts.readOnly})
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{sqlQuerier: sqlQuerier{conn: t}, tx: t}, nil
+}
+
+func (d *sqlDriver) ping(ctx context.Context) error { return d.db.PingContext(ctx) }
+
+func (d *sqlDriver) close() error { return d.db.Close() }
+
+func (d *sqlDriver) stats() PoolStats {
+	s := d.db.Stats()
+	return PoolStats{
+		Driver:       DriverStdlib,
+		MaxConns:     s.MaxOpenConnections,
+		TotalConns:   s.OpenConnections,
+		IdleConns:    s.Idle,
+		InUseConns:   s.InUse,
+		WaitCount:    s.WaitCount,
+		WaitDuration: s.WaitDuration,
+	}
+}
+
+// batch has no wire-level equivalent in database/sql, so statements run one by one.
+func (t *sqlTx) batch(ctx context.Context, stmts []statement) error {
+	for _, s := range stmts {
+		if _, err := t.tx.ExecContext(ctx, s.query, s.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFrom has no database/sql equivalent either, so rows are inserted one by one.
+func (t *sqlTx) copyFrom(ctx context.Context, table string, columns []string, rows [][]any) (int64, error) {
+	cols := make([]string, len(columns))
+	params := make([]string, len(columns))
+	for i, c := range columns {
+		cols[i] = pgx.Identifier{c}.Sanitize()
+		params[i] = "$" + strconv.Itoa(i+1)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);",
+		pgx.Identifier{table}.Sanitize(), strings.Join(cols, ", "), strings.Join(params, ", "))
+	for _, row := range rows {
+		if _, err := t.tx.ExecContext(ctx, query, row...); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(rows)), nil
+}
+
+func (t *sqlTx) commit(context.Context) error { return t.tx.Commit() }
+
+func (t *sqlTx) rollback(context.Context) error { return t.tx.Rollback() }
+
+// native pgx driver
+
+type (
+	pgxConn interface {
+		Exec(context.Context, string, ...any) (pgconn.CommandTag, error)
+		Query(context.Context, string, ...any) (pgx.Rows, error)
+		QueryRow(context.Context, string, ...any) pgx.Row
+	}
+	pgxQuerier struct{ conn pgxConn }
+	pgxRows    struct{ pgx.Rows }
+	pgxDriver  struct {
+		pgxQuerier
+		pool *pgxpool.Pool
+	}
+	pgxTx struct {
+		pgxQuerier
+		tx pgx.Tx
+	}
+)
+
+func newPGXDriver(pool *pgxpool.Pool) *pgxDriver {
+	return &pgxDriver{pgxQuerier: pgxQuerier{conn: pool}, pool: pool}
+}
+
+func (q pgxQuerier) exec(ctx context.Context, query string, args ...any) (int64, error) {
+	tag, err := q.conn.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (q pgxQuerier) queryRow(ctx context.Context, query string, args ...any) scanner {
+	return q.conn.QueryRow(ctx, query, args...)
+}
+
+func (q pgxQuerier) query(ctx context.Context, query string, args ...any) (rows, error) {
+	r, err := q.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgxRows{r}, nil
+}
+
+func (r pgxRows) close() { r.Rows.Close() }
+
+func (d *pgxDriver) begin(ctx context.Context, opts txOptions) (dbTx, error) {
+	t, err := d.pool.BeginTx(ctx, opts.pgx())
+	if err != nil {
+		return nil, err
+	}
+	return &pgxTx{pgxQuerier: pgxQuerier{conn: t}, tx: t}, nil
+}
+
+func (d *pgxDriver) ping(ctx context.Context) error { return d.pool.Ping(ctx) }
+
+func (d *pgxDriver) close() error {
+	d.pool.Close()
+	return nil
+}
+
+func (d *pgxDriver) stats() PoolStats {
+	s := d.pool.Stat()
+	return PoolStats{
+		Driver:       DriverPGXPool,
+		MaxConns:     int(s.MaxConns()),
+		TotalConns:   int(s.TotalConns()),
+		IdleConns:    int(s.IdleConns()),
+		InUseConns:   int(s.AcquiredConns()),
+		WaitCount:    s.EmptyAcquireCount(),
+		WaitDuration: s.EmptyAcquireWaitTime(),
+	}
+}
+
+// batch pipelines stmts through pgx.Batch in a single network round trip.
+func (t *pgxTx) batch(ctx context.Context, stmts []statement) error {
+	b := new(pgx.Batch)
+	for _, s := range stmts {
+		b.Queue(s.query, s.args...)
+	}
+	br := t.tx.SendBatch(ctx, b)
+	for i := range stmts {
+		if _, err := br.Exec(); err != nil {
+			_ = br.Close()
+			return fmt.Errorf("batch statement %d: %w", i, err)
+		}
+	}
+	return br.Close()
+}
+
+func (t *pgxTx) copyFrom(ctx context.Context, table string, columns []string, rows [][]any) (int64, error) {
+	return t.tx.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+}
+
+// pgx translates opts; isolation levels PostgreSQL does not distinguish map to the next stricter one.
+func (opts txOptions) pgx() pgx.TxOptions {
+	var o pgx.TxOptions
+	switch opts.isolation {
+	case sql.LevelReadUncommitted, sql.LevelReadCommitted:
+		o.IsoLevel = pgx.ReadCommitted
+	case sql.LevelRepeatableRead, sql.LevelSnapshot:
+		o.IsoLevel = pgx.RepeatableRead
+	case sql.LevelSerializable, sql.LevelLinearizable:
+		o.IsoLevel = pgx.Serializable
+	}
+	if opts.readOnly {
+		o.AccessMode = pgx.ReadOnly
+	}
+	return o
+}
+
+func (t *pgxTx) commit(ctx context.Context) error { return t.tx.Commit(ctx) }
+
+func (t *pgxTx) rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/alkmc/storefront/internal/entity"
+)
+
+// exportFetchSize is the number of rows queryFetchExport pulls per round trip.
+const exportFetchSize = 1000
+
+// queryFetchExport pulls the next rows of the cursor declared by queryDeclareExport.
+var queryFetchExport = fmt.Sprintf(`FETCH FORWARD %d FROM product_export;`, exportFetchSize)
+
+// errExportStopped unwinds the export transaction when the consumer stops iterating.
+var errExportStopped = errors.New("export stopped by consumer")
+
+// Export streams every product matching f in id order through a server-side cursor,
+// so memory use stays bounded by exportFetchSize whatever the catalog size. All rows
+// come from one snapshot; the transaction stays open until iteration ends. It is never retried,
+// since rows may already have been yielded.
+func (pg *Repository) Export(ctx context.Context, f entity.ProductFilter) iter.Seq2[entity.Product, error] {
+	return func(yield func(entity.Product, error) bool) {
+		err := pg.runTx(ctx, pg.txFor(opExport, txSnapshot), func(tx dbTx) error {
+			if _, err := tx.exec(ctx, queryDeclareExport, f.IncludeDeleted, string(f.Currency)); err != nil {
+				return err
+			}
+			for {
+				n, err := fetchExport(ctx, tx, yield)
+				if err != nil {
+					return err
+				}
+				if n < exportFetchSize {
+					return nil
+				}
+			}
+		})
+		if err != nil && !errors.Is(err, errExportStopped) {
+			yield(entity.Product{}, err)
+		}
+	}
+}
+
+// fetchExport yields the next batch of the export cursor and reports how many rows it held.
+func fetchExport(ctx context.Context, tx dbTx, yield func(entity.Product, error) bool) (int, error) {
+	rows, err := tx.query(ctx, queryFetchExport)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.close()
+
+	n := 0
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return n, err
+		}
+		n++
+		if !yield(p, nil) {
+			return n, errExportStopped
+		}
+	}
+	return n, rows.Err()
+}
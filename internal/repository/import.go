@@ -0,0 +1,309 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+const rejectDeleted = "product is deleted; restore it before importing"
+
+var importStagingColumns = []string{"line", "id", "name", "price_minor", "currency"}
+
+// CreateImportJob records a new import job of the tenant in ctx and fills in its tenant and timestamps.
+func (pg *Repository) CreateImportJob(ctx context.Context, j entity.ImportJob) (entity.ImportJob, error) {
+	err := pg.inTx(ctx, func(tx dbTx) error {
+		return tx.queryRow(ctx, queryInsertImportJob,
+			j.ID, string(j.Format), string(j.Origin), j.Source, string(j.Status), j.Actor, j.RequestID,
+		).Scan(&j.Tenant, &j.CreatedAt, &j.UpdatedAt)
+	})
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	return j, nil
+}
+
+func (pg *Repository) FindImportJob(ctx context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	var j entity.ImportJob
+	err := pg.conn(ctx, func(q querier) (err error) {
+		j, err = scanImportJobRow(q.queryRow(ctx, queryGetImportJob, id))
+		return err
+	})
+	return j, err
+}
+
+// ClaimImportJob marks the oldest pending job of origin as running and returns it.
+// Running jobs whose owner has not checkpointed within staleAfter are claimed as well,
+// which is how an interrupted import resumes. Jobs of every tenant are claimed; the job
+// names its tenant. entity.ErrNotFound means nothing to do.
+func (pg *Repository) ClaimImportJob(ctx context.Context, origin entity.ImportOrigin,
+	staleAfter time.Duration,
+) (entity.ImportJob, error) {
+	var j entity.ImportJob
+	err := pg.inTxWith(ctx, txAllTenants, func(tx dbTx) (err error) {
+		j, err = scanImportJobRow(tx.queryRow(ctx, queryClaimImportJob,
+			string(origin), staleAfter.Milliseconds()))
+		return err
+	})
+	return j, err
+}
+
+// ResumeImportJob moves an unfinished job of origin back to status, clearing its error.
+func (pg *Repository) ResumeImportJob(ctx context.Context, id uuid.UUID, origin entity.ImportOrigin,
+	status entity.ImportStatus,
+) (entity.ImportJob, error) {
+	var j entity.ImportJob
+	err := pg.inTx(ctx, func(tx dbTx) (err error) {
+		j, err = scanImportJobRow(tx.queryRow(ctx, queryResumeImportJob, id, string(origin), string(status)))
+		return err
+	})
+	return j, err
+}
+
+func (pg *Repository) FinishImportJob(ctx context.Context, id uuid.UUID, status entity.ImportStatus,
+	msg string,
+) error {
+	return pg.inTx(ctx, func(tx dbTx) error {
+		n, err := tx.exec(ctx, queryFinishImportJob, id, string(status), msg)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return entity.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// SaveImportSource stores part number part of the uploaded source of job id.
+func (pg *Repository) SaveImportSource(ctx context.Context, id uuid.UUID, part int, data []byte) error {
+	return pg.inTx(ctx, func(tx dbTx) error {
+		_, err := tx.exec(ctx, queryInsertImportSource, id, part, data)
+		return err
+	})
+}
+
+// ImportSource returns part number part of the uploaded source of job id; entity.ErrNotFound
+// means the source has no such part.
+func (pg *Repository) ImportSource(ctx context.Context, id uuid.UUID, part int) ([]byte, error) {
+	var data []byte
+	err := pg.conn(ctx, func(q querier) error {
+		err := q.queryRow(ctx, queryGetImportSource, id, part).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.ErrNotFound
+		}
+		return err
+	})
+	return data, err
+}
+
+// DeleteImportSource drops the uploaded source of job id.
+func (pg *Repository) DeleteImportSource(ctx context.Context, id uuid.UUID) error {
+	return pg.inTx(ctx, func(tx dbTx) error {
+		_, err := tx.exec(ctx, queryDeleteImportSource, id)
+		return err
+	})
+}
+
+// ImportChunk loads the chunk's rows into a staging table with COPY, merges them into
+// products, records audit entries and rejects, and advances the job checkpoint, all in
+// one transaction. It returns the advanced job and the ids of the merged products, whose
+// cached copies, or tombstones for those it inserted, are stale.
+func (pg *Repository) ImportChunk(ctx context.Context, c entity.ImportChunk,
+) (entity.ImportJob, []uuid.UUID, error) {
+	var (
+		job    entity.ImportJob
+		merged []uuid.UUID
+	)
+	err := pg.inTx(ctx, func(tx dbTx) error {
+		var checkpoint int64
+		if err := tx.queryRow(ctx, queryLockImportJob, c.JobID).Scan(&checkpoint); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return entity.ErrNotFound
+			}
+			return err
+		}
+		if checkpoint != c.From {
+			return entity.ErrImportCheckpoint
+		}
+
+		rejects := slices.Clone(c.Rejects)
+		accepted := int64(0)
+		if len(c.Rows) > 0 {
+			ids, err := mergeImport(ctx, tx, c.Rows)
+			if err != nil {
+				return err
+			}
+			// Rows missing from the merge hit a tombstone; every line of a merged id counts.
+			for _, r := range c.Rows {
+				if _, ok := ids[r.Product.ID]; !ok {
+					rejects = append(rejects, entity.ImportReject{Line: r.Line, Reason: rejectDeleted})
+					continue
+				}
+				accepted++
+			}
+			merged = slices.Collect(maps.Keys(ids))
+		}
+
+		if len(rejects) > 0 {
+			lines := make([]int64, len(rejects))
+			reasons := make([]string, len(rejects))
+			for i, r := range rejects {
+				lines[i], reasons[i] = r.Line, r.Reason
+			}
+			if _, err := tx.exec(ctx, queryInsertImportRejects, c.JobID, lines, reasons); err != nil {
+				return fmt.Errorf("insert import rejects: %w", err)
+			}
+		}
+
+		var err error
+		job, err = scanImportJob(tx.queryRow(ctx, queryAdvanceImportJob,
+			c.JobID, c.LastLine, accepted, int64(len(rejects))))
+		return err
+	})
+	if err != nil {
+		return entity.ImportJob{}, nil, err
+	}
+	return job, merged, nil
+}
+
+// mergeImport stages rows, upserts them into products and audits every merged product.
+// It returns the set of merged ids.
+func mergeImport(ctx context.Context, tx dbTx, rows []entity.ImportRow) (map[uuid.UUID]struct{}, error) {
+	if _, err := tx.exec(ctx, queryCreateImportStaging); err != nil {
+		return nil, fmt.Errorf("create import staging table: %w", err)
+	}
+	staged := make([][]any, len(rows))
+	for i, r := range rows {
+		p := r.Product
+		staged[i] = []any{r.Line, p.ID, p.Name, p.Price.MinorAmount, string(p.Price.Currency)}
+	}
+	if _, err := tx.copyFrom(ctx, "import_staging", importStagingColumns, staged); err != nil {
+		return nil, fmt.Errorf("copy import rows: %w", err)
+	}
+
+	res, err := tx.query(ctx, queryMergeImport)
+	if err != nil {
+		return nil, fmt.Errorf("merge import rows: %w", err)
+	}
+	defer res.close()
+
+	var (
+		merged  = make(map[uuid.UUID]struct{}, len(rows))
+		ids     []string
+		actions []string
+		changed []string
+	)
+	for res.Next() {
+		var (
+			before, after         entity.Product
+			existed               bool
+			oldCurrency, currency string
+		)
+		if err := res.Scan(&after.ID, &existed,
+			&before.Name, &before.Price.MinorAmount, &oldCurrency,
+			&after.Name, &after.Price.MinorAmount, &currency,
+		); err != nil {
+			return nil, err
+		}
+		before.ID = after.ID
+		before.Price.Currency = entity.Currency(oldCurrency)
+		after.Price.Currency = entity.Currency(currency)
+
+		action, changes := entity.AuditCreate, entity.Diff(nil, &after)
+		if existed {
+			action, changes = entity.AuditUpdate, entity.Diff(&before, &after)
+		}
+		data, err := json.Marshal(toAuditChanges(changes))
+		if err != nil {
+			return nil, fmt.Errorf("marshal audit changes: %w", err)
+		}
+		merged[after.ID] = struct{}{}
+		ids = append(ids, after.ID.String())
+		actions = append(actions, string(action))
+		changed = append(changed, string(data))
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+	res.close()
+
+	if len(ids) > 0 {
+		_, err := tx.exec(ctx, queryInsertImportAudit,
+			ids, actions, reqctx.Actor(ctx), reqctx.RequestID(ctx), changed)
+		if err != nil {
+			return nil, fmt.Errorf("insert import audit entries: %w", err)
+		}
+	}
+	return merged, nil
+}
+
+// ImportRejects returns one page of the job's rejected lines after line cursor.
+func (pg *Repository) ImportRejects(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.ImportRejectPage, error) {
+	var rejects []entity.ImportReject
+	err := pg.conn(ctx, func(q querier) (err error) {
+		rejects, err = importRejects(ctx, q, id, cursor, limit+1)
+		return err
+	})
+	if err != nil {
+		return entity.ImportRejectPage{}, err
+	}
+	if len(rejects) <= limit {
+		return entity.ImportRejectPage{Items: rejects}, nil
+	}
+	return entity.ImportRejectPage{Items: rejects[:limit], HasMore: true}, nil
+}
+
+// importRejects reads up to pageLimit rejected lines of the job after line cursor.
+func importRejects(ctx context.Context, q querier, id uuid.UUID, cursor int64, pageLimit int,
+) ([]entity.ImportReject, error) {
+	rows, err := q.query(ctx, queryImportRejects, id, cursor, pageLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.close()
+
+	rejects := make([]entity.ImportReject, 0, pageLimit)
+	for rows.Next() {
+		var r entity.ImportReject
+		if err := rows.Scan(&r.Line, &r.Reason); err != nil {
+			return nil, err
+		}
+		rejects = append(rejects, r)
+	}
+	return rejects, rows.Err()
+}
+
+func scanImportJobRow(s scanner) (entity.ImportJob, error) {
+	j, err := scanImportJob(s)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.ImportJob{}, entity.ErrNotFound
+	}
+	return j, err
+}
+
+func scanImportJob(s scanner) (entity.ImportJob, error) {
+	var (
+		j                      entity.ImportJob
+		format, origin, status string
+	)
+	if err := s.Scan(&j.ID, &j.Tenant, &format, &origin, &j.Source, &status, &j.Line, &j.Accepted, &j.Rejected,
+		&j.Error, &j.Actor, &j.RequestID, &j.CreatedAt, &j.UpdatedAt,
+	); err != nil {
+		return entity.ImportJob{}, err
+	}
+	j.Format = entity.ImportFormat(format)
+	j.Origin = entity.ImportOrigin(origin)
+	j.Status = entity.ImportStatus(status)
+	return j, nil
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"slices"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// CreateAPIKey stores a new key of the tenant in ctx and fills in its tenant and creation time.
+func (r *Repository) CreateAPIKey(ctx context.Context, k entity.APIKey) (entity.APIKey, error) {
+	k.Tenant = reqctx.Tenant(ctx)
+	k.CreatedAt = now()
+	k.Scopes = slices.Clone(k.Scopes)
+	err := r.write(ctx, func(st *state) error {
+		st.apiKeys[k.ID] = k
+		return nil
+	})
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+	return k, nil
+}
+
+// FindAPIKey returns the active key with hash, of whichever tenant owns it.
+func (r *Repository) FindAPIKey(ctx context.Context, hash []byte) (entity.APIKey, error) {
+	for _, k := range r.read(ctx).apiKeys {
+		if subtle.ConstantTimeCompare(k.Hash, hash) == 1 && !k.Revoked() {
+			return withoutHash(k), nil
+		}
+	}
+	return entity.APIKey{}, entity.ErrNotFound
+}
+
+// ListAPIKeys returns every key of the tenant in ctx, revoked ones included, oldest first.
+func (r *Repository) ListAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	tenant := reqctx.Tenant(ctx)
+	var keys []entity.APIKey
+	for _, k := range r.read(ctx).apiKeys {
+		if k.Tenant == tenant {
+			keys = append(keys, withoutHash(k))
+		}
+	}
+	slices.SortFunc(keys, func(a, b entity.APIKey) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), bytes.Compare(a.ID[:], b.ID[:]))
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an active key of the tenant in ctx.
+func (r *Repository) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	return r.write(ctx, func(st *state) error {
+		k, ok := st.apiKeys[id]
+		if !ok || k.Tenant != reqctx.Tenant(ctx) || k.Revoked() {
+			return entity.ErrNotFound
+		}
+		k.RevokedAt = now()
+		st.apiKeys[id] = k
+		return nil
+	})
+}
+
+// TouchAPIKey records that the key authenticated a request at, whichever tenant owns it.
+func (r *Repository) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.write(ctx, func(st *state) error {
+		k, ok := st.apiKeys[id]
+		if ok && k.LastUsedAt.Before(at) {
+			k.LastUsedAt = at
+			st.apiKeys[id] = k
+		}
+		return nil
+	})
+}
+
+// withoutHash returns k as the other stores read it, which never load the hash back.
+func withoutHash(k entity.APIKey) entity.APIKey {
+	k.Hash = nil
+	k.Scopes = slices.Clone(k.Scopes)
+	return k
+}
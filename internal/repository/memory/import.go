@@ -0,0 +1,245 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+const rejectDeleted = "product is deleted; restore it before importing"
+
+// CreateImportJob records a new import job of the tenant in ctx and fills in its tenant and timestamps.
+func (r *Repository) CreateImportJob(ctx context.Context, j entity.ImportJob) (entity.ImportJob, error) {
+	err := r.write(ctx, func(st *state) error {
+		j.Tenant = reqctx.Tenant(ctx)
+		j.CreatedAt = now()
+		j.UpdatedAt = j.CreatedAt
+		st.jobs[j.ID] = j
+		return nil
+	})
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	return j, nil
+}
+
+func (r *Repository) FindImportJob(ctx context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	j, ok := r.read(ctx).job(ctx, id)
+	if !ok {
+		return entity.ImportJob{}, entity.ErrNotFound
+	}
+	return j, nil
+}
+
+// ClaimImportJob marks the oldest pending job of origin as running and returns it.
+// Running jobs whose owner has not checkpointed within staleAfter are claimed as well.
+// Jobs of every tenant are claimed; the job names its tenant. entity.ErrNotFound means nothing to do.
+func (r *Repository) ClaimImportJob(ctx context.Context, origin entity.ImportOrigin,
+	staleAfter time.Duration,
+) (entity.ImportJob, error) {
+	var claimed entity.ImportJob
+	err := r.write(ctx, func(st *state) error {
+		stale := now().Add(-staleAfter)
+		found := false
+		for _, j := range st.jobs {
+			claimable := j.Status == entity.ImportPending ||
+				(j.Status == entity.ImportRunning && j.UpdatedAt.Before(stale))
+			if j.Origin != origin || !claimable || (found && !j.CreatedAt.Before(claimed.CreatedAt)) {
+				continue
+			}
+			claimed, found = j, true
+		}
+		if !found {
+			return entity.ErrNotFound
+		}
+		claimed.Status, claimed.UpdatedAt = entity.ImportRunning, now()
+		st.jobs[claimed.ID] = claimed
+		return nil
+	})
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	return claimed, nil
+}
+
+// ResumeImportJob moves an unfinished job of origin back to status, clearing its error.
+func (r *Repository) ResumeImportJob(ctx context.Context, id uuid.UUID, origin entity.ImportOrigin,
+	status entity.ImportStatus,
+) (entity.ImportJob, error) {
+	var j entity.ImportJob
+	err := r.write(ctx, func(st *state) error {
+		var ok bool
+		j, ok = st.job(ctx, id)
+		if !ok || j.Origin != origin || j.Status == entity.ImportCompleted {
+			return entity.ErrNotFound
+		}
+		j.Status, j.Error, j.UpdatedAt = status, "", now()
+		st.jobs[id] = j
+		return nil
+	})
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	return j, nil
+}
+
+func (r *Repository) FinishImportJob(ctx context.Context, id uuid.UUID, status entity.ImportStatus,
+	msg string,
+) error {
+	return r.write(ctx, func(st *state) error {
+		j, ok := st.job(ctx, id)
+		if !ok {
+			return entity.ErrNotFound
+		}
+		j.Status, j.Error, j.UpdatedAt = status, msg, now()
+		st.jobs[id] = j
+		return nil
+	})
+}
+
+// SaveImportSource stores part number part of the uploaded source of job id. Parts are
+// stored in order, from 0.
+func (r *Repository) SaveImportSource(ctx context.Context, id uuid.UUID, part int, data []byte) error {
+	return r.write(ctx, func(st *state) error {
+		if _, ok := st.job(ctx, id); !ok {
+			return entity.ErrNotFound
+		}
+		if part != len(st.sources[id]) {
+			return fmt.Errorf("import source part %d stored out of order", part)
+		}
+		st.sources[id] = append(st.sources[id], bytes.Clone(data))
+		return nil
+	})
+}
+
+// ImportSource returns part number part of the uploaded source of job id; entity.ErrNotFound
+// means the source has no such part.
+func (r *Repository) ImportSource(ctx context.Context, id uuid.UUID, part int) ([]byte, error) {
+	st := r.read(ctx)
+	if _, ok := st.job(ctx, id); !ok || part < 0 || part >= len(st.sources[id]) {
+		return nil, entity.ErrNotFound
+	}
+	return bytes.Clone(st.sources[id][part]), nil
+}
+
+// DeleteImportSource drops the uploaded source of job id.
+func (r *Repository) DeleteImportSource(ctx context.Context, id uuid.UUID) error {
+	return r.write(ctx, func(st *state) error {
+		if _, ok := st.job(ctx, id); ok {
+			delete(st.sources, id)
+		}
+		return nil
+	})
+}
+
+// ImportChunk merges the chunk's rows into products, records audit entries and rejects,
+// and advances the job checkpoint atomically. It returns the advanced job and the ids of the
+// merged products, whose cached copies, or tombstones for those it inserted, are stale.
+func (r *Repository) ImportChunk(ctx context.Context, c entity.ImportChunk,
+) (entity.ImportJob, []uuid.UUID, error) {
+	var (
+		job    entity.ImportJob
+		merged []uuid.UUID
+	)
+	err := r.write(ctx, func(st *state) error {
+		var ok bool
+		if job, ok = st.job(ctx, c.JobID); !ok {
+			return entity.ErrNotFound
+		}
+		if job.Line != c.From {
+			return entity.ErrImportCheckpoint
+		}
+
+		// The last line wins for repeated ids.
+		latest := make(map[uuid.UUID]entity.Product, len(c.Rows))
+		for _, row := range c.Rows {
+			latest[row.Product.ID] = row.Product
+		}
+		ids := slices.SortedFunc(maps.Keys(latest), func(a, b uuid.UUID) int {
+			return bytes.Compare(a[:], b[:])
+		})
+
+		merged = make([]uuid.UUID, 0, len(ids))
+		for _, id := range ids {
+			after := latest[id]
+			if err := checkConstraints(after); err != nil {
+				return err
+			}
+			before, existed := st.products[keyOf(ctx, id)]
+			if existed && before.Deleted() {
+				delete(latest, id)
+				continue
+			}
+			after.DeletedAt = time.Time{}
+			st.products[keyOf(ctx, id)] = after
+			merged = append(merged, id)
+			if existed {
+				st.appendAudit(ctx, id, entity.AuditUpdate, entity.Diff(&before, &after))
+			} else {
+				st.appendAudit(ctx, id, entity.AuditCreate, entity.Diff(nil, &after))
+			}
+		}
+
+		// Tombstoned ids have left latest; every line of a merged id counts.
+		rejects := slices.Clone(c.Rejects)
+		accepted := int64(0)
+		for _, row := range c.Rows {
+			if _, ok := latest[row.Product.ID]; !ok {
+				rejects = append(rejects, entity.ImportReject{Line: row.Line, Reason: rejectDeleted})
+				continue
+			}
+			accepted++
+		}
+		st.rejects[c.JobID] = append(st.rejects[c.JobID], rejects...)
+
+		job.Line = c.LastLine
+		job.Accepted += accepted
+		job.Rejected += int64(len(rejects))
+		job.UpdatedAt = now()
+		st.jobs[c.JobID] = job
+		return nil
+	})
+	if err != nil {
+		return entity.ImportJob{}, nil, err
+	}
+	return job, merged, nil
+}
+
+// ImportRejects returns one page of the job's rejected lines after line cursor.
+func (r *Repository) ImportRejects(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.ImportRejectPage, error) {
+	st := r.read(ctx)
+	if _, ok := st.job(ctx, id); !ok {
+		return entity.ImportRejectPage{}, nil
+	}
+	all := slices.SortedFunc(slices.Values(st.rejects[id]), func(a, b entity.ImportReject) int {
+		return cmp.Compare(a.Line, b.Line)
+	})
+	var rejects []entity.ImportReject
+	for _, rj := range all {
+		if rj.Line <= cursor {
+			continue
+		}
+		if rejects = append(rejects, rj); len(rejects) > limit {
+			return entity.ImportRejectPage{Items: rejects[:limit], HasMore: true}, nil
+		}
+	}
+	return entity.ImportRejectPage{Items: rejects}, nil
+}
+
+// job returns the import job with id if it belongs to the tenant in ctx.
+func (s *state) job(ctx context.Context, id uuid.UUID) (entity.ImportJob, bool) {
+	j, ok := s.jobs[id]
+	if !ok || j.Tenant != reqctx.Tenant(ctx) {
+		return entity.ImportJob{}, false
+	}
+	return j, true
+}
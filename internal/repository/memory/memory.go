@@ -0,0 +1,494 @@
+// Package memory is an in-process product store with the semantics of the PostgreSQL
+// repository, for tests and local development. Data is lost when the process exits.
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+type (
+	// state is one version of the store. A published state is never modified; writers
+	// change a clone and publish it, so readers see a consistent snapshot without locking.
+	state struct {
+		products map[productKey]entity.Product
+		audit    []auditRecord
+		jobs     map[uuid.UUID]entity.ImportJob
+		rejects  map[uuid.UUID][]entity.ImportReject
+		sources  map[uuid.UUID][][]byte
+		apiKeys  map[uuid.UUID]entity.APIKey
+	}
+	// productKey identifies a product; ids are unique per tenant only.
+	productKey struct {
+		tenant string
+		id     uuid.UUID
+	}
+	auditRecord struct {
+		tenant string
+		entry  entity.AuditEntry
+	}
+	Repository struct {
+		// writeMu serializes writers. WithinTx holds it until the transaction ends.
+		writeMu sync.Mutex
+		current atomic.Pointer[state]
+	}
+	txCtxKey   struct{}
+	unitOfWork struct {
+		repo        *Repository
+		st          *state
+		afterCommit []func(context.Context)
+	}
+)
+
+// New returns an empty store.
+func New() *Repository {
+	r := new(Repository)
+	r.current.Store(&state{
+		products: make(map[productKey]entity.Product),
+		jobs:     make(map[uuid.UUID]entity.ImportJob),
+		rejects:  make(map[uuid.UUID][]entity.ImportReject),
+		sources:  make(map[uuid.UUID][][]byte),
+		apiKeys:  make(map[uuid.UUID]entity.APIKey),
+	})
+	return r
+}
+
+// clone copies the maps of s. Audit entries, rejects and source parts are append-only, so
+// their slices are shared with the capacity clipped, which makes the next append copy them.
+func (s *state) clone() *state {
+	rejects := make(map[uuid.UUID][]entity.ImportReject, len(s.rejects))
+	for id, rs := range s.rejects {
+		rejects[id] = slices.Clip(rs)
+	}
+	sources := make(map[uuid.UUID][][]byte, len(s.sources))
+	for id, parts := range s.sources {
+		sources[id] = slices.Clip(parts)
+	}
+	return &state{
+		products: maps.Clone(s.products),
+		audit:    slices.Clip(s.audit),
+		jobs:     maps.Clone(s.jobs),
+		rejects:  rejects,
+		sources:  sources,
+		apiKeys:  maps.Clone(s.apiKeys),
+	}
+}
+
+// read returns the state visible to ctx: that of its transaction, or the last committed one.
+func (r *Repository) read(ctx context.Context) *state {
+	if uow, ok := r.unitOfWork(ctx); ok {
+		return uow.st
+	}
+	return r.current.Load()
+}
+
+// write applies fn atomically to a clone of the state visible to ctx, which replaces it only
+// when fn succeeds. Every write copies the product map, which is fine for the catalog sizes
+// this store is meant for.
+func (r *Repository) write(ctx context.Context, fn func(*state) error) error {
+	if uow, ok := r.unitOfWork(ctx); ok {
+		st := uow.st.clone()
+		if err := fn(st); err != nil {
+			return err
+		}
+		uow.st = st
+		return nil
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	st := r.current.Load().clone()
+	if err := fn(st); err != nil {
+		return err
+	}
+	r.current.Store(st)
+	return nil
+}
+
+// WithinTx runs fn in a transaction carried by the context it passes to fn, with the same
+// contract as the PostgreSQL repository. Writers are serialized while it runs, so fn must make
+// its writes with that context; a write using another context would wait for fn forever.
+func (r *Repository) WithinTx(ctx context.Context, fn func(context.Context) error) error {
+	if uow, ok := r.unitOfWork(ctx); ok {
+		return uow.savepoint(ctx, fn)
+	}
+	uow, err := r.runTx(ctx, fn)
+	if err != nil {
+		return err
+	}
+	for _, hook := range uow.afterCommit {
+		hook(ctx)
+	}
+	return nil
+}
+
+// runTx runs fn against a private copy of the state and publishes it when fn succeeds.
+func (r *Repository) runTx(ctx context.Context, fn func(context.Context) error) (*unitOfWork, error) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	uow := &unitOfWork{repo: r, st: r.current.Load()}
+	if err := fn(context.WithValue(ctx, txCtxKey{}, uow)); err != nil {
+		return nil, err
+	}
+	r.current.Store(uow.st)
+	return uow, nil
+}
+
+// AfterCommit defers fn until the transaction in ctx commits; without one fn runs at once.
+func (r *Repository) AfterCommit(ctx context.Context, fn func(context.Context)) {
+	uow, ok := r.unitOfWork(ctx)
+	if !ok {
+		fn(ctx)
+		return
+	}
+	uow.afterCommit = append(uow.afterCommit, fn)
+}
+
+// InTx reports whether ctx carries a transaction of this store.
+func (r *Repository) InTx(ctx context.Context) bool {
+	_, ok := r.unitOfWork(ctx)
+	return ok
+}
+
+func (r *Repository) unitOfWork(ctx context.Context) (*unitOfWork, bool) {
+	uow, ok := ctx.Value(txCtxKey{}).(*unitOfWork)
+	if !ok || uow.repo != r {
+		return nil, false
+	}
+	return uow, true
+}
+
+// savepoint runs fn and restores the state and hooks from before it on error or panic.
+// Writes replace the state rather than modify it, so keeping the pointer is enough.
+func (uow *unitOfWork) savepoint(ctx context.Context, fn func(context.Context) error) error {
+	saved, hooks := uow.st, len(uow.afterCommit)
+	rollback := func() {
+		uow.st = saved
+		uow.afterCommit = uow.afterCommit[:hooks]
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) Ping(context.Context) error {
+	return nil
+}
+
+// Replicas reports no replicas; the store has none.
+func (r *Repository) Replicas() []entity.ReplicaStatus {
+	return nil
+}
+
+func (r *Repository) Close() {}
+
+func (r *Repository) Save(ctx context.Context, p entity.Product) (entity.Product, error) {
+	err := r.write(ctx, func(st *state) error {
+		return st.insert(ctx, p)
+	})
+	if err != nil {
+		return entity.Product{}, err
+	}
+	return p, nil
+}
+
+// SaveAll inserts products and their audit entries atomically.
+func (r *Repository) SaveAll(ctx context.Context, ps []entity.Product) error {
+	return r.write(ctx, func(st *state) error {
+		for _, p := range ps {
+			if err := st.insert(ctx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Repository) FindByID(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	return r.read(ctx).liveProduct(ctx, id)
+}
+
+// FindByIDs returns the live products among ids, in no particular order; missing and deleted
+// ones are left out.
+func (r *Repository) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]entity.Product, error) {
+	st := r.read(ctx)
+	products := make([]entity.Product, 0, len(ids))
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		if p, err := st.liveProduct(ctx, id); err == nil && !seen[id] {
+			seen[id] = true
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+// RecentlyUpdated returns the live products of every tenant that were created, changed or
+// restored last, newest first, at most limit of them. The audit log records every such write
+// in order, so the store keeps no update times of its own.
+func (r *Repository) RecentlyUpdated(ctx context.Context, limit int) ([]entity.ProductRef, error) {
+	st := r.read(ctx)
+	refs := make([]entity.ProductRef, 0, limit)
+	seen := make(map[productKey]bool)
+	for _, rec := range slices.Backward(st.audit) {
+		if len(refs) == limit {
+			break
+		}
+		k := productKey{tenant: rec.tenant, id: rec.entry.ProductID}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if p, ok := st.products[k]; ok && !p.Deleted() {
+			refs = append(refs, entity.ProductRef{Tenant: k.tenant, ID: k.id})
+		}
+	}
+	return refs, nil
+}
+
+// FindAll returns the page of products after cursor in id order, as PostgreSQL orders UUIDs.
+func (r *Repository) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int, f entity.ProductFilter,
+) (entity.ProductPage, error) {
+	products := r.read(ctx).list(reqctx.Tenant(ctx), f)
+	if cursor.Valid {
+		i, found := slices.BinarySearchFunc(products, cursor.UUID, func(p entity.Product, id uuid.UUID) int {
+			return bytes.Compare(p.ID[:], id[:])
+		})
+		if found {
+			i++
+		}
+		products = products[i:]
+	}
+	if len(products) <= limit {
+		return entity.ProductPage{Items: products}, nil
+	}
+	return entity.ProductPage{Items: products[:limit], HasMore: true}, nil
+}
+
+// Export streams every product matching f in id order from a single snapshot.
+func (r *Repository) Export(ctx context.Context, f entity.ProductFilter) iter.Seq2[entity.Product, error] {
+	return func(yield func(entity.Product, error) bool) {
+		for _, p := range r.read(ctx).list(reqctx.Tenant(ctx), f) {
+			if !yield(p, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (r *Repository) Update(ctx context.Context, p entity.Product) error {
+	return r.write(ctx, func(st *state) error {
+		before, err := st.liveProduct(ctx, p.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkConstraints(p); err != nil {
+			return err
+		}
+		p.DeletedAt = time.Time{}
+		st.products[keyOf(ctx, p.ID)] = p
+		st.appendAudit(ctx, p.ID, entity.AuditUpdate, entity.Diff(&before, &p))
+		return nil
+	})
+}
+
+func (r *Repository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.write(ctx, func(st *state) error {
+		before, err := st.liveProduct(ctx, id)
+		if err != nil {
+			return err
+		}
+		after := before
+		after.DeletedAt = now()
+		st.products[keyOf(ctx, id)] = after
+		st.appendAudit(ctx, id, entity.AuditDelete, entity.Diff(&before, &after))
+		return nil
+	})
+}
+
+// Restore clears the tombstone of a soft-deleted product and returns it.
+func (r *Repository) Restore(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	var restored entity.Product
+	err := r.write(ctx, func(st *state) error {
+		before, ok := st.products[keyOf(ctx, id)]
+		if !ok || !before.Deleted() {
+			return entity.ErrNotFound
+		}
+		restored = before
+		restored.DeletedAt = time.Time{}
+		st.products[keyOf(ctx, id)] = restored
+		st.appendAudit(ctx, id, entity.AuditRestore, entity.Diff(&before, &restored))
+		return nil
+	})
+	if err != nil {
+		return entity.Product{}, err
+	}
+	return restored, nil
+}
+
+// Purge hard-deletes products of every tenant whose tombstone is older than before.
+func (r *Repository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.write(ctx, func(st *state) error {
+		keys := slices.SortedFunc(maps.Keys(st.products), func(a, b productKey) int {
+			return cmp.Or(strings.Compare(a.tenant, b.tenant), bytes.Compare(a.id[:], b.id[:]))
+		})
+		for _, k := range keys {
+			p := st.products[k]
+			if !p.Deleted() || !p.DeletedAt.Before(before) {
+				continue
+			}
+			delete(st.products, k)
+			st.audit = append(st.audit, st.newAudit(k.tenant, k.id, entity.AuditPurge, reqctx.Actor(ctx), "", nil))
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// History returns one page of audit entries for the product, newest first.
+// A zero cursor starts from the most recent entry.
+func (r *Repository) History(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.AuditPage, error) {
+	var (
+		entries []entity.AuditEntry
+		tenant  = reqctx.Tenant(ctx)
+	)
+	for _, rec := range slices.Backward(r.read(ctx).audit) {
+		e := rec.entry
+		if rec.tenant != tenant || e.ProductID != id || (cursor > 0 && e.ID >= cursor) {
+			continue
+		}
+		if entries = append(entries, e); len(entries) > limit {
+			return entity.AuditPage{Items: entries[:limit], HasMore: true}, nil
+		}
+	}
+	return entity.AuditPage{Items: entries}, nil
+}
+
+// AuditLog streams every audit entry recorded at or after since, oldest first.
+func (r *Repository) AuditLog(ctx context.Context, since time.Time) iter.Seq2[entity.AuditEntry, error] {
+	return func(yield func(entity.AuditEntry, error) bool) {
+		tenant := reqctx.Tenant(ctx)
+		for _, rec := range r.read(ctx).audit {
+			if rec.tenant == tenant && !rec.entry.OccurredAt.Before(since) && !yield(rec.entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+// list returns the products of tenant matching f sorted by id.
+func (s *state) list(tenant string, f entity.ProductFilter) []entity.Product {
+	out := make([]entity.Product, 0, len(s.products))
+	for k, p := range s.products {
+		if k.tenant != tenant || (p.Deleted() && !f.IncludeDeleted) ||
+			(f.Currency != "" && p.Price.Currency != f.Currency) {
+			continue
+		}
+		out = append(out, p)
+	}
+	slices.SortFunc(out, func(a, b entity.Product) int {
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+	return out
+}
+
+func (s *state) insert(ctx context.Context, p entity.Product) error {
+	if err := checkConstraints(p); err != nil {
+		return err
+	}
+	key := keyOf(ctx, p.ID)
+	if _, ok := s.products[key]; ok {
+		return &entity.ConstraintError{
+			Field:  entity.FieldID,
+			Reason: "a product with this id already exists",
+			Err:    entity.ErrConflict,
+		}
+	}
+	p.DeletedAt = time.Time{}
+	s.products[key] = p
+	s.appendAudit(ctx, p.ID, entity.AuditCreate, entity.Diff(nil, &p))
+	return nil
+}
+
+// liveProduct returns the product of the tenant in ctx, treating tombstoned ones as missing.
+func (s *state) liveProduct(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	p, ok := s.products[keyOf(ctx, id)]
+	if !ok || p.Deleted() {
+		return entity.Product{}, entity.ErrNotFound
+	}
+	return p, nil
+}
+
+// appendAudit records a mutation of the product, attributing it to the tenant, actor and
+// request carried by ctx.
+func (s *state) appendAudit(ctx context.Context, id uuid.UUID, action entity.AuditAction,
+	changes []entity.FieldChange,
+) {
+	s.audit = append(s.audit,
+		s.newAudit(reqctx.Tenant(ctx), id, action, reqctx.Actor(ctx), reqctx.RequestID(ctx), changes))
+}
+
+func (s *state) newAudit(tenant string, id uuid.UUID, action entity.AuditAction, actor, requestID string,
+	changes []entity.FieldChange,
+) auditRecord {
+	if changes == nil {
+		changes = []entity.FieldChange{}
+	}
+	return auditRecord{tenant: tenant, entry: entity.AuditEntry{
+		ID:         int64(len(s.audit)) + 1,
+		ProductID:  id,
+		Action:     action,
+		Actor:      actor,
+		RequestID:  requestID,
+		OccurredAt: now(),
+		Changes:    changes,
+	}}
+}
+
+// keyOf identifies the product with id of the tenant in ctx.
+func keyOf(ctx context.Context, id uuid.UUID) productKey {
+	return productKey{tenant: reqctx.Tenant(ctx), id: id}
+}
+
+// checkConstraints enforces the column types and CHECK constraints of the products table
+// with the errors the PostgreSQL repository maps them to. Like PostgreSQL, it does not reject
+// an empty name; that is left to Product.Validate.
+func checkConstraints(p entity.Product) error {
+	if utf8.RuneCountInString(p.Name) > entity.MaxNameLength || len(p.Price.Currency) > 3 {
+		return &entity.ConstraintError{
+			Reason: "a product field is longer than allowed",
+			Err:    entity.ErrConstraintViolation,
+		}
+	}
+	return p.Price.Validate()
+}
+
+// now truncates to the microsecond precision of PostgreSQL timestamps.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/alkmc/storefront/internal/repository/repotest"
+)
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(*testing.T) repotest.Repository {
+		return New()
+	})
+}
@@ -6,197 +6,383 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"time"
 
 	"github.com/alkmc/storefront/internal/config"
 	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 )
 
-type Repository struct {
-	logger *slog.Logger
-	db     *sql.DB
+type (
+	Repository struct {
+		logger *slog.Logger
+		db     driver
+		// replicas serve FindByID and FindAll when configured; nil sends every read to db.
+		replicas *replicaSet
+		retry    retryPolicy
+		// isolation holds the isolation levels configured per operation.
+		isolation map[string]sql.IsolationLevel
+	}
+	scanner interface {
+		Scan(dest ...any) error
+	}
+)
+
+// New creates a PostgreSQL repository backed by the driver selected in cfg,
+// routing reads to the replicas in cfg.ReplicaHosts when there are any.
+func New(ctx context.Context, l *slog.Logger, cfg config.Postgres) (*Repository, error) {
+	isolation, err := newIsolation(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db, err := open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ping(ctx); err != nil {
+		_ = db.close()
+		return nil, fmt.Errorf("failed to ping pg database: %w", err)
+	}
+	l.Info("successfully connected to db", slog.String("driver", cfg.Driver))
+
+	replicas, err := openReplicas(ctx, l, cfg, db)
+	if err != nil {
+		_ = db.close()
+		return nil, err
+	}
+	return new(Repository{
+		logger: l, db: db, replicas: replicas, retry: newRetryPolicy(cfg), isolation: isolation,
+	}), nil
 }
 
-// NewPG creates a new PostgreSQL repository
-func NewPG(ctx context.Context, l *slog.Logger, cfg config.Postgres) (*Repository, error) {
+// open creates the connection pool of the driver selected in cfg without connecting.
+func open(ctx context.Context, cfg config.Postgres) (driver, error) {
+	switch cfg.Driver {
+	case DriverPGXPool:
+		return openPGXPool(ctx, cfg)
+	case DriverStdlib:
+		return openPG(cfg)
+	default:
+		return nil, fmt.Errorf("unknown pg driver %q", cfg.Driver)
+	}
+}
+
+// openPG opens a database/sql pool.
+func openPG(cfg config.Postgres) (driver, error) {
 	pgCfg, err := pgx.ParseConfig(cfg.DSN())
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse pg config: %w", err)
 	}
-	pdb := stdlib.OpenDB(*pgCfg)
+	pgCfg.Tracer = queryTracer{}
+	pdb := stdlib.OpenDB(*pgCfg, stdlib.OptionAfterConnect(afterConnect(cfg)))
 	pdb.SetMaxOpenConns(cfg.MaxOpenConns)
 	pdb.SetMaxIdleConns(cfg.MaxIdleConns)
 	pdb.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	return newSQLDriver(pdb), nil
+}
 
-	if err := pdb.PingContext(ctx); err != nil {
-		_ = pdb.Close()
-		return nil, fmt.Errorf("failed to ping pg database: %w", err)
+// openPGXPool opens a native pgx connection pool,
+// which caches prepared statements per connection and pipelines batched writes.
+func openPGXPool(ctx context.Context, cfg config.Postgres) (driver, error) {
+	poolCfg, err := pgxpool.ParseConfig(cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pg config: %w", err)
+	}
+	poolCfg.MaxConns = int32(cfg.MaxOpenConns)
+	poolCfg.MinIdleConns = int32(cfg.MinIdleConns)
+	poolCfg.MaxConnLifetime = cfg.ConnMaxLifetime
+	poolCfg.AfterConnect = afterConnect(cfg)
+	poolCfg.ConnConfig.Tracer = queryTracer{}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pg pool: %w", err)
 	}
-	l.Info("successfully connected to db")
+	return newPGXDriver(pool), nil
+}
 
-	return new(Repository{logger: l, db: pdb}), nil
+// afterConnect applies per-session settings to every new physical connection.
+func afterConnect(cfg config.Postgres) func(context.Context, *pgx.Conn) error {
+	timeout := strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	return func(ctx context.Context, conn *pgx.Conn) error {
+		if _, err := conn.Exec(ctx, querySessionSettings, timeout, cfg.ApplicationName); err != nil {
+			return fmt.Errorf("apply session settings: %w", err)
+		}
+		return nil
+	}
 }
 
 func (pg *Repository) Ping(ctx context.Context) error {
-	return pg.db.PingContext(ctx)
+	return pg.db.ping(ctx)
+}
+
+// Stats reports connection pool usage for metrics.
+func (pg *Repository) Stats() PoolStats {
+	return pg.db.stats()
 }
 
 func (pg *Repository) Close() {
-	if err := pg.db.Close(); err != nil {
+	if pg.replicas != nil {
+		pg.replicas.close()
+	}
+	if err := pg.db.close(); err != nil {
 		pg.logger.Error("failed to close db connection", slog.Any("error", err))
 	}
 	pg.logger.Info("connection to db closed")
 }
 
 func (pg *Repository) Save(ctx context.Context, p entity.Product) (entity.Product, error) {
-	tx, err := pg.db.BeginTx(ctx, nil)
+	audit, err := auditStatement(ctx, p.ID, entity.AuditCreate, entity.Diff(nil, &p))
 	if err != nil {
 		return entity.Product{}, err
 	}
-
-	stmt, err := tx.PrepareContext(ctx, queryInsert)
+	err = pg.inTxWith(ctx, pg.txFor(opSave, txOptions{}), func(tx dbTx) error {
+		return tx.batch(ctx, []statement{
+			stmt(queryInsert, p.ID, p.Name, p.Price.MinorAmount, string(p.Price.Currency)),
+			audit,
+		})
+	})
 	if err != nil {
-		_ = tx.Rollback()
 		return entity.Product{}, err
 	}
-	defer stmt.Close()
+	return p, nil
+}
 
-	if _, err := stmt.ExecContext(
-		ctx, p.ID, p.Name, p.Price.MinorAmount, string(p.Price.Currency),
-	); err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return entity.Product{}, fmt.Errorf("failed to rollback transaction: %w", rollbackErr)
+// SaveAll inserts products and their audit entries atomically, pipelining the statements.
+func (pg *Repository) SaveAll(ctx context.Context, ps []entity.Product) error {
+	stmts := make([]statement, 0, 2*len(ps))
+	for _, p := range ps {
+		audit, err := auditStatement(ctx, p.ID, entity.AuditCreate, entity.Diff(nil, &p))
+		if err != nil {
+			return err
 		}
-		return entity.Product{}, err
+		stmts = append(stmts,
+			stmt(queryInsert, p.ID, p.Name, p.Price.MinorAmount, string(p.Price.Currency)),
+			audit,
+		)
 	}
-
-	if err := tx.Commit(); err != nil {
-		return entity.Product{}, err
-	}
-	return p, nil
+	return pg.inTxWith(ctx, pg.txFor(opSave, txOptions{}), func(tx dbTx) error {
+		return tx.batch(ctx, stmts)
+	})
 }
 
 func (pg *Repository) FindByID(ctx context.Context, id uuid.UUID) (entity.Product, error) {
-	row := pg.db.QueryRowContext(ctx, queryGetByID, id)
-
 	var p entity.Product
-	var currency string
-	if err := row.Scan(&p.ID, &p.Name, &p.Price.MinorAmount, &currency); err != nil {
+	err := pg.read(ctx, func(q querier) (err error) {
+		p, err = scanProduct(q.queryRow(ctx, queryGetByID, id))
+		return err
+	})
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return entity.Product{}, entity.ErrNotFound
 		}
 		return entity.Product{}, err
 	}
-	p.Price.Currency = entity.Currency(currency)
 	return p, nil
 }
 
-func (pg *Repository) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int,
-) (entity.ProductPage, error) {
-	var (
-		rows      *sql.Rows
-		err       error
-		pageLimit = limit + 1
-	)
-
-	if cursor.Valid {
-		rows, err = pg.db.QueryContext(ctx, queryGetAllAfterCursor, cursor.UUID, pageLimit)
-	} else {
-		rows, err = pg.db.QueryContext(ctx, queryGetAll, pageLimit)
+// FindByIDs returns the live products among ids, in no particular order; missing and deleted
+// ones are left out.
+func (pg *Repository) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]entity.Product, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = id.String()
 	}
+	var products []entity.Product
+	err := pg.read(ctx, func(q querier) error {
+		rows, err := q.query(ctx, queryGetByIDs, keys)
+		if err != nil {
+			return err
+		}
+		defer rows.close()
+
+		products = make([]entity.Product, 0, len(ids))
+		for rows.Next() {
+			p, err := scanProduct(rows)
+			if err != nil {
+				return err
+			}
+			products = append(products, p)
+		}
+		return rows.Err()
+	})
 	if err != nil {
-		return entity.ProductPage{}, err
+		return nil, err
 	}
-	defer rows.Close()
+	return products, nil
+}
 
-	products := make([]entity.Product, 0, limit+1)
-	for rows.Next() {
-		var p entity.Product
-		var currency string
-		if err := rows.Scan(&p.ID, &p.Name, &p.Price.MinorAmount, &currency); err != nil {
-			return entity.ProductPage{}, err
+// RecentlyUpdated returns the live products of every tenant that were created, changed or
+// restored last, newest first, at most limit of them.
+func (pg *Repository) RecentlyUpdated(ctx context.Context, limit int) ([]entity.ProductRef, error) {
+	var refs []entity.ProductRef
+	err := pg.inTxWith(ctx, txAllTenantsReadOnly, func(tx dbTx) error {
+		rows, err := tx.query(ctx, queryRecentlyUpdated, limit)
+		if err != nil {
+			return err
 		}
-		p.Price.Currency = entity.Currency(currency)
-		products = append(products, p)
+		defer rows.close()
+
+		refs = make([]entity.ProductRef, 0, limit)
+		for rows.Next() {
+			var r entity.ProductRef
+			if err := rows.Scan(&r.Tenant, &r.ID); err != nil {
+				return err
+			}
+			refs = append(refs, r)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
+	return refs, nil
+}
 
-	if err = rows.Err(); err != nil {
+func (pg *Repository) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int, f entity.ProductFilter,
+) (entity.ProductPage, error) {
+	var products []entity.Product
+	err := pg.read(ctx, func(q querier) (err error) {
+		products, err = findAll(ctx, q, cursor, limit+1, f)
+		return err
+	})
+	if err != nil {
 		return entity.ProductPage{}, err
 	}
-
 	return productPage(products, limit), nil
 }
 
-func (pg *Repository) Update(ctx context.Context, p entity.Product) error {
-	tx, err := pg.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
+// findAll reads up to pageLimit products after cursor.
+func findAll(ctx context.Context, q querier, cursor uuid.NullUUID, pageLimit int, f entity.ProductFilter,
+) ([]entity.Product, error) {
+	var (
+		rows rows
+		err  error
+	)
+	if cursor.Valid {
+		rows, err = q.query(ctx, queryGetAllAfterCursor,
+			cursor.UUID, pageLimit, f.IncludeDeleted, string(f.Currency))
+	} else {
+		rows, err = q.query(ctx, queryGetAll, pageLimit, f.IncludeDeleted, string(f.Currency))
 	}
-
-	stmt, err := tx.PrepareContext(ctx, queryUpdate)
 	if err != nil {
-		_ = tx.Rollback()
-		return err
+		return nil, err
 	}
-	defer stmt.Close()
+	defer rows.close()
 
-	res, err := stmt.ExecContext(ctx, p.ID, p.Name, p.Price.MinorAmount, string(p.Price.Currency))
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return fmt.Errorf("failed to rollback transaction: %w", rollbackErr)
+	products := make([]entity.Product, 0, pageLimit)
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
 		}
-		return err
-	}
-	rows, err := res.RowsAffected()
-	if err != nil {
-		_ = tx.Rollback()
-		return err
-	}
-	if rows == 0 {
-		_ = tx.Rollback()
-		return entity.ErrNotFound
-	}
-	if err := tx.Commit(); err != nil {
-		return err
+		products = append(products, p)
 	}
-	return nil
+	return products, rows.Err()
+}
+
+func (pg *Repository) Update(ctx context.Context, p entity.Product) error {
+	return pg.inTxWith(ctx, pg.txFor(opUpdate, txOptions{}), func(tx dbTx) error {
+		before, err := lockLiveProduct(ctx, tx, p.ID)
+		if err != nil {
+			return err
+		}
+		audit, err := auditStatement(ctx, p.ID, entity.AuditUpdate, entity.Diff(&before, &p))
+		if err != nil {
+			return err
+		}
+		return tx.batch(ctx, []statement{
+			stmt(queryUpdate, p.ID, p.Name, p.Price.MinorAmount, string(p.Price.Currency)),
+			audit,
+		})
+	})
 }
 
 func (pg *Repository) Delete(ctx context.Context, id uuid.UUID) error {
-	tx, err := pg.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
+	return pg.inTxWith(ctx, pg.txFor(opDelete, txOptions{}), func(tx dbTx) error {
+		before, err := lockLiveProduct(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		after := before
+		if err := tx.queryRow(ctx, queryDelete, id).Scan(&after.DeletedAt); err != nil {
+			return err
+		}
+		return insertAudit(ctx, tx, id, entity.AuditDelete, entity.Diff(&before, &after))
+	})
+}
 
-	stmt, err := tx.PrepareContext(ctx, queryDelete)
+// Restore clears the tombstone of a soft-deleted product and returns it.
+func (pg *Repository) Restore(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	var restored entity.Product
+	err := pg.inTxWith(ctx, pg.txFor(opRestore, txOptions{}), func(tx dbTx) error {
+		before, err := lockProduct(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !before.Deleted() {
+			return entity.ErrNotFound
+		}
+		if restored, err = scanProduct(tx.queryRow(ctx, queryRestore, id)); err != nil {
+			return err
+		}
+		return insertAudit(ctx, tx, id, entity.AuditRestore, entity.Diff(&before, &restored))
+	})
 	if err != nil {
-		_ = tx.Rollback()
-		return err
+		return entity.Product{}, err
 	}
-	defer stmt.Close()
+	return restored, nil
+}
 
-	res, err := stmt.ExecContext(ctx, id)
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return fmt.Errorf("failed to rollback transaction: %w", rollbackErr)
-		}
+// Purge hard-deletes products of every tenant whose tombstone is older than before.
+// Inside WithinTx it only reaches the tenant of the transaction.
+func (pg *Repository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := pg.inTxWith(ctx, pg.txFor(opPurge, txAllTenants), func(tx dbTx) (err error) {
+		n, err = tx.exec(ctx, queryPurge, before, reqctx.Actor(ctx))
 		return err
+	})
+	return n, err
+}
+
+// lockProduct reads the product by id, including tombstoned ones, and locks its row.
+func lockProduct(ctx context.Context, q querier, id uuid.UUID) (entity.Product, error) {
+	p, err := scanProduct(q.queryRow(ctx, queryGetForUpdate, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Product{}, entity.ErrNotFound
 	}
-	rows, err := res.RowsAffected()
+	return p, err
+}
+
+// lockLiveProduct is lockProduct that treats tombstoned products as missing.
+func lockLiveProduct(ctx context.Context, q querier, id uuid.UUID) (entity.Product, error) {
+	p, err := lockProduct(ctx, q, id)
 	if err != nil {
-		_ = tx.Rollback()
-		return err
+		return entity.Product{}, err
 	}
-	if rows == 0 {
-		_ = tx.Rollback()
-		return entity.ErrNotFound
+	if p.Deleted() {
+		return entity.Product{}, entity.ErrNotFound
 	}
-	if err := tx.Commit(); err != nil {
-		return err
+	return p, nil
+}
+
+func scanProduct(s scanner) (entity.Product, error) {
+	var (
+		p         entity.Product
+		currency  string
+		deletedAt sql.NullTime
+	)
+	if err := s.Scan(&p.ID, &p.Name, &p.Price.MinorAmount, &currency, &deletedAt); err != nil {
+		return entity.Product{}, err
 	}
-	return nil
+	p.Price.Currency = entity.Currency(currency)
+	p.DeletedAt = deletedAt.Time
+	return p, nil
 }
 
 func productPage(products []entity.Product, limit int) entity.ProductPage {
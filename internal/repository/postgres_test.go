@@ -4,23 +4,61 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net"
+	"slices"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/alkmc/storefront/internal/config"
 	"github.com/alkmc/storefront/internal/entity"
 	"github.com/alkmc/storefront/internal/migrate"
+	"github.com/alkmc/storefront/internal/repository/repotest"
+	"github.com/alkmc/storefront/internal/reqctx"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
 
-func setupTestContainerDB(t *testing.T) (*Repository, func()) {
+// forEachDriver runs fn against a fresh database once per supported driver.
+func forEachDriver(t *testing.T, fn func(t *testing.T, repo *Repository)) {
+	t.Helper()
+	for _, driver := range []string{DriverStdlib, DriverPGXPool} {
+		t.Run(driver, func(t *testing.T) {
+			repo, cleanup := setupTestContainerDB(t, driver)
+			defer cleanup()
+			fn(t, repo)
+		})
+	}
+}
+
+func setupTestContainerDB(t *testing.T, driver string) (*Repository, func()) {
+	t.Helper()
+
+	pgConfig, terminate := startTestContainer(t, driver)
+	repo, err := New(t.Context(), slog.New(slog.DiscardHandler), pgConfig)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
+	}
+
+	cleanup := func() {
+		repo.Close()
+		terminate()
+	}
+
+	return repo, cleanup
+}
+
+// startTestContainer starts a migrated postgres container and returns its settings.
+func startTestContainer(t *testing.T, driver string) (config.Postgres, func()) {
 	t.Helper()
 	ctx := t.Context()
 
@@ -64,6 +102,14 @@ func setupTestContainerDB(t *testing.T) (*Repository, func()) {
 		MaxOpenConns:    5,
 		MaxIdleConns:    2,
 		ConnMaxLifetime: 5 * time.Minute,
+
+		Driver:           driver,
+		StatementTimeout: 5 * time.Second,
+		ApplicationName:  "storefront-test",
+
+		RetryAttempts:  3,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  10 * time.Millisecond,
 	}
 
 	pgxCfg, err := pgx.ParseConfig(pgConfig.DSN())
@@ -71,327 +117,968 @@ func setupTestContainerDB(t *testing.T) (*Repository, func()) {
 		t.Fatalf("failed to parse pg config: %v", err)
 	}
 	migrationDB := stdlib.OpenDB(*pgxCfg)
-	if err := migrate.Up(ctx, migrationDB); err != nil {
+	if err := migrate.Up(ctx, migrationDB, migrate.DialectPostgres); err != nil {
 		t.Fatalf("failed to apply migrations: %v", err)
 	}
 	if err := migrationDB.Close(); err != nil {
 		t.Fatalf("failed to close migration db: %v", err)
 	}
 
-	logger := slog.New(slog.DiscardHandler)
-	repo, err := NewPG(ctx, logger, pgConfig)
-	if err != nil {
-		t.Fatalf("failed to create repo: %v", err)
-	}
-
-	cleanup := func() {
-		repo.Close()
+	terminate := func() {
 		if err := pgContainer.Terminate(context.Background()); err != nil {
 			t.Fatalf("failed to terminate pg container: %v", err)
 		}
 	}
 
-	return repo, cleanup
+	return pgConfig, terminate
 }
 
+const queryTruncateAll = `
+	TRUNCATE products, product_audit, import_jobs, import_rejects, import_sources RESTART IDENTITY;`
+
 func testMoney(amount int64) entity.Money {
 	return entity.Money{MinorAmount: amount, Currency: entity.CurrencyPLN}
 }
 
+// TestRepository_Conformance runs the suite the in-memory store passes as well,
+// emptying the tables before every case.
+func TestRepository_Conformance(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		repotest.Run(t, func(t *testing.T) repotest.Repository {
+			t.Helper()
+			if _, err := repo.db.exec(t.Context(), queryTruncateAll); err != nil {
+				t.Fatalf("failed to truncate tables: %v", err)
+			}
+			return repo
+		})
+	})
+}
+
 func TestRepository_Save(t *testing.T) {
-	repo, cleanup := setupTestContainerDB(t)
-	defer cleanup()
-	ctx := t.Context()
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
 
-	tests := []struct {
-		name    string
-		product entity.Product
-		wantErr bool
-	}{
-		{
-			name:    "success",
-			product: entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Car", Price: testMoney(1050)},
-			wantErr: false,
-		},
-		{
-			name:    "negative price - fails check constraint",
-			product: entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Bike", Price: testMoney(-500)},
-			wantErr: true,
-		},
-		{
-			name: "invalid currency - fails check constraint",
-			product: entity.Product{
-				ID:    uuid.Must(uuid.NewV7()),
-				Name:  "Bike",
-				Price: entity.Money{MinorAmount: 500, Currency: entity.Currency("XXX")},
+		tests := []struct {
+			name    string
+			product entity.Product
+			wantErr bool
+		}{
+			{
+				name:    "success",
+				product: entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Car", Price: testMoney(1050)},
+				wantErr: false,
 			},
-			wantErr: true,
-		},
-	}
+			{
+				name:    "negative price - fails check constraint",
+				product: entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Bike", Price: testMoney(-500)},
+				wantErr: true,
+			},
+			{
+				name: "invalid currency - fails check constraint",
+				product: entity.Product{
+					ID:    uuid.Must(uuid.NewV7()),
+					Name:  "Bike",
+					Price: entity.Money{MinorAmount: 500, Currency: entity.Currency("XXX")},
+				},
+				wantErr: true,
+			},
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			_, err := repo.Save(ctx, tt.product)
-			if tt.wantErr {
-				if err == nil {
-					t.Fatalf("expected error")
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := repo.Save(ctx, tt.product)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("expected error")
+					}
+					return
 				}
-				return
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+		}
+
+		t.Run("duplicate id", func(t *testing.T) {
+			seededID := uuid.Must(uuid.NewV7())
+			if _, err := repo.Save(
+				ctx, entity.Product{ID: seededID, Name: "Boat", Price: testMoney(1000)},
+			); err != nil {
+				t.Fatalf("failed to save setup product: %v", err)
 			}
-			if err != nil {
-				t.Fatalf("unexpected error: %v", err)
+
+			if _, err := repo.Save(
+				ctx, entity.Product{ID: seededID, Name: "Plane", Price: testMoney(10000)},
+			); err == nil {
+				t.Fatal("expected error")
 			}
 		})
-	}
+	})
+}
+
+func TestRepository_FindByID(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
 
-	t.Run("duplicate id", func(t *testing.T) {
-		seededID := uuid.Must(uuid.NewV7())
+		id := uuid.Must(uuid.NewV7())
 		if _, err := repo.Save(
-			ctx, entity.Product{ID: seededID, Name: "Boat", Price: testMoney(1000)},
+			ctx, entity.Product{ID: id, Name: "Car", Price: testMoney(1050)},
 		); err != nil {
-			t.Fatalf("failed to save setup product: %v", err)
+			t.Fatalf("failed to save product: %v", err)
+		}
+
+		tests := []struct {
+			name    string
+			id      uuid.UUID
+			wantErr bool
+		}{
+			{
+				name:    "existing product",
+				id:      id,
+				wantErr: false,
+			},
+			{
+				name:    "non-existing product",
+				id:      uuid.Must(uuid.NewV7()),
+				wantErr: true,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				p, err := repo.FindByID(ctx, tt.id)
+				if tt.wantErr {
+					if !errors.Is(err, entity.ErrNotFound) {
+						t.Fatalf("expected entity.ErrNotFound, got %v", err)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if p.ID != tt.id {
+					t.Errorf("got %v, want %v", p.ID, tt.id)
+				}
+			})
+		}
+	})
+}
+
+func TestRepository_FindAll(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
+
+		page, err := repo.FindAll(ctx, uuid.NullUUID{}, 50, entity.ProductFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(page.Items) != 0 {
+			t.Errorf("expected 0 products, got %d", len(page.Items))
+		}
+		if page.HasMore {
+			t.Error("expected HasMore=false on empty table")
+		}
+
+		p1 := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "P1", Price: testMoney(100)}
+		if _, err := repo.Save(ctx, p1); err != nil {
+			t.Fatalf("failed to save product 1: %v", err)
+		}
+		p2 := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "P2", Price: testMoney(200)}
+		if _, err := repo.Save(ctx, p2); err != nil {
+			t.Fatalf("failed to save product 2: %v", err)
+		}
+
+		page, err = repo.FindAll(ctx, uuid.NullUUID{}, 50, entity.ProductFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(page.Items) != 2 {
+			t.Errorf("expected 2 products, got %d", len(page.Items))
+		}
+		if page.HasMore {
+			t.Error("expected HasMore=false when page is not full")
+		}
+
+		// First keyset page: limit 1 yields p1 and signals more.
+		first, err := repo.FindAll(ctx, uuid.NullUUID{}, 1, entity.ProductFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(first.Items) != 1 || first.Items[0].ID != p1.ID {
+			t.Fatalf("expected [p1], got %+v", first.Items)
+		}
+		if !first.HasMore {
+			t.Error("expected HasMore=true on full first page")
+		}
+
+		// Second keyset page: cursor at p1 yields p2 and ends the stream.
+		cursor := uuid.NullUUID{UUID: first.Items[0].ID, Valid: true}
+		second, err := repo.FindAll(ctx, cursor, 1, entity.ProductFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(second.Items) != 1 || second.Items[0].ID != p2.ID {
+			t.Fatalf("expected [p2], got %+v", second.Items)
+		}
+		if second.HasMore {
+			t.Error("expected HasMore=false on last page")
+		}
+
+		// Cursor at the last product yields an empty final page.
+		cursor = uuid.NullUUID{UUID: p2.ID, Valid: true}
+		empty, err := repo.FindAll(ctx, cursor, 1, entity.ProductFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(empty.Items) != 0 {
+			t.Fatalf("expected empty page, got %+v", empty.Items)
 		}
+		if empty.HasMore {
+			t.Error("expected HasMore=false after last product")
+		}
+	})
+}
 
+func TestRepository_Update(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
+
+		id := uuid.Must(uuid.NewV7())
 		if _, err := repo.Save(
-			ctx, entity.Product{ID: seededID, Name: "Plane", Price: testMoney(10000)},
-		); err == nil {
-			t.Fatal("expected error")
+			ctx, entity.Product{ID: id, Name: "OldName", Price: testMoney(1000)},
+		); err != nil {
+			t.Fatalf("failed to save product: %v", err)
+		}
+
+		tests := []struct {
+			name      string
+			product   entity.Product
+			wantErr   bool
+			wantErrIs error
+		}{
+			{
+				name:    "success",
+				product: entity.Product{ID: id, Name: "NewName", Price: testMoney(2000)},
+				wantErr: false,
+			},
+			{
+				name:    "negative price - fails check constraint",
+				product: entity.Product{ID: id, Name: "NewName", Price: testMoney(-100)},
+				wantErr: true,
+			},
+			{
+				name:      "non-existing product returns ErrNotFound",
+				product:   entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Ghost", Price: testMoney(100)},
+				wantErr:   true,
+				wantErrIs: entity.ErrNotFound,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := repo.Update(ctx, tt.product)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("expected error")
+					}
+					if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+						t.Fatalf("expected %v, got %v", tt.wantErrIs, err)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				p, err := repo.FindByID(ctx, tt.product.ID)
+				if err != nil {
+					t.Fatalf("failed to fetch updated product: %v", err)
+				}
+				if p.Name != tt.product.Name || p.Price != tt.product.Price {
+					t.Errorf("update failed: got %+v", p)
+				}
+			})
 		}
 	})
 }
 
-func TestRepository_FindByID(t *testing.T) {
-	repo, cleanup := setupTestContainerDB(t)
-	defer cleanup()
-	ctx := t.Context()
+func TestRepository_Delete(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
 
-	id := uuid.Must(uuid.NewV7())
-	if _, err := repo.Save(
-		ctx, entity.Product{ID: id, Name: "Car", Price: testMoney(1050)},
-	); err != nil {
-		t.Fatalf("failed to save product: %v", err)
-	}
+		id := uuid.Must(uuid.NewV7())
+		if _, err := repo.Save(
+			ctx, entity.Product{ID: id, Name: "ToDelete", Price: testMoney(1000)},
+		); err != nil {
+			t.Fatalf("failed to save product: %v", err)
+		}
 
-	tests := []struct {
-		name    string
-		id      uuid.UUID
-		wantErr bool
-	}{
-		{
-			name:    "existing product",
-			id:      id,
-			wantErr: false,
-		},
-		{
-			name:    "non-existing product",
-			id:      uuid.Must(uuid.NewV7()),
-			wantErr: true,
-		},
-	}
+		tests := []struct {
+			name    string
+			id      uuid.UUID
+			wantErr bool
+		}{
+			{
+				name:    "success",
+				id:      id,
+				wantErr: false,
+			},
+			{
+				name:    "non-existing product returns ErrNotFound",
+				id:      uuid.Must(uuid.NewV7()),
+				wantErr: true,
+			},
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p, err := repo.FindByID(ctx, tt.id)
-			if tt.wantErr {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := repo.Delete(ctx, tt.id)
+				if tt.wantErr {
+					if !errors.Is(err, entity.ErrNotFound) {
+						t.Fatalf("expected entity.ErrNotFound, got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				_, err = repo.FindByID(ctx, tt.id)
 				if !errors.Is(err, entity.ErrNotFound) {
-					t.Fatalf("expected entity.ErrNotFound, got %v", err)
+					t.Fatalf("expected entity.ErrNotFound after deletion, got %v", err)
 				}
-				return
+			})
+		}
+	})
+}
+
+func TestRepository_SoftDelete(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
+
+		id := uuid.Must(uuid.NewV7())
+		if _, err := repo.Save(
+			ctx, entity.Product{ID: id, Name: "Tombstoned", Price: testMoney(1000)},
+		); err != nil {
+			t.Fatalf("failed to save product: %v", err)
+		}
+		if err := repo.Delete(ctx, id); err != nil {
+			t.Fatalf("failed to delete product: %v", err)
+		}
+
+		if err := repo.Delete(ctx, id); !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("expected entity.ErrNotFound on second delete, got %v", err)
+		}
+		if err := repo.Update(
+			ctx, entity.Product{ID: id, Name: "Zombie", Price: testMoney(1)},
+		); !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("expected entity.ErrNotFound updating deleted product, got %v", err)
+		}
+
+		live, err := repo.FindAll(ctx, uuid.NullUUID{}, 50, entity.ProductFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(live.Items) != 0 {
+			t.Fatalf("expected deleted product hidden, got %+v", live.Items)
+		}
+
+		all, err := repo.FindAll(ctx, uuid.NullUUID{}, 50, entity.ProductFilter{IncludeDeleted: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(all.Items) != 1 || !all.Items[0].Deleted() {
+			t.Fatalf("expected one tombstoned product, got %+v", all.Items)
+		}
+
+		restored, err := repo.Restore(ctx, id)
+		if err != nil {
+			t.Fatalf("failed to restore product: %v", err)
+		}
+		if restored.ID != id || restored.Deleted() {
+			t.Fatalf("unexpected restored product: %+v", restored)
+		}
+		if _, err := repo.FindByID(ctx, id); err != nil {
+			t.Fatalf("expected restored product to be visible, got %v", err)
+		}
+		if _, err := repo.Restore(ctx, id); !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("expected entity.ErrNotFound restoring live product, got %v", err)
+		}
+	})
+}
+
+func TestRepository_Purge(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
+
+		deleted := uuid.Must(uuid.NewV7())
+		live := uuid.Must(uuid.NewV7())
+		for _, id := range []uuid.UUID{deleted, live} {
+			if _, err := repo.Save(
+				ctx, entity.Product{ID: id, Name: "Purgeable", Price: testMoney(1000)},
+			); err != nil {
+				t.Fatalf("failed to save product: %v", err)
+			}
+		}
+		if err := repo.Delete(ctx, deleted); err != nil {
+			t.Fatalf("failed to delete product: %v", err)
+		}
+
+		n, err := repo.Purge(ctx, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 0 {
+			t.Fatalf("expected fresh tombstone to survive retention, purged %d", n)
+		}
+
+		n, err = repo.Purge(ctx, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 1 {
+			t.Fatalf("expected 1 purged product, got %d", n)
+		}
+		if _, err := repo.Restore(ctx, deleted); !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("expected purged product to be gone, got %v", err)
+		}
+		if _, err := repo.FindByID(ctx, live); err != nil {
+			t.Fatalf("expected live product to survive purge, got %v", err)
+		}
+	})
+}
+
+func TestRepository_Audit(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := reqctx.WithRequestID(reqctx.WithActor(t.Context(), "alice"), "req-1")
+
+		id := uuid.Must(uuid.NewV7())
+		if _, err := repo.Save(ctx, entity.Product{ID: id, Name: "Car", Price: testMoney(1000)}); err != nil {
+			t.Fatalf("failed to save product: %v", err)
+		}
+		if err := repo.Update(ctx, entity.Product{ID: id, Name: "Car", Price: testMoney(1500)}); err != nil {
+			t.Fatalf("failed to update product: %v", err)
+		}
+		if err := repo.Delete(ctx, id); err != nil {
+			t.Fatalf("failed to delete product: %v", err)
+		}
+		if _, err := repo.Restore(ctx, id); err != nil {
+			t.Fatalf("failed to restore product: %v", err)
+		}
+		if err := repo.Update(
+			ctx, entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Ghost", Price: testMoney(1)},
+		); !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("expected entity.ErrNotFound, got %v", err)
+		}
+
+		first, err := repo.History(ctx, id, 0, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		wantActions := []entity.AuditAction{entity.AuditRestore, entity.AuditDelete, entity.AuditUpdate}
+		if len(first.Items) != len(wantActions) || !first.HasMore {
+			t.Fatalf("expected 3 entries and more, got %+v", first)
+		}
+		for i, want := range wantActions {
+			e := first.Items[i]
+			if e.Action != want || e.Actor != "alice" || e.RequestID != "req-1" {
+				t.Errorf("entry %d: got %+v, want action %s by alice", i, e, want)
 			}
+		}
+		update := first.Items[2]
+		if len(update.Changes) != 1 || update.Changes[0].Field != "price.minorAmount" {
+			t.Fatalf("expected price diff, got %+v", update.Changes)
+		}
+		if got := fmt.Sprint(update.Changes[0].Before, update.Changes[0].After); got != "1000 1500" {
+			t.Errorf("got price diff %s, want 1000 1500", got)
+		}
 
+		second, err := repo.History(ctx, id, first.Items[2].ID, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(second.Items) != 1 || second.Items[0].Action != entity.AuditCreate || second.HasMore {
+			t.Fatalf("expected only the create entry, got %+v", second)
+		}
+
+		var exported []entity.AuditAction
+		for e, err := range repo.AuditLog(ctx, time.Time{}) {
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
-			if p.ID != tt.id {
-				t.Errorf("got %v, want %v", p.ID, tt.id)
+			exported = append(exported, e.Action)
+		}
+		wantExport := []entity.AuditAction{
+			entity.AuditCreate, entity.AuditUpdate, entity.AuditDelete, entity.AuditRestore,
+		}
+		if !slices.Equal(exported, wantExport) {
+			t.Errorf("got exported actions %v, want %v", exported, wantExport)
+		}
+	})
+}
+
+func TestRepository_SaveAll(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
+
+		products := []entity.Product{
+			{ID: uuid.Must(uuid.NewV7()), Name: "B1", Price: testMoney(100)},
+			{ID: uuid.Must(uuid.NewV7()), Name: "B2", Price: testMoney(200)},
+		}
+		if err := repo.SaveAll(ctx, products); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, p := range products {
+			if _, err := repo.FindByID(ctx, p.ID); err != nil {
+				t.Fatalf("expected product %s to be saved, got %v", p.ID, err)
 			}
+		}
+
+		// A failing row rolls back the whole batch.
+		dup := []entity.Product{
+			{ID: uuid.Must(uuid.NewV7()), Name: "B3", Price: testMoney(300)},
+			products[0],
+		}
+		if err := repo.SaveAll(ctx, dup); err == nil {
+			t.Fatal("expected duplicate id error")
+		}
+		if _, err := repo.FindByID(ctx, dup[0].ID); !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("expected batch rollback, got %v", err)
+		}
+	})
+}
+
+func TestRepository_SessionSettings(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		var timeout, appName string
+		if err := repo.db.queryRow(
+			t.Context(), "SELECT current_setting('statement_timeout'), current_setting('application_name');",
+		).Scan(&timeout, &appName); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if timeout != "5s" || appName != "storefront-test" {
+			t.Errorf("got statement_timeout=%q application_name=%q", timeout, appName)
+		}
+		if stats := repo.Stats(); stats.MaxConns != 5 {
+			t.Errorf("got max conns %d, want 5", stats.MaxConns)
+		}
+	})
+}
+
+// TestRepository_TenantIsolation checks that row level security, not only the repository's
+// queries, keeps tenants apart: raw statements in a transaction scoped to another tenant
+// neither see nor change the rows.
+func TestRepository_TenantIsolation(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		acme := reqctx.WithTenant(t.Context(), "acme")
+		p := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Car", Price: testMoney(100)}
+		if _, err := repo.Save(acme, p); err != nil {
+			t.Fatalf("failed to save product: %v", err)
+		}
+
+		globex := reqctx.WithTenant(t.Context(), "globex")
+		err := repo.inTx(globex, func(tx dbTx) error {
+			var n int
+			if err := tx.queryRow(globex, "SELECT count(*) FROM products;").Scan(&n); err != nil {
+				return err
+			}
+			if n != 0 {
+				t.Errorf("expected no visible products, got %d", n)
+			}
+			deleted, err := tx.exec(globex, "DELETE FROM products;")
+			if err != nil {
+				return err
+			}
+			if deleted != 0 {
+				t.Errorf("expected nothing deleted, got %d", deleted)
+			}
+			return nil
 		})
-	}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, err := repo.FindByID(acme, p.ID); err != nil || got.ID != p.ID {
+			t.Errorf("expected the product to survive, got %+v, %v", got, err)
+		}
+	})
 }
 
-func TestRepository_FindAll(t *testing.T) {
-	repo, cleanup := setupTestContainerDB(t)
-	defer cleanup()
-	ctx := t.Context()
+func TestRepository_Import(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := reqctx.WithActor(t.Context(), "importer")
 
-	page, err := repo.FindAll(ctx, uuid.NullUUID{}, 50)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-	if len(page.Items) != 0 {
-		t.Errorf("expected 0 products, got %d", len(page.Items))
-	}
-	if page.HasMore {
-		t.Error("expected HasMore=false on empty table")
-	}
+		existing := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Old", Price: testMoney(100)}
+		deleted := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Gone", Price: testMoney(100)}
+		for _, p := range []entity.Product{existing, deleted} {
+			if _, err := repo.Save(ctx, p); err != nil {
+				t.Fatalf("failed to save product: %v", err)
+			}
+		}
+		if err := repo.Delete(ctx, deleted.ID); err != nil {
+			t.Fatalf("failed to delete product: %v", err)
+		}
 
-	p1 := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "P1", Price: testMoney(100)}
-	if _, err := repo.Save(ctx, p1); err != nil {
-		t.Fatalf("failed to save product 1: %v", err)
-	}
-	p2 := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "P2", Price: testMoney(200)}
-	if _, err := repo.Save(ctx, p2); err != nil {
-		t.Fatalf("failed to save product 2: %v", err)
-	}
+		job, err := repo.CreateImportJob(ctx, entity.ImportJob{
+			ID: uuid.Must(uuid.NewV7()), Format: entity.ImportCSV, Origin: entity.ImportOriginAPI,
+			Source: "/tmp/p.csv", Status: entity.ImportPending, Actor: "importer",
+		})
+		if err != nil {
+			t.Fatalf("failed to create job: %v", err)
+		}
+		claimed, err := repo.ClaimImportJob(ctx, entity.ImportOriginAPI, time.Minute)
+		if err != nil || claimed.ID != job.ID || claimed.Status != entity.ImportRunning {
+			t.Fatalf("expected to claim the job, got %+v, %v", claimed, err)
+		}
+		_, err = repo.ClaimImportJob(ctx, entity.ImportOriginAPI, time.Minute)
+		if !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("a freshly claimed job must not be claimed again, got %v", err)
+		}
 
-	page, err = repo.FindAll(ctx, uuid.NullUUID{}, 50)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-	if len(page.Items) != 2 {
-		t.Errorf("expected 2 products, got %d", len(page.Items))
-	}
-	if page.HasMore {
-		t.Error("expected HasMore=false when page is not full")
-	}
+		created := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "New", Price: testMoney(300)}
+		chunk := entity.ImportChunk{
+			JobID:    job.ID,
+			LastLine: 5,
+			Rows: []entity.ImportRow{
+				{Line: 2, Product: created},
+				{Line: 3, Product: entity.Product{ID: existing.ID, Name: "Renamed", Price: testMoney(100)}},
+				{Line: 4, Product: deleted},
+			},
+			Rejects: []entity.ImportReject{{Line: 5, Reason: "the product name is empty"}},
+		}
+		advanced, updated, err := repo.ImportChunk(ctx, chunk)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if advanced.Line != 5 || advanced.Accepted != 2 || advanced.Rejected != 2 {
+			t.Errorf("got job %+v, want checkpoint 5 with 2 accepted and 2 rejected", advanced)
+		}
+		if !slices.Equal(updated, []uuid.UUID{existing.ID}) {
+			t.Errorf("got updated %v, want only %s", updated, existing.ID)
+		}
+		if _, _, err := repo.ImportChunk(ctx, chunk); !errors.Is(err, entity.ErrImportCheckpoint) {
+			t.Errorf("replaying a chunk must fail with ErrImportCheckpoint, got %v", err)
+		}
 
-	// First keyset page: limit 1 yields p1 and signals more.
-	first, err := repo.FindAll(ctx, uuid.NullUUID{}, 1)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-	if len(first.Items) != 1 || first.Items[0].ID != p1.ID {
-		t.Fatalf("expected [p1], got %+v", first.Items)
+		got, err := repo.FindByID(ctx, existing.ID)
+		if err != nil || got.Name != "Renamed" {
+			t.Errorf("expected existing product to be updated, got %+v, %v", got, err)
+		}
+		if _, err := repo.FindByID(ctx, created.ID); err != nil {
+			t.Errorf("expected new product to be inserted, got %v", err)
+		}
+		if _, err := repo.FindByID(ctx, deleted.ID); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("tombstoned product must stay deleted, got %v", err)
+		}
+
+		history, err := repo.History(ctx, existing.ID, 0, 1)
+		if err != nil || len(history.Items) != 1 {
+			t.Fatalf("unexpected history %+v, %v", history, err)
+		}
+		if e := history.Items[0]; e.Action != entity.AuditUpdate || e.Actor != "importer" || len(e.Changes) != 1 {
+			t.Errorf("got audit entry %+v, want a name update by importer", e)
+		}
+
+		rejects, err := repo.ImportRejects(ctx, job.ID, 0, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(rejects.Items) != 1 || rejects.Items[0].Line != 4 || !rejects.HasMore {
+			t.Errorf("got rejects %+v, want line 4 first", rejects)
+		}
+		rejects, err = repo.ImportRejects(ctx, job.ID, 4, 10)
+		if err != nil || len(rejects.Items) != 1 || rejects.Items[0].Line != 5 || rejects.HasMore {
+			t.Errorf("got rejects %+v, %v, want only line 5", rejects, err)
+		}
+
+		if err := repo.FinishImportJob(ctx, job.ID, entity.ImportCompleted, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = repo.ResumeImportJob(ctx, job.ID, entity.ImportOriginAPI, entity.ImportPending)
+		if !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("completed jobs must not resume, got %v", err)
+		}
+	})
+}
+
+func TestRepository_Export(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
+
+		// Span more than one FETCH so the cursor loop is exercised.
+		ps := make([]entity.Product, exportFetchSize+1)
+		for i := range ps {
+			ps[i] = entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "P", Price: testMoney(int64(i))}
+		}
+		ps[0].Price.Currency = entity.CurrencyEUR
+		if err := repo.SaveAll(ctx, ps); err != nil {
+			t.Fatalf("failed to save products: %v", err)
+		}
+		if err := repo.Delete(ctx, ps[1].ID); err != nil {
+			t.Fatalf("failed to delete product: %v", err)
+		}
+
+		count := func(f entity.ProductFilter) int {
+			t.Helper()
+			n := 0
+			var last uuid.UUID
+			for p, err := range repo.Export(ctx, f) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if p.ID.String() <= last.String() {
+					t.Fatalf("export out of id order: %s after %s", p.ID, last)
+				}
+				last = p.ID
+				n++
+			}
+			return n
+		}
+		if got := count(entity.ProductFilter{}); got != len(ps)-1 {
+			t.Errorf("expected %d live products, got %d", len(ps)-1, got)
+		}
+		if got := count(entity.ProductFilter{IncludeDeleted: true}); got != len(ps) {
+			t.Errorf("expected %d products, got %d", len(ps), got)
+		}
+		if got := count(entity.ProductFilter{Currency: entity.CurrencyEUR}); got != 1 {
+			t.Errorf("expected 1 EUR product, got %d", got)
+		}
+
+		// Stopping early releases the transaction; the pool must stay usable.
+		for range repo.Export(ctx, entity.ProductFilter{}) {
+			break
+		}
+		if _, err := repo.FindByID(ctx, ps[0].ID); err != nil {
+			t.Fatalf("unexpected error after early stop: %v", err)
+		}
+	})
+}
+
+func TestRepository_Replicas(t *testing.T) {
+	ctx := t.Context()
+	pgConfig, terminate := startTestContainer(t, DriverPGXPool)
+	defer terminate()
+
+	// The primary doubles as a zero-lag replica; the second address refuses connections.
+	pgConfig.ReplicaHosts = []string{
+		net.JoinHostPort(pgConfig.Host, strconv.Itoa(pgConfig.Port)),
+		net.JoinHostPort(pgConfig.Host, "1"),
 	}
-	if !first.HasMore {
-		t.Error("expected HasMore=true on full first page")
+	pgConfig.ReplicaMaxLag = time.Second
+	pgConfig.ReplicaCheckInterval = time.Minute
+	repo, err := New(ctx, slog.New(slog.DiscardHandler), pgConfig)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
 	}
+	defer repo.Close()
 
-	// Second keyset page: cursor at p1 yields p2 and ends the stream.
-	cursor := uuid.NullUUID{UUID: first.Items[0].ID, Valid: true}
-	second, err := repo.FindAll(ctx, cursor, 1)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	statuses := repo.Replicas()
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 replica statuses, got %d", len(statuses))
 	}
-	if len(second.Items) != 1 || second.Items[0].ID != p2.ID {
-		t.Fatalf("expected [p2], got %+v", second.Items)
+	if !statuses[0].Available || statuses[0].Lag != 0 {
+		t.Errorf("expected first replica available without lag, got %+v", statuses[0])
 	}
-	if second.HasMore {
-		t.Error("expected HasMore=false on last page")
+	if statuses[1].Available || statuses[1].Error == "" {
+		t.Errorf("expected second replica unavailable with a reason, got %+v", statuses[1])
 	}
 
-	// Cursor at the last product yields an empty final page.
-	cursor = uuid.NullUUID{UUID: p2.ID, Valid: true}
-	empty, err := repo.FindAll(ctx, cursor, 1)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	p := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "P", Price: testMoney(100)}
+	if _, err := repo.Save(ctx, p); err != nil {
+		t.Fatalf("failed to save product: %v", err)
 	}
-	if len(empty.Items) != 0 {
-		t.Fatalf("expected empty page, got %+v", empty.Items)
+	for _, ctx := range []context.Context{ctx, reqctx.WithReadYourWrites(ctx)} {
+		for range 4 {
+			if _, err := repo.FindByID(ctx, p.ID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		page, err := repo.FindAll(ctx, uuid.NullUUID{}, 10, entity.ProductFilter{})
+		if err != nil || len(page.Items) != 1 {
+			t.Fatalf("expected 1 product, got %d: %v", len(page.Items), err)
+		}
 	}
-	if empty.HasMore {
-		t.Error("expected HasMore=false after last product")
+	if _, err := repo.FindByID(ctx, uuid.Must(uuid.NewV7())); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound from replica read, got %v", err)
 	}
 }
 
-func TestRepository_Update(t *testing.T) {
-	repo, cleanup := setupTestContainerDB(t)
-	defer cleanup()
-	ctx := t.Context()
+func TestRepository_ConstraintErrors(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
 
-	id := uuid.Must(uuid.NewV7())
-	if _, err := repo.Save(
-		ctx, entity.Product{ID: id, Name: "OldName", Price: testMoney(1000)},
-	); err != nil {
-		t.Fatalf("failed to save product: %v", err)
-	}
+		p := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "P", Price: testMoney(100)}
+		if _, err := repo.Save(ctx, p); err != nil {
+			t.Fatalf("failed to save product: %v", err)
+		}
+		_, err := repo.Save(ctx, p)
+		assertConstraintError(t, err, entity.ErrConflict, entity.FieldID)
+
+		free := entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "P", Price: testMoney(0)}
+		_, err = repo.Save(ctx, free)
+		assertConstraintError(t, err, entity.ErrConstraintViolation, entity.FieldPriceAmount)
+
+		p.Price.Currency = "XXX"
+		err = repo.Update(ctx, p)
+		assertConstraintError(t, err, entity.ErrConstraintViolation, entity.FieldPriceCurrency)
+	})
+}
 
-	tests := []struct {
-		name      string
-		product   entity.Product
-		wantErr   bool
-		wantErrIs error
-	}{
-		{
-			name:    "success",
-			product: entity.Product{ID: id, Name: "NewName", Price: testMoney(2000)},
-			wantErr: false,
-		},
-		{
-			name:    "negative price - fails check constraint",
-			product: entity.Product{ID: id, Name: "NewName", Price: testMoney(-100)},
-			wantErr: true,
-		},
-		{
-			name:      "non-existing product returns ErrNotFound",
-			product:   entity.Product{ID: uuid.Must(uuid.NewV7()), Name: "Ghost", Price: testMoney(100)},
-			wantErr:   true,
-			wantErrIs: entity.ErrNotFound,
-		},
+func assertConstraintError(t *testing.T, err, kind error, field string) {
+	t.Helper()
+	ce, ok := errors.AsType[*entity.ConstraintError](err)
+	if !ok || !errors.Is(err, kind) {
+		t.Errorf("expected a ConstraintError wrapping %v, got %v", kind, err)
+		return
+	}
+	if ce.Field != field {
+		t.Errorf("expected field %q, got %q", field, ce.Field)
 	}
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := repo.Update(ctx, tt.product)
-			if tt.wantErr {
-				if err == nil {
-					t.Fatalf("expected error")
-				}
-				if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
-					t.Fatalf("expected %v, got %v", tt.wantErrIs, err)
-				}
-				return
+func TestRepository_WithinTx(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
+		errAbort := errors.New("abort")
+		newProduct := func(name string) entity.Product {
+			return entity.Product{ID: uuid.Must(uuid.NewV7()), Name: name, Price: testMoney(100)}
+		}
+		exists := func(p entity.Product) bool {
+			t.Helper()
+			_, err := repo.FindByID(ctx, p.ID)
+			if err != nil && !errors.Is(err, entity.ErrNotFound) {
+				t.Fatalf("failed to find product: %v", err)
 			}
+			return err == nil
+		}
 
-			if err != nil {
-				t.Fatalf("unexpected error: %v", err)
+		// Calls sharing the context commit together, and hooks wait for the commit.
+		a, b := newProduct("A"), newProduct("B")
+		var hooks []string
+		err := repo.WithinTx(ctx, func(ctx context.Context) error {
+			if _, err := repo.Save(ctx, a); err != nil {
+				return err
 			}
-			p, err := repo.FindByID(ctx, tt.product.ID)
-			if err != nil {
-				t.Fatalf("failed to fetch updated product: %v", err)
+			if _, err := repo.FindByID(ctx, a.ID); err != nil {
+				return fmt.Errorf("uncommitted write not visible in the transaction: %w", err)
 			}
-			if p.Name != tt.product.Name || p.Price != tt.product.Price {
-				t.Errorf("update failed: got %+v", p)
+			if _, err := repo.Save(ctx, b); err != nil {
+				return err
 			}
+			repo.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "outer") })
+			if exists(a) {
+				t.Error("expected the write to stay invisible outside the transaction before commit")
+			}
+			return nil
 		})
-	}
-}
-
-func TestRepository_Delete(t *testing.T) {
-	repo, cleanup := setupTestContainerDB(t)
-	defer cleanup()
-	ctx := t.Context()
-
-	id := uuid.Must(uuid.NewV7())
-	if _, err := repo.Save(
-		ctx, entity.Product{ID: id, Name: "ToDelete", Price: testMoney(1000)},
-	); err != nil {
-		t.Fatalf("failed to save product: %v", err)
-	}
-
-	tests := []struct {
-		name    string
-		id      uuid.UUID
-		wantErr bool
-	}{
-		{
-			name:    "success",
-			id:      id,
-			wantErr: false,
-		},
-		{
-			name:    "non-existing product returns ErrNotFound",
-			id:      uuid.Must(uuid.NewV7()),
-			wantErr: true,
-		},
-	}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists(a) || !exists(b) {
+			t.Error("expected both products to be committed")
+		}
+		if !slices.Equal(hooks, []string{"outer"}) {
+			t.Errorf("expected the hook to run after commit, got %v", hooks)
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := repo.Delete(ctx, tt.id)
-			if tt.wantErr {
-				if !errors.Is(err, entity.ErrNotFound) {
-					t.Fatalf("expected entity.ErrNotFound, got %v", err)
+		// A failed savepoint rolls back only its own work and hooks.
+		c, d := newProduct("C"), newProduct("D")
+		hooks = nil
+		err = repo.WithinTx(ctx, func(ctx context.Context) error {
+			if _, err := repo.Save(ctx, c); err != nil {
+				return err
+			}
+			err := repo.WithinTx(ctx, func(ctx context.Context) error {
+				if _, err := repo.Save(ctx, d); err != nil {
+					return err
 				}
-				return
+				repo.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "inner") })
+				_, err := repo.Save(ctx, c)
+				return err
+			})
+			if !errors.Is(err, entity.ErrConflict) {
+				return fmt.Errorf("expected ErrConflict from the savepoint, got %w", err)
 			}
-			if err != nil {
-				t.Fatalf("unexpected error: %v", err)
+			repo.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "outer") })
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists(c) || exists(d) {
+			t.Error("expected the outer write committed and the savepoint's rolled back")
+		}
+		if !slices.Equal(hooks, []string{"outer"}) {
+			t.Errorf("expected only the outer hook, got %v", hooks)
+		}
+
+		// An error rolls back everything and drops the hooks.
+		e := newProduct("E")
+		hooks = nil
+		err = repo.WithinTx(ctx, func(ctx context.Context) error {
+			if _, err := repo.Save(ctx, e); err != nil {
+				return err
 			}
-			_, err = repo.FindByID(ctx, tt.id)
-			if !errors.Is(err, entity.ErrNotFound) {
-				t.Fatalf("expected entity.ErrNotFound after deletion, got %v", err)
+			repo.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "outer") })
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Errorf("expected errAbort, got %v", err)
+		}
+		if exists(e) || len(hooks) != 0 {
+			t.Errorf("expected a full rollback, got hooks %v", hooks)
+		}
+	})
+}
+
+func TestRepository_TxRetry(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, repo *Repository) {
+		ctx := t.Context()
+		serializable := txOptions{isolation: sql.LevelSerializable}
+
+		attempts := 0
+		err := repo.inTxWith(ctx, serializable, func(tx dbTx) error {
+			attempts++
+			if attempts < 3 {
+				return &pgconn.PgError{Code: sqlStateSerializationFailure}
 			}
+			_, err := tx.exec(ctx, "SELECT 1;")
+			return err
 		})
-	}
+		if err != nil || attempts != 3 {
+			t.Errorf("expected success on the third attempt, got %d attempts: %v", attempts, err)
+		}
+
+		attempts = 0
+		err = repo.inTxWith(ctx, txOptions{}, func(dbTx) error {
+			attempts++
+			return &pgconn.PgError{Code: sqlStateDeadlockDetected}
+		})
+		if attempts != repo.retry.attempts {
+			t.Errorf("expected %d attempts, got %d: %v", repo.retry.attempts, attempts, err)
+		}
+
+		// A non-transient error is returned at once.
+		attempts = 0
+		err = repo.inTxWith(ctx, txOptions{}, func(dbTx) error {
+			attempts++
+			return entity.ErrNotFound
+		})
+		if !errors.Is(err, entity.ErrNotFound) || attempts != 1 {
+			t.Errorf("expected one attempt with ErrNotFound, got %d: %v", attempts, err)
+		}
+
+		// No retry is started that the deadline would cut short.
+		slow := *repo
+		slow.retry = retryPolicy{attempts: 3, baseDelay: time.Hour, maxDelay: time.Hour}
+		deadlineCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		attempts = 0
+		_ = slow.inTxWith(deadlineCtx, txOptions{}, func(dbTx) error {
+			attempts++
+			return &pgconn.PgError{Code: sqlStateSerializationFailure}
+		})
+		if attempts != 1 {
+			t.Errorf("expected a single attempt within the deadline, got %d", attempts)
+		}
+	})
 }
@@ -1,29 +1,230 @@
 package repository
 
 const (
+	querySessionSettings = `
+		SELECT set_config('statement_timeout', $1, false),
+		       set_config('application_name', $2, false);`
+	// queryScopeTenant subjects the rest of the transaction to the tenant_isolation policies
+	// for tenant $1; see the tenant_isolation migration.
+	queryScopeTenant = `
+		SELECT set_config('role', 'storefront_tenant', true),
+		       set_config('app.tenant_id', $1, true);`
+
 	queryInsert = `
 		INSERT INTO products (id, name, price_minor, currency)
 		VALUES ($1, $2, $3, $4);`
 	queryGetByID = `
-		SELECT id, name, price_minor, currency
+		SELECT id, name, price_minor, currency, deleted_at
 		FROM products
-		WHERE id = $1;`
+		WHERE id = $1 AND deleted_at IS NULL;`
+	queryGetByIDs = `
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL;`
+	// queryRecentlyUpdated spans tenants; ties are broken by key so the order is stable.
+	queryRecentlyUpdated = `
+		SELECT tenant_id, id
+		FROM products
+		WHERE deleted_at IS NULL
+		ORDER BY updated_at DESC, tenant_id, id
+		LIMIT $1;`
+	queryGetForUpdate = `
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE id = $1
+		FOR UPDATE;`
 	queryGetAll = `
-		SELECT id, name, price_minor, currency
+		SELECT id, name, price_minor, currency, deleted_at
 		FROM products
+		WHERE (deleted_at IS NULL OR $2::boolean) AND ($3::text = '' OR currency = $3)
 		ORDER BY id
 		LIMIT $1;`
 	queryGetAllAfterCursor = `
-		SELECT id, name, price_minor, currency
+		SELECT id, name, price_minor, currency, deleted_at
 		FROM products
-		WHERE id > $1
+		WHERE id > $1 AND (deleted_at IS NULL OR $3::boolean) AND ($4::text = '' OR currency = $4)
 		ORDER BY id
 		LIMIT $2;`
+	// Lag is zero on a server that is not in recovery, or has replayed all the primary wrote
+	// up to position $1 of queryCurrentLSN, so an idle primary does not make its replicas look
+	// stale. A replica that stopped receiving falls behind as soon as the primary writes again,
+	// however much of what it did receive it has replayed.
+	queryCurrentLSN = `SELECT pg_current_wal_lsn()::text;`
+	queryReplicaLag = `
+		SELECT CASE
+			WHEN NOT pg_is_in_recovery() OR pg_last_wal_replay_lsn() >= $1::pg_lsn THEN 0
+			ELSE COALESCE((EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) * 1000)::bigint, 0)
+		END;`
+	queryDeclareExport = `
+		DECLARE product_export NO SCROLL CURSOR FOR
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE (deleted_at IS NULL OR $1::boolean) AND ($2::text = '' OR currency = $2)
+		ORDER BY id;`
 	queryUpdate = `
 		UPDATE products
-		SET name = $2, price_minor = $3, currency = $4
-		WHERE id = $1;`
+		SET name = $2, price_minor = $3, currency = $4, updated_at = now()
+		WHERE id = $1 AND deleted_at IS NULL;`
 	queryDelete = `
-		DELETE FROM products
+		UPDATE products
+		SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING deleted_at;`
+	queryRestore = `
+		UPDATE products
+		SET deleted_at = NULL, updated_at = now()
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, name, price_minor, currency, deleted_at;`
+	queryPurge = `
+		WITH purged AS (
+			DELETE FROM products
+			WHERE deleted_at < $1
+			RETURNING tenant_id, id
+		)
+		INSERT INTO product_audit (tenant_id, product_id, action, actor)
+		SELECT tenant_id, id, 'purge', $2
+		FROM purged;`
+
+	queryInsertAudit = `
+		INSERT INTO product_audit (product_id, action, actor, request_id, changes)
+		VALUES ($1, $2, $3, $4, $5);`
+	queryHistory = `
+		SELECT id, product_id, action, actor, request_id, occurred_at, changes
+		FROM product_audit
+		WHERE product_id = $1
+		ORDER BY id DESC
+		LIMIT $2;`
+	queryHistoryBeforeCursor = `
+		SELECT id, product_id, action, actor, request_id, occurred_at, changes
+		FROM product_audit
+		WHERE product_id = $1 AND id < $2
+		ORDER BY id DESC
+		LIMIT $3;`
+	queryAuditLog = `
+		SELECT id, product_id, action, actor, request_id, occurred_at, changes
+		FROM product_audit
+		WHERE occurred_at >= $1
+		ORDER BY id;`
+
+	queryInsertImportJob = `
+		INSERT INTO import_jobs (id, format, origin, source, status, actor, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING tenant_id, created_at, updated_at;`
+	queryGetImportJob = `
+		SELECT id, tenant_id, format, origin, source, status, line, accepted, rejected, error, actor,
+		       request_id, created_at, updated_at
+		FROM import_jobs
+		WHERE id = $1;`
+	// Running jobs count as abandoned once their owner stops advancing updated_at for $2 ms.
+	queryClaimImportJob = `
+		UPDATE import_jobs
+		SET status = 'running', updated_at = now()
+		WHERE id = (
+			SELECT id
+			FROM import_jobs
+			WHERE origin = $1
+			  AND (status = 'pending' OR (status = 'running' AND updated_at < now() - $2 * interval '1 millisecond'))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, tenant_id, format, origin, source, status, line, accepted, rejected, error, actor,
+		          request_id, created_at, updated_at;`
+	queryResumeImportJob = `
+		UPDATE import_jobs
+		SET status = $3, error = '', updated_at = now()
+		WHERE id = $1 AND origin = $2 AND status <> 'completed'
+		RETURNING id, tenant_id, format, origin, source, status, line, accepted, rejected, error, actor,
+		          request_id, created_at, updated_at;`
+	queryLockImportJob = `
+		SELECT line
+		FROM import_jobs
+		WHERE id = $1
+		FOR UPDATE;`
+	queryAdvanceImportJob = `
+		UPDATE import_jobs
+		SET line = $2, accepted = accepted + $3, rejected = rejected + $4, updated_at = now()
+		WHERE id = $1
+		RETURNING id, tenant_id, format, origin, source, status, line, accepted, rejected, error, actor,
+		          request_id, created_at, updated_at;`
+	queryFinishImportJob = `
+		UPDATE import_jobs
+		SET status = $2, error = $3, updated_at = now()
 		WHERE id = $1;`
+	// COPY cannot load tables under row-level security, so audit entries and rejects of
+	// an import chunk arrive as arrays instead.
+	queryInsertImportSource = `
+		INSERT INTO import_sources (job_id, part, data)
+		VALUES ($1, $2, $3);`
+	queryGetImportSource = `
+		SELECT data
+		FROM import_sources
+		WHERE job_id = $1 AND part = $2;`
+	queryDeleteImportSource = `
+		DELETE FROM import_sources
+		WHERE job_id = $1;`
+	queryInsertImportAudit = `
+		INSERT INTO product_audit (product_id, action, actor, request_id, changes)
+		SELECT product_id, action, $3, $4, changes::jsonb
+		FROM unnest($1::uuid[], $2::text[], $5::text[]) AS a (product_id, action, changes);`
+	queryInsertImportRejects = `
+		INSERT INTO import_rejects (job_id, line, reason)
+		SELECT $1, line, reason
+		FROM unnest($2::bigint[], $3::text[]) AS r (line, reason);`
+	queryImportRejects = `
+		SELECT line, reason
+		FROM import_rejects
+		WHERE job_id = $1 AND line > $2
+		ORDER BY line
+		LIMIT $3;`
+
+	// The staging table has no constraints so COPY never aborts halfway; rows are
+	// validated before loading and the merge enforces the real ones.
+	queryCreateImportStaging = `
+		CREATE TEMP TABLE import_staging (
+			line BIGINT NOT NULL,
+			id UUID NOT NULL,
+			name TEXT NOT NULL,
+			price_minor BIGINT NOT NULL,
+			currency TEXT NOT NULL
+		) ON COMMIT DROP;`
+	// queryMergeImport upserts the staged rows, the last line winning for repeated ids.
+	// Tombstoned products are left alone and do not come back in the result.
+	queryMergeImport = `
+		INSERT INTO products AS p (id, name, price_minor, currency)
+		SELECT DISTINCT ON (id) id, name, price_minor, currency
+		FROM import_staging
+		ORDER BY id, line DESC
+		ON CONFLICT (tenant_id, id) DO UPDATE
+		SET name = EXCLUDED.name, price_minor = EXCLUDED.price_minor, currency = EXCLUDED.currency,
+		    updated_at = now()
+		WHERE p.deleted_at IS NULL
+		RETURNING new.id, old.id IS NOT NULL,
+		          COALESCE(old.name, ''), COALESCE(old.price_minor, 0), COALESCE(old.currency, ''),
+		          new.name, new.price_minor, new.currency;`
+)
+
+// Scopes are read space-separated rather than as an array, which both drivers scan alike.
+const (
+	queryInsertAPIKey = `
+		INSERT INTO api_keys (id, name, hash, scopes, tier)
+		VALUES ($1, $2, $3, $4::text[], $5)
+		RETURNING tenant_id, created_at;`
+	// queryFindAPIKey spans tenants: the key is what names the tenant.
+	queryFindAPIKey = `
+		SELECT id, tenant_id, name, array_to_string(scopes, ' '), tier, last_used_at, revoked_at, created_at
+		FROM api_keys
+		WHERE hash = $1 AND revoked_at IS NULL;`
+	queryListAPIKeys = `
+		SELECT id, tenant_id, name, array_to_string(scopes, ' '), tier, last_used_at, revoked_at, created_at
+		FROM api_keys
+		ORDER BY created_at, id;`
+	queryRevokeAPIKey = `
+		UPDATE api_keys
+		SET revoked_at = now()
+		WHERE id = $1 AND revoked_at IS NULL;`
+	queryTouchAPIKey = `
+		UPDATE api_keys
+		SET last_used_at = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2);`
 )
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+)
+
+// replicaCheckTimeout bounds a single replica lag probe.
+const replicaCheckTimeout = 2 * time.Second
+
+type (
+	replica struct {
+		addr string
+		db   driver
+
+		mu     sync.RWMutex
+		status entity.ReplicaStatus
+	}
+	// replicaSet balances reads round-robin over the replicas that passed their last check.
+	replicaSet struct {
+		logger *slog.Logger
+		// primary is where the replicas replicate from; their lag is measured against it.
+		primary  querier
+		maxLag   time.Duration
+		interval time.Duration
+		all      []*replica
+		next     atomic.Uint64
+	}
+)
+
+// openReplicas opens a pool per configured replica and runs the first health check,
+// so reads are routed as soon as New returns. An unreachable replica is not fatal;
+// it stays out of rotation until a later check succeeds.
+func openReplicas(ctx context.Context, l *slog.Logger, cfg config.Postgres, primary querier,
+) (*replicaSet, error) {
+	if len(cfg.ReplicaHosts) == 0 {
+		return nil, nil
+	}
+	rs := &replicaSet{logger: l, primary: primary, maxLag: cfg.ReplicaMaxLag, interval: cfg.ReplicaCheckInterval}
+	for _, addr := range cfg.ReplicaHosts {
+		rcfg, err := cfg.Replica(addr)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		db, err := open(ctx, rcfg)
+		if err != nil {
+			rs.close()
+			return nil, fmt.Errorf("replica %s: %w", addr, err)
+		}
+		rs.all = append(rs.all, &replica{addr: addr, db: db, status: entity.ReplicaStatus{Addr: addr}})
+	}
+	rs.check(ctx)
+	return rs, nil
+}
+
+// read runs fn against an available replica, or against the primary when there is none,
+// when ctx asks for read-your-writes, or when the replica fails. Only errors that are not
+// about the data itself, such as a dropped connection, trigger the fallback. Inside WithinTx,
+// fn reads through the transaction to see its uncommitted writes; otherwise it runs in a
+// read-only transaction scoped to the tenant in ctx.
+func (pg *Repository) read(ctx context.Context, fn func(querier) error) error {
+	if uow, ok := pg.unitOfWork(ctx); ok {
+		return fn(uow.tx)
+	}
+	readOn := func(db driver) error {
+		return pg.runTxOn(ctx, db, pg.txFor(opRead, txReadOnly), func(tx dbTx) error {
+			return fn(tx)
+		})
+	}
+	if pg.replicas == nil || reqctx.ReadYourWrites(ctx) {
+		return readOn(pg.db)
+	}
+	r := pg.replicas.pick()
+	if r == nil {
+		return readOn(pg.db)
+	}
+	err := readOn(r.db)
+	if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+		return err
+	}
+	pg.logger.WarnContext(ctx, "replica read failed, falling back to primary",
+		slog.String("replica", r.addr), slog.Any("error", err))
+	r.setStatus(0, err)
+	return readOn(pg.db)
+}
+
+// RunReplicaMonitor re-checks replica health and lag every PG_REPLICA_CHECK_INTERVAL until
+// ctx is done. It returns at once when no replicas are configured.
+func (pg *Repository) RunReplicaMonitor(ctx context.Context) error {
+	if pg.replicas == nil {
+		return nil
+	}
+	ticker := time.NewTicker(pg.replicas.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			pg.replicas.check(ctx)
+		}
+	}
+}
+
+// Replicas reports the last health check of every configured replica.
+func (pg *Repository) Replicas() []entity.ReplicaStatus {
+	if pg.replicas == nil {
+		return nil
+	}
+	out := make([]entity.ReplicaStatus, len(pg.replicas.all))
+	for i, r := range pg.replicas.all {
+		out[i] = r.snapshot()
+	}
+	return out
+}
+
+func (rs *replicaSet) pick() *replica {
+	n := uint64(len(rs.all))
+	start := rs.next.Add(1)
+	for i := range n {
+		if r := rs.all[(start+i)%n]; r.available() {
+			return r
+		}
+	}
+	return nil
+}
+
+// check probes every replica concurrently and records its lag behind the primary. When the
+// primary cannot tell its position, the replicas keep their last status.
+func (rs *replicaSet) check(ctx context.Context) {
+	lsn, err := rs.primaryLSN(ctx)
+	if err != nil {
+		rs.logger.WarnContext(ctx, "replica check skipped", slog.Any("error", err))
+		return
+	}
+	var wg sync.WaitGroup
+	for _, r := range rs.all {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+			defer cancel()
+
+			var lagMs int64
+			err := r.db.queryRow(ctx, queryReplicaLag, lsn).Scan(&lagMs)
+			lag := time.Duration(lagMs) * time.Millisecond
+			if err == nil && lag > rs.maxLag {
+				err = fmt.Errorf("lag %s exceeds %s", lag, rs.maxLag)
+			}
+			if was := r.available(); was != (err == nil) {
+				level := slog.LevelWarn
+				if err == nil {
+					level = slog.LevelInfo
+				}
+				rs.logger.Log(ctx, level, "replica availability changed", slog.String("replica", r.addr),
+					slog.Bool("available", err == nil), slog.Duration("lag", lag), slog.Any("error", err))
+			}
+			r.setStatus(lag, err)
+		})
+	}
+	wg.Wait()
+}
+
+// primaryLSN returns the current write position of the primary.
+func (rs *replicaSet) primaryLSN(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+	defer cancel()
+
+	var lsn string
+	if err := rs.primary.queryRow(ctx, queryCurrentLSN).Scan(&lsn); err != nil {
+		return "", fmt.Errorf("primary wal position: %w", err)
+	}
+	return lsn, nil
+}
+
+func (rs *replicaSet) close() {
+	for _, r := range rs.all {
+		if err := r.db.close(); err != nil {
+			rs.logger.Error("failed to close replica connection",
+				slog.String("replica", r.addr), slog.Any("error", err))
+		}
+	}
+}
+
+func (r *replica) available() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status.Available
+}
+
+func (r *replica) snapshot() entity.ReplicaStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status
+}
+
+func (r *replica) setStatus(lag time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = entity.ReplicaStatus{Addr: r.addr, Available: err == nil, Lag: lag, CheckedAt: time.Now()}
+	if err != nil {
+		r.status.Error = err.Error()
+	}
+}
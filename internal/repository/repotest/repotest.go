@@ -0,0 +1,873 @@
+// Package repotest is the conformance suite every product store must pass, so the
+// PostgreSQL repository, the SQLite one and the in-memory stand-in keep the same semantics.
+package repotest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// Repository is the store surface the suite exercises.
+type Repository interface {
+	Save(context.Context, entity.Product) (entity.Product, error)
+	SaveAll(context.Context, []entity.Product) error
+	FindByID(context.Context, uuid.UUID) (entity.Product, error)
+	FindByIDs(context.Context, []uuid.UUID) ([]entity.Product, error)
+	RecentlyUpdated(context.Context, int) ([]entity.ProductRef, error)
+	FindAll(context.Context, uuid.NullUUID, int, entity.ProductFilter) (entity.ProductPage, error)
+	Export(context.Context, entity.ProductFilter) iter.Seq2[entity.Product, error]
+	Update(context.Context, entity.Product) error
+	Delete(context.Context, uuid.UUID) error
+	Restore(context.Context, uuid.UUID) (entity.Product, error)
+	Purge(context.Context, time.Time) (int64, error)
+	History(context.Context, uuid.UUID, int64, int) (entity.AuditPage, error)
+	WithinTx(context.Context, func(context.Context) error) error
+	AfterCommit(context.Context, func(context.Context))
+
+	CreateImportJob(context.Context, entity.ImportJob) (entity.ImportJob, error)
+	SaveImportSource(context.Context, uuid.UUID, int, []byte) error
+	ImportSource(context.Context, uuid.UUID, int) ([]byte, error)
+	DeleteImportSource(context.Context, uuid.UUID) error
+	ClaimImportJob(context.Context, entity.ImportOrigin, time.Duration) (entity.ImportJob, error)
+	ResumeImportJob(
+		context.Context, uuid.UUID, entity.ImportOrigin, entity.ImportStatus,
+	) (entity.ImportJob, error)
+	FinishImportJob(context.Context, uuid.UUID, entity.ImportStatus, string) error
+	ImportChunk(context.Context, entity.ImportChunk) (entity.ImportJob, []uuid.UUID, error)
+	ImportRejects(context.Context, uuid.UUID, int64, int) (entity.ImportRejectPage, error)
+
+	CreateAPIKey(context.Context, entity.APIKey) (entity.APIKey, error)
+	FindAPIKey(context.Context, []byte) (entity.APIKey, error)
+	ListAPIKeys(context.Context) ([]entity.APIKey, error)
+	RevokeAPIKey(context.Context, uuid.UUID) error
+	TouchAPIKey(context.Context, uuid.UUID, time.Time) error
+}
+
+// Run runs the suite. newRepo must return an empty store for every subtest.
+func Run(t *testing.T, newRepo func(t *testing.T) Repository) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, Repository)
+	}{
+		{"SaveAndFind", testSaveAndFind},
+		{"FindByIDs", testFindByIDs},
+		{"RecentlyUpdated", testRecentlyUpdated},
+		{"ConstraintErrors", testConstraintErrors},
+		{"FindAllKeyset", testFindAllKeyset},
+		{"FindAllFilter", testFindAllFilter},
+		{"Export", testExport},
+		{"Update", testUpdate},
+		{"DeleteAndRestore", testDeleteAndRestore},
+		{"Purge", testPurge},
+		{"History", testHistory},
+		{"WithinTx", testWithinTx},
+		{"Import", testImport},
+		{"ImportSource", testImportSource},
+		{"TenantIsolation", testTenantIsolation},
+		{"APIKeys", testAPIKeys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func newProduct(name string, amount int64, currency entity.Currency) entity.Product {
+	return entity.Product{
+		ID:    uuid.New(),
+		Name:  name,
+		Price: entity.Money{MinorAmount: amount, Currency: currency},
+	}
+}
+
+func save(t *testing.T, repo Repository, ps ...entity.Product) {
+	t.Helper()
+	if err := repo.SaveAll(t.Context(), ps); err != nil {
+		t.Fatalf("failed to save products: %v", err)
+	}
+}
+
+func ids(ps []entity.Product) []uuid.UUID {
+	out := make([]uuid.UUID, len(ps))
+	for i, p := range ps {
+		out[i] = p.ID
+	}
+	return out
+}
+
+// sortedIDs orders ids the way PostgreSQL orders UUIDs, bytewise.
+func sortedIDs(ps ...entity.Product) []uuid.UUID {
+	return slices.SortedFunc(slices.Values(ids(ps)), func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+}
+
+func testSaveAndFind(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	p := newProduct("Car", 100, entity.CurrencyPLN)
+
+	saved, err := repo.Save(ctx, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if saved != p {
+		t.Errorf("got saved %+v, want %+v", saved, p)
+	}
+	got, err := repo.FindByID(ctx, p.ID)
+	if err != nil || got != p {
+		t.Errorf("got %+v, %v, want %+v", got, err, p)
+	}
+	if _, err := repo.FindByID(ctx, uuid.New()); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown id, got %v", err)
+	}
+}
+
+func testFindByIDs(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	a, b, deleted := newProduct("A", 100, entity.CurrencyPLN), newProduct("B", 200, entity.CurrencyEUR),
+		newProduct("Deleted", 300, entity.CurrencyPLN)
+	save(t, repo, a, b, deleted)
+	if err := repo.Delete(ctx, deleted.ID); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+
+	got, err := repo.FindByIDs(ctx, []uuid.UUID{b.ID, deleted.ID, uuid.New(), a.ID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	byID := func(x, y entity.Product) int { return bytes.Compare(x.ID[:], y.ID[:]) }
+	want := []entity.Product{a, b}
+	slices.SortFunc(got, byID)
+	slices.SortFunc(want, byID)
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v, want the live products %+v", got, want)
+	}
+	other := reqctx.WithTenant(ctx, "acme")
+	if got, err := repo.FindByIDs(other, []uuid.UUID{a.ID}); err != nil || len(got) != 0 {
+		t.Errorf("expected no products of another tenant, got %+v, %v", got, err)
+	}
+	if got, err := repo.FindByIDs(ctx, nil); err != nil || len(got) != 0 {
+		t.Errorf("expected no products for no ids, got %+v, %v", got, err)
+	}
+}
+
+func testRecentlyUpdated(t *testing.T, repo Repository) {
+	ctx, acme := t.Context(), reqctx.WithTenant(t.Context(), "acme")
+	updated := newProduct("Updated", 100, entity.CurrencyPLN)
+	restored := newProduct("Restored", 100, entity.CurrencyPLN)
+	deleted := newProduct("Deleted", 100, entity.CurrencyPLN)
+	theirs := newProduct("Theirs", 100, entity.CurrencyPLN)
+	// Each write is a transaction of its own, so their times differ.
+	save(t, repo, updated)
+	save(t, repo, restored)
+	if _, err := repo.Save(acme, theirs); err != nil {
+		t.Fatalf("failed to save product: %v", err)
+	}
+	save(t, repo, deleted)
+	if err := repo.Delete(ctx, restored.ID); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+	if _, err := repo.Restore(ctx, restored.ID); err != nil {
+		t.Fatalf("failed to restore product: %v", err)
+	}
+	updated.Name = "Changed"
+	if err := repo.Update(ctx, updated); err != nil {
+		t.Fatalf("failed to update product: %v", err)
+	}
+	if err := repo.Delete(ctx, deleted.ID); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+
+	want := []entity.ProductRef{
+		{Tenant: reqctx.DefaultTenant, ID: updated.ID},
+		{Tenant: reqctx.DefaultTenant, ID: restored.ID},
+		{Tenant: "acme", ID: theirs.ID},
+	}
+	got, err := repo.RecentlyUpdated(ctx, 10)
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("got %+v, %v, want %+v", got, err, want)
+	}
+	if got, err := repo.RecentlyUpdated(acme, 2); err != nil || !slices.Equal(got, want[:2]) {
+		t.Errorf("got %+v, %v, want every tenant's %+v", got, err, want[:2])
+	}
+}
+
+func testConstraintErrors(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	p := newProduct("Car", 100, entity.CurrencyPLN)
+	save(t, repo, p)
+
+	tests := []struct {
+		name  string
+		write func() error
+		kind  error
+		field string
+	}{
+		{
+			name:  "duplicate id",
+			write: func() error { _, err := repo.Save(ctx, p); return err },
+			kind:  entity.ErrConflict,
+			field: entity.FieldID,
+		},
+		{
+			name: "zero price",
+			write: func() error {
+				_, err := repo.Save(ctx, newProduct("Free", 0, entity.CurrencyPLN))
+				return err
+			},
+			kind:  entity.ErrConstraintViolation,
+			field: entity.FieldPriceAmount,
+		},
+		{
+			name: "unknown currency",
+			write: func() error {
+				q := p
+				q.Price.Currency = "XXX"
+				return repo.Update(ctx, q)
+			},
+			kind:  entity.ErrConstraintViolation,
+			field: entity.FieldPriceCurrency,
+		},
+		{
+			name: "batch with a duplicate",
+			write: func() error {
+				return repo.SaveAll(ctx, []entity.Product{newProduct("New", 100, entity.CurrencyPLN), p})
+			},
+			kind:  entity.ErrConflict,
+			field: entity.FieldID,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.write()
+			ce, ok := errors.AsType[*entity.ConstraintError](err)
+			if !ok || !errors.Is(err, tt.kind) {
+				t.Fatalf("expected a ConstraintError wrapping %v, got %v", tt.kind, err)
+			}
+			if ce.Field != tt.field {
+				t.Errorf("got field %q, want %q", ce.Field, tt.field)
+			}
+		})
+	}
+
+	page, err := repo.FindAll(ctx, uuid.NullUUID{}, 10, entity.ProductFilter{})
+	if err != nil || len(page.Items) != 1 || page.Items[0] != p {
+		t.Errorf("rejected writes must leave the store unchanged, got %+v, %v", page.Items, err)
+	}
+}
+
+func testFindAllKeyset(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	// Random UUIDs, so insertion order differs from id order.
+	var ps []entity.Product
+	for range 5 {
+		ps = append(ps, newProduct("P", 100, entity.CurrencyPLN))
+	}
+	save(t, repo, ps...)
+
+	var (
+		got    []uuid.UUID
+		cursor uuid.NullUUID
+	)
+	for pages := 0; ; pages++ {
+		if pages > len(ps) {
+			t.Fatal("keyset pagination does not terminate")
+		}
+		page, err := repo.FindAll(ctx, cursor, 2, entity.ProductFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, ids(page.Items)...)
+		if !page.HasMore {
+			break
+		}
+		if len(page.Items) != 2 {
+			t.Fatalf("got a short page of %d with more to come", len(page.Items))
+		}
+		cursor = uuid.NullUUID{UUID: page.Items[len(page.Items)-1].ID, Valid: true}
+	}
+	want := sortedIDs(ps...)
+	if !slices.Equal(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+
+	// A cursor whose product no longer qualifies continues from the next greater id.
+	if err := repo.Delete(ctx, want[1]); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+	page, err := repo.FindAll(ctx, uuid.NullUUID{UUID: want[1], Valid: true}, 10, entity.ProductFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(page.Items); !slices.Equal(got, want[2:]) {
+		t.Errorf("got %v after a deleted cursor, want %v", got, want[2:])
+	}
+}
+
+func testFindAllFilter(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	pln := newProduct("PLN", 100, entity.CurrencyPLN)
+	eur := newProduct("EUR", 100, entity.CurrencyEUR)
+	gone := newProduct("Gone", 100, entity.CurrencyEUR)
+	save(t, repo, pln, eur, gone)
+	if err := repo.Delete(ctx, gone.ID); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter entity.ProductFilter
+		want   []uuid.UUID
+	}{
+		{name: "live", want: sortedIDs(pln, eur)},
+		{
+			name:   "including deleted",
+			filter: entity.ProductFilter{IncludeDeleted: true},
+			want:   sortedIDs(pln, eur, gone),
+		},
+		{name: "currency", filter: entity.ProductFilter{Currency: entity.CurrencyEUR}, want: sortedIDs(eur)},
+		{
+			name:   "currency including deleted",
+			filter: entity.ProductFilter{Currency: entity.CurrencyEUR, IncludeDeleted: true},
+			want:   sortedIDs(eur, gone),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.FindAll(ctx, uuid.NullUUID{}, 10, tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := ids(page.Items); !slices.Equal(got, tt.want) || page.HasMore {
+				t.Errorf("got %v (more: %v), want %v", got, page.HasMore, tt.want)
+			}
+		})
+	}
+}
+
+func testExport(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	a := newProduct("A", 100, entity.CurrencyPLN)
+	b := newProduct("B", 100, entity.CurrencyPLN)
+	c := newProduct("C", 100, entity.CurrencyUSD)
+	save(t, repo, a, b, c)
+
+	var got []uuid.UUID
+	for p, err := range repo.Export(ctx, entity.ProductFilter{Currency: entity.CurrencyPLN}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, p.ID)
+	}
+	if want := sortedIDs(a, b); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, err := range repo.Export(ctx, entity.ProductFilter{}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		break
+	}
+	if _, err := repo.FindByID(ctx, a.ID); err != nil {
+		t.Errorf("store unusable after an abandoned export: %v", err)
+	}
+}
+
+func testUpdate(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	p := newProduct("Old", 100, entity.CurrencyPLN)
+	save(t, repo, p)
+
+	p.Name, p.Price = "New", entity.Money{MinorAmount: 250, Currency: entity.CurrencyEUR}
+	if err := repo.Update(ctx, p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err := repo.FindByID(ctx, p.ID); err != nil || got != p {
+		t.Errorf("got %+v, %v, want %+v", got, err, p)
+	}
+	err := repo.Update(ctx, newProduct("Missing", 100, entity.CurrencyPLN))
+	if !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown product, got %v", err)
+	}
+	if err := repo.Delete(ctx, p.ID); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+	if err := repo.Update(ctx, p); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a deleted product, got %v", err)
+	}
+}
+
+func testDeleteAndRestore(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	p := newProduct("Car", 100, entity.CurrencyPLN)
+	save(t, repo, p)
+
+	if _, err := repo.Restore(ctx, p.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound restoring a live product, got %v", err)
+	}
+	if err := repo.Delete(ctx, p.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := repo.FindByID(ctx, p.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a deleted product, got %v", err)
+	}
+	if err := repo.Delete(ctx, p.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting twice, got %v", err)
+	}
+	if err := repo.Delete(ctx, uuid.New()); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting an unknown product, got %v", err)
+	}
+
+	page, err := repo.FindAll(ctx, uuid.NullUUID{}, 10, entity.ProductFilter{IncludeDeleted: true})
+	if err != nil || len(page.Items) != 1 || !page.Items[0].Deleted() {
+		t.Fatalf("expected the tombstone in the listing, got %+v, %v", page.Items, err)
+	}
+
+	restored, err := repo.Restore(ctx, p.ID)
+	if err != nil || restored != p {
+		t.Errorf("got restored %+v, %v, want %+v", restored, err, p)
+	}
+	if got, err := repo.FindByID(ctx, p.ID); err != nil || got != p {
+		t.Errorf("got %+v, %v, want %+v", got, err, p)
+	}
+}
+
+func testPurge(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	live := newProduct("Live", 100, entity.CurrencyPLN)
+	gone := newProduct("Gone", 100, entity.CurrencyPLN)
+	save(t, repo, live, gone)
+	if err := repo.Delete(ctx, gone.ID); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+
+	if n, err := repo.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("expected nothing purged before the tombstone, got %d, %v", n, err)
+	}
+	n, err := repo.Purge(reqctx.WithActor(ctx, reqctx.System), time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected one product purged, got %d, %v", n, err)
+	}
+	page, err := repo.FindAll(ctx, uuid.NullUUID{}, 10, entity.ProductFilter{IncludeDeleted: true})
+	if err != nil || !slices.Equal(ids(page.Items), []uuid.UUID{live.ID}) {
+		t.Errorf("expected only the live product left, got %+v, %v", page.Items, err)
+	}
+	if _, err := repo.Restore(ctx, gone.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound restoring a purged product, got %v", err)
+	}
+
+	history, err := repo.History(ctx, gone.ID, 0, 1)
+	if err != nil || len(history.Items) != 1 {
+		t.Fatalf("unexpected history %+v, %v", history, err)
+	}
+	if e := history.Items[0]; e.Action != entity.AuditPurge || e.Actor != reqctx.System {
+		t.Errorf("got %+v, want a purge by %s", e, reqctx.System)
+	}
+}
+
+func testHistory(t *testing.T, repo Repository) {
+	ctx := reqctx.WithRequestID(reqctx.WithActor(t.Context(), "alice"), "req-1")
+	p := newProduct("Old", 100, entity.CurrencyPLN)
+	other := newProduct("Other", 100, entity.CurrencyPLN)
+	save(t, repo, p)
+	if _, err := repo.Save(ctx, other); err != nil {
+		t.Fatalf("failed to save product: %v", err)
+	}
+	steps := []func() error{
+		func() error { p.Name = "New"; return repo.Update(ctx, p) },
+		func() error { return repo.Delete(ctx, p.ID) },
+		func() error { _, err := repo.Restore(ctx, p.ID); return err },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var (
+		got    []entity.AuditAction
+		cursor int64
+	)
+	for {
+		page, err := repo.History(ctx, p.ID, cursor, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, e := range page.Items {
+			if e.ProductID != p.ID {
+				t.Errorf("got an entry of product %s", e.ProductID)
+			}
+			got = append(got, e.Action)
+		}
+		if !page.HasMore {
+			break
+		}
+		cursor = page.Items[len(page.Items)-1].ID
+	}
+	want := []entity.AuditAction{entity.AuditRestore, entity.AuditDelete, entity.AuditUpdate, entity.AuditCreate}
+	if !slices.Equal(got, want) {
+		t.Errorf("got actions %v, want %v", got, want)
+	}
+
+	page, err := repo.History(ctx, p.ID, 0, 1)
+	if err != nil || len(page.Items) != 1 || !page.HasMore {
+		t.Fatalf("unexpected history %+v, %v", page, err)
+	}
+	restore := page.Items[0]
+	if restore.Actor != "alice" || restore.RequestID != "req-1" || restore.OccurredAt.IsZero() {
+		t.Errorf("got %+v, want an entry by alice in req-1", restore)
+	}
+	if len(restore.Changes) != 1 || restore.Changes[0].Field != "deletedAt" || restore.Changes[0].After != nil {
+		t.Errorf("got changes %+v, want deletedAt cleared", restore.Changes)
+	}
+
+	if page, err := repo.History(ctx, uuid.New(), 0, 10); err != nil || len(page.Items) != 0 {
+		t.Errorf("expected no history for an unknown product, got %+v, %v", page, err)
+	}
+}
+
+func testWithinTx(t *testing.T, repo Repository) {
+	ctx := t.Context()
+	errAbort := errors.New("abort")
+	exists := func(p entity.Product) bool {
+		t.Helper()
+		_, err := repo.FindByID(ctx, p.ID)
+		if err != nil && !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("failed to find product: %v", err)
+		}
+		return err == nil
+	}
+
+	a, b := newProduct("A", 100, entity.CurrencyPLN), newProduct("B", 100, entity.CurrencyPLN)
+	var hooks []string
+	err := repo.WithinTx(ctx, func(ctx context.Context) error {
+		if _, err := repo.Save(ctx, a); err != nil {
+			return err
+		}
+		err := repo.WithinTx(ctx, func(ctx context.Context) error {
+			if _, err := repo.Save(ctx, b); err != nil {
+				return err
+			}
+			repo.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "inner") })
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			return err
+		}
+		if _, err := repo.FindByID(ctx, a.ID); err != nil {
+			return err
+		}
+		if _, err := repo.FindByID(ctx, b.ID); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected the savepoint's write rolled back, got %v", err)
+		}
+		repo.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "outer") })
+		if len(hooks) != 0 {
+			t.Errorf("hooks ran before commit: %v", hooks)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists(a) || exists(b) {
+		t.Error("expected the outer write committed and the savepoint's rolled back")
+	}
+	if !slices.Equal(hooks, []string{"outer"}) {
+		t.Errorf("got hooks %v, want only the outer one", hooks)
+	}
+
+	c := newProduct("C", 100, entity.CurrencyPLN)
+	hooks = nil
+	err = repo.WithinTx(ctx, func(ctx context.Context) error {
+		if _, err := repo.Save(ctx, c); err != nil {
+			return err
+		}
+		repo.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "outer") })
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("expected errAbort, got %v", err)
+	}
+	if exists(c) || len(hooks) != 0 {
+		t.Errorf("expected a full rollback, got hooks %v", hooks)
+	}
+
+	ran := false
+	repo.AfterCommit(ctx, func(context.Context) { ran = true })
+	if !ran {
+		t.Error("expected a hook outside a transaction to run at once")
+	}
+}
+
+func testImport(t *testing.T, repo Repository) {
+	ctx := reqctx.WithActor(t.Context(), "importer")
+	existing := newProduct("Old", 100, entity.CurrencyPLN)
+	deleted := newProduct("Gone", 100, entity.CurrencyPLN)
+	save(t, repo, existing, deleted)
+	if err := repo.Delete(ctx, deleted.ID); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+
+	job, err := repo.CreateImportJob(ctx, entity.ImportJob{
+		ID: uuid.New(), Format: entity.ImportCSV, Origin: entity.ImportOriginAPI,
+		Source: "/tmp/p.csv", Status: entity.ImportPending, Actor: "importer",
+	})
+	if err != nil || job.CreatedAt.IsZero() {
+		t.Fatalf("failed to create job: %+v, %v", job, err)
+	}
+	claimed, err := repo.ClaimImportJob(ctx, entity.ImportOriginAPI, time.Minute)
+	if err != nil || claimed.ID != job.ID || claimed.Status != entity.ImportRunning {
+		t.Fatalf("expected to claim the job, got %+v, %v", claimed, err)
+	}
+	_, err = repo.ClaimImportJob(ctx, entity.ImportOriginAPI, time.Minute)
+	if !errors.Is(err, entity.ErrNotFound) {
+		t.Fatalf("a freshly claimed job must not be claimed again, got %v", err)
+	}
+
+	created := newProduct("New", 300, entity.CurrencyPLN)
+	renamed := existing
+	renamed.Name = "Renamed"
+	chunk := entity.ImportChunk{
+		JobID:    job.ID,
+		LastLine: 6,
+		Rows: []entity.ImportRow{
+			{Line: 2, Product: created},
+			{Line: 3, Product: existing},
+			{Line: 4, Product: renamed},
+			{Line: 5, Product: deleted},
+		},
+		Rejects: []entity.ImportReject{{Line: 6, Reason: "the product name is empty"}},
+	}
+	advanced, merged, err := repo.ImportChunk(ctx, chunk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if advanced.Line != 6 || advanced.Accepted != 3 || advanced.Rejected != 2 {
+		t.Errorf("got job %+v, want checkpoint 6 with 3 accepted and 2 rejected", advanced)
+	}
+	// Inserted ids count too: a cached tombstone of theirs is as stale as a cached copy.
+	slices.SortFunc(merged, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+	if want := sortedIDs(existing, created); !slices.Equal(merged, want) {
+		t.Errorf("got merged %v, want %v", merged, want)
+	}
+	if _, _, err := repo.ImportChunk(ctx, chunk); !errors.Is(err, entity.ErrImportCheckpoint) {
+		t.Errorf("replaying a chunk must fail with ErrImportCheckpoint, got %v", err)
+	}
+	if got, err := repo.FindByID(ctx, existing.ID); err != nil || got != renamed {
+		t.Errorf("expected the last line to win, got %+v, %v", got, err)
+	}
+	if got, err := repo.FindByID(ctx, created.ID); err != nil || got != created {
+		t.Errorf("expected the new product inserted, got %+v, %v", got, err)
+	}
+	if _, err := repo.FindByID(ctx, deleted.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("a tombstoned product must stay deleted, got %v", err)
+	}
+
+	rejects, err := repo.ImportRejects(ctx, job.ID, 0, 1)
+	if err != nil || len(rejects.Items) != 1 || rejects.Items[0].Line != 5 || !rejects.HasMore {
+		t.Errorf("got rejects %+v, %v, want line 5 first", rejects, err)
+	}
+	rejects, err = repo.ImportRejects(ctx, job.ID, 5, 10)
+	if err != nil || len(rejects.Items) != 1 || rejects.Items[0].Line != 6 || rejects.HasMore {
+		t.Errorf("got rejects %+v, %v, want only line 6", rejects, err)
+	}
+
+	if err := repo.FinishImportJob(ctx, job.ID, entity.ImportCompleted, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = repo.ResumeImportJob(ctx, job.ID, entity.ImportOriginAPI, entity.ImportPending)
+	if !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("completed jobs must not resume, got %v", err)
+	}
+	err = repo.FinishImportJob(ctx, uuid.New(), entity.ImportFailed, "x")
+	if !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound finishing an unknown job, got %v", err)
+	}
+}
+
+func testImportSource(t *testing.T, repo Repository) {
+	acme := reqctx.WithTenant(t.Context(), "acme")
+	job, err := repo.CreateImportJob(acme, entity.ImportJob{
+		ID: uuid.New(), Format: entity.ImportCSV, Origin: entity.ImportOriginAPI,
+		Status: entity.ImportPending, Actor: "importer",
+	})
+	if err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	parts := [][]byte{[]byte("name,minorAmount,currency\n"), []byte("A,1,PLN\n")}
+	for i, data := range parts {
+		if err := repo.SaveImportSource(acme, job.ID, i, data); err != nil {
+			t.Fatalf("failed to save part %d: %v", i, err)
+		}
+	}
+
+	for i, want := range parts {
+		if got, err := repo.ImportSource(acme, job.ID, i); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("got part %d %q, %v, want %q", i, got, err, want)
+		}
+	}
+	if _, err := repo.ImportSource(acme, job.ID, len(parts)); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound past the last part", err)
+	}
+	globex := reqctx.WithTenant(t.Context(), "globex")
+	if _, err := repo.ImportSource(globex, job.ID, 0); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("got %v, want the source hidden from other tenants", err)
+	}
+	if err := repo.DeleteImportSource(globex, job.ID); err != nil {
+		t.Fatalf("failed to delete as another tenant: %v", err)
+	}
+	if _, err := repo.ImportSource(acme, job.ID, 0); err != nil {
+		t.Errorf("another tenant must not delete the source, got %v", err)
+	}
+
+	if err := repo.DeleteImportSource(acme, job.ID); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := repo.ImportSource(acme, job.ID, 0); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("got %v, want the deleted source gone", err)
+	}
+}
+
+func testTenantIsolation(t *testing.T, repo Repository) {
+	acme := reqctx.WithTenant(t.Context(), "acme")
+	globex := reqctx.WithTenant(t.Context(), "globex")
+	p := newProduct("Acme car", 100, entity.CurrencyPLN)
+	if _, err := repo.Save(acme, p); err != nil {
+		t.Fatalf("failed to save product: %v", err)
+	}
+	theirs := p
+	theirs.Name = "Globex car"
+	if _, err := repo.Save(globex, theirs); err != nil {
+		t.Fatalf("tenants must choose ids independently, got %v", err)
+	}
+
+	if _, err := repo.FindByID(t.Context(), p.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected the default tenant not to see the product, got %v", err)
+	}
+	if err := repo.Delete(globex, p.ID); err != nil {
+		t.Fatalf("failed to delete product: %v", err)
+	}
+	if got, err := repo.FindByID(acme, p.ID); err != nil || got != p {
+		t.Errorf("expected the other tenant's delete to leave %+v, got %+v, %v", p, got, err)
+	}
+	other := newProduct("Acme only", 100, entity.CurrencyPLN)
+	if _, err := repo.Save(acme, other); err != nil {
+		t.Fatalf("failed to save product: %v", err)
+	}
+	if err := repo.Update(globex, other); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating another tenant's product, got %v", err)
+	}
+	page, err := repo.FindAll(globex, uuid.NullUUID{}, 10, entity.ProductFilter{IncludeDeleted: true})
+	if err != nil || !slices.Equal(ids(page.Items), []uuid.UUID{p.ID}) || page.Items[0].Name != theirs.Name {
+		t.Errorf("expected only the tenant's own product, got %+v, %v", page.Items, err)
+	}
+	history, err := repo.History(acme, p.ID, 0, 10)
+	if err != nil || len(history.Items) != 1 || history.Items[0].Action != entity.AuditCreate {
+		t.Errorf("expected only the tenant's own history, got %+v, %v", history, err)
+	}
+
+	job, err := repo.CreateImportJob(globex, entity.ImportJob{
+		ID: uuid.New(), Format: entity.ImportCSV, Origin: entity.ImportOriginAPI,
+		Source: "/tmp/p.csv", Status: entity.ImportPending,
+	})
+	if err != nil || job.Tenant != "globex" {
+		t.Fatalf("expected a job of globex, got %+v, %v", job, err)
+	}
+	claimed, err := repo.ClaimImportJob(t.Context(), entity.ImportOriginAPI, time.Minute)
+	if err != nil || claimed.ID != job.ID || claimed.Tenant != "globex" {
+		t.Fatalf("expected to claim the job across tenants, got %+v, %v", claimed, err)
+	}
+	err = repo.FinishImportJob(acme, job.ID, entity.ImportFailed, "x")
+	if !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound finishing another tenant's job, got %v", err)
+	}
+	_, _, err = repo.ImportChunk(acme, entity.ImportChunk{
+		JobID: job.ID, LastLine: 1, Rejects: []entity.ImportReject{{Line: 1, Reason: "x"}},
+	})
+	if !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound importing into another tenant's job, got %v", err)
+	}
+	if _, _, err := repo.ImportChunk(globex, entity.ImportChunk{
+		JobID: job.ID, LastLine: 1, Rejects: []entity.ImportReject{{Line: 1, Reason: "x"}},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rejects, err := repo.ImportRejects(acme, job.ID, 0, 10); err != nil || len(rejects.Items) != 0 {
+		t.Errorf("expected no rejects of another tenant's job, got %+v, %v", rejects, err)
+	}
+
+	n, err := repo.Purge(t.Context(), time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected the purge to reach every tenant, got %d, %v", n, err)
+	}
+	if got, err := repo.FindByID(acme, p.ID); err != nil || got != p {
+		t.Errorf("expected the purge to leave %+v, got %+v, %v", p, got, err)
+	}
+}
+
+func testAPIKeys(t *testing.T, repo Repository) {
+	acme := reqctx.WithTenant(t.Context(), "acme")
+	hash := bytes.Repeat([]byte{7}, 32)
+	key, err := repo.CreateAPIKey(acme, entity.APIKey{
+		ID: uuid.New(), Name: "storefront", Hash: hash,
+		Scopes: []entity.Scope{entity.ScopeProductsRead, entity.ScopeProductsWrite}, Tier: "premium",
+	})
+	if err != nil || key.Tenant != "acme" || key.CreatedAt.IsZero() {
+		t.Fatalf("failed to create key: %+v, %v", key, err)
+	}
+
+	found, err := repo.FindAPIKey(t.Context(), hash)
+	if err != nil || found.ID != key.ID || found.Tenant != "acme" || found.Name != key.Name {
+		t.Fatalf("expected to find the key across tenants, got %+v, %v", found, err)
+	}
+	if !slices.Equal(found.Scopes, key.Scopes) || found.Tier != "premium" ||
+		!found.LastUsedAt.IsZero() || found.Revoked() {
+		t.Errorf("got %+v, want an unused active premium key with scopes %v", found, key.Scopes)
+	}
+	if _, err := repo.FindAPIKey(t.Context(), bytes.Repeat([]byte{8}, 32)); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown hash, got %v", err)
+	}
+
+	used := time.Now().Truncate(time.Millisecond)
+	if err := repo.TouchAPIKey(t.Context(), key.ID, used); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := repo.TouchAPIKey(t.Context(), key.ID, used.Add(-time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if found, err := repo.FindAPIKey(t.Context(), hash); err != nil || !found.LastUsedAt.Equal(used) {
+		t.Errorf("expected last use at %v, got %+v, %v", used, found, err)
+	}
+
+	if keys, err := repo.ListAPIKeys(reqctx.WithTenant(t.Context(), "globex")); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys of another tenant, got %+v, %v", keys, err)
+	}
+	err = repo.RevokeAPIKey(reqctx.WithTenant(t.Context(), "globex"), key.ID)
+	if !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound revoking another tenant's key, got %v", err)
+	}
+	if err := repo.RevokeAPIKey(acme, key.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := repo.RevokeAPIKey(acme, key.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound revoking a revoked key, got %v", err)
+	}
+	if _, err := repo.FindAPIKey(t.Context(), hash); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("a revoked key must not authenticate, got %v", err)
+	}
+	keys, err := repo.ListAPIKeys(acme)
+	if err != nil || len(keys) != 1 || keys[0].ID != key.ID || !keys[0].Revoked() {
+		t.Errorf("expected the revoked key listed, got %+v, %v", keys, err)
+	}
+}
@@ -0,0 +1,110 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// CreateAPIKey stores a new key of the tenant in ctx and fills in its tenant and creation time.
+func (r *Repository) CreateAPIKey(ctx context.Context, k entity.APIKey) (entity.APIKey, error) {
+	k.Tenant = reqctx.Tenant(ctx)
+	k.CreatedAt = now()
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, queryInsertAPIKey, k.Tenant, k.ID[:], k.Name, k.Hash,
+			joinScopes(k.Scopes), k.Tier, k.CreatedAt.UnixMicro())
+		return err
+	})
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+	return k, nil
+}
+
+// FindAPIKey returns the active key with hash, of whichever tenant owns it.
+func (r *Repository) FindAPIKey(ctx context.Context, hash []byte) (entity.APIKey, error) {
+	k, err := scanAPIKey(r.read(ctx).QueryRowContext(ctx, queryFindAPIKey, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.APIKey{}, entity.ErrNotFound
+	}
+	return k, err
+}
+
+// ListAPIKeys returns every key of the tenant in ctx, revoked ones included, oldest first.
+func (r *Repository) ListAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	rows, err := r.read(ctx).QueryContext(ctx, queryListAPIKeys, reqctx.Tenant(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []entity.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes an active key of the tenant in ctx.
+func (r *Repository) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, queryRevokeAPIKey, reqctx.Tenant(ctx), id[:], now().UnixMicro())
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return entity.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// TouchAPIKey records that the key authenticated a request at, whichever tenant owns it.
+func (r *Repository) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, queryTouchAPIKey, id[:], at.UnixMicro())
+		return err
+	})
+}
+
+func scanAPIKey(s scanner) (entity.APIKey, error) {
+	var (
+		k                   entity.APIKey
+		scopes              string
+		lastUsed, revokedAt sql.NullInt64
+		createdAt           int64
+	)
+	err := s.Scan(&k.ID, &k.Tenant, &k.Name, &scopes, &k.Tier, &lastUsed, &revokedAt, &createdAt)
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+	for f := range strings.FieldsSeq(scopes) {
+		k.Scopes = append(k.Scopes, entity.Scope(f))
+	}
+	k.LastUsedAt = fromMicros(lastUsed)
+	k.RevokedAt = fromMicros(revokedAt)
+	k.CreatedAt = time.UnixMicro(createdAt)
+	return k, nil
+}
+
+func joinScopes(scopes []entity.Scope) string {
+	out := make([]string, len(scopes))
+	for i, s := range scopes {
+		out[i] = string(s)
+	}
+	return strings.Join(out, " ")
+}
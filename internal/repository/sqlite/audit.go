@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// auditChange is the stored form of a field change, the same JSON as in PostgreSQL.
+type auditChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// History returns one page of audit entries for the product, newest first.
+// A zero cursor starts from the most recent entry.
+func (r *Repository) History(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.AuditPage, error) {
+	var (
+		rows   *sql.Rows
+		err    error
+		tenant = reqctx.Tenant(ctx)
+	)
+	if cursor > 0 {
+		rows, err = r.read(ctx).QueryContext(ctx, queryHistoryBeforeCursor, tenant, id[:], cursor, limit+1)
+	} else {
+		rows, err = r.read(ctx).QueryContext(ctx, queryHistory, tenant, id[:], limit+1)
+	}
+	if err != nil {
+		return entity.AuditPage{}, err
+	}
+	defer rows.Close()
+
+	entries := make([]entity.AuditEntry, 0, limit+1)
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return entity.AuditPage{}, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.AuditPage{}, err
+	}
+
+	if len(entries) <= limit {
+		return entity.AuditPage{Items: entries}, nil
+	}
+	return entity.AuditPage{Items: entries[:limit], HasMore: true}, nil
+}
+
+// AuditLog streams every audit entry recorded at or after since, oldest first,
+// without buffering the result set.
+func (r *Repository) AuditLog(ctx context.Context, since time.Time) iter.Seq2[entity.AuditEntry, error] {
+	return func(yield func(entity.AuditEntry, error) bool) {
+		rows, err := r.read(ctx).QueryContext(ctx, queryAuditLog, reqctx.Tenant(ctx), since.UnixMicro())
+		if err != nil {
+			yield(entity.AuditEntry{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			e, err := scanAudit(rows)
+			if !yield(e, err) || err != nil {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(entity.AuditEntry{}, err)
+		}
+	}
+}
+
+// insertAudit records a mutation of the product through q, attributing it to the
+// actor and request carried by ctx.
+func insertAudit(ctx context.Context, q querier, id uuid.UUID, action entity.AuditAction,
+	changes []entity.FieldChange,
+) error {
+	stored := make([]auditChange, len(changes))
+	for i, c := range changes {
+		stored[i] = auditChange{Field: c.Field, Before: c.Before, After: c.After}
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("marshal audit changes: %w", err)
+	}
+	_, err = q.ExecContext(ctx, queryInsertAudit, reqctx.Tenant(ctx), id[:], string(action), reqctx.Actor(ctx),
+		reqctx.RequestID(ctx), now().UnixMicro(), string(data))
+	return err
+}
+
+func scanAudit(s scanner) (entity.AuditEntry, error) {
+	var (
+		e               entity.AuditEntry
+		action, changes string
+		occurredAt      int64
+	)
+	if err := s.Scan(
+		&e.ID, &e.ProductID, &action, &e.Actor, &e.RequestID, &occurredAt, &changes,
+	); err != nil {
+		return entity.AuditEntry{}, err
+	}
+	e.Action = entity.AuditAction(action)
+	e.OccurredAt = time.UnixMicro(occurredAt)
+
+	// UseNumber keeps int64 amounts exact instead of widening them to float64.
+	dec := json.NewDecoder(strings.NewReader(changes))
+	dec.UseNumber()
+	var stored []auditChange
+	if err := dec.Decode(&stored); err != nil {
+		return entity.AuditEntry{}, fmt.Errorf("unmarshal audit changes of entry %d: %w", e.ID, err)
+	}
+	e.Changes = make([]entity.FieldChange, len(stored))
+	for i, c := range stored {
+		e.Changes[i] = entity.FieldChange{Field: c.Field, Before: c.Before, After: c.After}
+	}
+	return e, nil
+}
@@ -0,0 +1,266 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+const rejectDeleted = "product is deleted; restore it before importing"
+
+// CreateImportJob records a new import job of the tenant in ctx and fills in its tenant and timestamps.
+func (r *Repository) CreateImportJob(ctx context.Context, j entity.ImportJob) (entity.ImportJob, error) {
+	j.Tenant = reqctx.Tenant(ctx)
+	j.CreatedAt = now()
+	j.UpdatedAt = j.CreatedAt
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, queryInsertImportJob, j.Tenant, j.ID[:], string(j.Format),
+			string(j.Origin), j.Source, string(j.Status), j.Actor, j.RequestID, j.CreatedAt.UnixMicro())
+		return err
+	})
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	return j, nil
+}
+
+func (r *Repository) FindImportJob(ctx context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	return scanImportJobRow(r.read(ctx).QueryRowContext(ctx, queryGetImportJob, reqctx.Tenant(ctx), id[:]))
+}
+
+// ClaimImportJob marks the oldest pending job of origin as running and returns it.
+// Running jobs whose owner has not checkpointed within staleAfter are claimed as well,
+// which is how an interrupted import resumes. Jobs of every tenant are claimed; the job
+// names its tenant. entity.ErrNotFound means nothing to do.
+func (r *Repository) ClaimImportJob(ctx context.Context, origin entity.ImportOrigin,
+	staleAfter time.Duration,
+) (entity.ImportJob, error) {
+	var j entity.ImportJob
+	err := r.inTx(ctx, func(tx *sql.Tx) (err error) {
+		t := now()
+		j, err = scanImportJobRow(tx.QueryRowContext(ctx, queryClaimImportJob,
+			string(origin), t.Add(-staleAfter).UnixMicro(), t.UnixMicro()))
+		return err
+	})
+	return j, err
+}
+
+// ResumeImportJob moves an unfinished job of origin back to status, clearing its error.
+func (r *Repository) ResumeImportJob(ctx context.Context, id uuid.UUID, origin entity.ImportOrigin,
+	status entity.ImportStatus,
+) (entity.ImportJob, error) {
+	var j entity.ImportJob
+	err := r.inTx(ctx, func(tx *sql.Tx) (err error) {
+		j, err = scanImportJobRow(tx.QueryRowContext(ctx, queryResumeImportJob,
+			reqctx.Tenant(ctx), id[:], string(origin), string(status), now().UnixMicro()))
+		return err
+	})
+	return j, err
+}
+
+func (r *Repository) FinishImportJob(ctx context.Context, id uuid.UUID, status entity.ImportStatus,
+	msg string,
+) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, queryFinishImportJob,
+			reqctx.Tenant(ctx), id[:], string(status), msg, now().UnixMicro())
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return entity.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// SaveImportSource stores part number part of the uploaded source of job id.
+func (r *Repository) SaveImportSource(ctx context.Context, id uuid.UUID, part int, data []byte) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, queryInsertImportSource, reqctx.Tenant(ctx), id[:], part, data)
+		return err
+	})
+}
+
+// ImportSource returns part number part of the uploaded source of job id; entity.ErrNotFound
+// means the source has no such part.
+func (r *Repository) ImportSource(ctx context.Context, id uuid.UUID, part int) ([]byte, error) {
+	var data []byte
+	err := r.read(ctx).QueryRowContext(ctx, queryGetImportSource, reqctx.Tenant(ctx), id[:], part).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, entity.ErrNotFound
+	}
+	return data, err
+}
+
+// DeleteImportSource drops the uploaded source of job id.
+func (r *Repository) DeleteImportSource(ctx context.Context, id uuid.UUID) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, queryDeleteImportSource, reqctx.Tenant(ctx), id[:])
+		return err
+	})
+}
+
+// ImportChunk merges the chunk's rows into products, records audit entries and rejects,
+// and advances the job checkpoint, all in one transaction. It returns the advanced job and
+// the ids of the merged products, whose cached copies, or tombstones for those it inserted,
+// are stale. SQLite has no COPY; rows are
+// upserted one by one, which is cheap on the local file.
+func (r *Repository) ImportChunk(ctx context.Context, c entity.ImportChunk,
+) (entity.ImportJob, []uuid.UUID, error) {
+	var (
+		job    entity.ImportJob
+		merged []uuid.UUID
+	)
+	tenant := reqctx.Tenant(ctx)
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var checkpoint int64
+		err := tx.QueryRowContext(ctx, queryImportCheckpoint, tenant, c.JobID[:]).Scan(&checkpoint)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return entity.ErrNotFound
+			}
+			return err
+		}
+		if checkpoint != c.From {
+			return entity.ErrImportCheckpoint
+		}
+
+		ids, err := mergeImport(ctx, tx, c.Rows)
+		if err != nil {
+			return err
+		}
+		rejects := slices.Clone(c.Rejects)
+		accepted := int64(0)
+		// Rows missing from the merge hit a tombstone; every line of a merged id counts.
+		for _, row := range c.Rows {
+			if _, ok := ids[row.Product.ID]; !ok {
+				rejects = append(rejects, entity.ImportReject{Line: row.Line, Reason: rejectDeleted})
+				continue
+			}
+			accepted++
+		}
+		merged = slices.Collect(maps.Keys(ids))
+		for _, rj := range rejects {
+			_, err := tx.ExecContext(ctx, queryInsertImportReject, tenant, c.JobID[:], rj.Line, rj.Reason)
+			if err != nil {
+				return err
+			}
+		}
+
+		job, err = scanImportJob(tx.QueryRowContext(ctx, queryAdvanceImportJob,
+			tenant, c.JobID[:], c.LastLine, accepted, int64(len(rejects)), now().UnixMicro()))
+		return err
+	})
+	if err != nil {
+		return entity.ImportJob{}, nil, err
+	}
+	return job, merged, nil
+}
+
+// mergeImport upserts rows into products, the last line winning for repeated ids, and audits
+// every merged product. Tombstoned products are left alone. It returns the set of merged ids.
+func mergeImport(ctx context.Context, tx *sql.Tx, rows []entity.ImportRow) (map[uuid.UUID]struct{}, error) {
+	latest := make(map[uuid.UUID]entity.Product, len(rows))
+	for _, row := range rows {
+		latest[row.Product.ID] = row.Product
+	}
+	ids := slices.SortedFunc(maps.Keys(latest), func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	merged := make(map[uuid.UUID]struct{}, len(ids))
+	for _, id := range ids {
+		after := latest[id]
+		after.DeletedAt = time.Time{}
+		before, err := findProduct(ctx, tx, id)
+		existed := err == nil
+		if err != nil && !errors.Is(err, entity.ErrNotFound) {
+			return nil, err
+		}
+		if existed && before.Deleted() {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, queryUpsertImport,
+			reqctx.Tenant(ctx), id[:], after.Name, after.Price.MinorAmount, string(after.Price.Currency),
+			now().UnixMicro())
+		if err != nil {
+			return nil, err
+		}
+		action, changes := entity.AuditCreate, entity.Diff(nil, &after)
+		if existed {
+			action, changes = entity.AuditUpdate, entity.Diff(&before, &after)
+		}
+		if err := insertAudit(ctx, tx, id, action, changes); err != nil {
+			return nil, err
+		}
+		merged[id] = struct{}{}
+	}
+	return merged, nil
+}
+
+// ImportRejects returns one page of the job's rejected lines after line cursor.
+func (r *Repository) ImportRejects(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.ImportRejectPage, error) {
+	rows, err := r.read(ctx).QueryContext(ctx, queryImportRejects, reqctx.Tenant(ctx), id[:], cursor, limit+1)
+	if err != nil {
+		return entity.ImportRejectPage{}, err
+	}
+	defer rows.Close()
+
+	rejects := make([]entity.ImportReject, 0, limit+1)
+	for rows.Next() {
+		var rj entity.ImportReject
+		if err := rows.Scan(&rj.Line, &rj.Reason); err != nil {
+			return entity.ImportRejectPage{}, err
+		}
+		rejects = append(rejects, rj)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.ImportRejectPage{}, err
+	}
+
+	if len(rejects) <= limit {
+		return entity.ImportRejectPage{Items: rejects}, nil
+	}
+	return entity.ImportRejectPage{Items: rejects[:limit], HasMore: true}, nil
+}
+
+func scanImportJobRow(s scanner) (entity.ImportJob, error) {
+	j, err := scanImportJob(s)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.ImportJob{}, entity.ErrNotFound
+	}
+	return j, err
+}
+
+func scanImportJob(s scanner) (entity.ImportJob, error) {
+	var (
+		j                      entity.ImportJob
+		format, origin, status string
+		createdAt, updatedAt   int64
+	)
+	if err := s.Scan(&j.ID, &j.Tenant, &format, &origin, &j.Source, &status, &j.Line, &j.Accepted, &j.Rejected,
+		&j.Error, &j.Actor, &j.RequestID, &createdAt, &updatedAt,
+	); err != nil {
+		return entity.ImportJob{}, err
+	}
+	j.Format = entity.ImportFormat(format)
+	j.Origin = entity.ImportOrigin(origin)
+	j.Status = entity.ImportStatus(status)
+	j.CreatedAt = time.UnixMicro(createdAt)
+	j.UpdatedAt = time.UnixMicro(updatedAt)
+	return j, nil
+}
@@ -0,0 +1,185 @@
+package sqlite
+
+// Ids are bound as the 16 bytes of the UUID and timestamps as Unix microseconds;
+// see the sqlite migrations. ?1 is the tenant of every statement scoped to one.
+const (
+	queryInsert = `
+		INSERT INTO products (tenant_id, id, name, price_minor, currency, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6);`
+	queryGetByID = `
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE tenant_id = ?1 AND id = ?2 AND deleted_at IS NULL;`
+	// queryGetByIDs takes the ids as a JSON array of their hex digits.
+	queryGetByIDs = `
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE tenant_id = ?1 AND id IN (SELECT unhex(value) FROM json_each(?2)) AND deleted_at IS NULL;`
+	// queryRecentlyUpdated spans tenants; ties are broken by key so the order is stable.
+	queryRecentlyUpdated = `
+		SELECT tenant_id, id
+		FROM products
+		WHERE deleted_at IS NULL
+		ORDER BY updated_at DESC, tenant_id, id
+		LIMIT ?1;`
+	queryGetAnyByID = `
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE tenant_id = ?1 AND id = ?2;`
+	queryGetAll = `
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE tenant_id = ?1 AND (deleted_at IS NULL OR ?3) AND (?4 = '' OR currency = ?4)
+		ORDER BY id
+		LIMIT ?2;`
+	queryGetAllAfterCursor = `
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE tenant_id = ?1 AND id > ?2 AND (deleted_at IS NULL OR ?4) AND (?5 = '' OR currency = ?5)
+		ORDER BY id
+		LIMIT ?3;`
+	queryExport = `
+		SELECT id, name, price_minor, currency, deleted_at
+		FROM products
+		WHERE tenant_id = ?1 AND (deleted_at IS NULL OR ?2) AND (?3 = '' OR currency = ?3)
+		ORDER BY id;`
+	queryUpdate = `
+		UPDATE products
+		SET name = ?3, price_minor = ?4, currency = ?5, updated_at = ?6
+		WHERE tenant_id = ?1 AND id = ?2 AND deleted_at IS NULL;`
+	queryDelete = `
+		UPDATE products
+		SET deleted_at = ?3
+		WHERE tenant_id = ?1 AND id = ?2 AND deleted_at IS NULL;`
+	queryRestore = `
+		UPDATE products
+		SET deleted_at = NULL, updated_at = ?3
+		WHERE tenant_id = ?1 AND id = ?2 AND deleted_at IS NOT NULL;`
+	// queryAuditPurge records the purge of the products queryPurge is about to delete, in every
+	// tenant. Both run in one transaction on the single writer connection, so they see the same rows.
+	queryAuditPurge = `
+		INSERT INTO product_audit (tenant_id, product_id, action, actor, occurred_at)
+		SELECT tenant_id, id, 'purge', ?2, ?3
+		FROM products
+		WHERE deleted_at < ?1
+		ORDER BY tenant_id, id;`
+	queryPurge = `
+		DELETE FROM products
+		WHERE deleted_at < ?1;`
+
+	queryInsertAudit = `
+		INSERT INTO product_audit (tenant_id, product_id, action, actor, request_id, occurred_at, changes)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7);`
+	queryHistory = `
+		SELECT id, product_id, action, actor, request_id, occurred_at, changes
+		FROM product_audit
+		WHERE tenant_id = ?1 AND product_id = ?2
+		ORDER BY id DESC
+		LIMIT ?3;`
+	queryHistoryBeforeCursor = `
+		SELECT id, product_id, action, actor, request_id, occurred_at, changes
+		FROM product_audit
+		WHERE tenant_id = ?1 AND product_id = ?2 AND id < ?3
+		ORDER BY id DESC
+		LIMIT ?4;`
+	queryAuditLog = `
+		SELECT id, product_id, action, actor, request_id, occurred_at, changes
+		FROM product_audit
+		WHERE tenant_id = ?1 AND occurred_at >= ?2
+		ORDER BY id;`
+
+	queryInsertImportJob = `
+		INSERT INTO import_jobs (tenant_id, id, format, origin, source, status, actor, request_id,
+		                         created_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?9);`
+	queryGetImportJob = `
+		SELECT id, tenant_id, format, origin, source, status, line, accepted, rejected, error, actor,
+		       request_id, created_at, updated_at
+		FROM import_jobs
+		WHERE tenant_id = ?1 AND id = ?2;`
+	// queryClaimImportJob claims across tenants. Running jobs count as abandoned once their owner
+	// stops advancing updated_at before ?2. The single writer connection serializes claims, so no
+	// row locking is needed.
+	queryClaimImportJob = `
+		UPDATE import_jobs
+		SET status = 'running', updated_at = ?3
+		WHERE id = (
+			SELECT id
+			FROM import_jobs
+			WHERE origin = ?1 AND (status = 'pending' OR (status = 'running' AND updated_at < ?2))
+			ORDER BY created_at
+			LIMIT 1
+		)
+		RETURNING id, tenant_id, format, origin, source, status, line, accepted, rejected, error, actor,
+		          request_id, created_at, updated_at;`
+	queryResumeImportJob = `
+		UPDATE import_jobs
+		SET status = ?4, error = '', updated_at = ?5
+		WHERE tenant_id = ?1 AND id = ?2 AND origin = ?3 AND status <> 'completed'
+		RETURNING id, tenant_id, format, origin, source, status, line, accepted, rejected, error, actor,
+		          request_id, created_at, updated_at;`
+	queryImportCheckpoint = `
+		SELECT line
+		FROM import_jobs
+		WHERE tenant_id = ?1 AND id = ?2;`
+	queryAdvanceImportJob = `
+		UPDATE import_jobs
+		SET line = ?3, accepted = accepted + ?4, rejected = rejected + ?5, updated_at = ?6
+		WHERE tenant_id = ?1 AND id = ?2
+		RETURNING id, tenant_id, format, origin, source, status, line, accepted, rejected, error, actor,
+		          request_id, created_at, updated_at;`
+	queryFinishImportJob = `
+		UPDATE import_jobs
+		SET status = ?3, error = ?4, updated_at = ?5
+		WHERE tenant_id = ?1 AND id = ?2;`
+	queryInsertImportReject = `
+		INSERT INTO import_rejects (tenant_id, job_id, line, reason)
+		VALUES (?1, ?2, ?3, ?4);`
+	queryImportRejects = `
+		SELECT line, reason
+		FROM import_rejects
+		WHERE tenant_id = ?1 AND job_id = ?2 AND line > ?3
+		ORDER BY line
+		LIMIT ?4;`
+	queryInsertImportSource = `
+		INSERT INTO import_sources (tenant_id, job_id, part, data)
+		VALUES (?1, ?2, ?3, ?4);`
+	queryGetImportSource = `
+		SELECT data
+		FROM import_sources
+		WHERE tenant_id = ?1 AND job_id = ?2 AND part = ?3;`
+	queryDeleteImportSource = `
+		DELETE FROM import_sources
+		WHERE tenant_id = ?1 AND job_id = ?2;`
+	queryUpsertImport = `
+		INSERT INTO products (tenant_id, id, name, price_minor, currency, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6)
+		ON CONFLICT (tenant_id, id) DO UPDATE
+		SET name = excluded.name, price_minor = excluded.price_minor, currency = excluded.currency,
+		    updated_at = excluded.updated_at;`
+)
+
+// Scopes are stored space-separated.
+const (
+	queryInsertAPIKey = `
+		INSERT INTO api_keys (tenant_id, id, name, hash, scopes, tier, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7);`
+	// queryFindAPIKey spans tenants: the key is what names the tenant.
+	queryFindAPIKey = `
+		SELECT id, tenant_id, name, scopes, tier, last_used_at, revoked_at, created_at
+		FROM api_keys
+		WHERE hash = ?1 AND revoked_at IS NULL;`
+	queryListAPIKeys = `
+		SELECT id, tenant_id, name, scopes, tier, last_used_at, revoked_at, created_at
+		FROM api_keys
+		WHERE tenant_id = ?1
+		ORDER BY created_at, id;`
+	queryRevokeAPIKey = `
+		UPDATE api_keys
+		SET revoked_at = ?3
+		WHERE tenant_id = ?1 AND id = ?2 AND revoked_at IS NULL;`
+	queryTouchAPIKey = `
+		UPDATE api_keys
+		SET last_used_at = ?2
+		WHERE id = ?1 AND (last_used_at IS NULL OR last_used_at < ?2);`
+)
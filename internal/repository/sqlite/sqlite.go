@@ -0,0 +1,393 @@
+// Package sqlite is a product store on an embedded SQLite database, for single-node
+// deployments that cannot run PostgreSQL. It has the semantics of the PostgreSQL repository
+// and passes the same conformance suite. The schema comes from the sqlite migration set.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+type (
+	// Repository writes through a single connection, so writers queue in the pool instead of
+	// contending for the database lock, and reads through a pool of read-only connections,
+	// which WAL mode lets run alongside the writer.
+	Repository struct {
+		logger *slog.Logger
+		writer *sql.DB
+		reader *sql.DB
+	}
+	// querier is the statement surface shared by the pools and transactions.
+	querier interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	}
+	scanner interface {
+		Scan(dest ...any) error
+	}
+)
+
+// Open opens the read-write connection to the database file at cfg.Path, creating the file
+// and switching it to WAL mode when needed. It is the writer of New and the connection
+// cmd/migrate applies the sqlite migrations through. Its transactions begin IMMEDIATE,
+// taking the write lock up front, so they wait out a writer in another process for
+// cfg.BusyTimeout instead of failing when they first write.
+func Open(cfg config.SQLite) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dsn(cfg, url.Values{
+		"_txlock": {"immediate"},
+		"_pragma": {"journal_mode(WAL)", "synchronous(NORMAL)", "foreign_keys(1)"},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// New opens the database at cfg.Path. The schema must be migrated already.
+func New(ctx context.Context, l *slog.Logger, cfg config.SQLite) (*Repository, error) {
+	writer, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// The writer connects first, so the file exists and is in WAL mode before any reader opens it.
+	if err := writer.PingContext(ctx); err != nil {
+		_ = writer.Close()
+		return nil, fmt.Errorf("failed to ping sqlite database: %w", err)
+	}
+	reader, err := sql.Open("sqlite", dsn(cfg, url.Values{"_pragma": {"query_only(1)"}}))
+	if err != nil {
+		_ = writer.Close()
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	reader.SetMaxOpenConns(max(cfg.MaxReadConns, 1))
+	reader.SetMaxIdleConns(max(cfg.MaxReadConns, 1))
+	if err := reader.PingContext(ctx); err != nil {
+		_ = reader.Close()
+		_ = writer.Close()
+		return nil, fmt.Errorf("failed to ping sqlite database: %w", err)
+	}
+	l.Info("successfully opened sqlite database", slog.String("path", cfg.Path))
+	return new(Repository{logger: l, writer: writer, reader: reader}), nil
+}
+
+// dsn builds the driver DSN for cfg.Path with params, adding the busy timeout every
+// connection needs.
+func dsn(cfg config.SQLite, params url.Values) string {
+	busy := "busy_timeout(" + strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10) + ")"
+	params["_pragma"] = append([]string{busy}, params["_pragma"]...)
+	return "file:" + cfg.Path + "?" + params.Encode()
+}
+
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.reader.PingContext(ctx)
+}
+
+// Replicas reports no replicas; the database is a local file.
+func (r *Repository) Replicas() []entity.ReplicaStatus {
+	return nil
+}
+
+func (r *Repository) Close() {
+	if err := errors.Join(r.reader.Close(), r.writer.Close()); err != nil {
+		r.logger.Error("failed to close sqlite database", slog.Any("error", err))
+	}
+	r.logger.Info("sqlite database closed")
+}
+
+func (r *Repository) Save(ctx context.Context, p entity.Product) (entity.Product, error) {
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		return insertProduct(ctx, tx, p)
+	})
+	if err != nil {
+		return entity.Product{}, err
+	}
+	return p, nil
+}
+
+// SaveAll inserts products and their audit entries atomically.
+func (r *Repository) SaveAll(ctx context.Context, ps []entity.Product) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		for _, p := range ps {
+			if err := insertProduct(ctx, tx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func insertProduct(ctx context.Context, tx querier, p entity.Product) error {
+	_, err := tx.ExecContext(ctx, queryInsert, reqctx.Tenant(ctx), p.ID[:], p.Name, p.Price.MinorAmount,
+		string(p.Price.Currency), now().UnixMicro())
+	if err != nil {
+		return err
+	}
+	return insertAudit(ctx, tx, p.ID, entity.AuditCreate, entity.Diff(nil, &p))
+}
+
+func (r *Repository) FindByID(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	p, err := scanProduct(r.read(ctx).QueryRowContext(ctx, queryGetByID, reqctx.Tenant(ctx), id[:]))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Product{}, entity.ErrNotFound
+	}
+	return p, err
+}
+
+// FindByIDs returns the live products among ids, in no particular order; missing and deleted
+// ones are left out.
+func (r *Repository) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]entity.Product, error) {
+	hexIDs := make([]string, len(ids))
+	for i, id := range ids {
+		hexIDs[i] = hex.EncodeToString(id[:])
+	}
+	list, err := json.Marshal(hexIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.read(ctx).QueryContext(ctx, queryGetByIDs, reqctx.Tenant(ctx), string(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := make([]entity.Product, 0, len(ids))
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+// RecentlyUpdated returns the live products of every tenant that were created, changed or
+// restored last, newest first, at most limit of them.
+func (r *Repository) RecentlyUpdated(ctx context.Context, limit int) ([]entity.ProductRef, error) {
+	rows, err := r.read(ctx).QueryContext(ctx, queryRecentlyUpdated, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := make([]entity.ProductRef, 0, limit)
+	for rows.Next() {
+		var ref entity.ProductRef
+		if err := rows.Scan(&ref.Tenant, &ref.ID); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// FindAll returns the page of products after cursor in id order. Ids are stored as their
+// 16 bytes, which SQLite compares like PostgreSQL compares UUIDs, so pages match.
+func (r *Repository) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int, f entity.ProductFilter,
+) (entity.ProductPage, error) {
+	var (
+		rows   *sql.Rows
+		err    error
+		tenant = reqctx.Tenant(ctx)
+	)
+	if cursor.Valid {
+		rows, err = r.read(ctx).QueryContext(ctx, queryGetAllAfterCursor,
+			tenant, cursor.UUID[:], limit+1, f.IncludeDeleted, string(f.Currency))
+	} else {
+		rows, err = r.read(ctx).QueryContext(ctx, queryGetAll,
+			tenant, limit+1, f.IncludeDeleted, string(f.Currency))
+	}
+	if err != nil {
+		return entity.ProductPage{}, err
+	}
+	defer rows.Close()
+
+	products := make([]entity.Product, 0, limit+1)
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return entity.ProductPage{}, err
+		}
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.ProductPage{}, err
+	}
+
+	if len(products) <= limit {
+		return entity.ProductPage{Items: products}, nil
+	}
+	return entity.ProductPage{Items: products[:limit], HasMore: true}, nil
+}
+
+// Export streams every product matching f in id order. The rows come from one read
+// transaction, which pins a single snapshot until iteration ends; SQLite steps through
+// the result without buffering it.
+func (r *Repository) Export(ctx context.Context, f entity.ProductFilter) iter.Seq2[entity.Product, error] {
+	return func(yield func(entity.Product, error) bool) {
+		tx, err := r.reader.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			yield(entity.Product{}, err)
+			return
+		}
+		// Nothing is written, so the transaction always ends in a rollback.
+		defer func() { _ = tx.Rollback() }()
+
+		rows, err := tx.QueryContext(ctx, queryExport, reqctx.Tenant(ctx), f.IncludeDeleted, string(f.Currency))
+		if err != nil {
+			yield(entity.Product{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			p, err := scanProduct(rows)
+			if !yield(p, err) || err != nil {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(entity.Product{}, err)
+		}
+	}
+}
+
+func (r *Repository) Update(ctx context.Context, p entity.Product) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := findLiveProduct(ctx, tx, p.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, queryUpdate, reqctx.Tenant(ctx), p.ID[:], p.Name, p.Price.MinorAmount,
+			string(p.Price.Currency), now().UnixMicro())
+		if err != nil {
+			return err
+		}
+		return insertAudit(ctx, tx, p.ID, entity.AuditUpdate, entity.Diff(&before, &p))
+	})
+}
+
+func (r *Repository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := findLiveProduct(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		after := before
+		after.DeletedAt = now()
+		_, err = tx.ExecContext(ctx, queryDelete, reqctx.Tenant(ctx), id[:], after.DeletedAt.UnixMicro())
+		if err != nil {
+			return err
+		}
+		return insertAudit(ctx, tx, id, entity.AuditDelete, entity.Diff(&before, &after))
+	})
+}
+
+// Restore clears the tombstone of a soft-deleted product and returns it.
+func (r *Repository) Restore(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	var restored entity.Product
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := findProduct(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !before.Deleted() {
+			return entity.ErrNotFound
+		}
+		if _, err := tx.ExecContext(ctx, queryRestore, reqctx.Tenant(ctx), id[:], now().UnixMicro()); err != nil {
+			return err
+		}
+		restored = before
+		restored.DeletedAt = time.Time{}
+		return insertAudit(ctx, tx, id, entity.AuditRestore, entity.Diff(&before, &restored))
+	})
+	if err != nil {
+		return entity.Product{}, err
+	}
+	return restored, nil
+}
+
+// Purge hard-deletes products of every tenant whose tombstone is older than before.
+func (r *Repository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		cutoff := before.UnixMicro()
+		_, err := tx.ExecContext(ctx, queryAuditPurge, cutoff, reqctx.Actor(ctx), now().UnixMicro())
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, queryPurge, cutoff)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n, err
+}
+
+// findProduct reads the product by id, including tombstoned ones. Inside a write
+// transaction the row cannot change underneath, since the writer holds the database lock.
+func findProduct(ctx context.Context, q querier, id uuid.UUID) (entity.Product, error) {
+	p, err := scanProduct(q.QueryRowContext(ctx, queryGetAnyByID, reqctx.Tenant(ctx), id[:]))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Product{}, entity.ErrNotFound
+	}
+	return p, err
+}
+
+// findLiveProduct is findProduct that treats tombstoned products as missing.
+func findLiveProduct(ctx context.Context, q querier, id uuid.UUID) (entity.Product, error) {
+	p, err := findProduct(ctx, q, id)
+	if err != nil {
+		return entity.Product{}, err
+	}
+	if p.Deleted() {
+		return entity.Product{}, entity.ErrNotFound
+	}
+	return p, nil
+}
+
+func scanProduct(s scanner) (entity.Product, error) {
+	var (
+		p         entity.Product
+		currency  string
+		deletedAt sql.NullInt64
+	)
+	if err := s.Scan(&p.ID, &p.Name, &p.Price.MinorAmount, &currency, &deletedAt); err != nil {
+		return entity.Product{}, err
+	}
+	p.Price.Currency = entity.Currency(currency)
+	p.DeletedAt = fromMicros(deletedAt)
+	return p, nil
+}
+
+// fromMicros converts a nullable Unix microsecond timestamp, NULL being the zero time.
+func fromMicros(t sql.NullInt64) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return time.UnixMicro(t.Int64)
+}
+
+// now truncates to the microsecond precision timestamps are stored with.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/migrate"
+	"github.com/alkmc/storefront/internal/repository/repotest"
+)
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repository {
+		return newTestRepository(t)
+	})
+}
+
+// newTestRepository opens a migrated database in a fresh file under t.TempDir.
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	cfg := config.SQLite{
+		Path:         filepath.Join(t.TempDir(), "storefront.db"),
+		BusyTimeout:  time.Second,
+		MaxReadConns: 2,
+	}
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := migrate.Up(t.Context(), db, migrate.DialectSQLite); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close migration db: %v", err)
+	}
+
+	repo, err := New(t.Context(), slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+	if err != nil {
+		t.Fatalf("failed to open repository: %v", err)
+	}
+	t.Cleanup(repo.Close)
+	return repo
+}
+
+func TestNew_WALMode(t *testing.T) {
+	repo := newTestRepository(t)
+
+	var mode string
+	if err := repo.reader.QueryRowContext(t.Context(), "PRAGMA journal_mode;").Scan(&mode); err != nil {
+		t.Fatalf("failed to read journal mode: %v", err)
+	}
+	if mode != "wal" {
+		t.Errorf("got journal mode %q, want wal", mode)
+	}
+	if _, err := repo.reader.ExecContext(t.Context(), "DELETE FROM products;"); err == nil {
+		t.Error("expected the reader pool to reject writes")
+	}
+}
@@ -0,0 +1,196 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// constraintFields attributes the products constraints to the field they guard, by the name
+// SQLite reports them under. Keep it in sync with the sqlite migrations.
+var constraintFields = map[string]entity.ConstraintError{
+	"products.tenant_id, products.id": {Field: entity.FieldID, Reason: "a product with this id already exists"},
+	"products_price_minor_check": {
+		Field: entity.FieldPriceAmount, Reason: "the product price must be positive",
+	},
+	"products_currency_check": {Field: entity.FieldPriceCurrency, Reason: "the product currency is invalid"},
+	// Like the PostgreSQL repository, which learns no column from a value too long for it.
+	"products_name_length":     {Reason: "a product field is longer than allowed"},
+	"products_currency_length": {Reason: "a product field is longer than allowed"},
+}
+
+type (
+	txCtxKey struct{}
+	// unitOfWork is the transaction WithinTx stores in the context. It belongs to one goroutine;
+	// calls sharing it must not run concurrently.
+	unitOfWork struct {
+		repo *Repository
+		tx   *sql.Tx
+		// savepoints counts the open savepoints, naming the next one.
+		savepoints int
+		// afterCommit runs once the outermost transaction commits.
+		afterCommit []func(context.Context)
+	}
+)
+
+// WithinTx runs fn in a transaction carried by the context it passes to fn, with the same
+// contract as the PostgreSQL repository. The transaction holds the only write connection
+// while it runs, so fn must make its writes with that context; a write using another
+// context would wait for fn forever.
+func (r *Repository) WithinTx(ctx context.Context, fn func(context.Context) error) error {
+	if uow, ok := r.unitOfWork(ctx); ok {
+		return domainError(uow.savepoint(ctx, fn))
+	}
+	var uow *unitOfWork
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		uow = &unitOfWork{repo: r, tx: tx}
+		return fn(context.WithValue(ctx, txCtxKey{}, uow))
+	})
+	if err != nil {
+		return err
+	}
+	for _, hook := range uow.afterCommit {
+		hook(ctx)
+	}
+	return nil
+}
+
+// AfterCommit defers fn until the transaction in ctx commits and drops it if the transaction,
+// or the savepoint it was registered in, rolls back. Without a transaction fn runs at once.
+func (r *Repository) AfterCommit(ctx context.Context, fn func(context.Context)) {
+	uow, ok := r.unitOfWork(ctx)
+	if !ok {
+		fn(ctx)
+		return
+	}
+	uow.afterCommit = append(uow.afterCommit, fn)
+}
+
+// InTx reports whether ctx carries a transaction of this repository.
+func (r *Repository) InTx(ctx context.Context) bool {
+	_, ok := r.unitOfWork(ctx)
+	return ok
+}
+
+func (r *Repository) unitOfWork(ctx context.Context) (*unitOfWork, bool) {
+	uow, ok := ctx.Value(txCtxKey{}).(*unitOfWork)
+	if !ok || uow.repo != r {
+		return nil, false
+	}
+	return uow, true
+}
+
+// read returns the transaction in ctx, whose reads see its own writes, or the reader pool.
+func (r *Repository) read(ctx context.Context) querier {
+	if uow, ok := r.unitOfWork(ctx); ok {
+		return uow.tx
+	}
+	return r.reader
+}
+
+// inTx runs fn in a transaction on the writer, committing on success and rolling back on
+// error or panic, or joins the transaction in ctx. Constraint violations come back as
+// entity errors. Unlike PostgreSQL, SQLite has no transient failures worth retrying here:
+// the single writer never deadlocks, and a lock held by another process is waited out.
+func (r *Repository) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	if uow, ok := r.unitOfWork(ctx); ok {
+		return domainError(fn(uow.tx))
+	}
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback transaction: %w", errors.Join(err, rollbackErr))
+		}
+		return domainError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// savepoint runs fn inside a savepoint of the transaction, rolling back to it on error or panic.
+// After-commit hooks registered by fn are discarded with its work.
+func (uow *unitOfWork) savepoint(ctx context.Context, fn func(context.Context) error) error {
+	uow.savepoints++
+	name := "sp_" + strconv.Itoa(uow.savepoints)
+	hooks := len(uow.afterCommit)
+	if _, err := uow.tx.ExecContext(ctx, "SAVEPOINT "+name+";"); err != nil {
+		return fmt.Errorf("create savepoint: %w", err)
+	}
+	rollback := func(ctx context.Context) error {
+		uow.afterCommit = uow.afterCommit[:hooks]
+		_, err := uow.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name+";")
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = rollback(context.WithoutCancel(ctx))
+			panic(p)
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		if rollbackErr := rollback(ctx); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback to savepoint: %w", errors.Join(err, rollbackErr))
+		}
+		return err
+	}
+	if _, err := uow.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name+";"); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+	return nil
+}
+
+// domainError translates integrity violations into entity.ConstraintError and passes anything else through.
+func domainError(err error) error {
+	se, ok := errors.AsType[*sqlite.Error](err)
+	if !ok {
+		return err
+	}
+	var ce entity.ConstraintError
+	switch se.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY, sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		ce = constraintError(se, entity.ErrConflict, "the product conflicts with an existing one")
+	case sqlite3.SQLITE_CONSTRAINT_CHECK:
+		ce = constraintError(se, entity.ErrConstraintViolation, "the product violates a data constraint")
+	default:
+		return err
+	}
+	return &ce
+}
+
+// constraintError looks up the field guarded by the violated constraint, falling back to reason.
+// SQLite names the constraint only in the message, as in "CHECK constraint failed: <name>",
+// or "UNIQUE constraint failed: <table>.<column>, ..." for keys, followed by the result code.
+func constraintError(se *sqlite.Error, kind error, reason string) entity.ConstraintError {
+	msg := se.Error()
+	name := ""
+	if i := strings.LastIndex(msg, "constraint failed: "); i >= 0 {
+		name = msg[i+len("constraint failed: "):]
+		if j := strings.LastIndex(name, " ("); j >= 0 {
+			name = name[:j]
+		}
+	}
+	ce, ok := constraintFields[name]
+	if !ok {
+		ce.Reason = reason
+	}
+	ce.Err = kind
+	return ce
+}
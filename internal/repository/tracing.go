@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/alkmc/storefront/internal/repository")
+
+// queryTracer records the statements, batches and copies of pgx connections, of both drivers,
+// in client spans named by their operation. Statements carry their arguments as parameters,
+// so the query text is recorded as it is.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData,
+) context.Context {
+	op := operation(data.SQL)
+	return startSpan(ctx, conn, op, semconv.DBOperationName(op), semconv.DBQueryText(data.SQL))
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	endQuerySpan(ctx, data.Err)
+}
+
+func (queryTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData,
+) context.Context {
+	return startSpan(ctx, conn, "BATCH",
+		semconv.DBOperationName("BATCH"), semconv.DBOperationBatchSize(data.Batch.Len()))
+}
+
+// TraceBatchQuery records each statement of a batch as an event of its span.
+func (queryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	attrs := []attribute.KeyValue{semconv.DBQueryText(data.SQL)}
+	if data.Err != nil {
+		attrs = append(attrs, attribute.String("error.message", data.Err.Error()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("query", trace.WithAttributes(attrs...))
+}
+
+func (queryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	endQuerySpan(ctx, data.Err)
+}
+
+func (queryTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData,
+) context.Context {
+	table := data.TableName.Sanitize()
+	return startSpan(ctx, conn, "COPY "+table, semconv.DBOperationName("COPY"), semconv.DBCollectionName(table))
+}
+
+func (queryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	endQuerySpan(ctx, data.Err)
+}
+
+// startSpan starts the client span of operation op against the database of conn, with attrs.
+func startSpan(ctx context.Context, conn *pgx.Conn, op string, attrs ...attribute.KeyValue) context.Context {
+	cfg := conn.Config()
+	attrs = append(attrs, semconv.DBSystemNamePostgreSQL, semconv.DBNamespace(cfg.Database),
+		semconv.ServerAddress(cfg.Host), semconv.ServerPort(int(cfg.Port)))
+	ctx, _ = tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx
+}
+
+// endQuerySpan ends the span of ctx, marking it failed by err. A query returning no rows has
+// not failed: it answers that there are none.
+func endQuerySpan(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// operation returns the leading keyword of query, such as SELECT or WITH.
+func operation(query string) string {
+	query = strings.TrimLeftFunc(query, unicode.IsSpace)
+	if i := strings.IndexFunc(query, unicode.IsSpace); i >= 0 {
+		query = query[:i]
+	}
+	return strings.ToUpper(query)
+}
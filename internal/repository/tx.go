@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SQLSTATE codes the repository reacts to.
+const (
+	sqlStateUniqueViolation      = "23505"
+	sqlStateCheckViolation       = "23514"
+	sqlStateStringTooLong        = "22001"
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+	// sqlStateClassConnection prefixes connection exceptions, such as 08006 connection_failure.
+	sqlStateClassConnection = "08"
+)
+
+// constraintFields attributes the products constraints to the field they guard.
+// Keep it in sync with the constraint names in the migrations.
+var constraintFields = map[string]entity.ConstraintError{
+	"products_pkey": {Field: entity.FieldID, Reason: "a product with this id already exists"},
+	"products_price_minor_check": {
+		Field: entity.FieldPriceAmount, Reason: "the product price must be positive",
+	},
+	"products_currency_check": {Field: entity.FieldPriceCurrency, Reason: "the product currency is invalid"},
+}
+
+// Operations whose isolation level PG_TX_ISOLATION sets. Product lookups and listings, and
+// writes, default to READ COMMITTED, exports to REPEATABLE READ. Inside WithinTx every
+// operation runs at the level of within_tx.
+const (
+	opRead     = "read"
+	opExport   = "export"
+	opSave     = "save"
+	opUpdate   = "update"
+	opDelete   = "delete"
+	opRestore  = "restore"
+	opPurge    = "purge"
+	opWithinTx = "within_tx"
+)
+
+var (
+	txOperations = []string{opRead, opExport, opSave, opUpdate, opDelete, opRestore, opPurge, opWithinTx}
+	// isolationLevels names the levels PG_TX_ISOLATION accepts.
+	isolationLevels = map[string]sql.IsolationLevel{
+		"read_committed":  sql.LevelReadCommitted,
+		"repeatable_read": sql.LevelRepeatableRead,
+		"serializable":    sql.LevelSerializable,
+	}
+)
+
+var (
+	// txReadOnly reads at the default isolation level.
+	txReadOnly = txOptions{readOnly: true}
+	// txSnapshot reads a consistent, read-only view of the database.
+	txSnapshot = txOptions{isolation: sql.LevelRepeatableRead, readOnly: true}
+	// txAllTenants writes across tenants, for maintenance.
+	txAllTenants = txOptions{allTenants: true}
+	// txAllTenantsReadOnly reads across tenants, for maintenance.
+	txAllTenantsReadOnly = txOptions{readOnly: true, allTenants: true}
+)
+
+type (
+	// retryPolicy bounds how often and how fast a failed transaction is attempted again.
+	retryPolicy struct {
+		attempts  int
+		baseDelay time.Duration
+		maxDelay  time.Duration
+	}
+	// commitError marks a failed COMMIT, whose outcome is unknown when the connection dropped.
+	commitError struct{ err error }
+)
+
+// newIsolation parses the isolation level of each operation in cfg.TxIsolation.
+func newIsolation(cfg config.Postgres) (map[string]sql.IsolationLevel, error) {
+	isolation := make(map[string]sql.IsolationLevel, len(cfg.TxIsolation))
+	for op, name := range cfg.TxIsolation {
+		if !slices.Contains(txOperations, op) {
+			return nil, fmt.Errorf("PG_TX_ISOLATION: unknown operation %q, want one of %s",
+				op, strings.Join(txOperations, ", "))
+		}
+		level, ok := isolationLevels[name]
+		if !ok {
+			return nil, fmt.Errorf("PG_TX_ISOLATION: unknown isolation level %q for %s", name, op)
+		}
+		isolation[op] = level
+	}
+	return isolation, nil
+}
+
+func newRetryPolicy(cfg config.Postgres) retryPolicy {
+	return retryPolicy{
+		attempts:  max(cfg.RetryAttempts, 1),
+		baseDelay: cfg.RetryBaseDelay,
+		maxDelay:  cfg.RetryMaxDelay,
+	}
+}
+
+func (e commitError) Error() string { return "commit: " + e.err.Error() }
+
+func (e commitError) Unwrap() error { return e.err }
+
+// txFor returns opts at the isolation level configured for op, if there is one.
+func (pg *Repository) txFor(op string, opts txOptions) txOptions {
+	if level, ok := pg.isolation[op]; ok {
+		opts.isolation = level
+	}
+	return opts
+}
+
+// inTx runs fn in a READ COMMITTED transaction; see inTxWith.
+func (pg *Repository) inTx(ctx context.Context, fn func(dbTx) error) error {
+	return pg.inTxWith(ctx, txOptions{}, fn)
+}
+
+// inTxWith runs fn in a transaction with opts, retrying the whole transaction on transient
+// failures for as long as the retry policy and the deadline of ctx allow. fn may therefore run
+// more than once and must not have side effects outside the transaction. Constraint violations
+// come back as entity errors. Inside WithinTx, fn joins the transaction in ctx instead; opts
+// and retries are then left to the outermost call.
+func (pg *Repository) inTxWith(ctx context.Context, opts txOptions, fn func(dbTx) error) error {
+	if uow, ok := pg.unitOfWork(ctx); ok {
+		return domainError(fn(uow.tx))
+	}
+	for attempt := 1; ; attempt++ {
+		err := pg.runTx(ctx, opts, fn)
+		if err == nil || attempt >= pg.retry.attempts || ctx.Err() != nil || !retryable(err) {
+			return domainError(err)
+		}
+		delay := pg.retry.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return domainError(err)
+		}
+		pg.logger.WarnContext(ctx, "retrying transaction", slog.Int("attempt", attempt),
+			slog.Duration("delay", delay), slog.Any("error", err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return domainError(err)
+		case <-timer.C:
+		}
+	}
+}
+
+// runTx runs fn in a single transaction on the primary; see runTxOn.
+func (pg *Repository) runTx(ctx context.Context, opts txOptions, fn func(dbTx) error) error {
+	return pg.runTxOn(ctx, pg.db, opts, fn)
+}
+
+// runTxOn runs fn in a single transaction on db, committing on success and rolling back on error
+// or panic. The panic case matters for Export, whose fn runs the caller's loop body. Unless opts
+// ask for all tenants, the transaction is scoped to the tenant in ctx before fn runs, so row-level
+// security hides the rows of every other tenant from it.
+func (pg *Repository) runTxOn(ctx context.Context, db driver, opts txOptions, fn func(dbTx) error) error {
+	tx, err := db.begin(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.rollback(context.WithoutCancel(ctx))
+			panic(p)
+		}
+	}()
+	if !opts.allTenants {
+		if _, err := tx.exec(ctx, queryScopeTenant, reqctx.Tenant(ctx)); err != nil {
+			_ = tx.rollback(ctx)
+			return fmt.Errorf("scope transaction to tenant: %w", err)
+		}
+	}
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.rollback(ctx); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback transaction: %w", errors.Join(err, rollbackErr))
+		}
+		return err
+	}
+	if err := tx.commit(ctx); err != nil {
+		return commitError{err}
+	}
+	return nil
+}
+
+// backoff returns a random delay in [0, min(maxDelay, baseDelay·2^(attempt-1))), the "full jitter"
+// scheme, so clients that collided once do not collide again in lockstep.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	ceiling := min(p.maxDelay, p.baseDelay<<min(attempt-1, 30))
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// retryable reports whether a failed transaction can safely run again. PostgreSQL rolls back
+// serialization failures and deadlock victims itself. A lost connection rolls the transaction
+// back as well, unless it was lost during COMMIT, when the outcome is unknown and only errors
+// raised before anything reached the server qualify.
+func retryable(err error) bool {
+	if pgErr, ok := errors.AsType[*pgconn.PgError](err); ok {
+		switch pgErr.Code {
+		case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, sqlStateClassConnection)
+	}
+	if pgconn.SafeToRetry(err) || errors.Is(err, sqldriver.ErrBadConn) {
+		return true
+	}
+	if _, ok := errors.AsType[*pgconn.ConnectError](err); ok {
+		return true
+	}
+	if _, ok := errors.AsType[commitError](err); ok {
+		return false
+	}
+	_, isNet := errors.AsType[net.Error](err)
+	return isNet || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// domainError translates integrity violations into entity.ConstraintError and passes anything else through.
+func domainError(err error) error {
+	pgErr, ok := errors.AsType[*pgconn.PgError](err)
+	if !ok {
+		return err
+	}
+	var ce entity.ConstraintError
+	switch pgErr.Code {
+	case sqlStateUniqueViolation:
+		ce = constraintError(pgErr, entity.ErrConflict, "the product conflicts with an existing one")
+	case sqlStateCheckViolation:
+		ce = constraintError(pgErr, entity.ErrConstraintViolation, "the product violates a data constraint")
+	case sqlStateStringTooLong:
+		// PostgreSQL does not name the column. Product.Validate bounds every text field,
+		// so this only guards against callers that skipped it.
+		ce = entity.ConstraintError{
+			Reason: "a product field is longer than allowed",
+			Err:    entity.ErrConstraintViolation,
+		}
+	default:
+		return err
+	}
+	return &ce
+}
+
+// constraintError looks up the field guarded by the violated constraint, falling back to reason.
+func constraintError(pgErr *pgconn.PgError, kind error, reason string) entity.ConstraintError {
+	ce, ok := constraintFields[pgErr.ConstraintName]
+	if !ok {
+		ce.Reason = reason
+	}
+	ce.Err = kind
+	return ce
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"io"
+	"maps"
+	"testing"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestNewIsolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      map[string]string
+		want    map[string]sql.IsolationLevel
+		wantErr bool
+	}{
+		{name: "unset", want: map[string]sql.IsolationLevel{}},
+		{
+			name: "levels",
+			in:   map[string]string{opUpdate: "serializable", opExport: "serializable", opRead: "read_committed"},
+			want: map[string]sql.IsolationLevel{
+				opUpdate: sql.LevelSerializable, opExport: sql.LevelSerializable, opRead: sql.LevelReadCommitted,
+			},
+		},
+		{name: "unknown operation", in: map[string]string{"upsert": "serializable"}, wantErr: true},
+		{name: "unknown level", in: map[string]string{opSave: "snapshot"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newIsolation(config.Postgres{TxIsolation: tt.in})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTxFor(t *testing.T) {
+	pg := &Repository{isolation: map[string]sql.IsolationLevel{opExport: sql.LevelSerializable}}
+
+	want := txOptions{isolation: sql.LevelSerializable, readOnly: true}
+	if got := pg.txFor(opExport, txSnapshot); got != want {
+		t.Errorf("configured: got %+v, want %+v", got, want)
+	}
+	if got := pg.txFor(opUpdate, txOptions{}); got != (txOptions{}) {
+		t.Errorf("unconfigured: got %+v", got)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "serialization failure", err: &pgconn.PgError{Code: sqlStateSerializationFailure}, want: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: sqlStateDeadlockDetected}, want: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: sqlStateUniqueViolation}, want: false},
+		{name: "statement timeout", err: &pgconn.PgError{Code: "57014"}, want: false},
+		{name: "serialization failure at commit", err: commitError{&pgconn.PgError{Code: "40001"}}, want: true},
+		{name: "dropped connection", err: io.ErrUnexpectedEOF, want: true},
+		{name: "dropped connection at commit", err: commitError{io.ErrUnexpectedEOF}, want: false},
+		{name: "bad conn", err: sqldriver.ErrBadConn, want: true},
+		{name: "not found", err: sql.ErrNoRows, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
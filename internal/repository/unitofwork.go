@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type (
+	txCtxKey struct{}
+	// unitOfWork is the transaction WithinTx stores in the context. It belongs to one goroutine;
+	// calls sharing it must not run concurrently.
+	unitOfWork struct {
+		db driver
+		tx dbTx
+		// savepoints counts the open savepoints, naming the next one.
+		savepoints int
+		// afterCommit runs once the outermost transaction commits.
+		afterCommit []func(context.Context)
+	}
+)
+
+// WithinTx runs fn in a transaction carried by the context it passes to fn, so that every
+// repository call made with that context joins it and they commit or roll back as one.
+// Called again inside fn, it opens a savepoint instead, whose failure rolls back only the
+// work of the inner fn. The outermost call is retried on transient failures like any write,
+// so fn may run more than once. Export always reads its own snapshot and does not join.
+func (pg *Repository) WithinTx(ctx context.Context, fn func(context.Context) error) error {
+	if uow, ok := pg.unitOfWork(ctx); ok {
+		return domainError(uow.savepoint(ctx, fn))
+	}
+	var uow *unitOfWork
+	err := pg.inTxWith(ctx, pg.txFor(opWithinTx, txOptions{}), func(tx dbTx) error {
+		uow = &unitOfWork{db: pg.db, tx: tx}
+		return fn(context.WithValue(ctx, txCtxKey{}, uow))
+	})
+	if err != nil {
+		return err
+	}
+	for _, hook := range uow.afterCommit {
+		hook(ctx)
+	}
+	return nil
+}
+
+// AfterCommit defers fn until the transaction in ctx commits and drops it if the transaction,
+// or the savepoint it was registered in, rolls back. Without a transaction fn runs at once.
+func (pg *Repository) AfterCommit(ctx context.Context, fn func(context.Context)) {
+	uow, ok := pg.unitOfWork(ctx)
+	if !ok {
+		fn(ctx)
+		return
+	}
+	uow.afterCommit = append(uow.afterCommit, fn)
+}
+
+// InTx reports whether ctx carries a transaction of this repository.
+func (pg *Repository) InTx(ctx context.Context) bool {
+	_, ok := pg.unitOfWork(ctx)
+	return ok
+}
+
+// unitOfWork returns the transaction stored in ctx by WithinTx, ignoring those of other repositories.
+func (pg *Repository) unitOfWork(ctx context.Context) (*unitOfWork, bool) {
+	uow, ok := ctx.Value(txCtxKey{}).(*unitOfWork)
+	if !ok || uow.db != pg.db {
+		return nil, false
+	}
+	return uow, true
+}
+
+// conn runs fn on the transaction in ctx, or else in a read-only transaction of its own on the
+// primary, scoped to the tenant in ctx. Unlike inTx it never retries, so fn may stream rows
+// to the caller.
+func (pg *Repository) conn(ctx context.Context, fn func(querier) error) error {
+	if uow, ok := pg.unitOfWork(ctx); ok {
+		return fn(uow.tx)
+	}
+	return pg.runTx(ctx, txReadOnly, func(tx dbTx) error {
+		return fn(tx)
+	})
+}
+
+// savepoint runs fn inside a savepoint of the transaction, rolling back to it on error or panic.
+// After-commit hooks registered by fn are discarded with its work.
+func (uow *unitOfWork) savepoint(ctx context.Context, fn func(context.Context) error) (err error) {
+	uow.savepoints++
+	name := "sp_" + strconv.Itoa(uow.savepoints)
+	hooks := len(uow.afterCommit)
+	if _, err := uow.tx.exec(ctx, "SAVEPOINT "+name+";"); err != nil {
+		return fmt.Errorf("create savepoint: %w", err)
+	}
+	rollback := func(ctx context.Context) error {
+		uow.afterCommit = uow.afterCommit[:hooks]
+		_, err := uow.tx.exec(ctx, "ROLLBACK TO SAVEPOINT "+name+";")
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = rollback(context.WithoutCancel(ctx))
+			panic(p)
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		if rollbackErr := rollback(ctx); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback to savepoint: %w", errors.Join(err, rollbackErr))
+		}
+		return err
+	}
+	if _, err := uow.tx.exec(ctx, "RELEASE SAVEPOINT "+name+";"); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Package reqctx carries request-scoped identity, tenancy and consistency hints through
+// context.Context from the transport layer down to the repository.
+package reqctx
+
+import "context"
+
+const (
+	// Anonymous is the actor recorded for requests without an authenticated identity.
+	Anonymous = "anonymous"
+	// System is the actor recorded for background jobs.
+	System = "system"
+	// DefaultTenant owns the data of contexts that name no tenant, including everything
+	// stored before tenancy was introduced.
+	DefaultTenant = "default"
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	actorKey
+	tenantKey
+	readYourWritesKey
+)
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID stored in ctx, or "" when there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithActor returns a copy of ctx carrying the identity performing the request.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// Actor returns the identity stored in ctx, defaulting to Anonymous.
+func Actor(ctx context.Context) string {
+	if a, ok := ctx.Value(actorKey).(string); ok && a != "" {
+		return a
+	}
+	return Anonymous
+}
+
+// WithTenant returns a copy of ctx scoped to the tenant with the given id. Stores only
+// read and write the data of that tenant.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// Tenant returns the tenant stored in ctx, defaulting to DefaultTenant.
+func Tenant(ctx context.Context) string {
+	if t, ok := ctx.Value(tenantKey).(string); ok && t != "" {
+		return t
+	}
+	return DefaultTenant
+}
+
+// WithReadYourWrites returns a copy of ctx whose reads go to the primary database,
+// so they observe writes made just before instead of a possibly lagging replica.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesKey, true)
+}
+
+// ReadYourWrites reports whether ctx asks for reads from the primary.
+func ReadYourWrites(ctx context.Context) bool {
+	v, _ := ctx.Value(readYourWritesKey).(bool)
+	return v
+}
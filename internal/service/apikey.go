@@ -0,0 +1,121 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+const (
+	// apiKeyPrefix marks storefront secrets, so they are easy to spot in leaks and logs.
+	apiKeyPrefix = "sfk_"
+	// apiKeyBytes is the entropy of a secret.
+	apiKeyBytes = 32
+)
+
+type (
+	apiKeyStore interface {
+		CreateAPIKey(context.Context, entity.APIKey) (entity.APIKey, error)
+		FindAPIKey(context.Context, []byte) (entity.APIKey, error)
+		ListAPIKeys(context.Context) ([]entity.APIKey, error)
+		RevokeAPIKey(context.Context, uuid.UUID) error
+		TouchAPIKey(context.Context, uuid.UUID, time.Time) error
+	}
+	// APIKeys issues and verifies the API keys of the public API. Secrets are stored as their
+	// HMAC-SHA256 under a server-side pepper: a random 256-bit secret needs no slow hash, and
+	// the digest lets a request be authenticated with a single indexed lookup.
+	APIKeys struct {
+		logger     *slog.Logger
+		store      apiKeyStore
+		pepper     []byte
+		touchEvery time.Duration
+	}
+)
+
+// NewAPIKeys initializes API key management over store.
+func NewAPIKeys(l *slog.Logger, s apiKeyStore, cfg config.Auth) *APIKeys {
+	return new(APIKeys{
+		logger:     l,
+		store:      s,
+		pepper:     []byte(cfg.APIKeyPepper.Reveal()),
+		touchEvery: cfg.APIKeyTouchInterval,
+	})
+}
+
+// Create issues a key in the rate limit tier for the tenant in ctx, or in entity.DefaultTier
+// when tier is empty, and returns it with its secret, which is not kept and cannot be shown again.
+func (a *APIKeys) Create(ctx context.Context, name string, scopes []entity.Scope, tier string,
+) (entity.APIKey, string, error) {
+	k := entity.APIKey{Name: name, Scopes: scopes, Tier: cmp.Or(tier, entity.DefaultTier)}
+	if err := k.Validate(); err != nil {
+		return entity.APIKey{}, "", err
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return entity.APIKey{}, "", fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	raw := make([]byte, apiKeyBytes)
+	_, _ = rand.Read(raw) // never fails
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	k.ID, k.Hash = id, a.hash(secret)
+
+	created, err := a.store.CreateAPIKey(ctx, k)
+	if err != nil {
+		return entity.APIKey{}, "", err
+	}
+	created.Hash = nil
+	return created, secret, nil
+}
+
+// Authenticate returns the active key whose secret is given, of whichever tenant owns it.
+// Anything else fails with entity.ErrInvalidAPIKey. The last use is recorded at most once
+// per touch interval; failing to record it does not fail the request.
+func (a *APIKeys) Authenticate(ctx context.Context, secret string) (entity.APIKey, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return entity.APIKey{}, entity.ErrInvalidAPIKey
+	}
+	k, err := a.store.FindAPIKey(ctx, a.hash(secret))
+	if err != nil {
+		if errors.Is(err, entity.ErrNotFound) {
+			return entity.APIKey{}, entity.ErrInvalidAPIKey
+		}
+		return entity.APIKey{}, err
+	}
+
+	if now := time.Now(); now.Sub(k.LastUsedAt) >= a.touchEvery {
+		if err := a.store.TouchAPIKey(ctx, k.ID, now); err != nil {
+			a.logger.Warn("failed to record api key use", slog.Any("error", err), slog.String("id", k.ID.String()))
+		} else {
+			k.LastUsedAt = now
+		}
+	}
+	return k, nil
+}
+
+// List returns the keys of the tenant in ctx, revoked ones included.
+func (a *APIKeys) List(ctx context.Context) ([]entity.APIKey, error) {
+	return a.store.ListAPIKeys(ctx)
+}
+
+// Revoke revokes an active key of the tenant in ctx; entity.ErrNotFound otherwise.
+func (a *APIKeys) Revoke(ctx context.Context, id uuid.UUID) error {
+	return a.store.RevokeAPIKey(ctx, id)
+}
+
+func (a *APIKeys) hash(secret string) []byte {
+	mac := hmac.New(sha256.New, a.pepper)
+	mac.Write([]byte(secret))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	memrepo "github.com/alkmc/storefront/internal/repository/memory"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// touchCounter counts the recorded uses of keys.
+type touchCounter struct {
+	*memrepo.Repository
+	touches int
+}
+
+func (c *touchCounter) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	c.touches++
+	return c.Repository.TouchAPIKey(ctx, id, at)
+}
+
+func newTestAPIKeys(touchEvery time.Duration) (*APIKeys, *touchCounter) {
+	store := &touchCounter{Repository: memrepo.New()}
+	cfg := config.Auth{APIKeyPepper: config.Secret(strings.Repeat("p", 32)), APIKeyTouchInterval: touchEvery}
+	return NewAPIKeys(slog.New(slog.DiscardHandler), store, cfg), store
+}
+
+func TestAPIKeys_Authenticate(t *testing.T) {
+	ctx := reqctx.WithTenant(t.Context(), "acme")
+	keys, _ := newTestAPIKeys(time.Minute)
+
+	created, secret, err := keys.Create(ctx, "storefront", []entity.Scope{entity.ScopeProductsRead}, "")
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	if !strings.HasPrefix(secret, apiKeyPrefix) || created.Hash != nil {
+		t.Fatalf("got secret %q and hash %x, want a prefixed secret and no hash", secret, created.Hash)
+	}
+
+	// Keys are found by secret whatever the tenant of the request.
+	got, err := keys.Authenticate(t.Context(), secret)
+	if err != nil {
+		t.Fatalf("failed to authenticate: %v", err)
+	}
+	if got.ID != created.ID || got.Tenant != "acme" || got.Tier != entity.DefaultTier ||
+		!slices.Contains(got.Scopes, entity.ScopeProductsRead) {
+		t.Errorf("got %+v, want key %s of acme with products:read in the default tier", got, created.ID)
+	}
+
+	for _, bad := range []string{"", "sfk_", secret + "x", strings.TrimPrefix(secret, apiKeyPrefix)} {
+		if _, err := keys.Authenticate(t.Context(), bad); !errors.Is(err, entity.ErrInvalidAPIKey) {
+			t.Errorf("authenticating %q: got %v, want ErrInvalidAPIKey", bad, err)
+		}
+	}
+
+	if err := keys.Revoke(ctx, created.ID); err != nil {
+		t.Fatalf("failed to revoke: %v", err)
+	}
+	if _, err := keys.Authenticate(t.Context(), secret); !errors.Is(err, entity.ErrInvalidAPIKey) {
+		t.Errorf("got %v for a revoked key, want ErrInvalidAPIKey", err)
+	}
+	if err := keys.Revoke(ctx, created.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("got %v revoking twice, want ErrNotFound", err)
+	}
+}
+
+func TestAPIKeys_Create_Invalid(t *testing.T) {
+	keys, _ := newTestAPIKeys(time.Minute)
+
+	_, _, err := keys.Create(t.Context(), "storefront", []entity.Scope{"products:admin"}, "")
+	if _, ok := errors.AsType[*entity.ConstraintError](err); !ok {
+		t.Fatalf("got %v, want a *ConstraintError", err)
+	}
+}
+
+func TestAPIKeys_TouchIsThrottled(t *testing.T) {
+	keys, store := newTestAPIKeys(time.Hour)
+	_, secret, err := keys.Create(t.Context(), "storefront", entity.Scopes, "premium")
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+
+	for range 3 {
+		got, err := keys.Authenticate(t.Context(), secret)
+		if err != nil {
+			t.Fatalf("failed to authenticate: %v", err)
+		}
+		if got.LastUsedAt.IsZero() {
+			t.Error("expected the last use to be set")
+		}
+	}
+	if store.touches != 1 {
+		t.Errorf("got %d recorded uses, want 1 within the touch interval", store.touches)
+	}
+}
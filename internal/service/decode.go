@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"strings"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+)
+
+// maxImportLineBytes bounds a single NDJSON line; longer lines fail the whole import.
+const maxImportLineBytes = 1 << 20
+
+type (
+	// importRecord is one source line: a valid product or the reason it was rejected.
+	importRecord struct {
+		line    int64
+		product entity.Product
+		err     error
+	}
+	// csvColumns maps the required fields to their position in a CSV record; id is -1 when absent.
+	csvColumns struct {
+		id, name, minorAmount, currency int
+	}
+	importLine struct {
+		ID    uuid.UUID `json:"id"`
+		Name  string    `json:"name"`
+		Price struct {
+			MinorAmount int64           `json:"minorAmount"`
+			Currency    entity.Currency `json:"currency"`
+		} `json:"price"`
+	}
+)
+
+// decodeImport yields every record of src. A non-nil error is fatal and ends the sequence;
+// problems confined to one line are reported through importRecord.err instead.
+func decodeImport(format entity.ImportFormat, src io.Reader) iter.Seq2[importRecord, error] {
+	switch format {
+	case entity.ImportCSV:
+		return decodeCSV(src)
+	case entity.ImportNDJSON:
+		return decodeNDJSON(src)
+	default:
+		return func(yield func(importRecord, error) bool) {
+			yield(importRecord{}, fmt.Errorf("unsupported import format %q", format))
+		}
+	}
+}
+
+// decodeCSV expects a header naming the name, minorAmount and currency columns, plus an
+// optional id column, in any order.
+func decodeCSV(src io.Reader) iter.Seq2[importRecord, error] {
+	return func(yield func(importRecord, error) bool) {
+		r := csv.NewReader(src)
+		r.ReuseRecord = true
+		header, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			yield(importRecord{}, errors.New("csv header is missing"))
+			return
+		}
+		if err != nil {
+			yield(importRecord{}, fmt.Errorf("read csv header: %w", err))
+			return
+		}
+		cols, err := parseCSVHeader(header)
+		if err != nil {
+			yield(importRecord{}, err)
+			return
+		}
+		r.FieldsPerRecord = len(header)
+
+		for {
+			rec, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if pe, ok := errors.AsType[*csv.ParseError](err); ok {
+				if !yield(importRecord{line: int64(pe.StartLine), err: pe.Err}, nil) {
+					return
+				}
+				continue
+			}
+			if err != nil {
+				yield(importRecord{}, err)
+				return
+			}
+			line, _ := r.FieldPos(0)
+			p, err := cols.product(rec)
+			if err == nil {
+				p, err = prepareImport(p)
+			}
+			if !yield(importRecord{line: int64(line), product: p, err: err}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func parseCSVHeader(header []string) (csvColumns, error) {
+	cols := csvColumns{id: -1, name: -1, minorAmount: -1, currency: -1}
+	for i, h := range header {
+		var pos *int
+		switch h = strings.TrimSpace(h); h {
+		case "id":
+			pos = &cols.id
+		case "name":
+			pos = &cols.name
+		case "minorAmount":
+			pos = &cols.minorAmount
+		case "currency":
+			pos = &cols.currency
+		default:
+			return csvColumns{}, fmt.Errorf("unknown csv column %q", h)
+		}
+		if *pos >= 0 {
+			return csvColumns{}, fmt.Errorf("duplicate csv column %q", h)
+		}
+		*pos = i
+	}
+	if cols.name < 0 || cols.minorAmount < 0 || cols.currency < 0 {
+		return csvColumns{}, errors.New("csv header must contain name, minorAmount and currency")
+	}
+	return cols, nil
+}
+
+func (c csvColumns) product(rec []string) (entity.Product, error) {
+	var p entity.Product
+	if c.id >= 0 && rec[c.id] != "" {
+		id, err := uuid.Parse(rec[c.id])
+		if err != nil {
+			return entity.Product{}, fmt.Errorf("invalid id: %q", rec[c.id])
+		}
+		p.ID = id
+	}
+	amount, err := strconv.ParseInt(rec[c.minorAmount], 10, 64)
+	if err != nil {
+		return entity.Product{}, fmt.Errorf("invalid minorAmount: %q", rec[c.minorAmount])
+	}
+	p.Name = rec[c.name]
+	p.Price = entity.Money{MinorAmount: amount, Currency: entity.Currency(rec[c.currency])}
+	return p, nil
+}
+
+// decodeNDJSON expects one product per line in the shape accepted by POST /product,
+// optionally with an id. Blank lines are skipped.
+func decodeNDJSON(src io.Reader) iter.Seq2[importRecord, error] {
+	return func(yield func(importRecord, error) bool) {
+		sc := bufio.NewScanner(src)
+		sc.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+		var line int64
+		for sc.Scan() {
+			line++
+			raw := bytes.TrimSpace(sc.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			var in importLine
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			rec := importRecord{line: line}
+			if err := dec.Decode(&in); err != nil {
+				rec.err = errors.New("invalid json: " + strings.TrimPrefix(err.Error(), "json: "))
+			} else {
+				rec.product, rec.err = prepareImport(entity.Product{
+					ID:    in.ID,
+					Name:  in.Name,
+					Price: entity.Money{MinorAmount: in.Price.MinorAmount, Currency: in.Price.Currency},
+				})
+			}
+			if !yield(rec, nil) {
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			yield(importRecord{}, fmt.Errorf("read line %d: %w", line+1, err))
+		}
+	}
+}
+
+// prepareImport validates p and assigns an id when the source did not provide one.
+func prepareImport(p entity.Product) (entity.Product, error) {
+	if err := p.Validate(); err != nil {
+		return entity.Product{}, err
+	}
+	if p.ID == uuid.Nil {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return entity.Product{}, fmt.Errorf("failed to generate uuid: %w", err)
+		}
+		p.ID = id
+	}
+	return p, nil
+}
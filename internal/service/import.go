@@ -0,0 +1,404 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// importSourcePart is the size of the parts an upload is stored in, which bounds what a job
+// holds of its source in memory. Tests shrink it.
+var importSourcePart = 1 << 20
+
+type (
+	importStore interface {
+		WithinTx(context.Context, func(context.Context) error) error
+		CreateImportJob(context.Context, entity.ImportJob) (entity.ImportJob, error)
+		SaveImportSource(context.Context, uuid.UUID, int, []byte) error
+		ImportSource(context.Context, uuid.UUID, int) ([]byte, error)
+		DeleteImportSource(context.Context, uuid.UUID) error
+		FindImportJob(context.Context, uuid.UUID) (entity.ImportJob, error)
+		ClaimImportJob(context.Context, entity.ImportOrigin, time.Duration) (entity.ImportJob, error)
+		ResumeImportJob(
+			context.Context, uuid.UUID, entity.ImportOrigin, entity.ImportStatus,
+		) (entity.ImportJob, error)
+		FinishImportJob(context.Context, uuid.UUID, entity.ImportStatus, string) error
+		ImportChunk(context.Context, entity.ImportChunk) (entity.ImportJob, []uuid.UUID, error)
+		ImportRejects(context.Context, uuid.UUID, int64, int) (entity.ImportRejectPage, error)
+	}
+	// Importer runs bulk product imports as resumable jobs. Sources are processed in
+	// chunks of cfg.BatchSize lines, each committed together with the job checkpoint,
+	// so an interrupted job picks up after the last committed chunk.
+	Importer struct {
+		logger *slog.Logger
+		store  importStore
+		cache  cacher
+		cfg    config.Import
+		wake   chan struct{}
+	}
+	// storedSource reads the upload of a job back from the store, one part at a time.
+	storedSource struct {
+		ctx   context.Context
+		store importStore
+		id    uuid.UUID
+		part  int
+		buf   []byte
+		done  bool
+	}
+)
+
+// NewImporter initializes the import pipeline. An empty cfg.Dir spools uploads under os.TempDir.
+func NewImporter(l *slog.Logger, s importStore, c cacher, cfg config.Import) *Importer {
+	if cfg.Dir == "" {
+		cfg.Dir = filepath.Join(os.TempDir(), "storefront-imports")
+	}
+	return new(Importer{logger: l, store: s, cache: c, cfg: cfg, wake: make(chan struct{}, 1)})
+}
+
+// Submit stores src together with a new job and queues the job for Run. The source is kept
+// in the store rather than on local disk, so whichever instance claims the job can read it.
+func (im *Importer) Submit(ctx context.Context, format entity.ImportFormat, src io.Reader,
+) (entity.ImportJob, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return entity.ImportJob{}, fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	// The upload is spooled first: its size is checked before anything is stored, and the
+	// transaction below may have to read it more than once.
+	if err := os.MkdirAll(im.cfg.Dir, 0o750); err != nil {
+		return entity.ImportJob{}, fmt.Errorf("create import dir: %w", err)
+	}
+	path := filepath.Join(im.cfg.Dir, id.String()+"."+string(format))
+	if err := spool(path, src, im.cfg.MaxBytes); err != nil {
+		return entity.ImportJob{}, err
+	}
+	defer im.removeSpool(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return entity.ImportJob{}, fmt.Errorf("open import source: %w", err)
+	}
+	defer f.Close()
+
+	var job entity.ImportJob
+	err = im.store.WithinTx(ctx, func(ctx context.Context) error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewind import source: %w", err)
+		}
+		var err error
+		job, err = im.store.CreateImportJob(ctx, newImportJob(ctx, id, format, entity.ImportOriginAPI, "",
+			entity.ImportPending))
+		if err != nil {
+			return err
+		}
+		return im.saveSource(ctx, id, f)
+	})
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	im.notify()
+	return job, nil
+}
+
+// saveSource stores src as the upload of job id, in parts of importSourcePart bytes. An empty
+// upload still gets its first part, so a missing one tells a lost source from an empty one.
+func (im *Importer) saveSource(ctx context.Context, id uuid.UUID, src io.Reader) error {
+	buf := make([]byte, importSourcePart)
+	for part := 0; ; part++ {
+		n, err := io.ReadFull(src, buf)
+		switch {
+		case errors.Is(err, io.EOF) && part > 0:
+			return nil
+		case err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF):
+			return fmt.Errorf("read import source: %w", err)
+		}
+		if err := im.store.SaveImportSource(ctx, id, part, buf[:n]); err != nil {
+			return fmt.Errorf("store import source: %w", err)
+		}
+		if n < len(buf) {
+			return nil
+		}
+	}
+}
+
+// Retry queues an unfinished job submitted through Submit to continue from its checkpoint.
+func (im *Importer) Retry(ctx context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	job, err := im.store.ResumeImportJob(ctx, id, entity.ImportOriginAPI, entity.ImportPending)
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	im.notify()
+	return job, nil
+}
+
+// Import runs a job for the file at path to completion in the calling goroutine.
+func (im *Importer) Import(ctx context.Context, format entity.ImportFormat, path string,
+) (entity.ImportJob, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return entity.ImportJob{}, fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	job, err := im.store.CreateImportJob(ctx, newImportJob(ctx, id, format, entity.ImportOriginCLI, abs,
+		entity.ImportRunning))
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	return im.process(ctx, job)
+}
+
+// Resume continues an unfinished job started by Import, in the calling goroutine.
+func (im *Importer) Resume(ctx context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	job, err := im.store.ResumeImportJob(ctx, id, entity.ImportOriginCLI, entity.ImportRunning)
+	if err != nil {
+		return entity.ImportJob{}, err
+	}
+	return im.process(ctx, job)
+}
+
+func (im *Importer) Job(ctx context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	return im.store.FindImportJob(ctx, id)
+}
+
+func (im *Importer) Rejects(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.ImportRejectPage, error) {
+	return im.store.ImportRejects(ctx, id, cursor, limit)
+}
+
+// Run processes submitted jobs until ctx is done. Besides new submissions it periodically
+// claims jobs whose owner stopped checkpointing for cfg.Lease, e.g. after a crash or
+// redeploy, and resumes them from their checkpoint.
+func (im *Importer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(im.cfg.Lease / 2)
+	defer ticker.Stop()
+
+	for {
+		im.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-im.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (im *Importer) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := im.store.ClaimImportJob(ctx, entity.ImportOriginAPI, im.cfg.Lease)
+		if errors.Is(err, entity.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			im.logger.Error("failed to claim import job", slog.Any("error", err))
+			return
+		}
+		if _, err := im.process(ctx, job); err != nil {
+			im.logger.Warn("import job interrupted", slog.Any("error", err), slog.String("job_id", job.ID.String()))
+		}
+	}
+}
+
+// process loads the job's source from its checkpoint and records the outcome. A failed
+// job is not an error; the error result means the job was interrupted and stays running.
+func (im *Importer) process(ctx context.Context, job entity.ImportJob) (entity.ImportJob, error) {
+	// The import loads into the tenant that submitted it, and its audit entries are
+	// attributed to whoever did.
+	ctx = reqctx.WithActor(reqctx.WithRequestID(reqctx.WithTenant(ctx, job.Tenant), job.RequestID), job.Actor)
+	log := im.logger.With(slog.String("job_id", job.ID.String()))
+	log.Info("import started", slog.String("source", job.Source), slog.Int64("from_line", job.Line))
+
+	job, err := im.load(ctx, job)
+	switch {
+	case err == nil:
+		job.Status = entity.ImportCompleted
+	case ctx.Err() != nil, errors.Is(err, entity.ErrImportCheckpoint):
+		return job, err
+	default:
+		job.Status, job.Error = entity.ImportFailed, err.Error()
+	}
+
+	if err := im.store.FinishImportJob(ctx, job.ID, job.Status, job.Error); err != nil {
+		return job, fmt.Errorf("finish import job: %w", err)
+	}
+	// Failed uploads keep their source so Retry can continue from the checkpoint.
+	if job.Status == entity.ImportCompleted && job.Origin == entity.ImportOriginAPI {
+		im.removeSource(ctx, job)
+	}
+	log.Info("import finished", slog.String("status", string(job.Status)),
+		slog.Int64("accepted", job.Accepted), slog.Int64("rejected", job.Rejected), slog.String("error", job.Error))
+	return job, nil
+}
+
+// load streams the source into the store in chunks and returns the job as of its last checkpoint.
+func (im *Importer) load(ctx context.Context, job entity.ImportJob) (entity.ImportJob, error) {
+	src, err := im.openSource(ctx, job)
+	if err != nil {
+		return job, err
+	}
+	defer src.Close()
+
+	start := job.Line
+	chunk := entity.ImportChunk{JobID: job.ID, From: job.Line}
+	flush := func(last int64) error {
+		chunk.LastLine = last
+		next, updated, err := im.store.ImportChunk(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		im.invalidate(ctx, updated)
+		job = next
+		chunk = entity.ImportChunk{JobID: job.ID, From: job.Line}
+		return nil
+	}
+
+	var last int64
+	for rec, err := range decodeImport(job.Format, src) {
+		if err != nil {
+			return job, err
+		}
+		if rec.line <= start {
+			continue
+		}
+		last = rec.line
+		if rec.err != nil {
+			chunk.Rejects = append(chunk.Rejects, entity.ImportReject{Line: rec.line, Reason: rec.err.Error()})
+		} else {
+			chunk.Rows = append(chunk.Rows, entity.ImportRow{Line: rec.line, Product: rec.product})
+		}
+		if len(chunk.Rows)+len(chunk.Rejects) >= im.cfg.BatchSize {
+			if err := flush(last); err != nil {
+				return job, err
+			}
+		}
+	}
+	if len(chunk.Rows)+len(chunk.Rejects) > 0 {
+		if err := flush(last); err != nil {
+			return job, err
+		}
+	}
+	return job, nil
+}
+
+func (im *Importer) invalidate(ctx context.Context, ids []uuid.UUID) {
+	// Imported ids may fall on any page.
+	if err := im.cache.FlushPages(ctx); err != nil {
+		im.logger.Warn("cache flush pages failed", slog.Any("error", err))
+	}
+	for _, id := range ids {
+		key := id.String()
+		if err := im.cache.Invalidate(ctx, key); err != nil {
+			im.logger.Warn("cache invalidate failed", slog.Any("error", err), slog.String("key", key))
+		}
+	}
+}
+
+func (im *Importer) notify() {
+	select {
+	case im.wake <- struct{}{}:
+	default:
+	}
+}
+
+// openSource opens the upload of an API job kept in the store, or else the file the job names:
+// that of a CLI job, or the spooled upload of an API job submitted before uploads were stored.
+func (im *Importer) openSource(ctx context.Context, job entity.ImportJob) (io.ReadCloser, error) {
+	if job.Origin == entity.ImportOriginAPI && job.Source == "" {
+		return io.NopCloser(&storedSource{ctx: ctx, store: im.store, id: job.ID}), nil
+	}
+	f, err := os.Open(job.Source)
+	if err != nil {
+		return nil, fmt.Errorf("open import source: %w", err)
+	}
+	return f, nil
+}
+
+// removeSource drops the upload of a completed API job; see openSource.
+func (im *Importer) removeSource(ctx context.Context, job entity.ImportJob) {
+	if job.Source != "" {
+		im.removeSpool(job.Source)
+		return
+	}
+	if err := im.store.DeleteImportSource(ctx, job.ID); err != nil {
+		im.logger.Warn("failed to delete import source", slog.Any("error", err),
+			slog.String("job_id", job.ID.String()))
+	}
+}
+
+func (im *Importer) removeSpool(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		im.logger.Warn("failed to remove spooled upload", slog.Any("error", err), slog.String("path", path))
+	}
+}
+
+func (s *storedSource) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		data, err := s.store.ImportSource(s.ctx, s.id, s.part)
+		switch {
+		case errors.Is(err, entity.ErrNotFound) && s.part > 0:
+			s.done = true
+			continue
+		case errors.Is(err, entity.ErrNotFound):
+			return 0, errors.New("import source not found")
+		case err != nil:
+			return 0, fmt.Errorf("read import source part %d: %w", s.part, err)
+		}
+		s.buf, s.part = data, s.part+1
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func newImportJob(ctx context.Context, id uuid.UUID, format entity.ImportFormat, origin entity.ImportOrigin,
+	source string, status entity.ImportStatus,
+) entity.ImportJob {
+	return entity.ImportJob{
+		ID:        id,
+		Tenant:    reqctx.Tenant(ctx),
+		Format:    format,
+		Origin:    origin,
+		Source:    source,
+		Status:    status,
+		Actor:     reqctx.Actor(ctx),
+		RequestID: reqctx.RequestID(ctx),
+	}
+}
+
+// spool copies at most maxBytes of src into a new file at path, removing it on failure.
+func spool(path string, src io.Reader, maxBytes int64) (err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("create import source: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(path)
+		}
+	}()
+
+	n, err := io.Copy(f, io.LimitReader(src, maxBytes+1))
+	if err != nil {
+		return fmt.Errorf("spool import source: %w", err)
+	}
+	if n > maxBytes {
+		return entity.ErrImportTooLarge
+	}
+	return f.Close()
+}
@@ -0,0 +1,462 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	memcache "github.com/alkmc/storefront/internal/cache/memory"
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	memrepo "github.com/alkmc/storefront/internal/repository/memory"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+// mockImportStore keeps jobs in memory and records every applied chunk.
+type mockImportStore struct {
+	jobs     map[uuid.UUID]entity.ImportJob
+	sources  map[uuid.UUID][][]byte
+	chunks   []entity.ImportChunk
+	tenants  []string
+	merged   []uuid.UUID
+	chunkErr error
+}
+
+func newMockImportStore() *mockImportStore {
+	return &mockImportStore{
+		jobs:    make(map[uuid.UUID]entity.ImportJob),
+		sources: make(map[uuid.UUID][][]byte),
+	}
+}
+
+func (m *mockImportStore) WithinTx(ctx context.Context, fn func(context.Context) error) error {
+	return fn(ctx)
+}
+
+func (m *mockImportStore) SaveImportSource(_ context.Context, id uuid.UUID, _ int, data []byte) error {
+	m.sources[id] = append(m.sources[id], slices.Clone(data))
+	return nil
+}
+
+func (m *mockImportStore) ImportSource(_ context.Context, id uuid.UUID, part int) ([]byte, error) {
+	if part >= len(m.sources[id]) {
+		return nil, entity.ErrNotFound
+	}
+	return m.sources[id][part], nil
+}
+
+func (m *mockImportStore) DeleteImportSource(_ context.Context, id uuid.UUID) error {
+	delete(m.sources, id)
+	return nil
+}
+
+func (m *mockImportStore) CreateImportJob(_ context.Context, j entity.ImportJob) (entity.ImportJob, error) {
+	m.jobs[j.ID] = j
+	return j, nil
+}
+
+func (m *mockImportStore) FindImportJob(_ context.Context, id uuid.UUID) (entity.ImportJob, error) {
+	j, ok := m.jobs[id]
+	if !ok {
+		return entity.ImportJob{}, entity.ErrNotFound
+	}
+	return j, nil
+}
+
+func (m *mockImportStore) ClaimImportJob(_ context.Context, origin entity.ImportOrigin, _ time.Duration,
+) (entity.ImportJob, error) {
+	for id, j := range m.jobs {
+		if j.Origin == origin && j.Status == entity.ImportPending {
+			j.Status = entity.ImportRunning
+			m.jobs[id] = j
+			return j, nil
+		}
+	}
+	return entity.ImportJob{}, entity.ErrNotFound
+}
+
+func (m *mockImportStore) ResumeImportJob(_ context.Context, id uuid.UUID, origin entity.ImportOrigin,
+	status entity.ImportStatus,
+) (entity.ImportJob, error) {
+	j, ok := m.jobs[id]
+	if !ok || j.Origin != origin || j.Status == entity.ImportCompleted {
+		return entity.ImportJob{}, entity.ErrNotFound
+	}
+	j.Status, j.Error = status, ""
+	m.jobs[id] = j
+	return j, nil
+}
+
+func (m *mockImportStore) FinishImportJob(_ context.Context, id uuid.UUID, status entity.ImportStatus,
+	msg string,
+) error {
+	j := m.jobs[id]
+	j.Status, j.Error = status, msg
+	m.jobs[id] = j
+	return nil
+}
+
+func (m *mockImportStore) ImportChunk(ctx context.Context, c entity.ImportChunk,
+) (entity.ImportJob, []uuid.UUID, error) {
+	m.tenants = append(m.tenants, reqctx.Tenant(ctx))
+	if m.chunkErr != nil {
+		return entity.ImportJob{}, nil, m.chunkErr
+	}
+	j := m.jobs[c.JobID]
+	if j.Line != c.From {
+		return entity.ImportJob{}, nil, entity.ErrImportCheckpoint
+	}
+	m.chunks = append(m.chunks, c)
+	j.Line = c.LastLine
+	j.Accepted += int64(len(c.Rows))
+	j.Rejected += int64(len(c.Rejects))
+	m.jobs[c.JobID] = j
+	return j, m.merged, nil
+}
+
+func (m *mockImportStore) ImportRejects(context.Context, uuid.UUID, int64, int,
+) (entity.ImportRejectPage, error) {
+	return entity.ImportRejectPage{}, nil
+}
+
+// recordingCache is mockCache that remembers invalidated keys.
+type recordingCache struct {
+	mockCache
+	invalidated []string
+}
+
+func (c *recordingCache) Invalidate(_ context.Context, key string) error {
+	c.invalidated = append(c.invalidated, key)
+	return nil
+}
+
+func newTestImporter(t *testing.T, store importStore, c cacher) *Importer {
+	t.Helper()
+	return NewImporter(slog.New(slog.DiscardHandler), store, c, config.Import{
+		Dir:       t.TempDir(),
+		BatchSize: 2,
+		MaxBytes:  1 << 10,
+		Lease:     time.Minute,
+	})
+}
+
+func writeSource(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	return path
+}
+
+func collectRecords(t *testing.T, format entity.ImportFormat, src string) ([]importRecord, error) {
+	t.Helper()
+	var out []importRecord
+	for rec, err := range decodeImport(format, strings.NewReader(src)) {
+		if err != nil {
+			return out, err
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func TestDecodeImport(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name        string
+		format      entity.ImportFormat
+		src         string
+		wantLines   []int64
+		wantRejects map[int64]string
+		wantErr     bool
+	}{
+		{
+			name:   "csv with rejects",
+			format: entity.ImportCSV,
+			src: "id,name,minorAmount,currency\n" +
+				id.String() + ",Car,1050,PLN\n" +
+				",Bike,abc,PLN\n" +
+				",Boat,100\n" +
+				",,100,PLN\n" +
+				",Kite,100,XYZ\n",
+			wantLines: []int64{2, 3, 4, 5, 6},
+			wantRejects: map[int64]string{
+				3: `invalid minorAmount: "abc"`,
+				4: "wrong number of fields",
+				5: "the product name is empty",
+				6: "the product currency is invalid",
+			},
+		},
+		{
+			name:      "csv columns in any order without id",
+			format:    entity.ImportCSV,
+			src:       "currency,minorAmount,name\nEUR,5,Pen\n",
+			wantLines: []int64{2},
+		},
+		{
+			name:    "csv unknown column",
+			format:  entity.ImportCSV,
+			src:     "name,minorAmount,currency,color\n",
+			wantErr: true,
+		},
+		{
+			name:    "csv missing header",
+			format:  entity.ImportCSV,
+			wantErr: true,
+		},
+		{
+			name:   "ndjson skips blank lines",
+			format: entity.ImportNDJSON,
+			src: `{"id":"` + id.String() + `","name":"Car","price":{"minorAmount":1050,"currency":"PLN"}}` + "\n" +
+				"\n" +
+				`{"name":"Bike","price":{"minorAmount":-1,"currency":"PLN"}}` + "\n" +
+				`{"name":"Boat","color":"red"}` + "\n" +
+				`not json`,
+			wantLines: []int64{1, 3, 4, 5},
+			wantRejects: map[int64]string{
+				3: "the product price must be positive",
+				4: `invalid json: unknown field "color"`,
+				5: "invalid json: invalid character 'o' in literal null (expecting 'u')",
+			},
+		},
+		{
+			name:    "ndjson line too long",
+			format:  entity.ImportNDJSON,
+			src:     strings.Repeat("x", maxImportLineBytes+1),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, err := collectRecords(t, tt.format, tt.src)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var lines []int64
+			for _, rec := range recs {
+				lines = append(lines, rec.line)
+				want, rejected := tt.wantRejects[rec.line]
+				switch {
+				case rejected && (rec.err == nil || rec.err.Error() != want):
+					t.Errorf("line %d: got error %v, want %q", rec.line, rec.err, want)
+				case !rejected && rec.err != nil:
+					t.Errorf("line %d: unexpected error %v", rec.line, rec.err)
+				case !rejected && rec.product.ID == uuid.Nil:
+					t.Errorf("line %d: expected an id to be assigned", rec.line)
+				}
+			}
+			if !slices.Equal(lines, tt.wantLines) {
+				t.Errorf("got lines %v, want %v", lines, tt.wantLines)
+			}
+		})
+	}
+
+	recs, _ := collectRecords(t, entity.ImportCSV, "id,name,minorAmount,currency\n"+id.String()+",Car,1,PLN\n")
+	if recs[0].product.ID != id {
+		t.Errorf("got id %s, want the one from the source %s", recs[0].product.ID, id)
+	}
+}
+
+func TestImporter_Import(t *testing.T) {
+	merged := uuid.Must(uuid.NewV7())
+	store := newMockImportStore()
+	store.merged = []uuid.UUID{merged}
+	c := new(recordingCache)
+	im := newTestImporter(t, store, c)
+
+	src := writeSource(t, "products.csv", "name,minorAmount,currency\n"+
+		"A,1,PLN\nB,0,PLN\nC,3,PLN\nD,4,PLN\nE,5,PLN\n")
+	ctx := reqctx.WithActor(t.Context(), "alice")
+
+	job, err := im.Import(ctx, entity.ImportCSV, src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job.Status != entity.ImportCompleted || job.Accepted != 4 || job.Rejected != 1 || job.Line != 6 {
+		t.Errorf("got job %+v, want completed with 4 accepted and 1 rejected up to line 6", job)
+	}
+	if job.Actor != "alice" || job.Origin != entity.ImportOriginCLI {
+		t.Errorf("got actor %q origin %q", job.Actor, job.Origin)
+	}
+
+	// Batch size 2 yields three chunks, each continuing from the previous checkpoint.
+	var from []int64
+	for _, ch := range store.chunks {
+		from = append(from, ch.From)
+	}
+	if !slices.Equal(from, []int64{0, 3, 5}) {
+		t.Errorf("got chunk checkpoints %v, want [0 3 5]", from)
+	}
+	if len(c.invalidated) != len(store.chunks) || c.invalidated[0] != merged.String() {
+		t.Errorf("got invalidated %v, want %s once per chunk", c.invalidated, merged)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("CLI sources must be left in place: %v", err)
+	}
+}
+
+func TestImporter_ResumeFromCheckpoint(t *testing.T) {
+	store := newMockImportStore()
+	im := newTestImporter(t, store, mockCache{})
+
+	id := uuid.Must(uuid.NewV7())
+	src := writeSource(t, "products.ndjson", strings.Repeat(
+		`{"name":"A","price":{"minorAmount":1,"currency":"PLN"}}`+"\n", 5))
+	store.jobs[id] = entity.ImportJob{
+		ID: id, Format: entity.ImportNDJSON, Origin: entity.ImportOriginCLI, Source: src,
+		Status: entity.ImportFailed, Line: 3, Accepted: 3,
+	}
+
+	job, err := im.Resume(t.Context(), id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job.Status != entity.ImportCompleted || job.Accepted != 5 {
+		t.Errorf("got job %+v, want completed with 5 accepted", job)
+	}
+	if len(store.chunks) != 1 || store.chunks[0].Rows[0].Line != 4 {
+		t.Errorf("expected a single chunk starting after the checkpoint, got %+v", store.chunks)
+	}
+}
+
+func TestImporter_Failures(t *testing.T) {
+	t.Run("store error fails the job", func(t *testing.T) {
+		store := newMockImportStore()
+		store.chunkErr = errors.New("db down")
+		im := newTestImporter(t, store, mockCache{})
+
+		job, err := im.Import(t.Context(), entity.ImportCSV,
+			writeSource(t, "p.csv", "name,minorAmount,currency\nA,1,PLN\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if job.Status != entity.ImportFailed || job.Error != "db down" {
+			t.Errorf("got status %q error %q", job.Status, job.Error)
+		}
+	})
+
+	t.Run("moved checkpoint leaves the job to its new owner", func(t *testing.T) {
+		store := newMockImportStore()
+		store.chunkErr = entity.ErrImportCheckpoint
+		im := newTestImporter(t, store, mockCache{})
+
+		job, err := im.Import(t.Context(), entity.ImportCSV,
+			writeSource(t, "p.csv", "name,minorAmount,currency\nA,1,PLN\n"))
+		if !errors.Is(err, entity.ErrImportCheckpoint) {
+			t.Fatalf("got %v, want ErrImportCheckpoint", err)
+		}
+		if store.jobs[job.ID].Status != entity.ImportRunning {
+			t.Errorf("got status %q, want running", store.jobs[job.ID].Status)
+		}
+	})
+}
+
+func TestImporter_SubmitAndRun(t *testing.T) {
+	store := newMockImportStore()
+	im := newTestImporter(t, store, mockCache{})
+
+	_, err := im.Submit(t.Context(), entity.ImportCSV, strings.NewReader(strings.Repeat("x", 2<<10)))
+	if !errors.Is(err, entity.ErrImportTooLarge) {
+		t.Fatalf("got %v, want ErrImportTooLarge", err)
+	}
+	if entries, _ := os.ReadDir(im.cfg.Dir); len(entries) != 0 {
+		t.Errorf("expected oversized upload to be removed, found %d files", len(entries))
+	}
+
+	job, err := im.Submit(reqctx.WithTenant(t.Context(), "acme"), entity.ImportCSV,
+		strings.NewReader("name,minorAmount,currency\nA,1,PLN\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job.Status != entity.ImportPending || job.Origin != entity.ImportOriginAPI || job.Tenant != "acme" {
+		t.Errorf("got status %q origin %q tenant %q", job.Status, job.Origin, job.Tenant)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	im.drain(ctx)
+	cancel()
+
+	done := store.jobs[job.ID]
+	if done.Status != entity.ImportCompleted || done.Accepted != 1 {
+		t.Errorf("got job %+v, want completed with 1 accepted", done)
+	}
+	if !slices.Equal(store.tenants, []string{"acme"}) {
+		t.Errorf("got chunks loaded for tenants %v, want the submitter's", store.tenants)
+	}
+	if entries, _ := os.ReadDir(im.cfg.Dir); len(entries) != 0 {
+		t.Errorf("expected the spooled upload to be removed, found %d files", len(entries))
+	}
+	if _, ok := store.sources[job.ID]; ok {
+		t.Error("expected the completed upload to be deleted from the store")
+	}
+}
+
+// TestImporter_OtherInstance runs an upload on an instance other than the one it was
+// submitted to, as happens when that one is busy or has crashed.
+func TestImporter_OtherInstance(t *testing.T) {
+	defer func(size int) { importSourcePart = size }(importSourcePart)
+	importSourcePart = 16
+
+	repo := memrepo.New()
+	submitter := newTestImporter(t, repo, mockCache{})
+	worker := newTestImporter(t, repo, mockCache{})
+	if submitter.cfg.Dir == worker.cfg.Dir {
+		t.Fatal("expected the instances to spool to different directories")
+	}
+
+	acme := reqctx.WithTenant(t.Context(), "acme")
+	job, err := submitter.Submit(acme, entity.ImportCSV,
+		strings.NewReader("name,minorAmount,currency\nA,1,PLN\nB,2,PLN\nC,3,PLN\n"))
+	if err != nil {
+		t.Fatalf("failed to submit: %v", err)
+	}
+	worker.drain(t.Context())
+
+	done, err := repo.FindImportJob(acme, job.ID)
+	if err != nil {
+		t.Fatalf("failed to find job: %v", err)
+	}
+	if done.Status != entity.ImportCompleted || done.Accepted != 3 || done.Line != 4 {
+		t.Errorf("got job %+v, want completed with 3 accepted up to line 4", done)
+	}
+	if _, err := repo.ImportSource(acme, job.ID, 0); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("got %v, want the completed upload deleted", err)
+	}
+}
+
+// TestImporter_ReplacesTombstones checks that a product looked up before an import creates it
+// is found once the import completes, rather than when its cached tombstone expires.
+func TestImporter_ReplacesTombstones(t *testing.T) {
+	repo, c := memrepo.New(), memcache.New(testCacheCfg)
+	srv := NewService(slog.New(slog.DiscardHandler), repo, c, time.Second)
+	im := newTestImporter(t, repo, c)
+
+	id := uuid.Must(uuid.NewV7())
+	if _, err := srv.FindByID(t.Context(), id); !errors.Is(err, entity.ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound before the import", err)
+	}
+	src := writeSource(t, "products.csv", "id,name,minorAmount,currency\n"+id.String()+",Car,100,PLN\n")
+	if job, err := im.Import(t.Context(), entity.ImportCSV, src); err != nil || job.Accepted != 1 {
+		t.Fatalf("got job %+v, %v, want the product imported", job, err)
+	}
+	if got, err := srv.FindByID(t.Context(), id); err != nil || got.Name != "Car" {
+		t.Errorf("got %+v, %v, want the imported product", got, err)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/alkmc/storefront/internal/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Kinds of cache lookup, and the singleflight calls loading their misses.
+const (
+	lookupProduct = "product"
+	lookupBatch   = "batch"
+	lookupPage    = "page"
+)
+
+var (
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storefront_cache_lookups_total",
+		Help: "Cache lookups, by kind and by how the cache answered: hit, tombstone, miss, bypass or error.",
+	}, []string{"kind", "result"})
+	cacheErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storefront_cache_errors_total",
+		Help: "Failed cache calls, by operation.",
+	}, []string{"op"})
+	singleflightCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storefront_singleflight_calls_total",
+		Help: "Loads of cache misses, by kind and by whether they shared the result of a concurrent load.",
+	}, []string{"kind", "shared"})
+)
+
+// countLookups counts n cache lookups of kind by the error the cache answered them with.
+func countLookups(kind string, err error, n int) {
+	result := "error"
+	switch {
+	case err == nil:
+		result = "hit"
+	case errors.Is(err, cache.ErrTombstone):
+		result = "tombstone"
+	case errors.Is(err, cache.ErrCacheMiss):
+		result = "miss"
+	case errors.Is(err, cache.ErrBypassed):
+		result = "bypass"
+	}
+	cacheLookups.WithLabelValues(kind, result).Add(float64(n))
+}
+
+func countLoad(kind string, shared bool) {
+	singleflightCalls.WithLabelValues(kind, strconv.FormatBool(shared)).Inc()
+}
+
+// opLabel turns the operation of a cache failure message into a label value.
+func opLabel(op string) string {
+	return strings.ReplaceAll(op, " ", "_")
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/alkmc/storefront/internal/reqctx"
+)
+
+// RunPurge hard-deletes tombstones older than retention every interval until ctx is done.
+// A non-positive interval disables the job.
+func (s *Service) RunPurge(ctx context.Context, interval, retention time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	ctx = reqctx.WithActor(ctx, reqctx.System)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.purge(ctx, retention)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) purge(ctx context.Context, retention time.Duration) {
+	ctx, span := tracer.Start(ctx, "Service.Purge")
+	before := time.Now().Add(-retention)
+	n, err := s.repo.Purge(ctx, before)
+	endSpan(span, err)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.ErrorContext(ctx, "purge of deleted products failed", slog.Any("error", err))
+		}
+		return
+	}
+	if n > 0 {
+		s.logger.InfoContext(ctx, "purged deleted products", slog.Int64("count", n), slog.Time("before", before))
+	}
+}
@@ -4,12 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/alkmc/storefront/internal/cache"
 	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -17,14 +24,30 @@ type (
 	repository interface {
 		Save(context.Context, entity.Product) (entity.Product, error)
 		FindByID(context.Context, uuid.UUID) (entity.Product, error)
-		FindAll(context.Context, uuid.NullUUID, int) (entity.ProductPage, error)
+		FindByIDs(context.Context, []uuid.UUID) ([]entity.Product, error)
+		FindAll(context.Context, uuid.NullUUID, int, entity.ProductFilter) (entity.ProductPage, error)
+		Export(context.Context, entity.ProductFilter) iter.Seq2[entity.Product, error]
 		Update(context.Context, entity.Product) error
 		Delete(context.Context, uuid.UUID) error
+		Restore(context.Context, uuid.UUID) (entity.Product, error)
+		Purge(context.Context, time.Time) (int64, error)
+		History(context.Context, uuid.UUID, int64, int) (entity.AuditPage, error)
+		WithinTx(context.Context, func(context.Context) error) error
+		AfterCommit(context.Context, func(context.Context))
+		InTx(context.Context) bool
 	}
 	cacher interface {
-		Set(context.Context, string, entity.Product) error
-		Get(context.Context, string) (entity.Product, error)
+		Set(context.Context, string, entity.Product, time.Duration) error
+		SetMissing(context.Context, string) error
+		SetMany(context.Context, []entity.Product, []string, time.Duration) (int, error)
+		Get(context.Context, string) (cache.Entry, error)
+		GetMany(context.Context, []string) ([]cache.Result, error)
 		Invalidate(context.Context, string) error
+		GetPage(context.Context, string) (entity.ProductPage, error)
+		PageVersion(context.Context) (cache.PageVersion, error)
+		SetPage(context.Context, string, entity.ProductPage, cache.PageVersion) error
+		InvalidatePages(context.Context, ...string) error
+		FlushPages(context.Context) error
 	}
 	Service struct {
 		logger      *slog.Logger
@@ -32,6 +55,14 @@ type (
 		cache       cacher
 		loadGroup   singleflight.Group
 		loadTimeout time.Duration
+		// refreshing holds the tenant keys being refreshed in the background.
+		refreshing sync.Map
+	}
+	// loaded is the outcome of loadProduct.
+	loaded struct {
+		product entity.Product
+		// stored is set when the product, or its tombstone, was cached.
+		stored bool
 	}
 )
 
@@ -42,7 +73,12 @@ func NewService(l *slog.Logger, r repository, c cacher, loadTimeout time.Duratio
 	return new(Service{logger: l, repo: r, cache: c, loadTimeout: loadTimeout})
 }
 
-func (s *Service) Create(ctx context.Context, p entity.Product) (entity.Product, error) {
+// Create saves p under a new id. Caching the product replaces any tombstone lookups of the id
+// left before it existed. Ids are time-ordered, so only the last pages of the list change.
+func (s *Service) Create(ctx context.Context, p entity.Product) (_ entity.Product, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Create")
+	defer func() { endSpan(span, err) }()
+
 	id, err := uuid.NewV7()
 	if err != nil {
 		return entity.Product{}, fmt.Errorf("failed to generate uuid: %w", err)
@@ -52,74 +88,429 @@ func (s *Service) Create(ctx context.Context, p entity.Product) (entity.Product,
 	if err != nil {
 		return entity.Product{}, err
 	}
-	key := saved.ID.String()
-	if err := s.cache.Set(ctx, key, saved); err != nil {
-		s.logger.Warn("cache set failed", slog.Any("error", err), slog.String("key", key))
-	}
+	s.cacheSet(ctx, saved)
+	s.pagesInvalidate(ctx, cache.TagTail)
 	return saved, nil
 }
 
+// WithinTx runs fn in a single transaction: every service call made with the context it
+// passes to fn commits or rolls back together, and their cache updates wait for the commit.
+// Nested calls open a savepoint. fn may be retried on transient database failures.
+func (s *Service) WithinTx(ctx context.Context, fn func(context.Context) error) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.WithinTx")
+	defer func() { endSpan(span, err) }()
+
+	return s.repo.WithinTx(ctx, fn)
+}
+
+// FindByID serves from the cache when it can, including the products it found missing.
+// Inside a transaction it reads through it instead, so uncommitted writes are visible and
+// never reach the cache.
 func (s *Service) FindByID(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	p, _, err := s.Lookup(ctx, id)
+	return p, err
+}
+
+// Lookup is FindByID reporting how the cache answered. A cached product marked for refresh,
+// because it is stale or was picked to be refreshed early, is served while it is reloaded in
+// the background.
+func (s *Service) Lookup(ctx context.Context, id uuid.UUID) (entity.Product, cache.Status, error) {
+	ctx, span := tracer.Start(ctx, "Service.Lookup", productID(id))
+	p, st, err := s.lookup(ctx, id)
+	span.SetAttributes(attribute.Bool("cache.hit", st.Hit), attribute.Bool("cache.collapsed", st.Collapsed))
+	endSpan(span, err)
+	return p, st, err
+}
+
+func (s *Service) lookup(ctx context.Context, id uuid.UUID) (entity.Product, cache.Status, error) {
+	if s.repo.InTx(ctx) {
+		p, err := s.repo.FindByID(ctx, id)
+		return p, cache.Status{Fwd: cache.FwdBypass}, err
+	}
 	key := id.String()
 	cached, err := s.cache.Get(ctx, key)
-	if err == nil {
-		return cached, nil
+	countLookups(lookupProduct, err, 1)
+	switch {
+	case err == nil:
+		if cached.Refresh {
+			s.refresh(ctx, id)
+		}
+		return cached.Product, cache.Status{Hit: true, TTL: time.Until(cached.Expiry)}, nil
+	case errors.Is(err, cache.ErrTombstone):
+		return entity.Product{}, cache.Status{Hit: true}, entity.ErrNotFound
+	case errors.Is(err, cache.ErrBypassed):
+		// Concurrent loads are still coalesced, sparing the database while the cache is down.
+		l, shared, err := s.loadProduct(ctx, id)
+		return l.product, cache.Status{Fwd: cache.FwdBypass, Collapsed: shared}, err
+	case !errors.Is(err, cache.ErrCacheMiss):
+		s.cacheFailed(ctx, "get", err, slog.String("key", key))
 	}
-	if !errors.Is(err, cache.ErrCacheMiss) {
-		s.logger.Warn("cache get failed", slog.Any("error", err), slog.String("key", key))
+	l, shared, err := s.loadProduct(ctx, id)
+	return l.product, cache.Status{Fwd: cache.FwdMiss, Stored: l.stored, Collapsed: shared}, err
+}
+
+// refresh reloads the product of id in the background, at most once at a time per tenant and
+// id however many lookups ask for it.
+func (s *Service) refresh(ctx context.Context, id uuid.UUID) {
+	key := cache.TenantKey(ctx, id.String())
+	if _, busy := s.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
 	}
-	return s.loadProduct(ctx, id)
+	go func() {
+		defer s.refreshing.Delete(key)
+		_, _, err := s.loadProduct(context.WithoutCancel(ctx), id)
+		if err != nil && !errors.Is(err, entity.ErrNotFound) {
+			s.logger.WarnContext(ctx, "cache refresh failed", slog.Any("error", err), slog.String("key", key))
+		}
+	}()
 }
 
-// loadProduct coalesces concurrent misses for id into a single DB load via singleflight.
-func (s *Service) loadProduct(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+// loadProduct coalesces concurrent misses for id into a single DB load via singleflight and
+// reports whether the load was shared. Misses of different tenants for the same id are loaded
+// separately. A product that does not exist is cached as a tombstone. Like every read that
+// fills the cache, the load goes to the primary: a lagging replica could cache a product
+// older than the write that just evicted it, which would then outlive the lag.
+func (s *Service) loadProduct(ctx context.Context, id uuid.UUID) (loaded, bool, error) {
 	key := id.String()
-	v, err, _ := s.loadGroup.Do(key, func() (any, error) {
-		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.loadTimeout)
+	v, err, shared := s.loadGroup.Do(cache.TenantKey(ctx, key), func() (any, error) {
+		loadCtx, cancel := s.loadContext(ctx)
 		defer cancel()
 
+		start := time.Now()
 		p, err := s.repo.FindByID(loadCtx, id)
+		if errors.Is(err, entity.ErrNotFound) {
+			if err := s.cache.SetMissing(loadCtx, key); err != nil {
+				s.cacheFailed(loadCtx, "set missing", err, slog.String("key", key))
+				return loaded{}, entity.ErrNotFound
+			}
+			return loaded{stored: true}, entity.ErrNotFound
+		}
 		if err != nil {
-			return entity.Product{}, err
+			return loaded{}, err
 		}
-		if err := s.cache.Set(loadCtx, key, p); err != nil {
-			s.logger.Warn("cache set failed", slog.Any("error", err), slog.String("key", key))
+		if err := s.cache.Set(loadCtx, key, p, time.Since(start)); err != nil {
+			s.cacheFailed(loadCtx, "set", err, slog.String("key", key))
+			return loaded{product: p}, nil
 		}
-		return p, nil
+		return loaded{product: p, stored: true}, nil
 	})
+	countLoad(lookupProduct, shared)
+	l, ok := v.(loaded)
+	if !ok {
+		return loaded{}, shared, fmt.Errorf("singleflight: unexpected result type %T", v)
+	}
+	return l, shared, err
+}
+
+// FindByIDs looks up the products of ids like FindByID, reading the cache once for all of them
+// and the database once for those it misses, which are then cached together. It returns the
+// products found in the order of ids, each once, and the ids of those that do not exist.
+func (s *Service) FindByIDs(ctx context.Context, ids []uuid.UUID) (_ []entity.Product, _ []uuid.UUID,
+	err error,
+) {
+	ctx, span := tracer.Start(ctx, "Service.FindByIDs",
+		trace.WithAttributes(attribute.Int("product.ids", len(ids))))
+	defer func() { endSpan(span, err) }()
+
+	ids = uniqueIDs(ids)
+	products := make(map[uuid.UUID]entity.Product, len(ids))
+	if s.repo.InTx(ctx) {
+		found, err := s.repo.FindByIDs(ctx, ids)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range found {
+			products[p.ID] = p
+		}
+		found, notFound := inOrder(ids, products)
+		return found, notFound, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = id.String()
+	}
+	var misses []uuid.UUID
+	results, err := s.cache.GetMany(ctx, keys)
 	if err != nil {
-		return entity.Product{}, err
+		s.cacheFailed(ctx, "get many", err, slog.Int("keys", len(keys)))
+		misses = ids
+		countLookups(lookupBatch, err, len(keys))
 	}
-	p, ok := v.(entity.Product)
+	for i, res := range results {
+		countLookups(lookupBatch, res.Err, 1)
+		switch {
+		case res.Err == nil:
+			products[ids[i]] = res.Entry.Product
+			if res.Entry.Refresh {
+				s.refresh(ctx, ids[i])
+			}
+		case errors.Is(res.Err, cache.ErrTombstone):
+			// Known not to exist.
+		default:
+			if !errors.Is(res.Err, cache.ErrCacheMiss) {
+				s.cacheFailed(ctx, "get", res.Err, slog.String("key", keys[i]))
+			}
+			misses = append(misses, ids[i])
+		}
+	}
+	if len(misses) > 0 {
+		loaded, err := s.loadProducts(ctx, misses)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range loaded {
+			products[p.ID] = p
+		}
+	}
+	found, notFound := inOrder(ids, products)
+	return found, notFound, nil
+}
+
+// loadContext detaches a cache fill from ctx, bounds it by loadTimeout, and sends its reads to
+// the primary.
+func (s *Service) loadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(reqctx.WithReadYourWrites(context.WithoutCancel(ctx)), s.loadTimeout)
+}
+
+// loadProducts loads the products of ids that exist and caches them in a single pipeline, with
+// tombstones for the others. Concurrent loads of the same ids are coalesced like in
+// loadProduct, which a single id is loaded by.
+func (s *Service) loadProducts(ctx context.Context, ids []uuid.UUID) ([]entity.Product, error) {
+	if len(ids) == 1 {
+		l, _, err := s.loadProduct(ctx, ids[0])
+		if errors.Is(err, entity.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []entity.Product{l.product}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = id.String()
+	}
+	slices.Sort(keys)
+	v, err, shared := s.loadGroup.Do(cache.TenantKey(ctx, strings.Join(keys, ",")), func() (any, error) {
+		loadCtx, cancel := s.loadContext(ctx)
+		defer cancel()
+
+		start := time.Now()
+		found, err := s.repo.FindByIDs(loadCtx, ids)
+		if err != nil {
+			return nil, err
+		}
+		exists := make(map[string]bool, len(found))
+		for _, p := range found {
+			exists[p.ID.String()] = true
+		}
+		missing := slices.DeleteFunc(keys, func(key string) bool { return exists[key] })
+		if _, err := s.cache.SetMany(loadCtx, found, missing, time.Since(start)); err != nil {
+			s.cacheFailed(loadCtx, "set many", err, slog.Int("keys", len(ids)))
+		}
+		return found, nil
+	})
+	countLoad(lookupBatch, shared)
+	if err != nil {
+		return nil, err
+	}
+	found, ok := v.([]entity.Product)
 	if !ok {
-		return entity.Product{}, fmt.Errorf("singleflight: unexpected result type %T", v)
+		return nil, fmt.Errorf("singleflight: unexpected result type %T", v)
 	}
-	return p, nil
+	return found, nil
 }
 
-func (s *Service) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int,
-) (entity.ProductPage, error) {
-	return s.repo.FindAll(ctx, cursor, limit)
+// uniqueIDs returns ids without repeats, in the order they first appear.
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
 }
 
-func (s *Service) Update(ctx context.Context, p entity.Product) error {
-	if err := s.repo.Update(ctx, p); err != nil {
-		return err
+// inOrder returns the products of ids in their order, and the ids products lacks.
+func inOrder(ids []uuid.UUID, products map[uuid.UUID]entity.Product) ([]entity.Product, []uuid.UUID) {
+	found := make([]entity.Product, 0, len(products))
+	var notFound []uuid.UUID
+	for _, id := range ids {
+		if p, ok := products[id]; ok {
+			found = append(found, p)
+		} else {
+			notFound = append(notFound, id)
+		}
 	}
-	key := p.ID.String()
-	if err := s.cache.Invalidate(ctx, key); err != nil {
-		s.logger.Warn("cache invalidate failed", slog.Any("error", err), slog.String("key", key))
+	return found, notFound
+}
+
+// FindAll serves pages of live products from the cache when it can. Pages listing deleted
+// products, and reads inside a transaction, go to the database.
+func (s *Service) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int, f entity.ProductFilter,
+) (_ entity.ProductPage, err error) {
+	ctx, span := tracer.Start(ctx, "Service.FindAll")
+	defer func() { endSpan(span, err) }()
+
+	if f.IncludeDeleted || s.repo.InTx(ctx) {
+		return s.repo.FindAll(ctx, cursor, limit, f)
 	}
-	return nil
+	key := pageKey(cursor, limit, f)
+	page, err := s.cache.GetPage(ctx, key)
+	countLookups(lookupPage, err, 1)
+	if err == nil {
+		return page, nil
+	}
+	if !errors.Is(err, cache.ErrCacheMiss) {
+		s.cacheFailed(ctx, "get page", err, slog.String("key", key))
+	}
+	// The version is read before the page, so a write committed in between fails the fill. The
+	// page is read from the primary, or it could list products older than that write.
+	v, err := s.cache.PageVersion(ctx)
+	if err != nil {
+		s.cacheFailed(ctx, "page version", err, slog.String("key", key))
+		return s.repo.FindAll(ctx, cursor, limit, f)
+	}
+	if page, err = s.repo.FindAll(reqctx.WithReadYourWrites(ctx), cursor, limit, f); err != nil {
+		return entity.ProductPage{}, err
+	}
+	if err := s.cache.SetPage(ctx, key, page, v); err != nil {
+		s.cacheFailed(ctx, "set page", err, slog.String("key", key))
+	}
+	return page, nil
+}
+
+// pageKey identifies a page of live products by its cursor, size and filters.
+func pageKey(cursor uuid.NullUUID, limit int, f entity.ProductFilter) string {
+	from := "first"
+	if cursor.Valid {
+		from = cursor.UUID.String()
+	}
+	return fmt.Sprintf("%s:%d:%s", from, limit, f.Currency)
 }
 
-func (s *Service) Delete(ctx context.Context, id uuid.UUID) error {
+// Export streams the whole catalog matching f straight from the database, bypassing the cache.
+func (s *Service) Export(ctx context.Context, f entity.ProductFilter) iter.Seq2[entity.Product, error] {
+	return func(yield func(entity.Product, error) bool) {
+		ctx, span := tracer.Start(ctx, "Service.Export")
+		var err error
+		defer func() { endSpan(span, err) }()
+
+		for p, pErr := range s.repo.Export(ctx, f) {
+			err = pErr
+			if !yield(p, err) {
+				return
+			}
+		}
+	}
+}
+
+// Update saves p over the live product of its id. A product changing currency leaves the
+// pages filtered by its old currency, which the eviction of the pages listing it covers, and
+// joins pages of the new one that do not list it yet, so every page is evicted then.
+func (s *Service) Update(ctx context.Context, p entity.Product) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.Update", productID(p.ID))
+	defer func() { endSpan(span, err) }()
+
+	return s.repo.WithinTx(ctx, func(ctx context.Context) error {
+		before, err := s.repo.FindByID(ctx, p.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.Update(ctx, p); err != nil {
+			return err
+		}
+		s.cacheInvalidate(ctx, p.ID)
+		if before.Price.Currency != p.Price.Currency {
+			s.pagesFlush(ctx)
+		}
+		return nil
+	})
+}
+
+func (s *Service) Delete(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "Service.Delete", productID(id))
+	defer func() { endSpan(span, err) }()
+
 	if err := s.repo.Delete(ctx, id); err != nil {
 		return err
 	}
-	key := id.String()
-	if err := s.cache.Invalidate(ctx, key); err != nil {
-		s.logger.Warn("cache invalidate failed", slog.Any("error", err), slog.String("key", key))
-	}
+	s.cacheInvalidate(ctx, id)
 	return nil
 }
+
+func (s *Service) Restore(ctx context.Context, id uuid.UUID) (_ entity.Product, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Restore", productID(id))
+	defer func() { endSpan(span, err) }()
+
+	p, err := s.repo.Restore(ctx, id)
+	if err != nil {
+		return entity.Product{}, err
+	}
+	s.cacheSet(ctx, p)
+	// The product returns to whichever pages its id falls in, none of which list it.
+	s.pagesFlush(ctx)
+	return p, nil
+}
+
+// cacheSet caches p once the write that produced it commits.
+func (s *Service) cacheSet(ctx context.Context, p entity.Product) {
+	s.repo.AfterCommit(ctx, func(ctx context.Context) {
+		key := p.ID.String()
+		if err := s.cache.Set(ctx, key, p, 0); err != nil {
+			s.cacheFailed(ctx, "set", err, slog.String("key", key))
+		}
+	})
+}
+
+// cacheInvalidate evicts the product, and the pages listing it, once the write that changed it
+// commits.
+func (s *Service) cacheInvalidate(ctx context.Context, id uuid.UUID) {
+	s.repo.AfterCommit(ctx, func(ctx context.Context) {
+		key := id.String()
+		if err := s.cache.Invalidate(ctx, key); err != nil {
+			s.cacheFailed(ctx, "invalidate", err, slog.String("key", key))
+		}
+	})
+	s.pagesInvalidate(ctx, cache.ProductTag(id))
+}
+
+// pagesInvalidate evicts the pages carrying any of tags once the write that changed them commits.
+func (s *Service) pagesInvalidate(ctx context.Context, tags ...string) {
+	s.repo.AfterCommit(ctx, func(ctx context.Context) {
+		if err := s.cache.InvalidatePages(ctx, tags...); err != nil {
+			s.cacheFailed(ctx, "invalidate pages", err, slog.Any("tags", tags))
+		}
+	})
+}
+
+// pagesFlush evicts every page of the tenant once the write that changed them commits.
+func (s *Service) pagesFlush(ctx context.Context) {
+	s.repo.AfterCommit(ctx, func(ctx context.Context) {
+		if err := s.cache.FlushPages(ctx); err != nil {
+			s.cacheFailed(ctx, "flush pages", err)
+		}
+	})
+}
+
+// cacheFailed logs and counts a failed cache call of op, unless the cache was bypassed while
+// it is down.
+func (s *Service) cacheFailed(ctx context.Context, op string, err error, attrs ...any) {
+	if !errors.Is(err, cache.ErrBypassed) {
+		cacheErrors.WithLabelValues(opLabel(op)).Inc()
+		s.logger.WarnContext(ctx, "cache "+op+" failed", append([]any{slog.Any("error", err)}, attrs...)...)
+	}
+}
+
+func (s *Service) History(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (_ entity.AuditPage, err error) {
+	ctx, span := tracer.Start(ctx, "Service.History", productID(id))
+	defer func() { endSpan(span, err) }()
+
+	return s.repo.History(ctx, id, cursor, limit)
+}
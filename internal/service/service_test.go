@@ -2,7 +2,10 @@ package service
 
 import (
 	"context"
+	"errors"
+	"iter"
 	"log/slog"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -10,24 +13,75 @@ import (
 	"time"
 
 	"github.com/alkmc/storefront/internal/cache"
+	memcache "github.com/alkmc/storefront/internal/cache/memory"
+	"github.com/alkmc/storefront/internal/config"
 	"github.com/alkmc/storefront/internal/entity"
+	memrepo "github.com/alkmc/storefront/internal/repository/memory"
+	"github.com/alkmc/storefront/internal/reqctx"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 type mockCache struct{}
 
-func (mockCache) Set(_ context.Context, _ string, _ entity.Product) error {
+func (mockCache) Set(_ context.Context, _ string, _ entity.Product, _ time.Duration) error {
 	return nil
 }
 
-func (mockCache) Get(_ context.Context, _ string) (entity.Product, error) {
-	return entity.Product{}, cache.ErrCacheMiss
+func (mockCache) SetMissing(_ context.Context, _ string) error {
+	return nil
+}
+
+func (mockCache) SetMany(_ context.Context, products []entity.Product, _ []string, _ time.Duration,
+) (int, error) {
+	return len(products), nil
+}
+
+func (mockCache) Get(_ context.Context, _ string) (cache.Entry, error) {
+	return cache.Entry{}, cache.ErrCacheMiss
+}
+
+func (mockCache) GetMany(_ context.Context, keys []string) ([]cache.Result, error) {
+	results := make([]cache.Result, len(keys))
+	for i := range results {
+		results[i].Err = cache.ErrCacheMiss
+	}
+	return results, nil
 }
 
 func (mockCache) Invalidate(_ context.Context, _ string) error {
 	return nil
 }
 
+func (mockCache) GetPage(_ context.Context, _ string) (entity.ProductPage, error) {
+	return entity.ProductPage{}, cache.ErrCacheMiss
+}
+
+func (mockCache) PageVersion(_ context.Context) (cache.PageVersion, error) {
+	return cache.PageVersion{}, nil
+}
+
+func (mockCache) SetPage(_ context.Context, _ string, _ entity.ProductPage, _ cache.PageVersion) error {
+	return nil
+}
+
+func (mockCache) InvalidatePages(_ context.Context, _ ...string) error {
+	return nil
+}
+
+func (mockCache) FlushPages(_ context.Context) error {
+	return nil
+}
+
+// testCacheCfg keeps entries fresh for the length of a test, and tombstones as well.
+var testCacheCfg = config.Redis{
+	TTL:          time.Minute,
+	HardTTL:      time.Minute,
+	TombstoneTTL: time.Minute,
+	PageTTL:      time.Minute,
+}
+
 func newTestService(repo repository) *Service {
 	return NewService(slog.New(slog.DiscardHandler), repo, mockCache{}, time.Second)
 }
@@ -37,13 +91,26 @@ func testMoney(amount int64) entity.Money {
 }
 
 type MockRepository struct {
-	SaveFn     func(context.Context, entity.Product) (entity.Product, error)
-	FindByIDFn func(context.Context, uuid.UUID) (entity.Product, error)
-	FindAllFn  func(context.Context, uuid.NullUUID, int) (entity.ProductPage, error)
-	UpdateFn   func(context.Context, entity.Product) error
-	DeleteFn   func(context.Context, uuid.UUID) error
+	SaveFn      func(context.Context, entity.Product) (entity.Product, error)
+	FindByIDFn  func(context.Context, uuid.UUID) (entity.Product, error)
+	FindByIDsFn func(context.Context, []uuid.UUID) ([]entity.Product, error)
+	FindAllFn   func(context.Context, uuid.NullUUID, int, entity.ProductFilter) (entity.ProductPage, error)
+	ExportFn    func(context.Context, entity.ProductFilter) iter.Seq2[entity.Product, error]
+	UpdateFn    func(context.Context, entity.Product) error
+	DeleteFn    func(context.Context, uuid.UUID) error
+	RestoreFn   func(context.Context, uuid.UUID) (entity.Product, error)
+	PurgeFn     func(context.Context, time.Time) (int64, error)
+	HistoryFn   func(context.Context, uuid.UUID, int64, int) (entity.AuditPage, error)
 }
 
+type (
+	// mockTx stands in for the transaction of WithinTx and collects AfterCommit hooks.
+	mockTx struct {
+		afterCommit []func(context.Context)
+	}
+	mockTxKey struct{}
+)
+
 func (m *MockRepository) Save(ctx context.Context, p entity.Product) (entity.Product, error) {
 	return m.SaveFn(ctx, p)
 }
@@ -52,9 +119,18 @@ func (m *MockRepository) FindByID(ctx context.Context, id uuid.UUID) (entity.Pro
 	return m.FindByIDFn(ctx, id)
 }
 
-func (m *MockRepository) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int,
+func (m *MockRepository) FindByIDs(ctx context.Context, ids []uuid.UUID) ([]entity.Product, error) {
+	return m.FindByIDsFn(ctx, ids)
+}
+
+func (m *MockRepository) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int, f entity.ProductFilter,
 ) (entity.ProductPage, error) {
-	return m.FindAllFn(ctx, cursor, limit)
+	return m.FindAllFn(ctx, cursor, limit, f)
+}
+
+func (m *MockRepository) Export(ctx context.Context, f entity.ProductFilter,
+) iter.Seq2[entity.Product, error] {
+	return m.ExportFn(ctx, f)
 }
 
 func (m *MockRepository) Update(ctx context.Context, p entity.Product) error {
@@ -71,6 +147,51 @@ func (m *MockRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
+func (m *MockRepository) Restore(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+	return m.RestoreFn(ctx, id)
+}
+
+func (m *MockRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if m.PurgeFn != nil {
+		return m.PurgeFn(ctx, before)
+	}
+	return 0, nil
+}
+
+func (m *MockRepository) History(ctx context.Context, id uuid.UUID, cursor int64, limit int,
+) (entity.AuditPage, error) {
+	return m.HistoryFn(ctx, id, cursor, limit)
+}
+
+// WithinTx commits when fn succeeds, running the hooks registered by fn. Nested calls join
+// the outer transaction.
+func (m *MockRepository) WithinTx(ctx context.Context, fn func(context.Context) error) error {
+	if m.InTx(ctx) {
+		return fn(ctx)
+	}
+	tx := new(mockTx)
+	if err := fn(context.WithValue(ctx, mockTxKey{}, tx)); err != nil {
+		return err
+	}
+	for _, hook := range tx.afterCommit {
+		hook(ctx)
+	}
+	return nil
+}
+
+func (m *MockRepository) AfterCommit(ctx context.Context, fn func(context.Context)) {
+	if tx, ok := ctx.Value(mockTxKey{}).(*mockTx); ok {
+		tx.afterCommit = append(tx.afterCommit, fn)
+		return
+	}
+	fn(ctx)
+}
+
+func (m *MockRepository) InTx(ctx context.Context) bool {
+	_, ok := ctx.Value(mockTxKey{}).(*mockTx)
+	return ok
+}
+
 func TestService_Create(t *testing.T) {
 	ctx := t.Context()
 
@@ -129,7 +250,10 @@ func TestService_FindByID(t *testing.T) {
 			name: "success",
 			id:   id,
 			mockSetup: func(m *MockRepository) {
-				m.FindByIDFn = func(_ context.Context, id uuid.UUID) (entity.Product, error) {
+				m.FindByIDFn = func(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+					if !reqctx.ReadYourWrites(ctx) {
+						return entity.Product{}, errors.New("cache filled from a replica")
+					}
 					return entity.Product{ID: id, Name: "Test"}, nil
 				}
 			},
@@ -209,6 +333,95 @@ func TestService_FindByID_CoalescesConcurrentMisses(t *testing.T) {
 	}
 }
 
+// TestService_FindByIDs checks that a batch lookup answers in request order, loads only what
+// the cache misses, and caches it, found or not.
+func TestService_FindByIDs(t *testing.T) {
+	ctx := t.Context()
+	c := memcache.New(testCacheCfg)
+	stored := map[uuid.UUID]entity.Product{}
+	var loads [][]uuid.UUID
+	mockRepo := &MockRepository{
+		FindByIDsFn: func(_ context.Context, ids []uuid.UUID) ([]entity.Product, error) {
+			loads = append(loads, ids)
+			var found []entity.Product
+			for _, id := range ids {
+				if p, ok := stored[id]; ok {
+					found = append(found, p)
+				}
+			}
+			return found, nil
+		},
+	}
+	srv := NewService(slog.New(slog.DiscardHandler), mockRepo, c, time.Second)
+
+	a, b, cached, gone := uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7()),
+		uuid.Must(uuid.NewV7())
+	for i, id := range []uuid.UUID{a, b} {
+		stored[id] = entity.Product{ID: id, Name: string(rune('A' + i)), Price: testMoney(100)}
+	}
+	err := c.Set(ctx, cached.String(), entity.Product{ID: cached, Name: "C", Price: testMoney(100)}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ids := []uuid.UUID{b, gone, cached, a, b}
+	for range 2 {
+		found, notFound, err := srv.FindByIDs(ctx, ids)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := pageNames(entity.ProductPage{Items: found}); !slices.Equal(got, []string{"B", "C", "A"}) {
+			t.Errorf("got %v, want B, C, A", got)
+		}
+		if !slices.Equal(notFound, []uuid.UUID{gone}) {
+			t.Errorf("got not found %v, want %v", notFound, []uuid.UUID{gone})
+		}
+	}
+	// The second lookup is answered by the cache, tombstone included.
+	if len(loads) != 1 || len(loads[0]) != 3 || slices.Contains(loads[0], cached) {
+		t.Errorf("got loads %v, want one of the 3 uncached ids", loads)
+	}
+}
+
+func TestService_FindByIDs_CoalescesConcurrentMisses(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ids := []uuid.UUID{uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7())}
+
+		var repoCalls atomic.Int32
+		release := make(chan struct{})
+		mockRepo := &MockRepository{
+			FindByIDsFn: func(_ context.Context, ids []uuid.UUID) ([]entity.Product, error) {
+				repoCalls.Add(1)
+				<-release
+				return []entity.Product{{ID: ids[0], Price: testMoney(100)}}, nil
+			},
+		}
+		srv := newTestService(mockRepo)
+
+		var wg sync.WaitGroup
+		for i := range 10 {
+			wg.Go(func() {
+				// The same misses are coalesced whatever their order.
+				lookup := slices.Clone(ids)
+				if i%2 == 1 {
+					slices.Reverse(lookup)
+				}
+				found, notFound, err := srv.FindByIDs(t.Context(), lookup)
+				if err != nil || len(found) != 1 || len(notFound) != 1 {
+					t.Errorf("got %v, %v, %v, want one found and one not", found, notFound, err)
+				}
+			})
+		}
+		synctest.Wait()
+		close(release)
+		wg.Wait()
+
+		if got := repoCalls.Load(); got != 1 {
+			t.Errorf("got %d repo calls, want 1", got)
+		}
+	})
+}
+
 func TestService_FindAll(t *testing.T) {
 	ctx := t.Context()
 
@@ -221,7 +434,9 @@ func TestService_FindAll(t *testing.T) {
 		{
 			name: "success",
 			mockSetup: func(m *MockRepository) {
-				m.FindAllFn = func(_ context.Context, _ uuid.NullUUID, _ int) (entity.ProductPage, error) {
+				m.FindAllFn = func(
+					_ context.Context, _ uuid.NullUUID, _ int, _ entity.ProductFilter,
+				) (entity.ProductPage, error) {
 					return entity.ProductPage{Items: []entity.Product{
 						{Name: "P1", Price: testMoney(100)}, {Name: "P2", Price: testMoney(200)},
 					}}, nil
@@ -238,7 +453,7 @@ func TestService_FindAll(t *testing.T) {
 			tt.mockSetup(mockRepo)
 			srv := newTestService(mockRepo)
 
-			page, err := srv.FindAll(ctx, uuid.NullUUID{}, 50)
+			page, err := srv.FindAll(ctx, uuid.NullUUID{}, 50, entity.ProductFilter{})
 			if tt.wantErr {
 				if err == nil {
 					t.Fatalf("expected error")
@@ -268,6 +483,9 @@ func TestService_Update(t *testing.T) {
 			name:    "success",
 			product: entity.Product{Name: "Update", Price: testMoney(1000)},
 			mockSetup: func(m *MockRepository) {
+				m.FindByIDFn = func(_ context.Context, id uuid.UUID) (entity.Product, error) {
+					return entity.Product{ID: id, Name: "Before", Price: testMoney(900)}, nil
+				}
 				m.UpdateFn = func(_ context.Context, _ entity.Product) error {
 					return nil
 				}
@@ -296,6 +514,453 @@ func TestService_Update(t *testing.T) {
 	}
 }
 
+func TestService_WithinTx(t *testing.T) {
+	id := uuid.Must(uuid.NewV7())
+	errRollback := errors.New("rollback")
+
+	tests := []struct {
+		name            string
+		fnErr           error
+		wantInvalidated []string
+	}{
+		{
+			name:            "commit invalidates after the transaction",
+			wantInvalidated: []string{id.String(), id.String()},
+		},
+		{name: "rollback leaves the cache alone", fnErr: errRollback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inTx bool
+			mockRepo := new(MockRepository{
+				FindByIDFn: func(ctx context.Context, id uuid.UUID) (entity.Product, error) {
+					return entity.Product{ID: id}, nil
+				},
+			})
+			c := new(recordingCache)
+			srv := NewService(slog.New(slog.DiscardHandler), mockRepo, c, time.Second)
+
+			err := srv.WithinTx(t.Context(), func(ctx context.Context) error {
+				inTx = mockRepo.InTx(ctx)
+				if err := srv.Update(ctx, entity.Product{ID: id}); err != nil {
+					return err
+				}
+				if err := srv.Delete(ctx, id); err != nil {
+					return err
+				}
+				if _, err := srv.FindByID(ctx, id); err != nil {
+					return err
+				}
+				if len(c.invalidated) != 0 {
+					t.Errorf("cache invalidated before commit: %v", c.invalidated)
+				}
+				return tt.fnErr
+			})
+			if !errors.Is(err, tt.fnErr) {
+				t.Fatalf("got error %v, want %v", err, tt.fnErr)
+			}
+			if !inTx {
+				t.Error("expected fn to run in a transaction")
+			}
+			if !slices.Equal(c.invalidated, tt.wantInvalidated) {
+				t.Errorf("got invalidated %v, want %v", c.invalidated, tt.wantInvalidated)
+			}
+		})
+	}
+}
+
+// TestService_MemoryBackends runs the service over the in-memory store and cache, so the
+// cache is exercised end to end rather than through fakes.
+func TestService_MemoryBackends(t *testing.T) {
+	ctx := t.Context()
+	repo, c := memrepo.New(), memcache.New(testCacheCfg)
+	srv := NewService(slog.New(slog.DiscardHandler), repo, c, time.Second)
+
+	p, err := srv.Create(ctx, entity.Product{Name: "Car", Price: testMoney(100)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cached, err := c.Get(ctx, p.ID.String()); err != nil || cached.Product != p {
+		t.Errorf("expected the created product cached, got %+v, %v", cached, err)
+	}
+
+	p.Name = "Bike"
+	err = srv.WithinTx(ctx, func(ctx context.Context) error {
+		if err := srv.Update(ctx, p); err != nil {
+			return err
+		}
+		if _, err := c.Get(ctx, p.ID.String()); err != nil {
+			t.Errorf("cache invalidated before commit: %v", err)
+		}
+		got, err := srv.FindByID(ctx, p.ID)
+		if err == nil && got.Name != "Bike" {
+			t.Errorf("got %q inside the transaction, want its own write", got.Name)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Get(ctx, p.ID.String()); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Errorf("expected the update to invalidate the cache after commit, got %v", err)
+	}
+	if got, err := srv.FindByID(ctx, p.ID); err != nil || got != p {
+		t.Errorf("got %+v, %v, want %+v", got, err, p)
+	}
+}
+
+// pageRepo is the in-memory repository counting list reads, with a hook run after each.
+type pageRepo struct {
+	repository
+	reads     atomic.Int32
+	afterRead func(context.Context)
+}
+
+func (r *pageRepo) FindAll(ctx context.Context, cursor uuid.NullUUID, limit int, f entity.ProductFilter,
+) (entity.ProductPage, error) {
+	r.reads.Add(1)
+	page, err := r.repository.FindAll(ctx, cursor, limit, f)
+	if r.afterRead != nil {
+		r.afterRead(ctx)
+	}
+	return page, err
+}
+
+func pageNames(page entity.ProductPage) []string {
+	names := make([]string, 0, len(page.Items))
+	for _, p := range page.Items {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// TestService_PageCache checks that list pages are served from the cache until a write
+// changes them.
+func TestService_PageCache(t *testing.T) {
+	ctx := t.Context()
+	repo := new(pageRepo{repository: memrepo.New()})
+	srv := NewService(slog.New(slog.DiscardHandler), repo, memcache.New(testCacheCfg), time.Second)
+
+	var products []entity.Product
+	for _, name := range []string{"A", "B", "C"} {
+		p, err := srv.Create(ctx, entity.Product{Name: name, Price: testMoney(100)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		products = append(products, p)
+	}
+	first := uuid.NullUUID{}
+	second := uuid.NullUUID{UUID: products[1].ID, Valid: true}
+	list := func(cursor uuid.NullUUID) []string {
+		t.Helper()
+		page, err := srv.FindAll(ctx, cursor, 2, entity.ProductFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return pageNames(page)
+	}
+	check := func(step string, cursor uuid.NullUUID, want []string, wantReads int32) {
+		t.Helper()
+		before := repo.reads.Load()
+		if got := list(cursor); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", step, got, want)
+		}
+		if got := repo.reads.Load() - before; got != wantReads {
+			t.Errorf("%s: got %d repo reads, want %d", step, got, wantReads)
+		}
+	}
+
+	check("first read", first, []string{"A", "B"}, 1)
+	check("cached", first, []string{"A", "B"}, 0)
+	check("first read of the tail", second, []string{"C"}, 1)
+
+	products[0].Name = "A2"
+	if err := srv.Update(ctx, products[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check("after an update", first, []string{"A2", "B"}, 1)
+	check("tail untouched by the update", second, []string{"C"}, 0)
+
+	if _, err := srv.Create(ctx, entity.Product{Name: "D", Price: testMoney(100)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check("head untouched by a create", first, []string{"A2", "B"}, 0)
+	check("tail after a create", second, []string{"C", "D"}, 1)
+
+	if err := srv.Delete(ctx, products[1].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check("after a delete", first, []string{"A2", "C"}, 1)
+
+	if _, err := srv.Restore(ctx, products[1].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check("after a restore", first, []string{"A2", "B"}, 1)
+
+	eur := entity.ProductFilter{Currency: entity.CurrencyEUR}
+	listEUR := func() []string {
+		t.Helper()
+		page, err := srv.FindAll(ctx, first, 2, eur)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return pageNames(page)
+	}
+	if got := listEUR(); len(got) != 0 {
+		t.Fatalf("got %v, want no products in EUR", got)
+	}
+	products[2].Price = entity.Money{MinorAmount: 100, Currency: entity.CurrencyEUR}
+	if err := srv.Update(ctx, products[2]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := listEUR(); !slices.Equal(got, []string{"C"}) {
+		t.Errorf("after a currency change: got %v, want the product in the pages of its new currency", got)
+	}
+}
+
+// TestService_PageCacheRacingWrite checks that a page read before a write commits is not
+// cached after the write has invalidated it.
+func TestService_PageCacheRacingWrite(t *testing.T) {
+	ctx := t.Context()
+	repo := new(pageRepo{repository: memrepo.New()})
+	srv := NewService(slog.New(slog.DiscardHandler), repo, memcache.New(testCacheCfg), time.Second)
+
+	p, err := srv.Create(ctx, entity.Product{Name: "Car", Price: testMoney(100)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	renamed := p
+	renamed.Name = "Bike"
+	repo.afterRead = func(ctx context.Context) {
+		repo.afterRead = nil
+		if err := srv.Update(ctx, renamed); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	page, err := srv.FindAll(ctx, uuid.NullUUID{}, 10, entity.ProductFilter{})
+	if err != nil || !slices.Equal(pageNames(page), []string{"Car"}) {
+		t.Fatalf("got %v, %v, want the page read before the update", pageNames(page), err)
+	}
+	page, err = srv.FindAll(ctx, uuid.NullUUID{}, 10, entity.ProductFilter{})
+	if err != nil || !slices.Equal(pageNames(page), []string{"Bike"}) {
+		t.Errorf("got %v, %v, want the updated page", pageNames(page), err)
+	}
+}
+
+// TestService_StaleWhileRevalidate checks that a stale product is served while a single
+// background load refreshes it.
+func TestService_StaleWhileRevalidate(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx := t.Context()
+		id := uuid.Must(uuid.NewV7())
+		var (
+			lookups atomic.Int32
+			name    atomic.Pointer[string]
+		)
+		name.Store(new("Car"))
+		release := make(chan struct{}, 1)
+		mockRepo := &MockRepository{
+			FindByIDFn: func(context.Context, uuid.UUID) (entity.Product, error) {
+				if lookups.Add(1) > 1 {
+					<-release
+				}
+				return entity.Product{ID: id, Name: *name.Load(), Price: testMoney(100)}, nil
+			},
+		}
+		c := memcache.New(config.Redis{TTL: time.Second, HardTTL: time.Minute})
+		srv := NewService(slog.New(slog.DiscardHandler), mockRepo, c, time.Second)
+
+		if _, status, err := srv.Lookup(ctx, id); err != nil || status.Hit || !status.Stored {
+			t.Fatalf("got %+v, %v, want a stored miss", status, err)
+		}
+		if _, status, _ := srv.Lookup(ctx, id); !status.Hit || status.TTL != time.Second {
+			t.Errorf("got %+v, want a fresh hit", status)
+		}
+
+		time.Sleep(2 * time.Second)
+		name.Store(new("Bike"))
+		for range 10 {
+			p, status, err := srv.Lookup(ctx, id)
+			if err != nil || p.Name != "Car" || !status.Hit || status.TTL >= 0 {
+				t.Fatalf("got %+v, %+v, %v, want the stale product", p, status, err)
+			}
+		}
+		release <- struct{}{}
+		synctest.Wait()
+
+		if p, status, _ := srv.Lookup(ctx, id); p.Name != "Bike" || status.TTL <= 0 {
+			t.Errorf("got %+v, %+v, want the refreshed product", p, status)
+		}
+		if got := lookups.Load(); got != 2 {
+			t.Errorf("got %d repo lookups, want 2", got)
+		}
+	})
+}
+
+// TestService_NegativeCaching checks that a missing product is looked up in the database once
+// per tombstone, and that creating the product replaces its tombstone.
+func TestService_NegativeCaching(t *testing.T) {
+	ctx := t.Context()
+	c := memcache.New(testCacheCfg)
+	var lookups atomic.Int32
+	mockRepo := &MockRepository{
+		FindByIDFn: func(context.Context, uuid.UUID) (entity.Product, error) {
+			lookups.Add(1)
+			return entity.Product{}, entity.ErrNotFound
+		},
+		SaveFn: func(ctx context.Context, p entity.Product) (entity.Product, error) {
+			// A lookup of the id races the insert and leaves a tombstone.
+			if err := c.SetMissing(ctx, p.ID.String()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return p, nil
+		},
+	}
+	srv := NewService(slog.New(slog.DiscardHandler), mockRepo, c, time.Second)
+
+	id := uuid.Must(uuid.NewV7())
+	for range 3 {
+		if _, err := srv.FindByID(ctx, id); !errors.Is(err, entity.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if got := lookups.Load(); got != 1 {
+		t.Errorf("got %d repo lookups, want 1", got)
+	}
+
+	p, err := srv.Create(ctx, entity.Product{Name: "Car", Price: testMoney(100)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err := srv.FindByID(ctx, p.ID); err != nil || got != p {
+		t.Errorf("got %+v, %v, want the created product", got, err)
+	}
+}
+
+// TestService_Metrics checks that lookups are counted by how the cache answered, and loads
+// of misses by whether they were shared.
+func TestService_Metrics(t *testing.T) {
+	ctx := t.Context()
+	srv := NewService(slog.New(slog.DiscardHandler), memrepo.New(), memcache.New(testCacheCfg), time.Second)
+	count := func(v *prometheus.CounterVec, values ...string) float64 {
+		return testutil.ToFloat64(v.WithLabelValues(values...))
+	}
+	hits, misses := count(cacheLookups, lookupProduct, "hit"), count(cacheLookups, lookupProduct, "miss")
+	tombstones := count(cacheLookups, lookupProduct, "tombstone")
+	loads := count(singleflightCalls, lookupProduct, "false")
+
+	p, err := srv.Create(ctx, entity.Product{Name: "Car", Price: testMoney(100)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	missing := uuid.Must(uuid.NewV7())
+	for _, id := range []uuid.UUID{p.ID, missing, missing} {
+		_, _ = srv.FindByID(ctx, id)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"hits", count(cacheLookups, lookupProduct, "hit") - hits, 1},
+		{"misses", count(cacheLookups, lookupProduct, "miss") - misses, 1},
+		{"tombstones", count(cacheLookups, lookupProduct, "tombstone") - tombstones, 1},
+		{"loads", count(singleflightCalls, lookupProduct, "false") - loads, 1},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+// stallingCache answers no call before its context is done.
+type stallingCache struct {
+	mockCache
+	calls atomic.Int32
+}
+
+func (c *stallingCache) Get(ctx context.Context, _ string) (cache.Entry, error) {
+	c.calls.Add(1)
+	<-ctx.Done()
+	return cache.Entry{}, ctx.Err()
+}
+
+func (c *stallingCache) Set(ctx context.Context, _ string, _ entity.Product, _ time.Duration) error {
+	c.calls.Add(1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (*stallingCache) Ping(context.Context) error {
+	return nil
+}
+
+// TestService_CacheBreaker checks that lookups stop waiting on a stalled cache once its
+// circuit breaker opens, and go to the database.
+func TestService_CacheBreaker(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx := t.Context()
+		id := uuid.Must(uuid.NewV7())
+		mockRepo := &MockRepository{
+			FindByIDFn: func(context.Context, uuid.UUID) (entity.Product, error) {
+				return entity.Product{ID: id, Name: "Car", Price: testMoney(100)}, nil
+			},
+		}
+		stalled := new(stallingCache)
+		cfg := config.Breaker{
+			Window:         10 * time.Second,
+			MinRequests:    4,
+			FailureRate:    0.5,
+			OpenFor:        time.Minute,
+			HalfOpenProbes: 1,
+			CallTimeout:    100 * time.Millisecond,
+		}
+		c := cache.NewGuarded(slog.New(slog.DiscardHandler), stalled, cfg)
+		srv := NewService(slog.New(slog.DiscardHandler), mockRepo, c, time.Second)
+
+		for range 2 {
+			if _, status, err := srv.Lookup(ctx, id); err != nil || status.Fwd != cache.FwdMiss {
+				t.Fatalf("got %+v, %v, want a miss", status, err)
+			}
+		}
+		calls := stalled.calls.Load()
+		start := time.Now()
+		for range 10 {
+			p, status, err := srv.Lookup(ctx, id)
+			if err != nil || p.Name != "Car" || status.Fwd != cache.FwdBypass {
+				t.Fatalf("got %+v, %+v, %v, want the product bypassing the cache", p, status, err)
+			}
+		}
+		if got := stalled.calls.Load(); got != calls {
+			t.Errorf("got %d cache calls while bypassed, want none", got-calls)
+		}
+		if elapsed := time.Since(start); elapsed != 0 {
+			t.Errorf("bypassed lookups took %v, want no wait", elapsed)
+		}
+	})
+}
+
+// TestService_TenantIsolation checks that a product cached for one tenant is never served
+// to another, even when both load the same id.
+func TestService_TenantIsolation(t *testing.T) {
+	acme := reqctx.WithTenant(t.Context(), "acme")
+	globex := reqctx.WithTenant(t.Context(), "globex")
+	c := memcache.New(testCacheCfg)
+	srv := NewService(slog.New(slog.DiscardHandler), memrepo.New(), c, time.Second)
+
+	p, err := srv.Create(acme, entity.Product{Name: "Car", Price: testMoney(100)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := srv.FindByID(globex, p.ID); !errors.Is(err, entity.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for another tenant's product, got %v", err)
+	}
+	if got, err := srv.FindByID(acme, p.ID); err != nil || got != p {
+		t.Errorf("got %+v, %v, want %+v", got, err, p)
+	}
+}
+
 func TestService_Delete(t *testing.T) {
 	ctx := t.Context()
 	id := uuid.Must(uuid.NewV7())
@@ -337,3 +1002,107 @@ func TestService_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestService_Restore(t *testing.T) {
+	ctx := t.Context()
+	id := uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name      string
+		mockSetup func(*MockRepository)
+		wantErr   error
+	}{
+		{
+			name: "success",
+			mockSetup: func(m *MockRepository) {
+				m.RestoreFn = func(_ context.Context, id uuid.UUID) (entity.Product, error) {
+					return entity.Product{ID: id, Name: "Restored", Price: testMoney(100)}, nil
+				}
+			},
+		},
+		{
+			name: "not deleted",
+			mockSetup: func(m *MockRepository) {
+				m.RestoreFn = func(_ context.Context, _ uuid.UUID) (entity.Product, error) {
+					return entity.Product{}, entity.ErrNotFound
+				}
+			},
+			wantErr: entity.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository{})
+			tt.mockSetup(mockRepo)
+			srv := newTestService(mockRepo)
+
+			p, err := srv.Restore(ctx, id)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.ID != id {
+				t.Errorf("got %v, want %v", p.ID, id)
+			}
+		})
+	}
+}
+
+func TestService_RunPurge(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		const (
+			interval  = time.Hour
+			retention = 24 * time.Hour
+		)
+		var cutoffs []time.Time
+		mockRepo := &MockRepository{
+			PurgeFn: func(ctx context.Context, before time.Time) (int64, error) {
+				if got := reqctx.Actor(ctx); got != reqctx.System {
+					t.Errorf("got actor %q, want %q", got, reqctx.System)
+				}
+				cutoffs = append(cutoffs, before)
+				return 1, nil
+			},
+		}
+		srv := newTestService(mockRepo)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		start := time.Now()
+		done := make(chan error)
+		go func() { done <- srv.RunPurge(ctx, interval, retention) }()
+
+		time.Sleep(2*interval + time.Minute)
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(cutoffs) != 3 {
+			t.Fatalf("got %d purge runs, want 3", len(cutoffs))
+		}
+		for i, got := range cutoffs {
+			want := start.Add(time.Duration(i)*interval - retention)
+			if !got.Equal(want) {
+				t.Errorf("run %d: got cutoff %v, want %v", i, got, want)
+			}
+		}
+	})
+}
+
+func TestService_RunPurgeDisabled(t *testing.T) {
+	mockRepo := &MockRepository{
+		PurgeFn: func(_ context.Context, _ time.Time) (int64, error) {
+			t.Error("purge must not run when the interval is zero")
+			return 0, nil
+		},
+	}
+	if err := newTestService(mockRepo).RunPurge(t.Context(), 0, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
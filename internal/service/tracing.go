@@ -0,0 +1,34 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/alkmc/storefront/internal/service")
+
+// productID is the span attribute of the product a call is about.
+func productID(id uuid.UUID) trace.SpanStartOption {
+	return trace.WithAttributes(attribute.String("product.id", id.String()))
+}
+
+// endSpan ends span, marking it failed by err unless err is nil or an answer to the client,
+// such as a product not found.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !isClientError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func isClientError(err error) bool {
+	return errors.Is(err, entity.ErrNotFound) || errors.Is(err, entity.ErrConflict) ||
+		errors.Is(err, entity.ErrConstraintViolation)
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+type (
+	warmRepository interface {
+		FindByIDs(context.Context, []uuid.UUID) ([]entity.Product, error)
+		RecentlyUpdated(context.Context, int) ([]entity.ProductRef, error)
+	}
+	warmCache interface {
+		Hottest(context.Context, int) ([]entity.ProductRef, error)
+		SetMany(context.Context, []entity.Product, []string, time.Duration) (int, error)
+		TrackReads(context.Context) error
+	}
+	// Warmer preloads the cache with the products it is likely to be asked for, so a cold
+	// instance or a flushed Redis does not send every first read to the database. It runs one
+	// warm-up at a time, and shares the product reads the cache counts to tell which are hot.
+	Warmer struct {
+		logger *slog.Logger
+		repo   warmRepository
+		cache  warmCache
+		cfg    config.Warm
+		// ready is set once the warm-up on startup is over, or when there is none.
+		ready atomic.Bool
+		// start hands the warm-ups asked for to Run.
+		start chan entity.WarmSource
+
+		mu sync.Mutex
+		// last is the latest warm-up; zero before the first.
+		last entity.WarmRun
+	}
+)
+
+// NewWarmer initializes cache warming configured by cfg.
+func NewWarmer(l *slog.Logger, r warmRepository, c warmCache, cfg config.Warm) (*Warmer, error) {
+	if !entity.WarmSource(cfg.Source).Valid() {
+		return nil, fmt.Errorf("unknown cache warm-up source %q", cfg.Source)
+	}
+	w := new(Warmer{logger: l, repo: r, cache: c, cfg: cfg, start: make(chan entity.WarmSource, 1)})
+	w.ready.Store(!cfg.OnStart)
+	return w, nil
+}
+
+// Run warms the cache from cfg.Source when cfg.OnStart is set, then runs the warm-ups asked
+// for through Start and shares the counted product reads every cfg.TrackInterval, until ctx
+// is done.
+func (w *Warmer) Run(ctx context.Context) error {
+	if w.cfg.OnStart {
+		source := entity.WarmSource(w.cfg.Source)
+		if _, err := w.begin(source); err == nil {
+			w.warm(ctx, source)
+		}
+		w.ready.Store(true)
+	}
+
+	var tick <-chan time.Time
+	if w.cfg.TrackInterval > 0 {
+		ticker := time.NewTicker(w.cfg.TrackInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case source := <-w.start:
+			w.warm(ctx, source)
+		case <-tick:
+			if err := w.cache.TrackReads(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Warn("failed to track product reads", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// Ready reports whether the warm-up on startup is over, however it went.
+func (w *Warmer) Ready() bool {
+	return w.ready.Load()
+}
+
+// Start queues a warm-up from source, or from cfg.Source when it is empty, for Run. It fails
+// with entity.ErrWarmRunning while another one runs.
+func (w *Warmer) Start(source entity.WarmSource) (entity.WarmRun, error) {
+	source = cmp.Or(source, entity.WarmSource(w.cfg.Source))
+	if !source.Valid() {
+		return entity.WarmRun{}, fmt.Errorf("unknown cache warm-up source %q", source)
+	}
+	run, err := w.begin(source)
+	if err != nil {
+		return entity.WarmRun{}, err
+	}
+	w.start <- source
+	return run, nil
+}
+
+// Progress returns the latest warm-up, and false when there has been none.
+func (w *Warmer) Progress() (entity.WarmRun, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last, !w.last.StartedAt.IsZero()
+}
+
+func (w *Warmer) begin(source entity.WarmSource) (entity.WarmRun, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.last.Status == entity.WarmRunning {
+		return entity.WarmRun{}, entity.ErrWarmRunning
+	}
+	w.last = entity.WarmRun{Source: source, Status: entity.WarmRunning, StartedAt: time.Now()}
+	return w.last, nil
+}
+
+func (w *Warmer) update(fn func(*entity.WarmRun)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(&w.last)
+}
+
+// warm runs the warm-up begun from source for at most cfg.Timeout and records how it went.
+func (w *Warmer) warm(ctx context.Context, source entity.WarmSource) {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+
+	err := w.load(ctx, source)
+	w.update(func(run *entity.WarmRun) {
+		run.Status, run.FinishedAt = entity.WarmCompleted, time.Now()
+		if err != nil {
+			run.Status, run.Error = entity.WarmFailed, err.Error()
+		}
+	})
+	run, _ := w.Progress()
+	w.logger.Info("cache warm-up finished", slog.String("source", string(run.Source)),
+		slog.String("status", string(run.Status)), slog.Int("picked", run.Picked), slog.Int("stored", run.Stored),
+		slog.Duration("duration", run.FinishedAt.Sub(run.StartedAt)), slog.String("error", run.Error))
+}
+
+// load reads the picked products of each tenant from the database and stores them in the
+// cache, cfg.BatchSize at a time.
+func (w *Warmer) load(ctx context.Context, source entity.WarmSource) error {
+	refs, err := w.pick(ctx, source)
+	if err != nil {
+		return err
+	}
+	w.update(func(run *entity.WarmRun) { run.Picked = len(refs) })
+
+	var tenants []string
+	ids := make(map[string][]uuid.UUID)
+	for _, ref := range refs {
+		if _, ok := ids[ref.Tenant]; !ok {
+			tenants = append(tenants, ref.Tenant)
+		}
+		ids[ref.Tenant] = append(ids[ref.Tenant], ref.ID)
+	}
+	for _, tenant := range tenants {
+		// Read from the primary, like every cache fill: a lagging replica would cache stale products.
+		ctx := reqctx.WithReadYourWrites(reqctx.WithTenant(ctx, tenant))
+		for batch := range slices.Chunk(ids[tenant], max(w.cfg.BatchSize, 1)) {
+			products, err := w.repo.FindByIDs(ctx, batch)
+			if err != nil {
+				return fmt.Errorf("load products to warm: %w", err)
+			}
+			stored, err := w.cache.SetMany(ctx, products, nil, 0)
+			w.update(func(run *entity.WarmRun) {
+				run.Loaded += len(products)
+				run.Missing += len(batch) - len(products)
+				run.Stored += stored
+			})
+			if err != nil {
+				return fmt.Errorf("warm cache: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// pick returns the products to warm, at most cfg.Size of them. The read counts start over
+// when Redis loses them, so hot products are topped up with the most recently updated.
+func (w *Warmer) pick(ctx context.Context, source entity.WarmSource) ([]entity.ProductRef, error) {
+	var refs []entity.ProductRef
+	if source == entity.WarmHot {
+		hot, err := w.cache.Hottest(ctx, w.cfg.Size)
+		if err != nil {
+			return nil, err
+		}
+		if refs = hot; len(refs) >= w.cfg.Size {
+			return refs, nil
+		}
+	}
+	recent, err := w.repo.RecentlyUpdated(ctx, w.cfg.Size)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[entity.ProductRef]bool, len(refs))
+	for _, ref := range refs {
+		seen[ref] = true
+	}
+	for _, ref := range recent {
+		if len(refs) >= w.cfg.Size {
+			break
+		}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/alkmc/storefront/internal/config"
+	"github.com/alkmc/storefront/internal/entity"
+	memrepo "github.com/alkmc/storefront/internal/repository/memory"
+	"github.com/alkmc/storefront/internal/reqctx"
+	"github.com/google/uuid"
+)
+
+type fakeWarmCache struct {
+	hot []entity.ProductRef
+
+	mu      sync.Mutex
+	stored  []entity.ProductRef
+	tracked int
+}
+
+func (c *fakeWarmCache) Hottest(_ context.Context, n int) ([]entity.ProductRef, error) {
+	return c.hot[:min(n, len(c.hot))], nil
+}
+
+func (c *fakeWarmCache) SetMany(ctx context.Context, products []entity.Product, _ []string, _ time.Duration,
+) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range products {
+		c.stored = append(c.stored, entity.ProductRef{Tenant: reqctx.Tenant(ctx), ID: p.ID})
+	}
+	return len(products), nil
+}
+
+func (c *fakeWarmCache) TrackReads(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tracked++
+	return nil
+}
+
+func (c *fakeWarmCache) take() []entity.ProductRef {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stored := c.stored
+	c.stored = nil
+	return stored
+}
+
+func TestWarmer(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		repo := memrepo.New()
+		save := func(tenant, name string) entity.ProductRef {
+			t.Helper()
+			p, err := repo.Save(reqctx.WithTenant(t.Context(), tenant),
+				entity.Product{ID: uuid.New(), Name: name, Price: testMoney(100)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return entity.ProductRef{Tenant: tenant, ID: p.ID}
+		}
+		a1, g1, a2 := save("acme", "A1"), save("globex", "G1"), save("acme", "A2")
+		gone := save("acme", "Gone")
+		if err := repo.Delete(reqctx.WithTenant(t.Context(), "acme"), gone.ID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		cfg := config.Warm{
+			OnStart:       true,
+			Source:        string(entity.WarmHot),
+			Size:          3,
+			BatchSize:     1,
+			Timeout:       time.Second,
+			TrackInterval: time.Minute,
+		}
+		c := &fakeWarmCache{hot: []entity.ProductRef{gone, g1}}
+		w, err := NewWarmer(slog.New(slog.DiscardHandler), repo, c, cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if w.Ready() {
+			t.Fatal("ready before the warm-up on startup")
+		}
+
+		ctx, cancel := context.WithCancel(t.Context())
+		done := make(chan error)
+		go func() { done <- w.Run(ctx) }()
+		synctest.Wait()
+
+		// Fewer products were counted than asked for, so the most recently updated top them up.
+		if !w.Ready() {
+			t.Fatal("not ready after the warm-up on startup")
+		}
+		run, ok := w.Progress()
+		if !ok || run.Status != entity.WarmCompleted || run.Picked != 3 || run.Loaded != 2 || run.Missing != 1 ||
+			run.Stored != 2 {
+			t.Fatalf("got %+v, want a completed warm-up storing 2 of 3", run)
+		}
+		if got, want := c.take(), []entity.ProductRef{a2, g1}; !slices.Equal(got, want) {
+			t.Errorf("got stored %v, want %v", got, want)
+		}
+
+		time.Sleep(cfg.TrackInterval)
+		synctest.Wait()
+		if c.tracked != 1 {
+			t.Errorf("got %d tracks, want 1", c.tracked)
+		}
+
+		if _, err := w.Start(entity.WarmRecent); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := w.Start(""); err == nil {
+			t.Error("expected ErrWarmRunning while a warm-up is queued")
+		}
+		synctest.Wait()
+		if run, _ := w.Progress(); run.Source != entity.WarmRecent || run.Status != entity.WarmCompleted {
+			t.Errorf("got %+v, want a completed warm-up from recent", run)
+		}
+		// Products are stored by tenant, in the order they were picked.
+		if got, want := c.take(), []entity.ProductRef{a2, a1, g1}; !slices.Equal(got, want) {
+			t.Errorf("got stored %v, want %v", got, want)
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestNewWarmer_UnknownSource(t *testing.T) {
+	if _, err := NewWarmer(slog.New(slog.DiscardHandler), memrepo.New(), &fakeWarmCache{},
+		config.Warm{Source: "cold"}); err == nil {
+		t.Fatal("expected an error for an unknown source")
+	}
+}
@@ -0,0 +1,114 @@
+// Package tracing sets up OpenTelemetry tracing: the exporter and sampler of config.Tracing,
+// W3C trace context propagation, and the trace ids of log records.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alkmc/storefront/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+)
+
+// Supported values of config.Tracing.Exporter.
+const (
+	ExporterOTLP    = "otlp"
+	ExporterConsole = "console"
+	ExporterNone    = "none"
+)
+
+// Setup installs the global tracer provider and the W3C traceparent and tracestate propagator.
+// The returned shutdown flushes the spans not yet exported; it must be called before exit.
+func Setup(ctx context.Context, cfg config.Tracing) (func(context.Context) error, error) {
+	sampler, err := newSampler(cfg.Sampler, cfg.SamplerArg)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx, resource.WithFromEnv(), resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("trace resource: %w", err)
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithSampler(sampler), sdktrace.WithResource(res)}
+	switch cfg.Exporter {
+	case ExporterNone:
+		// Spans are still started, so trace ids reach the logs and the propagated context.
+	case ExporterConsole:
+		// Not to stdout, where spans would interleave with the JSON log records.
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("console trace exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case ExporterOTLP:
+		exp, err := newOTLPExporter(ctx, cfg.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want %s, %s or %s",
+			cfg.Exporter, ExporterOTLP, ExporterConsole, ExporterNone)
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// newOTLPExporter exports over protocol to the endpoint of the OTEL_EXPORTER_OTLP_ variables,
+// localhost by default.
+func newOTLPExporter(ctx context.Context, protocol string) (sdktrace.SpanExporter, error) {
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+	switch protocol {
+	case "http/protobuf":
+		exp, err = otlptracehttp.New(ctx)
+	case "grpc":
+		exp, err = otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol %q, want http/protobuf or grpc", protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("otlp trace exporter: %w", err)
+	}
+	return exp, nil
+}
+
+// newSampler returns the sampler named like OTEL_TRACES_SAMPLER; ratio is the share of traces
+// traceidratio records.
+func newSampler(name string, ratio float64) (sdktrace.Sampler, error) {
+	base, parentBased := strings.CutPrefix(name, "parentbased_")
+	var s sdktrace.Sampler
+	switch base {
+	case "always_on":
+		s = sdktrace.AlwaysSample()
+	case "always_off":
+		s = sdktrace.NeverSample()
+	case "traceidratio":
+		if ratio < 0 || ratio > 1 {
+			return nil, errors.New("trace sampler ratio must be between 0 and 1")
+		}
+		s = sdktrace.TraceIDRatioBased(ratio)
+	default:
+		return nil, fmt.Errorf("unknown trace sampler %q", name)
+	}
+	if parentBased {
+		s = sdktrace.ParentBased(s)
+	}
+	return s, nil
+}
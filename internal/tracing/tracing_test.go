@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/alkmc/storefront/internal/config"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewSampler(t *testing.T) {
+	tests := []struct {
+		name    string
+		ratio   float64
+		want    string
+		wantErr bool
+	}{
+		{name: "always_on", want: "AlwaysOnSampler"},
+		{name: "always_off", want: "AlwaysOffSampler"},
+		{name: "traceidratio", ratio: 0.25, want: "TraceIDRatioBased{0.25}"},
+		{name: "parentbased_traceidratio", ratio: 0.5, want: "ParentBased{root:TraceIDRatioBased{0.5}"},
+		{name: "traceidratio", ratio: 2, wantErr: true},
+		{name: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		s, err := newSampler(tt.name, tt.ratio)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s(%v): expected an error", tt.name, tt.ratio)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if got := s.Description(); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	cfg := config.Tracing{Exporter: "jaeger", Sampler: "always_on", ServiceName: "storefront"}
+	if _, err := Setup(t.Context(), cfg); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(LogHandler(slog.NewJSONHandler(&buf, nil))).With(slog.String("component", "test"))
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "op")
+	defer span.End()
+	logger.InfoContext(ctx, "traced")
+	logger.InfoContext(context.Background(), "untraced")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d records, want 2", len(lines))
+	}
+	var traced, untraced map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &traced); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &untraced); err != nil {
+		t.Fatal(err)
+	}
+	sc := trace.SpanContextFromContext(ctx)
+	if traced["trace_id"] != sc.TraceID().String() || traced["span_id"] != sc.SpanID().String() {
+		t.Errorf("got %v, want the ids of the span", traced)
+	}
+	if traced["component"] != "test" {
+		t.Errorf("got %v, want the attributes of the logger kept", traced)
+	}
+	if _, ok := untraced["trace_id"]; ok {
+		t.Errorf("got %v, want no trace id without a span", untraced)
+	}
+}